@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ########### EFFECTIVE CONFIG ##################
+// -print-config dumps every flag's resolved value (defaults included) as
+// JSON; -with-config=FILE runs from such a dump and ignores the other
+// flags, so "I got different numbers" comes down to diffing two dumps.
+// The same dump is embedded in every JSON result as "config".
+
+// configOnly: flags that are not part of the config: the two above and
+// -version. They still work from the command line next to -with-config.
+var configOnly = map[string]bool{"print-config": true, "with-config": true, "version": true}
+
+// effectiveConfig: a config dump. Flags maps a flag name to its value as
+// typed on the command line; repeatable flags map to a list.
+type effectiveConfig struct {
+	SchemaVersion string         `json:"schemaVersion"`
+	Flags         map[string]any `json:"flags"`
+}
+
+// resolveConfig: the effective value of every flag. defaults and set (the
+// flags given on the command line) come from the flag set; dump is the
+// -with-config file, nil without one. With a dump, its values replace
+// the defaults and set is ignored; without, set wins over the defaults.
+// A dump naming an unknown flag is an error, so a typo or a flag from
+// another GoSize version isn't dropped silently.
+func resolveConfig(defaults, set, dump map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(defaults))
+	for k, v := range defaults {
+		out[k] = v
+	}
+	over := set
+	if dump != nil {
+		over = dump
+	}
+	for k, v := range over {
+		if _, ok := defaults[k]; !ok {
+			return nil, fmt.Errorf("unknown flag -%s", k)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// flagValues: the current (or, with defaults, default) value of every
+// config flag in fs; with onlySet, just those away from their default.
+// Aliases share a value, so setting one shows up under both names.
+func flagValues(fs *flag.FlagSet, defaults, onlySet bool) map[string]any {
+	out := make(map[string]any)
+	add := func(f *flag.Flag) {
+		if configOnly[f.Name] {
+			return
+		}
+		switch {
+		case defaults:
+			if _, ok := f.Value.(*stringList); ok {
+				out[f.Name] = []string{}
+			} else {
+				out[f.Name] = f.DefValue
+			}
+		default:
+			if l, ok := f.Value.(*stringList); ok {
+				if len(*l) > 0 || !onlySet {
+					out[f.Name] = append([]string{}, *l...)
+				}
+			} else if v := f.Value.String(); v != f.DefValue || !onlySet {
+				out[f.Name] = v
+			}
+		}
+	}
+	fs.VisitAll(add)
+	return out
+}
+
+// loadConfig reads a -print-config dump, or the "config" object of a
+// -json result. Lists decode as []any and are turned back into []string.
+func loadConfig(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		effectiveConfig
+		Config *effectiveConfig `json:"config"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := doc.effectiveConfig
+	if doc.Config != nil {
+		c = *doc.Config
+	}
+	if err := checkSchema(c.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Flags == nil {
+		return nil, fmt.Errorf("%s: no \"flags\" object", path)
+	}
+	for k, v := range c.Flags {
+		switch v := v.(type) {
+		case string:
+		case []any:
+			l := make([]string, len(v))
+			for i, e := range v {
+				s, ok := e.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: -%s: list entries must be strings", path, k)
+				}
+				l[i] = s
+			}
+			c.Flags[k] = l
+		default:
+			return nil, fmt.Errorf("%s: -%s: value must be a string or a list of strings", path, k)
+		}
+	}
+	return c.Flags, nil
+}
+
+// applyConfig sets every flag in fs to its value in eff, replacing what
+// the command line set. Values away from the default go through fs.Set,
+// so flag.Visit reports them as given.
+func applyConfig(fs *flag.FlagSet, eff map[string]any) error {
+	names := make([]string, 0, len(eff))
+	for k := range eff {
+		names = append(names, k)
+	}
+	sort.Strings(names) // aliases share a value; apply in a fixed order
+	for _, name := range names {
+		f := fs.Lookup(name)
+		switch v := eff[name].(type) {
+		case []string:
+			l, ok := f.Value.(*stringList)
+			if !ok {
+				return fmt.Errorf("-%s takes one value, not a list", name)
+			}
+			*l = nil
+			for _, s := range v {
+				fs.Set(name, s)
+			}
+		case string:
+			set := fs.Set
+			if v == f.DefValue {
+				set = func(_, v string) error { return f.Value.Set(v) }
+			}
+			if l, ok := f.Value.(*stringList); ok {
+				*l = nil
+				if v == "" {
+					continue
+				}
+			}
+			if err := set(name, v); err != nil {
+				return fmt.Errorf("-%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// commandLineConfig: the effective config of this run, after applying
+// the -with-config dump at path, if any, to the flags.
+func commandLineConfig(path string) (*effectiveConfig, error) {
+	fs := flag.CommandLine
+	var dump map[string]any
+	if path != "" {
+		var err error
+		if dump, err = loadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	eff, err := resolveConfig(flagValues(fs, true, false), flagValues(fs, false, true), dump)
+	if err != nil {
+		return nil, err
+	}
+	if dump != nil {
+		if err := applyConfig(fs, eff); err != nil {
+			return nil, err
+		}
+	}
+	return &effectiveConfig{SchemaVersion: schemaVersion, Flags: eff}, nil
+}
+
+// printConfig writes c as indented JSON (encoding/json sorts the keys).
+func printConfig(c *effectiveConfig) {
+	data, _ := json.MarshalIndent(c, "", "  ")
+	fmt.Printf("%s\n", data)
+}
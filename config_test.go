@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveConfig(t *testing.T) {
+	defaults := map[string]any{"top": "15", "workers": "8", "exclude": []string{}}
+	tests := []struct {
+		name      string
+		set, dump map[string]any
+		want      map[string]any
+		err       bool
+	}{
+		{name: "defaults", want: defaults},
+		{
+			name: "command line over defaults",
+			set:  map[string]any{"top": "50", "exclude": []string{"*.tmp"}},
+			want: map[string]any{"top": "50", "workers": "8", "exclude": []string{"*.tmp"}},
+		},
+		{
+			// The dump is the whole config: what the command line set is
+			// ignored, even for flags the dump leaves at their default.
+			name: "dump over command line",
+			set:  map[string]any{"top": "50", "workers": "2"},
+			dump: map[string]any{"top": "30"},
+			want: map[string]any{"top": "30", "workers": "8", "exclude": []string{}},
+		},
+		{
+			name: "empty dump",
+			set:  map[string]any{"top": "50"},
+			dump: map[string]any{},
+			want: defaults,
+		},
+		{name: "unknown flag in the dump", dump: map[string]any{"topp": "30"}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveConfig(defaults, tt.set, tt.dump)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if defaults["top"] != "15" {
+		t.Error("resolveConfig changed the defaults")
+	}
+}
+
+// configFlags: a flag set with one flag of each kind -print-config writes.
+func configFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("gosize", flag.ContinueOnError)
+	fs.Int("top", 15, "")
+	fs.Bool("plain", false, "")
+	fs.String("sort", "size", "")
+	fs.Var(&stringList{}, "exclude", "")
+	fs.Bool("version", false, "") // config-only: never in a dump
+	return fs
+}
+
+// A dump written by one run and read back by -with-config must set every
+// flag of the next run to the same value, whatever its own command line.
+func TestConfigRoundTrip(t *testing.T) {
+	first := configFlags()
+	if err := first.Parse([]string{"-top=40", "-plain", "-exclude=*.tmp", "-exclude=node_modules", "-version"}); err != nil {
+		t.Fatal(err)
+	}
+	eff, err := resolveConfig(flagValues(first, true, false), flagValues(first, false, true), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := eff["version"]; ok {
+		t.Error("-version is in the config")
+	}
+
+	dir := t.TempDir()
+	dumpPath, resultPath := filepath.Join(dir, "config.json"), filepath.Join(dir, "result.json")
+	dump, _ := json.Marshal(effectiveConfig{SchemaVersion: schemaVersion, Flags: eff})
+	result, _ := json.Marshal(map[string]any{"schemaVersion": schemaVersion, "config": effectiveConfig{SchemaVersion: schemaVersion, Flags: eff}})
+	for path, data := range map[string][]byte{dumpPath: dump, resultPath: result} {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{dumpPath, resultPath} {
+		next := configFlags()
+		if err := next.Parse([]string{"-top=5", "-sort=path", "-exclude=*.log"}); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eff2, err := resolveConfig(flagValues(next, true, false), flagValues(next, false, true), loaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(next, eff2); err != nil {
+			t.Fatal(err)
+		}
+		if got := flagValues(next, false, false); !maps.EqualFunc(got, eff, func(a, b any) bool { return reflect.DeepEqual(a, b) }) {
+			t.Errorf("%s: flags %v after applying it, want %v", filepath.Base(path), got, eff)
+		}
+		if got := next.Lookup("sort").Value.String(); got != "size" {
+			t.Errorf("%s: -sort=%s from the command line survived the dump", filepath.Base(path), got)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, doc := range map[string]string{
+		"schema":   `{"schemaVersion":"99.0","flags":{}}`,
+		"no flags": `{"schemaVersion":"` + schemaVersion + `"}`,
+		"number":   `{"schemaVersion":"` + schemaVersion + `","flags":{"top":40}}`,
+		"list":     `{"schemaVersion":"` + schemaVersion + `","flags":{"exclude":["a",1]}}`,
+		"syntax":   `{"schemaVersion":`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ########### CONTROL PIPE: PROTOCOL ##################
+// -control-pipe=NAME serves \\.\pipe\NAME for a GUI front end. Both
+// directions are newline-delimited JSON. The controller sends commands:
+//
+//	{"cmd":"start"}                     scan the -roots given on the command line
+//	{"cmd":"start","roots":["D:\\"]}    scan other roots
+//	{"cmd":"status"} {"cmd":"result"} {"cmd":"cancel"}
+//
+// and receives events: started, progress (pushed while a scan runs),
+// finished, status, result (the same document as -json), and error.
+// Only one controller may be connected at a time, and only the pipe's
+// owner, the account GoSize runs under, may connect (see pipeSDDL).
+
+// controlCmd: one line from the controller.
+type controlCmd struct {
+	Cmd   string   `json:"cmd"`
+	Roots []string `json:"roots,omitempty"`
+}
+
+// controlEvent: one line to the controller.
+type controlEvent struct {
+	Event     string           `json:"event"`
+	State     string           `json:"state,omitempty"` // status: idle, running, finished, cancelled
+	Roots     []string         `json:"roots,omitempty"`
+	Progress  *controlProgress `json:"progress,omitempty"`
+	Result    *jsonResult      `json:"result,omitempty"`
+	Cancelled bool             `json:"cancelled,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// controlProgress: the counters from the progress line.
+type controlProgress struct {
+	Elapsed   string `json:"elapsed"`
+	FilesSeen int64  `json:"filesSeen"`
+	DirsSeen  int64  `json:"dirsSeen"`
+	Skipped   int64  `json:"skipped"`
+	Errors    int64  `json:"errors"`
+}
+
+// controlPushInterval: how often progress events are pushed during a scan.
+const controlPushInterval = 500 * time.Millisecond
+
+// controlQueue: events held for a controller that is slow to read. Past
+// that, progress events are dropped; the next one has the totals anyway.
+const controlQueue = 64
+
+// ########### CONTROL PIPE: SERVER ##################
+// controlServer: scan state shared across controller sessions, so a GUI
+// that reconnects can still ask for the status or result of a scan.
+type controlServer struct {
+	roots    []string // defaults from -roots
+	cfg      walkCfg
+	splitDir bool
+
+	mu        sync.Mutex
+	out       *controlOut // current controller; nil between sessions
+	cur       *scan
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// serveControlPipe: accepts one controller at a time, forever.
+func serveControlPipe(name string, roots []string, cfg walkCfg, splitDir bool) error {
+	cfg.showProgress = false // progress goes to the pipe, not stderr
+	srv := &controlServer{roots: roots, cfg: cfg, splitDir: splitDir}
+
+	h, err := listenPipe(name)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+
+	conn := &pipeConn{h: h}
+	for {
+		if err := conn.accept(); err != nil {
+			return err
+		}
+		stopped := srv.session(conn)
+		windows.DisconnectNamedPipe(h) // fails a write still pending
+		<-stopped
+	}
+}
+
+// pipeSDDL: the pipes' security descriptor. Generic all for OWNER RIGHTS
+// and nobody else; protected, so nothing is inherited. Other accounts,
+// and services running as someone else, get access denied.
+const pipeSDDL = "D:P(A;;GA;;;OW)"
+
+// pipeSecurity: SECURITY_ATTRIBUTES with pipeSDDL.
+func pipeSecurity() (*windows.SecurityAttributes, error) {
+	sd, err := windows.SecurityDescriptorFromString(pipeSDDL)
+	if err != nil {
+		return nil, err
+	}
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+	return sa, nil
+}
+
+// listenPipe: creates \\.\pipe\NAME for one client at a time, local
+// clients of the same account only.
+func listenPipe(name string) (windows.Handle, error) {
+	return createPipe(name, 1, true) // a second client gets ERROR_PIPE_BUSY
+}
+
+// createPipe: one instance of \\.\pipe\NAME, of at most instances at
+// once. first fails when the name is taken already, by another process
+// or an earlier run; later instances of the same server pass false.
+func createPipe(name string, instances uint32, first bool) (windows.Handle, error) {
+	path, err := windows.UTF16PtrFromString(`\\.\pipe\` + name)
+	if err != nil {
+		return 0, err
+	}
+	sa, err := pipeSecurity()
+	if err != nil {
+		return 0, err
+	}
+	mode := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		mode |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	return windows.CreateNamedPipe(path, mode,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		instances, 64<<10, 64<<10, 0, sa)
+}
+
+// controlOut: the events for the connected controller, written by a
+// goroutine of their own, so a controller that stops reading holds up
+// neither srv.mu nor the scan's progress pushes.
+type controlOut struct {
+	events  chan controlEvent
+	gone    chan struct{} // closed when the session ends
+	stopped chan struct{} // closed once the writer is done with the connection
+}
+
+// write encodes events to w until the session ends or a write fails.
+func (o *controlOut) write(w io.Writer) {
+	defer close(o.stopped)
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-o.events:
+			if enc.Encode(ev) != nil {
+				return // the controller went away; send gives up at gone
+			}
+		case <-o.gone:
+			return
+		}
+	}
+}
+
+// session: reads commands until the controller disconnects. The returned
+// channel is closed once nothing writes to rw any more.
+func (srv *controlServer) session(rw io.ReadWriter) <-chan struct{} {
+	out := &controlOut{events: make(chan controlEvent, controlQueue), gone: make(chan struct{}), stopped: make(chan struct{})}
+	go out.write(rw)
+	srv.mu.Lock()
+	srv.out = out
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.out = nil
+		srv.mu.Unlock()
+		close(out.gone)
+	}()
+
+	sc := bufio.NewScanner(rw)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var cmd controlCmd
+		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
+			srv.send(controlEvent{Event: "error", Error: "bad command: " + err.Error()})
+			continue
+		}
+		srv.handle(cmd)
+	}
+	return out.stopped
+}
+
+func (srv *controlServer) handle(cmd controlCmd) {
+	switch cmd.Cmd {
+	case "start":
+		if err := srv.start(cmd.Roots); err != nil {
+			srv.send(controlEvent{Event: "error", Error: err.Error()})
+		}
+	case "cancel":
+		srv.mu.Lock()
+		if srv.cur != nil && srv.cancel != nil {
+			srv.cancelled = true
+			srv.cancel()
+		}
+		srv.mu.Unlock()
+	case "status":
+		state, sc := srv.state()
+		ev := controlEvent{Event: "status", State: state}
+		if sc != nil {
+			ev.Roots = sc.roots
+			ev.Progress = progressOf(sc)
+		}
+		srv.send(ev)
+	case "result":
+		state, sc := srv.state()
+		if state != "finished" && state != "cancelled" {
+			srv.send(controlEvent{Event: "error", State: state, Error: "no finished scan"})
+			return
+		}
+		res := sc.jsonResult(newDriveSpaceCache(), srv.splitDir)
+		srv.send(controlEvent{Event: "result", Result: &res})
+	default:
+		srv.send(controlEvent{Event: "error", Error: "unknown command " + cmd.Cmd})
+	}
+}
+
+// start launches a scan unless one is already running.
+func (srv *controlServer) start(roots []string) error {
+	if state, _ := srv.state(); state == "running" {
+		return errors.New("a scan is already running")
+	}
+	if len(roots) == 0 {
+		roots = srv.roots
+	} else {
+		var err error
+		if roots, err = resolveRoots(strings.Join(roots, ",")); err != nil {
+			return err
+		}
+		for _, r := range roots {
+			if err := checkRoot(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := startScan(ctx, roots, srv.cfg)
+	srv.mu.Lock()
+	srv.cur, srv.cancel, srv.cancelled = sc, cancel, false
+	srv.mu.Unlock()
+	srv.send(controlEvent{Event: "started", Roots: roots})
+
+	go func() {
+		defer cancel()
+		t := time.NewTicker(controlPushInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-sc.done:
+				srv.mu.Lock()
+				cancelled := srv.cancelled
+				srv.mu.Unlock()
+				srv.send(controlEvent{Event: "finished", Progress: progressOf(sc), Cancelled: cancelled})
+				return
+			case <-t.C:
+				srv.send(controlEvent{Event: "progress", Progress: progressOf(sc)})
+			}
+		}
+	}()
+	return nil
+}
+
+// state: "idle" before the first scan, then running/finished/cancelled.
+func (srv *controlServer) state() (string, *scan) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.cur == nil {
+		return "idle", nil
+	}
+	select {
+	case <-srv.cur.done:
+		if srv.cancelled {
+			return "cancelled", srv.cur
+		}
+		return "finished", srv.cur
+	default:
+		return "running", srv.cur
+	}
+}
+
+// send queues one event for the connected controller, if any, without
+// holding srv.mu. A progress event that finds the queue full is dropped;
+// others wait for room, or for the session to end.
+func (srv *controlServer) send(ev controlEvent) {
+	srv.mu.Lock()
+	out := srv.out
+	srv.mu.Unlock()
+	if out == nil {
+		return
+	}
+	if ev.Event == "progress" {
+		select {
+		case out.events <- ev:
+		default:
+		}
+		return
+	}
+	select {
+	case out.events <- ev:
+	case <-out.gone:
+	}
+}
+
+func progressOf(sc *scan) *controlProgress {
+	s := &sc.stats
+	return &controlProgress{
+		Elapsed:   time.Since(sc.start).Truncate(time.Millisecond).String(),
+		FilesSeen: atomic.LoadInt64(&s.filesSeen),
+		DirsSeen:  atomic.LoadInt64(&s.dirsSeen),
+		Skipped:   atomic.LoadInt64(&s.skipped),
+		Errors:    atomic.LoadInt64(&s.errors),
+	}
+}
+
+// ########### CONTROL PIPE: OVERLAPPED I/O ##################
+// pipeConn: io.ReadWriter over an overlapped pipe handle. Overlapped mode
+// lets a pending read (waiting for the next command) coexist with writes
+// of pushed progress events; a synchronous handle would serialize them.
+type pipeConn struct {
+	h        windows.Handle
+	deadline time.Time // for Read and Write; zero = none
+}
+
+// SetDeadline: after t, a pending or later Read or Write fails with
+// os.ErrDeadlineExceeded, as on a net.Conn.
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// accept waits for a controller to connect.
+func (c *pipeConn) accept() error {
+	ev, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(ev)
+	ov := windows.Overlapped{HEvent: ev}
+	err = windows.ConnectNamedPipe(c.h, &ov)
+	switch err {
+	case nil, windows.ERROR_PIPE_CONNECTED:
+		return nil
+	case windows.ERROR_IO_PENDING:
+		var n uint32
+		return windows.GetOverlappedResult(c.h, &ov, &n, true)
+	}
+	return err
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	return c.do(b, windows.ReadFile)
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := c.do(b[written:], windows.WriteFile)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// do runs one overlapped ReadFile/WriteFile and waits for it.
+func (c *pipeConn) do(b []byte, op func(windows.Handle, []byte, *uint32, *windows.Overlapped) error) (int, error) {
+	ev, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(ev)
+	ov := windows.Overlapped{HEvent: ev}
+	var n uint32
+	err = op(c.h, b, &n, &ov)
+	if err == windows.ERROR_IO_PENDING {
+		if !c.deadline.IsZero() {
+			wait := max(time.Until(c.deadline), 0)
+			if ev, _ := windows.WaitForSingleObject(ov.HEvent, uint32(wait.Milliseconds())); ev == uint32(windows.WAIT_TIMEOUT) {
+				windows.CancelIoEx(c.h, &ov)
+			}
+		}
+		err = windows.GetOverlappedResult(c.h, &ov, &n, true)
+	}
+	switch err {
+	case windows.ERROR_BROKEN_PIPE, windows.ERROR_NO_DATA:
+		return int(n), io.EOF
+	case windows.ERROR_OPERATION_ABORTED:
+		return int(n), os.ErrDeadlineExceeded
+	}
+	return int(n), err
+}
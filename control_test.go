@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// holdListing makes dir's listing wait until release is called, so a
+// scan of it keeps running as long as a test needs. Done at the end of
+// the test at the latest.
+func holdListing(t *testing.T, dir string) (release func()) {
+	t.Helper()
+	gate := make(chan struct{})
+	listDir = func(p string) ([]os.DirEntry, error) {
+		if pathKey(p) == pathKey(dir) {
+			<-gate
+		}
+		return os.ReadDir(p)
+	}
+	var closed bool
+	release = func() {
+		if !closed {
+			closed = true
+			close(gate)
+		}
+	}
+	t.Cleanup(func() {
+		release()
+		listDir = os.ReadDir
+	})
+	return release
+}
+
+// controlClient: a front end's side of the control protocol.
+type controlClient struct {
+	t   *testing.T
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+func newControlClient(t *testing.T, rw io.ReadWriter) *controlClient {
+	return &controlClient{t: t, enc: json.NewEncoder(rw), dec: json.NewDecoder(rw)}
+}
+
+func (c *controlClient) send(cmd controlCmd) {
+	c.t.Helper()
+	if err := c.enc.Encode(cmd); err != nil {
+		c.t.Fatalf("send %s: %v", cmd.Cmd, err)
+	}
+}
+
+// await reads events up to the first one named event; progress events
+// before it are skipped.
+func (c *controlClient) await(event string) controlEvent {
+	c.t.Helper()
+	for {
+		var ev controlEvent
+		if err := c.dec.Decode(&ev); err != nil {
+			c.t.Fatalf("waiting for %s: %v", event, err)
+		}
+		switch {
+		case ev.Event == event:
+			return ev
+		case ev.Event == "error":
+			c.t.Fatalf("waiting for %s: error event %q", event, ev.Error)
+		case ev.Event != "progress":
+			c.t.Fatalf("waiting for %s: got %s", event, ev.Event)
+		}
+	}
+}
+
+// controlCycle drives a full start, progress, status, result and cancel
+// round over rw, against a server whose -roots is root.
+func controlCycle(t *testing.T, rw io.ReadWriter, root string) {
+	c := newControlClient(t, rw)
+	slow := filepath.Join(root, "a")
+
+	release := holdListing(t, slow)
+	c.send(controlCmd{Cmd: "start"}) // the server's -roots: root
+	if ev := c.await("started"); len(ev.Roots) != 1 || ev.Roots[0] != root {
+		t.Fatalf("started %v, want %s", ev.Roots, root)
+	}
+	if ev := c.await("progress"); ev.Progress == nil {
+		t.Fatal("progress event without counters")
+	}
+	c.send(controlCmd{Cmd: "status"})
+	if ev := c.await("status"); ev.State != "running" {
+		t.Fatalf("status %q while the scan is held, want running", ev.State)
+	}
+	c.send(controlCmd{Cmd: "start"})
+	if ev := c.await("error"); ev.Error == "" {
+		t.Fatal("a second start while running was accepted")
+	}
+	release()
+	if ev := c.await("finished"); ev.Cancelled || ev.Progress.FilesSeen != 3 {
+		t.Fatalf("finished %+v, want 3 files, not cancelled", ev.Progress)
+	}
+	c.send(controlCmd{Cmd: "result"})
+	if ev := c.await("result"); ev.Result == nil || ev.Result.Summary.FilesSeen != 3 || len(ev.Result.Roots) != 1 {
+		t.Fatalf("result %+v", ev.Result)
+	}
+
+	release = holdListing(t, slow)
+	c.send(controlCmd{Cmd: "start"})
+	c.await("started")
+	c.send(controlCmd{Cmd: "cancel"})
+	release() // the held listing returns; the rest of the walk stops
+	if ev := c.await("finished"); !ev.Cancelled {
+		t.Fatal("finished after cancel, but not cancelled")
+	}
+	c.send(controlCmd{Cmd: "status"})
+	if ev := c.await("status"); ev.State != "cancelled" {
+		t.Fatalf("status %q after cancel, want cancelled", ev.State)
+	}
+}
+
+// awaitError: the next error event, skipping anything else.
+func (c *controlClient) awaitError() controlEvent {
+	c.t.Helper()
+	for {
+		var ev controlEvent
+		if err := c.dec.Decode(&ev); err != nil {
+			c.t.Fatalf("waiting for an error: %v", err)
+		}
+		if ev.Event == "error" {
+			return ev
+		}
+	}
+}
+
+func controlTree(t *testing.T) string {
+	return writeTree(t, map[string]int{"a/f1": 10, "a/f2": 20, "b/g": 30})
+}
+
+func TestControlSession(t *testing.T) {
+	root := controlTree(t)
+	srv := &controlServer{roots: []string{root}, cfg: testCfg()}
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		<-srv.session(server)
+		server.Close()
+	}()
+	controlCycle(t, client, root)
+
+	c := newControlClient(t, client)
+	c.send(controlCmd{Cmd: "bogus"})
+	if ev := c.awaitError(); ev.Error != "unknown command bogus" {
+		t.Errorf("unknown command: %q", ev.Error)
+	}
+}
+
+// A controller that stops reading must not hold up the server: progress
+// beyond the queue is dropped and srv.mu stays free.
+func TestControlSendDoesNotBlock(t *testing.T) {
+	srv := &controlServer{cfg: testCfg()}
+	server, client := net.Pipe() // unbuffered: every write waits for a read
+	stopped := make(chan (<-chan struct{}), 1)
+	go func() { stopped <- srv.session(server) }()
+	for connected := false; !connected; time.Sleep(time.Millisecond) {
+		srv.mu.Lock()
+		connected = srv.out != nil
+		srv.mu.Unlock()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := range controlQueue * 4 {
+			srv.send(controlEvent{Event: "progress", Progress: &controlProgress{FilesSeen: int64(i)}})
+		}
+		srv.state() // takes srv.mu
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked on a controller that doesn't read")
+	}
+
+	client.Close()
+	server.Close()
+	select {
+	case s := <-stopped:
+		<-s
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't end after the controller went away")
+	}
+}
+
+// TestControlPipe runs the cycle over \\.\pipe\NAME as a front end would.
+func TestControlPipe(t *testing.T) {
+	root := controlTree(t)
+	name := fmt.Sprintf("gosize-test-%d", os.Getpid())
+	go serveControlPipe(name, []string{root}, testCfg(), false)
+
+	var f *os.File
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var err error
+		if f, err = os.OpenFile(`\\.\pipe\`+name, os.O_RDWR, 0); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer f.Close()
+	controlCycle(t, f, root)
+}
+
+// Scans started one after another over the pipe share the server's cfg,
+// and each result must hold only what that scan found.
+func TestControlScansIndependent(t *testing.T) {
+	root := writeTree(t, map[string]int{
+		"a/f1.txt": 10, "a/f2": 20, "b/g.txt": 30,
+		"many/1": 1, "many/2": 1, "many/3": 1, "many/4": 1,
+	})
+	cfg := testCfg()
+	cfg.children = &childLog{}
+	cfg.find = newFindLog("txt", 0)
+	cfg.huge = &hugeLog{limit: 3}
+	var err error
+	if cfg.rollups, err = newRollupReport([]string{filepath.Join(root, "*")}); err != nil {
+		t.Fatal(err)
+	}
+	srv := &controlServer{roots: []string{root}, cfg: cfg}
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		<-srv.session(server)
+		server.Close()
+	}()
+
+	c := newControlClient(t, client)
+	var results [2]*jsonResult
+	for i := range results {
+		c.send(controlCmd{Cmd: "start"})
+		c.await("started")
+		c.await("finished")
+		c.send(controlCmd{Cmd: "result"})
+		results[i] = c.await("result").Result
+	}
+	for i, r := range results {
+		if len(r.Children) != 3 || len(r.Matches) != 2 || len(r.HugeDirs) != 1 || len(r.Rollups) != 1 || len(r.Rollups[0].Members) != 2 {
+			t.Errorf("scan %d: %d children, %d matches, %d huge dirs, rollups %+v", i+1, len(r.Children), len(r.Matches), len(r.HugeDirs), r.Rollups)
+		}
+	}
+	first, _ := json.Marshal([]any{results[0].Children, results[0].Rollups, results[0].HugeDirs})
+	second, _ := json.Marshal([]any{results[1].Children, results[1].Rollups, results[1].HugeDirs})
+	if string(first) != string(second) {
+		t.Errorf("second scan differs from the first:\n%s\n%s", second, first)
+	}
+}
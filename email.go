@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ########### EMAIL REPORT: SETTINGS ##################
+// -email-to=a@x,b@y -email-smtp=host:port mails the scan report once the
+// output is written. STARTTLS is used whenever the server offers it; the
+// login, if any, comes from GOSIZE_SMTP_USER / GOSIZE_SMTP_PASS so it
+// never shows up in the process list.
+type emailCfg struct {
+	to         []string
+	from       string
+	server     string // host:port
+	attachJSON bool   // attach the -json document as gosize.json
+}
+
+// Environment variables holding the SMTP login.
+const (
+	envSMTPUser = "GOSIZE_SMTP_USER"
+	envSMTPPass = "GOSIZE_SMTP_PASS"
+)
+
+// ########### EMAIL REPORT: MESSAGE ##################
+// emailReport: subject, plain-text body and optional attachment for sc.
+// The subject names the host and the largest directory (or file, when no
+// directories were ranked).
+func (sc *scan) emailReport(dsc *driveSpaceCache, attachJSON bool) (subject, body string, attach []byte) {
+	host, _ := os.Hostname()
+	dirs, files := sc.dirTop.sortedDesc(), sc.fileTop.sortedDesc()
+	if sc.cfg.combined {
+		dirs = nil
+	}
+
+	subject = "GoSize report for " + host
+	top := dirs
+	if len(top) == 0 {
+		top = files
+	}
+	if len(top) > 0 {
+		subject += fmt.Sprintf(": %s %s", top[0].Path, sizeLabel(top[0]))
+	}
+
+	var b bytes.Buffer
+	s := &sc.stats
+	fmt.Fprintf(&b, "Host: %s\n", host)
+	fmt.Fprintf(&b, "Roots: %s\n", rootStatusLine(sc.roots, sc.rootErrs))
+	fmt.Fprintf(&b, "Scanned %d files in %d directories in %s (skipped=%d, errors=%d)\n",
+		atomic.LoadInt64(&s.filesSeen), atomic.LoadInt64(&s.dirsSeen), sc.elapsed,
+		atomic.LoadInt64(&s.skipped), atomic.LoadInt64(&s.errors))
+	for _, list := range []struct {
+		title string
+		items []item
+	}{{"Largest Directories", dirs}, {"Largest Files", files}} {
+		if len(list.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s\n", list.title)
+		w := newTable(&b)
+		fmt.Fprintln(w, "RANK\tSIZE\tPATH")
+		for i, it := range list.items {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", i+1, sizeLabel(it), displayPath(it))
+		}
+		w.Flush()
+	}
+
+	if attachJSON {
+		attach, _ = json.MarshalIndent(sc.jsonResult(dsc, false), "", "  ")
+	}
+	return subject, b.String(), attach
+}
+
+// buildMessage: an RFC 5322 message with body as text/plain, or as the
+// first part of a multipart/mixed message when there is an attachment.
+func buildMessage(from string, to []string, subject, body, attachName string, attach []byte, now time.Time) []byte {
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "From: %s\r\n", from)
+	fmt.Fprintf(&m, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&m, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&m, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&m, "MIME-Version: 1.0\r\n")
+	text := strings.ReplaceAll(body, "\n", "\r\n")
+	if attach == nil {
+		fmt.Fprintf(&m, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s", text)
+		return m.Bytes()
+	}
+
+	boundary := newBoundary()
+	fmt.Fprintf(&m, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&m, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, text)
+	fmt.Fprintf(&m, "--%s\r\nContent-Type: application/json\r\n", boundary)
+	fmt.Fprintf(&m, "Content-Transfer-Encoding: base64\r\n")
+	fmt.Fprintf(&m, "Content-Disposition: attachment; filename=%q\r\n\r\n", attachName)
+	enc := base64.StdEncoding.EncodeToString(attach)
+	for len(enc) > 76 {
+		fmt.Fprintf(&m, "%s\r\n", enc[:76])
+		enc = enc[76:]
+	}
+	fmt.Fprintf(&m, "%s\r\n--%s--\r\n", enc, boundary)
+	return m.Bytes()
+}
+
+// newBoundary: a random MIME boundary; base64 output never contains "=_".
+func newBoundary() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("=_gosize_%x", b)
+}
+
+// ########### EMAIL REPORT: SENDING ##################
+// sendReport: builds and sends the report for sc.
+func (sc *scan) sendReport(ec emailCfg, dsc *driveSpaceCache) error {
+	subject, body, attach := sc.emailReport(dsc, ec.attachJSON)
+	from := ec.from
+	if from == "" {
+		host, _ := os.Hostname()
+		from = "gosize@" + host
+	}
+	msg := buildMessage(from, ec.to, subject, body, "gosize.json", attach, time.Now())
+	var auth smtp.Auth
+	if user := os.Getenv(envSMTPUser); user != "" {
+		host, _, err := net.SplitHostPort(ec.server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv(envSMTPPass), host)
+	}
+	return smtp.SendMail(ec.server, auth, from, ec.to, msg) // STARTTLS when offered
+}
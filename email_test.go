@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseMessage: msg as a mail reader sees it, failing t on bad syntax.
+func parseMessage(t *testing.T, msg []byte) *mail.Message {
+	t.Helper()
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, msg)
+	}
+	return m
+}
+
+func TestBuildMessagePlain(t *testing.T) {
+	now := time.Date(2024, 3, 4, 5, 6, 7, 0, time.FixedZone("", 3600))
+	subject := `GoSize report for HOST: C:\Users\Zoë 12.0 GB`
+	msg := buildMessage("gosize@HOST", []string{"a@x.example", "b@y.example"}, subject, "line 1\nline 2\n", "gosize.json", nil, now)
+	m := parseMessage(t, msg)
+
+	if got := m.Header.Get("To"); got != "a@x.example, b@y.example" {
+		t.Errorf("To: %s", got)
+	}
+	if got, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); err != nil || got != subject {
+		t.Errorf("Subject decodes to %q, %v", got, err)
+	}
+	if d, err := m.Header.Date(); err != nil || !d.Equal(now) {
+		t.Errorf("Date: %v, %v", d, err)
+	}
+	if ct, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type")); ct != "text/plain" || params["charset"] != "utf-8" {
+		t.Errorf("Content-Type: %s", m.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(m.Body)
+	if string(body) != "line 1\r\nline 2\r\n" {
+		t.Errorf("body %q, want CRLF line ends", body)
+	}
+	for _, line := range strings.Split(string(msg), "\r\n") {
+		if strings.Contains(line, "\n") {
+			t.Errorf("bare LF in %q", line)
+		}
+	}
+}
+
+func TestBuildMessageAttachment(t *testing.T) {
+	// Long enough for several base64 lines, and holding text that looks
+	// like a boundary.
+	attach := []byte(`{"path":"--=_gosize_","pad":"` + strings.Repeat("x", 300) + `"}`)
+	msg := buildMessage("gosize@HOST", []string{"a@x.example"}, "report", "the body\n", "gosize.json", attach, time.Now())
+	m := parseMessage(t, msg)
+
+	ct, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || ct != "multipart/mixed" || params["boundary"] == "" {
+		t.Fatalf("Content-Type: %s (%v)", m.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var parts []*multipart.Part
+	var data [][]byte
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			r = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts, data = append(parts, p), append(data, b)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("%d parts, want the body and the attachment", len(parts))
+	}
+	if got := parts[0].Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") || string(data[0]) != "the body\r\n" {
+		t.Errorf("first part %s %q", got, data[0])
+	}
+	if parts[1].FileName() != "gosize.json" || parts[1].Header.Get("Content-Type") != "application/json" {
+		t.Errorf("attachment headers %v", parts[1].Header)
+	}
+	if !bytes.Equal(data[1], attach) {
+		t.Errorf("attachment decodes to %s", data[1])
+	}
+	for _, line := range strings.Split(string(msg), "\r\n") {
+		if len(line) > 998 || (!strings.Contains(line, ":") && len(line) > 76) {
+			t.Errorf("line of %d bytes", len(line))
+		}
+	}
+	if a, b := newBoundary(), newBoundary(); a == b {
+		t.Error("two messages got the same boundary")
+	}
+}
+
+// The subject names the host and the largest directory; the attachment is
+// the scan's -json document.
+func TestEmailReport(t *testing.T) {
+	root := writeTree(t, map[string]int{"big/a.bin": 9000, "big/b.bin": 1000, "small/c.txt": 100})
+	cfg := testCfg()
+	sc := startScan(context.Background(), []string{root}, cfg)
+	sc.wait()
+	host, _ := os.Hostname()
+	subject, body, attach := sc.emailReport(newDriveSpaceCache(), true)
+
+	big := filepath.Join(root, "big")
+	if !strings.HasPrefix(subject, "GoSize report for "+host+": ") || !strings.Contains(subject, big) {
+		t.Errorf("subject %q, want the host and %s", subject, big)
+	}
+	for _, want := range []string{"Host: " + host, "Largest Directories", "Largest Files", filepath.Join(big, "a.bin")} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body lacks %q:\n%s", want, body)
+		}
+	}
+	var res jsonResult
+	if err := json.Unmarshal(attach, &res); err != nil || res.SchemaVersion != schemaVersion || res.Summary.FilesSeen != 3 {
+		t.Errorf("attachment: %v, schema %s, %d files", err, res.SchemaVersion, res.Summary.FilesSeen)
+	}
+
+	if _, _, attach := sc.emailReport(newDriveSpaceCache(), false); attach != nil {
+		t.Error("attachment without -email-json")
+	}
+}
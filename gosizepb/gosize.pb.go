@@ -0,0 +1,701 @@
+// gosize.proto: the -grpc remote scan API.
+//
+// Regenerate the Go code after editing (from the repo root):
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative gosizepb/gosize.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: gosizepb/gosize.proto
+
+package gosizepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ScanRequest: unset fields fall back to the server's command-line flags.
+type ScanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Roots         []string               `protobuf:"bytes,1,rep,name=roots,proto3" json:"roots,omitempty"` // globs allowed, as with -roots
+	Skip          []string               `protobuf:"bytes,2,rep,name=skip,proto3" json:"skip,omitempty"`   // filepath.Match patterns, as with -skip
+	TopK          int32                  `protobuf:"varint,3,opt,name=top_k,json=topK,proto3" json:"top_k,omitempty"`
+	MaxDepth      int32                  `protobuf:"varint,4,opt,name=max_depth,json=maxDepth,proto3" json:"max_depth,omitempty"`
+	SkipHidden    bool                   `protobuf:"varint,5,opt,name=skip_hidden,json=skipHidden,proto3" json:"skip_hidden,omitempty"`
+	FollowLinks   bool                   `protobuf:"varint,6,opt,name=follow_links,json=followLinks,proto3" json:"follow_links,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanRequest) Reset() {
+	*x = ScanRequest{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanRequest) ProtoMessage() {}
+
+func (x *ScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanRequest.ProtoReflect.Descriptor instead.
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ScanRequest) GetRoots() []string {
+	if x != nil {
+		return x.Roots
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetSkip() []string {
+	if x != nil {
+		return x.Skip
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetTopK() int32 {
+	if x != nil {
+		return x.TopK
+	}
+	return 0
+}
+
+func (x *ScanRequest) GetMaxDepth() int32 {
+	if x != nil {
+		return x.MaxDepth
+	}
+	return 0
+}
+
+func (x *ScanRequest) GetSkipHidden() bool {
+	if x != nil {
+		return x.SkipHidden
+	}
+	return false
+}
+
+func (x *ScanRequest) GetFollowLinks() bool {
+	if x != nil {
+		return x.FollowLinks
+	}
+	return false
+}
+
+type ScanEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*ScanEvent_Progress
+	//	*ScanEvent_Result
+	Event         isScanEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanEvent) Reset() {
+	*x = ScanEvent{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanEvent) ProtoMessage() {}
+
+func (x *ScanEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanEvent.ProtoReflect.Descriptor instead.
+func (*ScanEvent) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ScanEvent) GetEvent() isScanEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *ScanEvent) GetProgress() *ScanProgress {
+	if x != nil {
+		if x, ok := x.Event.(*ScanEvent_Progress); ok {
+			return x.Progress
+		}
+	}
+	return nil
+}
+
+func (x *ScanEvent) GetResult() *ScanResult {
+	if x != nil {
+		if x, ok := x.Event.(*ScanEvent_Result); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+type isScanEvent_Event interface {
+	isScanEvent_Event()
+}
+
+type ScanEvent_Progress struct {
+	Progress *ScanProgress `protobuf:"bytes,1,opt,name=progress,proto3,oneof"`
+}
+
+type ScanEvent_Result struct {
+	Result *ScanResult `protobuf:"bytes,2,opt,name=result,proto3,oneof"`
+}
+
+func (*ScanEvent_Progress) isScanEvent_Event() {}
+
+func (*ScanEvent_Result) isScanEvent_Event() {}
+
+// ScanProgress: the counters from the progress line.
+type ScanProgress struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ElapsedMs     int64                  `protobuf:"varint,1,opt,name=elapsed_ms,json=elapsedMs,proto3" json:"elapsed_ms,omitempty"`
+	FilesSeen     int64                  `protobuf:"varint,2,opt,name=files_seen,json=filesSeen,proto3" json:"files_seen,omitempty"`
+	DirsSeen      int64                  `protobuf:"varint,3,opt,name=dirs_seen,json=dirsSeen,proto3" json:"dirs_seen,omitempty"`
+	Skipped       int64                  `protobuf:"varint,4,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	Errors        int64                  `protobuf:"varint,5,opt,name=errors,proto3" json:"errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanProgress) Reset() {
+	*x = ScanProgress{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanProgress) ProtoMessage() {}
+
+func (x *ScanProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanProgress.ProtoReflect.Descriptor instead.
+func (*ScanProgress) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ScanProgress) GetElapsedMs() int64 {
+	if x != nil {
+		return x.ElapsedMs
+	}
+	return 0
+}
+
+func (x *ScanProgress) GetFilesSeen() int64 {
+	if x != nil {
+		return x.FilesSeen
+	}
+	return 0
+}
+
+func (x *ScanProgress) GetDirsSeen() int64 {
+	if x != nil {
+		return x.DirsSeen
+	}
+	return 0
+}
+
+func (x *ScanProgress) GetSkipped() int64 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *ScanProgress) GetErrors() int64 {
+	if x != nil {
+		return x.Errors
+	}
+	return 0
+}
+
+type Item struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rank          int32                  `protobuf:"varint,1,opt,name=rank,proto3" json:"rank,omitempty"`
+	SizeBytes     int64                  `protobuf:"varint,2,opt,name=size_bytes,json=sizeBytes,proto3" json:"size_bytes,omitempty"`
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
+	IsDir         bool                   `protobuf:"varint,4,opt,name=is_dir,json=isDir,proto3" json:"is_dir,omitempty"`
+	DrivePercent  float64                `protobuf:"fixed64,5,opt,name=drive_percent,json=drivePercent,proto3" json:"drive_percent,omitempty"` // 0 if unknown
+	LowerBound    bool                   `protobuf:"varint,6,opt,name=lower_bound,json=lowerBound,proto3" json:"lower_bound,omitempty"`        // size cut short by max_depth
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Item) Reset() {
+	*x = Item{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Item) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Item) ProtoMessage() {}
+
+func (x *Item) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Item.ProtoReflect.Descriptor instead.
+func (*Item) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Item) GetRank() int32 {
+	if x != nil {
+		return x.Rank
+	}
+	return 0
+}
+
+func (x *Item) GetSizeBytes() int64 {
+	if x != nil {
+		return x.SizeBytes
+	}
+	return 0
+}
+
+func (x *Item) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Item) GetIsDir() bool {
+	if x != nil {
+		return x.IsDir
+	}
+	return false
+}
+
+func (x *Item) GetDrivePercent() float64 {
+	if x != nil {
+		return x.DrivePercent
+	}
+	return 0
+}
+
+func (x *Item) GetLowerBound() bool {
+	if x != nil {
+		return x.LowerBound
+	}
+	return false
+}
+
+type RootStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Root          string                 `protobuf:"bytes,1,opt,name=root,proto3" json:"root,omitempty"`
+	Ok            bool                   `protobuf:"varint,2,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RootStatus) Reset() {
+	*x = RootStatus{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RootStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RootStatus) ProtoMessage() {}
+
+func (x *RootStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RootStatus.ProtoReflect.Descriptor instead.
+func (*RootStatus) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RootStatus) GetRoot() string {
+	if x != nil {
+		return x.Root
+	}
+	return ""
+}
+
+func (x *RootStatus) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *RootStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Stats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FilesSeen     int64                  `protobuf:"varint,1,opt,name=files_seen,json=filesSeen,proto3" json:"files_seen,omitempty"`
+	DirsSeen      int64                  `protobuf:"varint,2,opt,name=dirs_seen,json=dirsSeen,proto3" json:"dirs_seen,omitempty"`
+	Skipped       int64                  `protobuf:"varint,3,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	Errors        int64                  `protobuf:"varint,4,opt,name=errors,proto3" json:"errors,omitempty"`
+	DurationMs    int64                  `protobuf:"varint,5,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Stats) GetFilesSeen() int64 {
+	if x != nil {
+		return x.FilesSeen
+	}
+	return 0
+}
+
+func (x *Stats) GetDirsSeen() int64 {
+	if x != nil {
+		return x.DirsSeen
+	}
+	return 0
+}
+
+func (x *Stats) GetSkipped() int64 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *Stats) GetErrors() int64 {
+	if x != nil {
+		return x.Errors
+	}
+	return 0
+}
+
+func (x *Stats) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+type ScanResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Directories   []*Item                `protobuf:"bytes,1,rep,name=directories,proto3" json:"directories,omitempty"`
+	Files         []*Item                `protobuf:"bytes,2,rep,name=files,proto3" json:"files,omitempty"`
+	Stats         *Stats                 `protobuf:"bytes,3,opt,name=stats,proto3" json:"stats,omitempty"`
+	Roots         []*RootStatus          `protobuf:"bytes,4,rep,name=roots,proto3" json:"roots,omitempty"`
+	SchemaVersion string                 `protobuf:"bytes,5,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	RunId         string                 `protobuf:"bytes,6,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanResult) Reset() {
+	*x = ScanResult{}
+	mi := &file_gosizepb_gosize_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanResult) ProtoMessage() {}
+
+func (x *ScanResult) ProtoReflect() protoreflect.Message {
+	mi := &file_gosizepb_gosize_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanResult.ProtoReflect.Descriptor instead.
+func (*ScanResult) Descriptor() ([]byte, []int) {
+	return file_gosizepb_gosize_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ScanResult) GetDirectories() []*Item {
+	if x != nil {
+		return x.Directories
+	}
+	return nil
+}
+
+func (x *ScanResult) GetFiles() []*Item {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *ScanResult) GetStats() *Stats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+func (x *ScanResult) GetRoots() []*RootStatus {
+	if x != nil {
+		return x.Roots
+	}
+	return nil
+}
+
+func (x *ScanResult) GetSchemaVersion() string {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return ""
+}
+
+func (x *ScanResult) GetRunId() string {
+	if x != nil {
+		return x.RunId
+	}
+	return ""
+}
+
+var File_gosizepb_gosize_proto protoreflect.FileDescriptor
+
+const file_gosizepb_gosize_proto_rawDesc = "" +
+	"\n" +
+	"\x15gosizepb/gosize.proto\x12\tgosize.v1\"\xad\x01\n" +
+	"\vScanRequest\x12\x14\n" +
+	"\x05roots\x18\x01 \x03(\tR\x05roots\x12\x12\n" +
+	"\x04skip\x18\x02 \x03(\tR\x04skip\x12\x13\n" +
+	"\x05top_k\x18\x03 \x01(\x05R\x04topK\x12\x1b\n" +
+	"\tmax_depth\x18\x04 \x01(\x05R\bmaxDepth\x12\x1f\n" +
+	"\vskip_hidden\x18\x05 \x01(\bR\n" +
+	"skipHidden\x12!\n" +
+	"\ffollow_links\x18\x06 \x01(\bR\vfollowLinks\"|\n" +
+	"\tScanEvent\x125\n" +
+	"\bprogress\x18\x01 \x01(\v2\x17.gosize.v1.ScanProgressH\x00R\bprogress\x12/\n" +
+	"\x06result\x18\x02 \x01(\v2\x15.gosize.v1.ScanResultH\x00R\x06resultB\a\n" +
+	"\x05event\"\x9b\x01\n" +
+	"\fScanProgress\x12\x1d\n" +
+	"\n" +
+	"elapsed_ms\x18\x01 \x01(\x03R\telapsedMs\x12\x1d\n" +
+	"\n" +
+	"files_seen\x18\x02 \x01(\x03R\tfilesSeen\x12\x1b\n" +
+	"\tdirs_seen\x18\x03 \x01(\x03R\bdirsSeen\x12\x18\n" +
+	"\askipped\x18\x04 \x01(\x03R\askipped\x12\x16\n" +
+	"\x06errors\x18\x05 \x01(\x03R\x06errors\"\xaa\x01\n" +
+	"\x04Item\x12\x12\n" +
+	"\x04rank\x18\x01 \x01(\x05R\x04rank\x12\x1d\n" +
+	"\n" +
+	"size_bytes\x18\x02 \x01(\x03R\tsizeBytes\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x15\n" +
+	"\x06is_dir\x18\x04 \x01(\bR\x05isDir\x12#\n" +
+	"\rdrive_percent\x18\x05 \x01(\x01R\fdrivePercent\x12\x1f\n" +
+	"\vlower_bound\x18\x06 \x01(\bR\n" +
+	"lowerBound\"F\n" +
+	"\n" +
+	"RootStatus\x12\x12\n" +
+	"\x04root\x18\x01 \x01(\tR\x04root\x12\x0e\n" +
+	"\x02ok\x18\x02 \x01(\bR\x02ok\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\x96\x01\n" +
+	"\x05Stats\x12\x1d\n" +
+	"\n" +
+	"files_seen\x18\x01 \x01(\x03R\tfilesSeen\x12\x1b\n" +
+	"\tdirs_seen\x18\x02 \x01(\x03R\bdirsSeen\x12\x18\n" +
+	"\askipped\x18\x03 \x01(\x03R\askipped\x12\x16\n" +
+	"\x06errors\x18\x04 \x01(\x03R\x06errors\x12\x1f\n" +
+	"\vduration_ms\x18\x05 \x01(\x03R\n" +
+	"durationMs\"\xf9\x01\n" +
+	"\n" +
+	"ScanResult\x121\n" +
+	"\vdirectories\x18\x01 \x03(\v2\x0f.gosize.v1.ItemR\vdirectories\x12%\n" +
+	"\x05files\x18\x02 \x03(\v2\x0f.gosize.v1.ItemR\x05files\x12&\n" +
+	"\x05stats\x18\x03 \x01(\v2\x10.gosize.v1.StatsR\x05stats\x12+\n" +
+	"\x05roots\x18\x04 \x03(\v2\x15.gosize.v1.RootStatusR\x05roots\x12%\n" +
+	"\x0eschema_version\x18\x05 \x01(\tR\rschemaVersion\x12\x15\n" +
+	"\x06run_id\x18\x06 \x01(\tR\x05runId2@\n" +
+	"\x06GoSize\x126\n" +
+	"\x04Scan\x12\x16.gosize.v1.ScanRequest\x1a\x14.gosize.v1.ScanEvent0\x01B\x12Z\x10disktop/gosizepbb\x06proto3"
+
+var (
+	file_gosizepb_gosize_proto_rawDescOnce sync.Once
+	file_gosizepb_gosize_proto_rawDescData []byte
+)
+
+func file_gosizepb_gosize_proto_rawDescGZIP() []byte {
+	file_gosizepb_gosize_proto_rawDescOnce.Do(func() {
+		file_gosizepb_gosize_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gosizepb_gosize_proto_rawDesc), len(file_gosizepb_gosize_proto_rawDesc)))
+	})
+	return file_gosizepb_gosize_proto_rawDescData
+}
+
+var file_gosizepb_gosize_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_gosizepb_gosize_proto_goTypes = []any{
+	(*ScanRequest)(nil),  // 0: gosize.v1.ScanRequest
+	(*ScanEvent)(nil),    // 1: gosize.v1.ScanEvent
+	(*ScanProgress)(nil), // 2: gosize.v1.ScanProgress
+	(*Item)(nil),         // 3: gosize.v1.Item
+	(*RootStatus)(nil),   // 4: gosize.v1.RootStatus
+	(*Stats)(nil),        // 5: gosize.v1.Stats
+	(*ScanResult)(nil),   // 6: gosize.v1.ScanResult
+}
+var file_gosizepb_gosize_proto_depIdxs = []int32{
+	2, // 0: gosize.v1.ScanEvent.progress:type_name -> gosize.v1.ScanProgress
+	6, // 1: gosize.v1.ScanEvent.result:type_name -> gosize.v1.ScanResult
+	3, // 2: gosize.v1.ScanResult.directories:type_name -> gosize.v1.Item
+	3, // 3: gosize.v1.ScanResult.files:type_name -> gosize.v1.Item
+	5, // 4: gosize.v1.ScanResult.stats:type_name -> gosize.v1.Stats
+	4, // 5: gosize.v1.ScanResult.roots:type_name -> gosize.v1.RootStatus
+	0, // 6: gosize.v1.GoSize.Scan:input_type -> gosize.v1.ScanRequest
+	1, // 7: gosize.v1.GoSize.Scan:output_type -> gosize.v1.ScanEvent
+	7, // [7:8] is the sub-list for method output_type
+	6, // [6:7] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_gosizepb_gosize_proto_init() }
+func file_gosizepb_gosize_proto_init() {
+	if File_gosizepb_gosize_proto != nil {
+		return
+	}
+	file_gosizepb_gosize_proto_msgTypes[1].OneofWrappers = []any{
+		(*ScanEvent_Progress)(nil),
+		(*ScanEvent_Result)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gosizepb_gosize_proto_rawDesc), len(file_gosizepb_gosize_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_gosizepb_gosize_proto_goTypes,
+		DependencyIndexes: file_gosizepb_gosize_proto_depIdxs,
+		MessageInfos:      file_gosizepb_gosize_proto_msgTypes,
+	}.Build()
+	File_gosizepb_gosize_proto = out.File
+	file_gosizepb_gosize_proto_goTypes = nil
+	file_gosizepb_gosize_proto_depIdxs = nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"disktop/gosizepb"
+)
+
+// ########### GRPC: REMOTE SCANS ##################
+// -grpc=:9000 serves gosizepb.GoSize (see gosizepb/gosize.proto). Each Scan
+// call runs one scan through startScan, streams progress on the same
+// cadence as the control pipe, and finishes with a single result. The
+// call's context is the scan's context, so a client cancel stops the walk.
+//
+// A scan reads any path the account GoSize runs under can, so the API is
+// closed by default: an address without a host listens on 127.0.0.1, and
+// any other host but loopback is refused unless the server has TLS and
+// checks its clients, by certificate (-grpc-client-ca) or by a bearer
+// token (-grpc-token-file).
+type grpcServer struct {
+	gosizepb.UnimplementedGoSizeServer
+	roots []string // defaults from -roots
+	cfg   walkCfg
+	slots chan struct{} // one token per running scan (-grpc-max-scans)
+}
+
+// grpcOptions: -grpc and the flags that go with it.
+type grpcOptions struct {
+	addr              string
+	certFile, keyFile string // TLS is on when both are given
+	clientCA          string // require client certificates issued by this CA (mTLS)
+	tokenFile         string // require "authorization: Bearer <the file's token>"
+	maxScans          int
+}
+
+// serveGRPC: serves until the process exits.
+func serveGRPC(o grpcOptions, roots []string, cfg walkCfg) error {
+	srv, lis, err := listenGRPC(o, roots, cfg)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(lis)
+}
+
+// listenGRPC: the server for o, and its listener.
+func listenGRPC(o grpcOptions, roots []string, cfg walkCfg) (*grpc.Server, net.Listener, error) {
+	addr, opts, err := grpcServerOptions(o)
+	if err != nil {
+		return nil, nil, err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.showProgress = false // progress goes to the stream, not stderr
+	srv := grpc.NewServer(opts...)
+	gosizepb.RegisterGoSizeServer(srv, &grpcServer{roots: roots, cfg: cfg, slots: make(chan struct{}, max(o.maxScans, 1))})
+	return srv, lis, nil
+}
+
+// grpcServerOptions: the address to listen on and the TLS and token
+// options for o; an error for an address o leaves open to anyone.
+func grpcServerOptions(o grpcOptions) (string, []grpc.ServerOption, error) {
+	addr, loopback, err := grpcListenAddr(o.addr)
+	if err != nil {
+		return "", nil, err
+	}
+	var opts []grpc.ServerOption
+	tlsOn := o.certFile != "" || o.keyFile != ""
+	if tlsOn {
+		creds, err := grpcTLS(o)
+		if err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	} else if o.clientCA != "" {
+		return "", nil, errors.New("-grpc-client-ca needs -grpc-cert and -grpc-key")
+	}
+	if o.tokenFile != "" {
+		token, err := readToken(o.tokenFile)
+		if err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, grpc.StreamInterceptor(tokenAuth(token)))
+	}
+	if !loopback && (!tlsOn || o.clientCA == "" && o.tokenFile == "") {
+		return "", nil, fmt.Errorf("%s is not a loopback address: serving it needs -grpc-cert and -grpc-key, and -grpc-client-ca or -grpc-token-file", addr)
+	}
+	return addr, opts, nil
+}
+
+// grpcListenAddr: addr with an empty host made 127.0.0.1, and whether it
+// only takes connections from this machine.
+func grpcListenAddr(addr string) (string, bool, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	ip := net.ParseIP(host)
+	loopback := strings.EqualFold(host, "localhost") || ip != nil && ip.IsLoopback()
+	return net.JoinHostPort(host, port), loopback, nil
+}
+
+// grpcTLS: the server's certificate, and with a client CA, mTLS.
+func grpcTLS(o grpcOptions) (credentials.TransportCredentials, error) {
+	if o.certFile == "" || o.keyFile == "" {
+		return nil, errors.New("-grpc-cert and -grpc-key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if o.clientCA != "" {
+		pem, err := os.ReadFile(o.clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates", o.clientCA)
+		}
+		tc.ClientCAs, tc.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(tc), nil
+}
+
+// readToken: the first line of a -grpc-token-file.
+func readToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token, _, _ := strings.Cut(string(data), "\n")
+	if token = strings.TrimSpace(token); token == "" {
+		return "", fmt.Errorf("%s: empty token", path)
+	}
+	return token, nil
+}
+
+// tokenAuth: refuses calls whose "authorization" metadata isn't
+// "Bearer <token>".
+func tokenAuth(token string) grpc.StreamServerInterceptor {
+	want := []byte("Bearer " + token)
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		for _, got := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(got), want) == 1 {
+				return handler(srv, ss)
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+	}
+}
+
+func (g *grpcServer) Scan(req *gosizepb.ScanRequest, stream gosizepb.GoSize_ScanServer) error {
+	select {
+	case g.slots <- struct{}{}:
+		defer func() { <-g.slots }()
+	default:
+		return status.Errorf(codes.ResourceExhausted, "server is already running %d scan(s)", cap(g.slots))
+	}
+
+	roots, cfg, err := g.requestCfg(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	sc := startScan(ctx, roots, cfg)
+
+	t := time.NewTicker(controlPushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-sc.done:
+			if err := ctx.Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			return stream.Send(&gosizepb.ScanEvent{Event: &gosizepb.ScanEvent_Result{Result: grpcResult(sc)}})
+		case <-t.C:
+			ev := &gosizepb.ScanEvent{Event: &gosizepb.ScanEvent_Progress{Progress: grpcProgress(sc)}}
+			if err := stream.Send(ev); err != nil {
+				cancel()
+				sc.wait()
+				return err
+			}
+		}
+	}
+}
+
+// requestCfg: the server's flag configuration with the request's fields
+// laid over it. Booleans can only switch an option on.
+func (g *grpcServer) requestCfg(req *gosizepb.ScanRequest) ([]string, walkCfg, error) {
+	cfg := g.cfg
+	roots := g.roots
+	if len(req.GetRoots()) > 0 {
+		var err error
+		if roots, err = resolveRoots(strings.Join(req.GetRoots(), ",")); err != nil {
+			return nil, cfg, err
+		}
+		for _, r := range roots {
+			if err := checkRoot(r); err != nil {
+				return nil, cfg, err
+			}
+		}
+	}
+	if len(req.GetSkip()) > 0 {
+		cfg.skipPatterns = req.GetSkip()
+	}
+	if req.GetTopK() > 0 {
+		cfg.topK = int(req.GetTopK())
+	}
+	if req.GetMaxDepth() > 0 {
+		cfg.maxDepth = int(req.GetMaxDepth())
+	}
+	cfg.skipHidden = cfg.skipHidden || req.GetSkipHidden()
+	if req.GetFollowLinks() && !cfg.followLinks {
+		cfg.followLinks, cfg.reparse = true, cfg.reparse.withLinks()
+	}
+	return roots, cfg, nil
+}
+
+func grpcProgress(sc *scan) *gosizepb.ScanProgress {
+	s := &sc.stats
+	return &gosizepb.ScanProgress{
+		ElapsedMs: time.Since(sc.start).Milliseconds(),
+		FilesSeen: atomic.LoadInt64(&s.filesSeen),
+		DirsSeen:  atomic.LoadInt64(&s.dirsSeen),
+		Skipped:   atomic.LoadInt64(&s.skipped),
+		Errors:    atomic.LoadInt64(&s.errors),
+	}
+}
+
+func grpcResult(sc *scan) *gosizepb.ScanResult {
+	dsc := newDriveSpaceCache()
+	toItems := func(items []item) []*gosizepb.Item {
+		out := make([]*gosizepb.Item, 0, len(items))
+		for i, it := range items {
+			pct := 0.0
+			if tot := dsc.totalFor(it.Path); tot > 0 {
+				pct = (float64(it.Size) / float64(tot)) * 100
+			}
+			out = append(out, &gosizepb.Item{
+				Rank:         int32(i + 1),
+				SizeBytes:    it.Size,
+				Path:         it.Path,
+				IsDir:        it.IsDir,
+				DrivePercent: pct,
+				LowerBound:   it.Partial,
+			})
+		}
+		return out
+	}
+
+	s := &sc.stats
+	res := &gosizepb.ScanResult{
+		SchemaVersion: schemaVersion,
+		RunId:         sc.runID,
+		Files:         toItems(sc.fileTop.sortedDesc()),
+		Stats: &gosizepb.Stats{
+			FilesSeen:  atomic.LoadInt64(&s.filesSeen),
+			DirsSeen:   atomic.LoadInt64(&s.dirsSeen),
+			Skipped:    atomic.LoadInt64(&s.skipped),
+			Errors:     atomic.LoadInt64(&s.errors),
+			DurationMs: sc.elapsed.Milliseconds(),
+		},
+	}
+	if !sc.cfg.combined {
+		res.Directories = toItems(sc.dirTop.sortedDesc())
+	}
+	for i, r := range sc.roots {
+		st := &gosizepb.RootStatus{Root: r, Ok: sc.rootErrs[i] == nil}
+		if sc.rootErrs[i] != nil {
+			st.Error = sc.rootErrs[i].Error()
+		}
+		res.Roots = append(res.Roots, st)
+	}
+	return res
+}
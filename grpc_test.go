@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"disktop/gosizepb"
+)
+
+// startGRPC serves o (on a free loopback port unless o.addr is set) for
+// the length of the test and returns a client for it.
+func startGRPC(t *testing.T, o grpcOptions, roots []string, dial ...grpc.DialOption) gosizepb.GoSizeClient {
+	t.Helper()
+	if o.addr == "" {
+		o.addr = "127.0.0.1:0"
+	}
+	srv, lis, err := listenGRPC(o, roots, testCfg())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	if len(dial) == 0 {
+		dial = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(lis.Addr().String(), dial...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return gosizepb.NewGoSizeClient(conn)
+}
+
+// scanOnce runs one Scan call to its end: the progress events seen and
+// the result.
+func scanOnce(ctx context.Context, c gosizepb.GoSizeClient, req *gosizepb.ScanRequest) (int, *gosizepb.ScanResult, error) {
+	stream, err := c.Scan(ctx, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	progress := 0
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			return progress, nil, err
+		}
+		if ev.GetProgress() != nil {
+			progress++
+		}
+		if res := ev.GetResult(); res != nil {
+			if _, err := stream.Recv(); err != io.EOF {
+				return progress, res, errors.New("more after the result")
+			}
+			return progress, res, nil
+		}
+	}
+}
+
+func grpcTree(t *testing.T) string {
+	return writeTree(t, map[string]int{"a/f1": 100, "a/f2": 200, "b/g": 300, "b/c/h": 50})
+}
+
+func TestGRPCScan(t *testing.T) {
+	root := grpcTree(t)
+	c := startGRPC(t, grpcOptions{}, []string{root})
+
+	release := holdListing(t, filepath.Join(root, "b"))
+	time.AfterFunc(2*controlPushInterval, release) // long enough for progress events
+	progress, res, err := scanOnce(context.Background(), c, &gosizepb.ScanRequest{TopK: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress == 0 {
+		t.Error("no progress events while the scan was held")
+	}
+	if st := res.GetStats(); st.GetFilesSeen() != 4 || st.GetDirsSeen() != 4 {
+		t.Errorf("stats %v, want 4 files in 4 directories", st)
+	}
+	var files []string
+	for _, it := range res.GetFiles() {
+		files = append(files, filepath.Base(it.GetPath()))
+	}
+	if strings.Join(files, ",") != "g,f2" {
+		t.Errorf("top files %v, want g,f2 (top_k 2)", files)
+	}
+	if dirs := res.GetDirectories(); len(dirs) != 2 || dirs[0].GetSizeBytes() != 350 || dirs[1].GetSizeBytes() != 300 {
+		t.Errorf("top directories %v, want b (350) and a (300)", dirs)
+	}
+	if len(res.GetRoots()) != 1 || !res.GetRoots()[0].GetOk() {
+		t.Errorf("roots %v", res.GetRoots())
+	}
+}
+
+// Cancelling the call stops the walk and frees its slot.
+func TestGRPCCancel(t *testing.T) {
+	root := grpcTree(t)
+	c := startGRPC(t, grpcOptions{maxScans: 1}, []string{root})
+
+	release := holdListing(t, filepath.Join(root, "b"))
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.Scan(ctx, &gosizepb.ScanRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil { // a progress event: the scan is running
+		t.Fatal(err)
+	}
+	if _, _, err := scanOnce(context.Background(), c, &gosizepb.ScanRequest{}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second scan with -grpc-max-scans=1: %v, want ResourceExhausted", err)
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("after cancel: %v, want Canceled", err)
+	}
+	release()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		_, _, err := scanOnce(context.Background(), c, &gosizepb.ScanRequest{})
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.ResourceExhausted || time.Now().After(deadline) {
+			t.Fatalf("scan after a cancelled one: %v", err)
+		}
+	}
+}
+
+func TestGRPCToken(t *testing.T) {
+	root := grpcTree(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := startGRPC(t, grpcOptions{tokenFile: tokenFile}, []string{root})
+
+	for _, auth := range []string{"", "Bearer wrong", "s3cret"} {
+		ctx := context.Background()
+		if auth != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", auth)
+		}
+		if _, _, err := scanOnce(ctx, c, &gosizepb.ScanRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("authorization %q: %v, want Unauthenticated", auth, err)
+		}
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+	if _, _, err := scanOnce(ctx, c, &gosizepb.ScanRequest{}); err != nil {
+		t.Errorf("with the token: %v", err)
+	}
+}
+
+func TestGRPCListenAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		want     string
+		loopback bool
+	}{
+		{":9000", "127.0.0.1:9000", true},
+		{"localhost:9000", "localhost:9000", true},
+		{"[::1]:9000", "[::1]:9000", true},
+		{"0.0.0.0:9000", "0.0.0.0:9000", false},
+		{"192.168.1.5:9000", "192.168.1.5:9000", false},
+		{"host.example:9000", "host.example:9000", false},
+	}
+	for _, tt := range tests {
+		got, loopback, err := grpcListenAddr(tt.addr)
+		if err != nil || got != tt.want || loopback != tt.loopback {
+			t.Errorf("grpcListenAddr(%q) = %q, %v, %v; want %q, %v", tt.addr, got, loopback, err, tt.want, tt.loopback)
+		}
+	}
+}
+
+// A non-loopback address needs TLS and a client check.
+func TestGRPCRefusesOpenServer(t *testing.T) {
+	dir := testCerts(t)
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tlsOn := grpcOptions{certFile: filepath.Join(dir, "server.pem"), keyFile: filepath.Join(dir, "server.key")}
+	withCA, withToken := tlsOn, tlsOn
+	withCA.clientCA = filepath.Join(dir, "ca.pem")
+	withToken.tokenFile = tokenFile
+	plainToken := grpcOptions{tokenFile: tokenFile}
+
+	for _, tt := range []struct {
+		name string
+		o    grpcOptions
+		ok   bool
+	}{
+		{"plain", grpcOptions{}, false},
+		{"token without TLS", plainToken, false},
+		{"TLS alone", tlsOn, false},
+		{"TLS and client CA", withCA, true},
+		{"TLS and token", withToken, true},
+	} {
+		tt.o.addr = "0.0.0.0:9000"
+		if _, _, err := grpcServerOptions(tt.o); (err == nil) != tt.ok {
+			t.Errorf("%s on 0.0.0.0: err %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// With -grpc-client-ca only clients holding a certificate from that CA
+// get through.
+func TestGRPCMutualTLS(t *testing.T) {
+	root := grpcTree(t)
+	dir := testCerts(t)
+	o := grpcOptions{certFile: filepath.Join(dir, "server.pem"), keyFile: filepath.Join(dir, "server.key"), clientCA: filepath.Join(dir, "ca.pem")}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anon := startGRPC(t, o, []string{root}, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+	if _, _, err := scanOnce(context.Background(), anon, &gosizepb.ScanRequest{}); err == nil {
+		t.Error("a client without a certificate was served")
+	}
+	known := startGRPC(t, o, []string{root}, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}})))
+	if _, res, err := scanOnce(context.Background(), known, &gosizepb.ScanRequest{}); err != nil || res.GetStats().GetFilesSeen() != 4 {
+		t.Errorf("client with a certificate: %v, %v", res.GetStats(), err)
+	}
+}
+
+// testCerts writes ca.pem, server.pem/.key (for 127.0.0.1) and
+// client.pem/.key, both issued by the CA, to a temporary directory.
+func testCerts(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	write := func(name, typ string, der []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpl := func(serial int64, cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+	}
+
+	caKey := newKey()
+	ca := tmpl(1, "gosize test CA")
+	ca.IsCA, ca.BasicConstraintsValid, ca.KeyUsage = true, true, x509.KeyUsageCertSign
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("ca.pem", "CERTIFICATE", caDER)
+
+	leaf := func(name string, serial int64, usage x509.ExtKeyUsage) {
+		key := newKey()
+		c := tmpl(serial, name)
+		c.ExtKeyUsage, c.KeyUsage = []x509.ExtKeyUsage{usage}, x509.KeyUsageDigitalSignature
+		c.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		der, err := x509.CreateCertificate(rand.Reader, c, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(name+".pem", "CERTIFICATE", der)
+		write(name+".key", "PRIVATE KEY", keyDER)
+	}
+	leaf("server", 2, x509.ExtKeyUsageServerAuth)
+	leaf("client", 3, x509.ExtKeyUsageClientAuth)
+	return dir
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ########### SIZE INDEX: MODEL ##################
+// -index-serve keeps the total of every directory under -roots in memory
+// and answers -query over \\.\pipe\NAME (-index-pipe) without touching the
+// filesystem. After one full scan the index follows each volume's NTFS
+// change journal: a directory with a change in it is rescanned and its
+// ancestors adjusted, and a query first catches up with what the journal
+// recorded since. The index and the journal positions are saved to
+// -index-file, so a restarted service catches up from there instead of
+// rescanning. Roots without a journal (network shares, FAT, no
+// administrator rights) are rescanned every -index-refresh instead.
+
+// defaultIndexPipe: the pipe name shared by -index-serve and -query.
+const defaultIndexPipe = "gosize-index"
+
+// indexVersion: bumped when the -index-file layout changes.
+const indexVersion = 1
+
+// dirIndex: every directory total from one scan, and the catch-ups since.
+type dirIndex struct {
+	mu       sync.Mutex
+	Version  int         `json:"version"`
+	Roots    []string    `json:"roots"`
+	BuiltAt  time.Time   `json:"builtAt"`
+	Updated  time.Time   `json:"updated,omitzero"`   // last journal catch-up
+	Journals []usnCursor `json:"journals,omitempty"` // one per volume; none when a root has no journal
+	Dirs     []indexRow  `json:"dirs"`
+}
+
+// indexRow: one directory in the index, and one row of a query answer.
+type indexRow struct {
+	Path       string `json:"path"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	Files      int64  `json:"files"`
+	LowerBound bool   `json:"lowerBound,omitempty"`
+}
+
+// record adds a finished directory; called by walkDir for every one.
+func (x *dirIndex) record(path string, agg dirAgg) {
+	x.mu.Lock()
+	x.Dirs = append(x.Dirs, indexRow{Path: path, SizeBytes: agg.size, Files: agg.files, LowerBound: agg.partial || agg.netLost})
+	x.mu.Unlock()
+}
+
+// query: the top largest directories at or below under, largest first.
+// An index is read-only once built (a catch-up makes a new one), so no
+// lock is needed.
+func (x *dirIndex) query(under string, top int) []indexRow {
+	var out []indexRow
+	for _, r := range x.Dirs {
+		if isUnder(r.Path, under) {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SizeBytes > out[j].SizeBytes })
+	if top > 0 && len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
+
+// covers: whether under lies inside one of the indexed roots.
+func (x *dirIndex) covers(under string) bool {
+	for _, r := range x.Roots {
+		if isUnder(under, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadIndex(path string) (*dirIndex, error) {
+	data, err := readMaybeGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	var x dirIndex
+	if err := json.Unmarshal(data, &x); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if x.Version != indexVersion {
+		return nil, fmt.Errorf("%s: index version %d, want %d", path, x.Version, indexVersion)
+	}
+	return &x, nil
+}
+
+// replace: a copy of x with the subtree at dir replaced by rows, from a
+// rescan of it, and dir's ancestors' totals moved by the difference. rows
+// without dir itself mean it is gone.
+func (x *dirIndex) replace(dir string, rows []indexRow) *dirIndex {
+	var old, cur indexRow
+	for _, r := range x.Dirs {
+		if pathKey(r.Path) == pathKey(dir) {
+			old = r
+		}
+	}
+	for _, r := range rows {
+		if pathKey(r.Path) == pathKey(dir) {
+			cur = r
+		}
+	}
+	y := &dirIndex{Version: x.Version, Roots: x.Roots, BuiltAt: x.BuiltAt, Updated: x.Updated, Journals: x.Journals}
+	y.Dirs = make([]indexRow, 0, len(x.Dirs)+len(rows))
+	for _, r := range x.Dirs {
+		switch {
+		case isUnder(r.Path, dir):
+			continue
+		case isUnder(dir, r.Path):
+			r.SizeBytes += cur.SizeBytes - old.SizeBytes
+			r.Files += cur.Files - old.Files
+			r.LowerBound = r.LowerBound || cur.LowerBound
+		}
+		y.Dirs = append(y.Dirs, r)
+	}
+	y.Dirs = append(y.Dirs, rows...)
+	return y
+}
+
+func (x *dirIndex) save(path string) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// ########### SIZE INDEX: SERVICE ##################
+// indexQuery: one request line from a -query client.
+type indexQuery struct {
+	Cmd   string `json:"cmd"` // query or status
+	Under string `json:"under,omitempty"`
+	Top   int    `json:"top,omitempty"`
+}
+
+// indexReply: one reply line.
+type indexReply struct {
+	Event       string     `json:"event"` // query, status, or error
+	BuiltAt     time.Time  `json:"builtAt,omitzero"`
+	Updated     time.Time  `json:"updated,omitzero"` // last journal catch-up
+	Roots       []string   `json:"roots,omitempty"`
+	Indexed     int        `json:"indexedDirs,omitempty"`
+	Scanning    bool       `json:"scanning,omitempty"` // a rebuild is running
+	Directories []indexRow `json:"directories,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// indexServer: the current index and the rebuild loop.
+type indexServer struct {
+	roots   []string
+	cfg     walkCfg
+	file    string // -index-file; "" = memory only
+	refresh time.Duration
+
+	mu       sync.Mutex
+	cur      *dirIndex // nil until the first scan finishes or a file loads
+	scanning bool
+
+	catching sync.Mutex // one catch-up at a time
+}
+
+// serveIndex: runs the -index-serve service until the process exits.
+func serveIndex(name string, roots []string, cfg walkCfg, file string, refresh time.Duration) error {
+	cfg.showProgress = false
+	srv := &indexServer{roots: roots, cfg: cfg, file: file, refresh: refresh}
+	if file != "" {
+		x, err := loadIndex(file)
+		switch {
+		case err == nil:
+			srv.cur = x
+			fmt.Fprintf(os.Stderr, "index: %d directories from %s (built %s)\n", len(x.Dirs), file, x.BuiltAt.Format(time.DateTime))
+		case isCorrupt(err):
+			fmt.Fprintln(os.Stderr, "index: corrupt, ignoring it:", err)
+		case !errors.Is(err, os.ErrNotExist):
+			fmt.Fprintln(os.Stderr, "index:", err)
+		}
+	}
+	go srv.maintain()
+	return srv.serve(name)
+}
+
+// serve answers clients on \\.\pipe\NAME. Every client gets a pipe
+// instance of its own, so a slow one holds up nobody else; there is always
+// one more waiting for the next.
+func (srv *indexServer) serve(name string) error {
+	for first := true; ; first = false {
+		h, err := createPipe(name, windows.PIPE_UNLIMITED_INSTANCES, first)
+		if err != nil {
+			return err
+		}
+		conn := &pipeConn{h: h}
+		if err := conn.accept(); err != nil {
+			windows.CloseHandle(h)
+			return err
+		}
+		go func() {
+			defer windows.CloseHandle(h)
+			srv.session(conn)
+			windows.DisconnectNamedPipe(h)
+		}()
+	}
+}
+
+// indexCatchUp: how often the service reads the change journals between
+// queries, so it never falls far behind.
+const indexCatchUp = 10 * time.Second
+
+// maintain keeps the index current: from the journals as long as they
+// can tell what changed, else by a full scan, the first one right away
+// and later ones every refresh.
+func (srv *indexServer) maintain() {
+	var scanned time.Time // zero: not since the service started
+	for {
+		x := srv.index()
+		switch {
+		case x != nil && x.Journals != nil:
+			err := srv.catchUp()
+			if err == nil {
+				time.Sleep(indexCatchUp)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "index:", err, "- rescanning")
+		case scanned.IsZero():
+		case srv.refresh <= 0:
+			return
+		case time.Since(scanned) < srv.refresh:
+			time.Sleep(srv.refresh - time.Since(scanned))
+			continue
+		}
+		srv.rebuild()
+		scanned = time.Now()
+	}
+}
+
+// index: the current index, or nil.
+func (srv *indexServer) index() *dirIndex {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.cur
+}
+
+func (srv *indexServer) rebuild() {
+	srv.mu.Lock()
+	srv.scanning = true
+	srv.mu.Unlock()
+
+	// The journals' positions from before the scan: changes made while
+	// it runs are applied again by the first catch-up, which is harmless.
+	journals, err := journalCursors(srv.roots)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "index:", err, "- rescanning every -index-refresh instead")
+	}
+	x := &dirIndex{Version: indexVersion, Roots: srv.roots, Journals: journals}
+	cfg := srv.cfg
+	cfg.index = x
+	sc := startScan(context.Background(), srv.roots, cfg)
+	sc.wait()
+	x.BuiltAt = time.Now()
+
+	srv.mu.Lock()
+	srv.cur, srv.scanning = x, false
+	srv.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "index: %d directories in %s\n", len(x.Dirs), sc.elapsed.Truncate(time.Millisecond))
+	if srv.file != "" {
+		if err := x.save(srv.file); err != nil {
+			fmt.Fprintln(os.Stderr, "index:", err)
+		}
+	}
+}
+
+// indexIdle: how long a client may take to send its next request, or to
+// read a reply, before the service hangs up.
+var indexIdle = 30 * time.Second
+
+// session: answers request lines until the client disconnects or stays
+// idle for indexIdle.
+func (srv *indexServer) session(rw io.ReadWriter) {
+	enc := json.NewEncoder(rw)
+	sc := bufio.NewScanner(rw)
+	d, _ := rw.(interface{ SetDeadline(time.Time) error })
+	idle := func() {
+		if d != nil {
+			d.SetDeadline(time.Now().Add(indexIdle))
+		}
+	}
+	for idle(); sc.Scan(); idle() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var q indexQuery
+		if err := json.Unmarshal([]byte(line), &q); err != nil {
+			_ = enc.Encode(indexReply{Event: "error", Error: "bad request: " + err.Error()})
+			continue
+		}
+		rep := srv.answer(q)
+		idle()
+		_ = enc.Encode(rep)
+	}
+}
+
+func (srv *indexServer) answer(q indexQuery) indexReply {
+	if x := srv.index(); q.Cmd == "query" && x != nil && x.Journals != nil {
+		_ = srv.catchUp() // on failure, answer from what there is; maintain rescans
+	}
+	srv.mu.Lock()
+	x, scanning := srv.cur, srv.scanning
+	srv.mu.Unlock()
+
+	rep := indexReply{Event: q.Cmd, Scanning: scanning}
+	if x != nil {
+		rep.BuiltAt, rep.Updated, rep.Roots, rep.Indexed = x.BuiltAt, x.Updated, x.Roots, len(x.Dirs)
+	}
+	switch q.Cmd {
+	case "status":
+	case "query":
+		switch {
+		case x == nil:
+			rep.Event, rep.Error = "error", "no index yet; the first scan is still running"
+		case !x.covers(q.Under):
+			rep.Event, rep.Error = "error", q.Under+" is outside the indexed roots"
+		default:
+			rep.Directories = x.query(q.Under, q.Top)
+		}
+	default:
+		rep.Event, rep.Error = "error", "unknown command "+q.Cmd
+	}
+	return rep
+}
+
+// ########### SIZE INDEX: CHANGE JOURNAL ##################
+// usnCursor: where catch-up goes on reading a volume's change journal.
+type usnCursor struct {
+	Volume string `json:"volume"` // C:\
+	ID     uint64 `json:"id"`     // the journal's instance; a new one means records were lost
+	Next   int64  `json:"next"`   // first USN not applied yet
+}
+
+const (
+	fsctlQueryUsnJournal = 0x000900f4 // FSCTL_QUERY_USN_JOURNAL
+	fsctlReadUsnJournal  = 0x000900bb // FSCTL_READ_USN_JOURNAL
+	volumeNameDOS        = 0x0        // VOLUME_NAME_DOS: C:\..., not \Device\...
+
+	// usnChanges: the USN_REASON_* bits that can change a total or what a
+	// walk counts: data written or cut, streams, create, delete, rename,
+	// attributes (hidden, compressed), hard links, reparse points.
+	usnChanges = 0x1 | 0x2 | 0x4 | 0x10 | 0x20 | 0x40 | 0x100 | 0x200 | 0x1000 | 0x2000 |
+		0x8000 | 0x10000 | 0x20000 | 0x100000 | 0x200000
+)
+
+var procOpenFileById = windows.NewLazySystemDLL("kernel32.dll").NewProc("OpenFileById")
+
+// errJournalLost: the journal no longer has every record since the cursor.
+var errJournalLost = errors.New("change journal was reset or has wrapped")
+
+// journalCursors: each root volume's journal as it stands now; an error
+// when any root has none.
+func journalCursors(roots []string) ([]usnCursor, error) {
+	var out []usnCursor
+	seen := make(map[string]bool)
+	for _, r := range roots {
+		vol := volumeRoot(r)
+		if vol == "" || strings.HasPrefix(vol, `\\`) {
+			return nil, fmt.Errorf("%s: no change journal on a network share", r)
+		}
+		if seen[pathKey(vol)] {
+			continue
+		}
+		seen[pathKey(vol)] = true
+		h, err := openVolume(vol)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", vol, err)
+		}
+		id, next, err := queryJournal(h)
+		windows.CloseHandle(h)
+		if err != nil {
+			return nil, fmt.Errorf("%s: change journal: %w", vol, err)
+		}
+		out = append(out, usnCursor{Volume: vol, ID: id, Next: next})
+	}
+	return out, nil
+}
+
+// openVolume: \\.\C: for reading its journal; needs administrator rights.
+func openVolume(vol string) (windows.Handle, error) {
+	p, err := windows.UTF16PtrFromString(`\\.\` + strings.TrimRight(vol, `\`))
+	if err != nil {
+		return 0, err
+	}
+	return windows.CreateFile(p, windows.GENERIC_READ, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil, windows.OPEN_EXISTING, 0, 0)
+}
+
+// queryJournal: the journal's ID and the USN its next record gets.
+func queryJournal(h windows.Handle) (id uint64, next int64, err error) {
+	var out [56]byte // USN_JOURNAL_DATA_V0
+	var n uint32
+	if err := windows.DeviceIoControl(h, fsctlQueryUsnJournal, nil, 0, &out[0], uint32(len(out)), &n, nil); err != nil {
+		return 0, 0, err
+	}
+	return uint64(le64(out[:], 0)), le64(out[:], 16), nil
+}
+
+// readJournal calls f with the parent directory of every changed file
+// or directory recorded from c.Next on, and returns the USN to go on from.
+func readJournal(h windows.Handle, c usnCursor, f func(parent uint64)) (int64, error) {
+	id, _, err := queryJournal(h)
+	if err != nil {
+		return 0, err
+	}
+	if id != c.ID {
+		return 0, errJournalLost
+	}
+	var in [40]byte // READ_USN_JOURNAL_DATA_V0; no waiting, every record
+	binary.LittleEndian.PutUint32(in[8:], usnChanges)
+	binary.LittleEndian.PutUint64(in[32:], c.ID)
+	buf := make([]byte, 64<<10)
+	next := c.Next
+	for {
+		binary.LittleEndian.PutUint64(in[0:], uint64(next))
+		var n uint32
+		err := windows.DeviceIoControl(h, fsctlReadUsnJournal, &in[0], uint32(len(in)), &buf[0], uint32(len(buf)), &n, nil)
+		if errors.Is(err, windows.ERROR_JOURNAL_ENTRY_DELETED) {
+			return 0, errJournalLost
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n < 8 {
+			return next, nil
+		}
+		next = le64(buf, 0)
+		if n == 8 {
+			return next, nil // no more records
+		}
+		if err := usnRecords(buf[8:n], f); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// usnRecords calls f with the parent reference of each USN_RECORD_V2 in
+// b, as FSCTL_READ_USN_JOURNAL returns them after the next USN.
+func usnRecords(b []byte, f func(parent uint64)) error {
+	for len(b) > 0 {
+		if len(b) < 60 {
+			return errors.New("short USN record")
+		}
+		n := int(le32(b, 0))
+		if n < 60 || n > len(b) {
+			return fmt.Errorf("USN record of %d bytes", n)
+		}
+		if le16(b, 4) == 2 {
+			f(uint64(le64(b, 16)))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// fileIDDescriptor: FILE_ID_DESCRIPTOR for a 64-bit file reference.
+type fileIDDescriptor struct {
+	size, typ uint32
+	id        uint64
+	_         uint64 // the rest of the union
+}
+
+// refPath: the path of the directory with file reference ref on the
+// volume h is open on; false when it is gone.
+func refPath(h windows.Handle, ref uint64) (string, bool) {
+	d := fileIDDescriptor{id: ref}
+	d.size = uint32(unsafe.Sizeof(d))
+	r, _, _ := procOpenFileById.Call(uintptr(h), uintptr(unsafe.Pointer(&d)), windows.FILE_READ_ATTRIBUTES,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, 0, windows.FILE_FLAG_BACKUP_SEMANTICS)
+	fh := windows.Handle(r)
+	if fh == windows.InvalidHandle {
+		return "", false
+	}
+	defer windows.CloseHandle(fh)
+	buf := make([]uint16, windows.MAX_PATH)
+	for {
+		n, err := windows.GetFinalPathNameByHandle(fh, &buf[0], uint32(len(buf)), volumeNameDOS)
+		if err != nil {
+			return "", false
+		}
+		if int(n) < len(buf) {
+			p := windows.UTF16ToString(buf[:n])
+			if rest, ok := strings.CutPrefix(p, `\\?\UNC\`); ok {
+				return `\\` + rest, true
+			}
+			return strings.TrimPrefix(p, `\\?\`), true
+		}
+		buf = make([]uint16, n)
+	}
+}
+
+// catchUp applies what the journals recorded since the index was built or
+// last caught up. Every indexed directory with a change in it is
+// rescanned (the outermost only, when one lies inside another).
+func (srv *indexServer) catchUp() error {
+	srv.catching.Lock()
+	defer srv.catching.Unlock()
+	x := srv.index()
+	if x == nil || x.Journals == nil {
+		return nil
+	}
+
+	journals := slices.Clone(x.Journals)
+	changed := make(map[string]string) // pathKey -> path
+	for i, c := range journals {
+		h, err := openVolume(c.Volume)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Volume, err)
+		}
+		refs := make(map[uint64]bool)
+		next, err := readJournal(h, c, func(parent uint64) { refs[parent] = true })
+		if err == nil {
+			for ref := range refs {
+				if p, ok := refPath(h, ref); ok {
+					changed[pathKey(p)] = p
+				}
+			}
+		}
+		windows.CloseHandle(h)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Volume, err)
+		}
+		journals[i].Next = next
+	}
+
+	y := &dirIndex{Version: x.Version, Roots: x.Roots, BuiltAt: x.BuiltAt, Updated: time.Now(), Journals: journals, Dirs: x.Dirs}
+	rescanned := 0
+	if len(changed) > 0 {
+		indexed := make(map[string]bool, len(x.Dirs))
+		for _, r := range x.Dirs {
+			indexed[pathKey(r.Path)] = true
+		}
+		for _, dir := range outermost(changed) {
+			// One the index doesn't have is new, and its parent has a
+			// record of its own, or lies where the walk doesn't count.
+			if indexed[pathKey(dir)] {
+				y = y.replace(dir, srv.rescan(dir))
+				rescanned++
+			}
+		}
+	}
+
+	srv.mu.Lock()
+	if srv.cur == x { // else a rebuild finished meanwhile
+		srv.cur = y
+	}
+	srv.mu.Unlock()
+	if rescanned > 0 && srv.file != "" {
+		if err := y.save(srv.file); err != nil {
+			fmt.Fprintln(os.Stderr, "index:", err)
+		}
+	}
+	return nil
+}
+
+// outermost: the paths in dirs that lie inside none of the others.
+func outermost(dirs map[string]string) []string {
+	all := slices.Collect(maps.Values(dirs))
+	sort.Slice(all, func(i, j int) bool { return len(all[i]) < len(all[j]) })
+	var out []string
+	for _, d := range all {
+		if !slices.ContainsFunc(out, func(o string) bool { return isUnder(d, o) }) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// rescan: the index rows of a fresh walk of dir, at its depth below its
+// root, so -max-depth and the like apply as in a full scan.
+func (srv *indexServer) rescan(dir string) []indexRow {
+	x := &dirIndex{}
+	cfg := srv.cfg
+	cfg.index = x
+	depth := 0
+	if rel, err := filepath.Rel(srv.roots[rootIndex(srv.roots, dir)], dir); err == nil && rel != "." {
+		depth = strings.Count(rel, string(filepath.Separator)) + 1
+	}
+	sc := newScan([]string{dir}, cfg)
+	walkDir(context.Background(), dir, depth, sc.cfg, newWorkSem(cfg.workers), sc.fileTop, sc.dirTop, &sc.stats)
+	return x.Dirs
+}
+
+// ########### SIZE INDEX: CLIENT ##################
+// queryIndex: asks the -index-serve service on \\.\pipe\NAME for the top
+// largest directories under path. An error means the service couldn't
+// answer (not running, busy, no index yet, path not indexed) and the
+// caller should scan instead.
+func queryIndex(name, under string, top int) (indexReply, error) {
+	f, err := os.OpenFile(`\\.\pipe\`+name, os.O_RDWR, 0)
+	if err != nil {
+		return indexReply{}, err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(indexQuery{Cmd: "query", Under: under, Top: top}); err != nil {
+		return indexReply{}, err
+	}
+	var rep indexReply
+	if err := json.NewDecoder(f).Decode(&rep); err != nil {
+		return indexReply{}, err
+	}
+	if rep.Event == "error" {
+		return rep, errors.New(rep.Error)
+	}
+	return rep, nil
+}
+
+// printIndexReply: the -query answer as a "Largest Directories" table.
+func printIndexReply(name string, rep indexReply) {
+	w := newTable(os.Stdout)
+	fmt.Println("Largest Directories")
+	fmt.Fprintln(w, "RANK\tSIZE\tFILES\tPATH")
+	for i, r := range rep.Directories {
+		size := humanBytesFixed(r.SizeBytes)
+		if r.LowerBound {
+			size = "≥" + size
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", i+1, size, r.Files, r.Path)
+	}
+	w.Flush()
+	fmt.Printf("\nFrom the index at \\\\.\\pipe\\%s, built %s (%d directories)", name, rep.BuiltAt.Format(time.DateTime), rep.Indexed)
+	if !rep.Updated.IsZero() {
+		fmt.Printf(", current as of %s", rep.Updated.Format(time.DateTime))
+	}
+	fmt.Println()
+}
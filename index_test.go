@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// testIndex: a served index of three directories under C:\Data.
+func testIndex() *indexServer {
+	x := &dirIndex{Version: indexVersion, Roots: []string{`C:\Data`}, BuiltAt: time.Now(), Dirs: []indexRow{
+		{Path: `C:\Data`, SizeBytes: 600, Files: 6},
+		{Path: `C:\Data\a`, SizeBytes: 100, Files: 1},
+		{Path: `C:\Data\b`, SizeBytes: 500, Files: 5},
+	}}
+	return &indexServer{roots: x.Roots, cur: x}
+}
+
+func TestIndexAnswer(t *testing.T) {
+	srv := testIndex()
+	rep := srv.answer(indexQuery{Cmd: "query", Under: `C:\Data`, Top: 2})
+	if rep.Event != "query" || len(rep.Directories) != 2 || rep.Directories[0].Path != `C:\Data` || rep.Directories[1].Path != `C:\Data\b` {
+		t.Errorf("top 2 under the root: %+v", rep)
+	}
+	if rep := srv.answer(indexQuery{Cmd: "query", Under: `C:\Data\a`}); len(rep.Directories) != 1 {
+		t.Errorf("under a: %+v", rep.Directories)
+	}
+	if rep := srv.answer(indexQuery{Cmd: "query", Under: `D:\`}); rep.Event != "error" {
+		t.Errorf("a path outside the roots was answered: %+v", rep)
+	}
+	if rep := srv.answer(indexQuery{Cmd: "status"}); rep.Indexed != 3 {
+		t.Errorf("status: %d directories, want 3", rep.Indexed)
+	}
+	srv.cur = nil
+	if rep := srv.answer(indexQuery{Cmd: "query", Under: `C:\Data`}); rep.Event != "error" {
+		t.Errorf("answered before the first scan: %+v", rep)
+	}
+}
+
+// A client that connects and sends nothing is hung up on after indexIdle.
+func TestIndexSessionIdle(t *testing.T) {
+	defer func(d time.Duration) { indexIdle = d }(indexIdle)
+	indexIdle = 50 * time.Millisecond
+	srv := testIndex()
+	server, client := net.Pipe()
+	defer client.Close()
+	ended := make(chan struct{})
+	go func() {
+		srv.session(server)
+		close(ended)
+	}()
+
+	if err := json.NewEncoder(client).Encode(indexQuery{Cmd: "status"}); err != nil {
+		t.Fatal(err)
+	}
+	var rep indexReply
+	if err := json.NewDecoder(client).Decode(&rep); err != nil || rep.Event != "status" {
+		t.Fatalf("status: %+v, %v", rep, err)
+	}
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session still open after the client went idle")
+	}
+}
+
+// TestIndexPipe: an idle client on \\.\pipe\NAME doesn't keep -query out.
+func TestIndexPipe(t *testing.T) {
+	srv := testIndex()
+	name := fmt.Sprintf("gosize-index-test-%d", os.Getpid())
+	go srv.serve(name)
+
+	var idle *os.File
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var err error
+		if idle, err = os.OpenFile(`\\.\pipe\`+name, os.O_RDWR, 0); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer idle.Close()
+
+	rep, err := queryIndex(name, `C:\Data`, 1)
+	if err != nil || len(rep.Directories) != 1 || rep.Directories[0].SizeBytes != 600 {
+		t.Fatalf("query next to an idle client: %+v, %v", rep, err)
+	}
+}
+
+func TestIndexReplace(t *testing.T) {
+	x := testIndex().cur
+	x.Dirs = append(x.Dirs, indexRow{Path: `C:\Data\b\old`, SizeBytes: 400, Files: 4})
+	y := x.replace(`C:\Data\b`, []indexRow{
+		{Path: `C:\Data\b`, SizeBytes: 150, Files: 2, LowerBound: true},
+		{Path: `C:\Data\b\new`, SizeBytes: 50, Files: 1, LowerBound: true},
+	})
+	got := make(map[string]indexRow)
+	for _, r := range y.Dirs {
+		got[r.Path] = r
+	}
+	want := map[string]indexRow{
+		`C:\Data`:       {Path: `C:\Data`, SizeBytes: 250, Files: 3, LowerBound: true},
+		`C:\Data\a`:     {Path: `C:\Data\a`, SizeBytes: 100, Files: 1},
+		`C:\Data\b`:     {Path: `C:\Data\b`, SizeBytes: 150, Files: 2, LowerBound: true},
+		`C:\Data\b\new`: {Path: `C:\Data\b\new`, SizeBytes: 50, Files: 1, LowerBound: true},
+	}
+	if len(got) != len(want) {
+		t.Errorf("%d rows, want %d: %+v", len(got), len(want), y.Dirs)
+	}
+	for p, w := range want {
+		if got[p] != w {
+			t.Errorf("%s: %+v, want %+v", p, got[p], w)
+		}
+	}
+	if len(x.Dirs) != 4 || x.Dirs[0].SizeBytes != 600 {
+		t.Errorf("replace changed the index it was called on: %+v", x.Dirs)
+	}
+
+	gone := x.replace(`C:\Data\b`, nil) // deleted since
+	if len(gone.Dirs) != 2 || gone.Dirs[0].SizeBytes != 100 || gone.Dirs[0].Files != 1 {
+		t.Errorf("b deleted: %+v", gone.Dirs)
+	}
+}
+
+func TestOutermost(t *testing.T) {
+	dirs := map[string]string{}
+	for _, p := range []string{`C:\a\b`, `C:\a`, `C:\a\b\c`, `C:\ab`, `D:\x`} {
+		dirs[strings.ToLower(p)] = p
+	}
+	got := outermost(dirs)
+	slices.Sort(got)
+	if want := []string{`C:\a`, `C:\ab`, `D:\x`}; !slices.Equal(got, want) {
+		t.Errorf("outermost = %q, want %q", got, want)
+	}
+}
+
+// usnRecord: a USN_RECORD_V2 (or a later major version) for name in the
+// directory with reference parent.
+func usnRecord(major uint16, parent uint64, name string) []byte {
+	u := utf16.Encode([]rune(name))
+	b := make([]byte, (60+2*len(u)+7)&^7)
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.LittleEndian.PutUint16(b[4:], major)
+	binary.LittleEndian.PutUint64(b[8:], 0x0001000000000777)
+	binary.LittleEndian.PutUint64(b[16:], parent)
+	binary.LittleEndian.PutUint32(b[40:], 0x100) // FILE_CREATE
+	binary.LittleEndian.PutUint16(b[56:], uint16(2*len(u)))
+	binary.LittleEndian.PutUint16(b[58:], 60)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[60+2*i:], c)
+	}
+	return b
+}
+
+func TestUsnRecords(t *testing.T) {
+	var b []byte
+	b = append(b, usnRecord(2, 0x0005000000000010, "a.txt")...)
+	b = append(b, usnRecord(3, 0x0005000000000020, "skipped, not asked for")...)
+	b = append(b, usnRecord(2, 0x0002000000000030, "")...)
+	var parents []uint64
+	if err := usnRecords(b, func(p uint64) { parents = append(parents, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{0x0005000000000010, 0x0002000000000030}; !slices.Equal(parents, want) {
+		t.Errorf("parents %x, want %x", parents, want)
+	}
+
+	for name, bad := range map[string][]byte{
+		"short":     b[:40],
+		"truncated": b[:len(b)-8],
+		"zero size": make([]byte, 64),
+	} {
+		if err := usnRecords(bad, func(uint64) {}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// indexOf: the index a full scan of root builds, rows sorted by path.
+func indexOf(t *testing.T, root string) []indexRow {
+	t.Helper()
+	x := &dirIndex{Roots: []string{root}}
+	cfg := testCfg()
+	cfg.index = x
+	startScan(context.Background(), []string{root}, cfg).wait()
+	slices.SortFunc(x.Dirs, func(a, b indexRow) int { return strings.Compare(a.Path, b.Path) })
+	return x.Dirs
+}
+
+// Rescanning what the journal names, and only that, must give the index a
+// full scan would.
+func TestIndexCatchUpRescan(t *testing.T) {
+	root := writeTree(t, map[string]int{"a/x/f": 100, "a/y/g": 50, "a/y/z/h": 5, "b/k": 30})
+	srv := &indexServer{roots: []string{root}, cfg: testCfg()}
+	x := &dirIndex{Roots: srv.roots, Dirs: indexOf(t, root)}
+	a, ax := filepath.Join(root, "a"), filepath.Join(root, "a", "x")
+
+	step := func(what string, changed ...string) {
+		t.Helper()
+		dirs := make(map[string]string)
+		for _, d := range changed {
+			dirs[pathKey(d)] = d
+		}
+		for _, d := range outermost(dirs) {
+			x = x.replace(d, srv.rescan(d))
+		}
+		got := slices.Clone(x.Dirs)
+		slices.SortFunc(got, func(a, b indexRow) int { return strings.Compare(a.Path, b.Path) })
+		if want := indexOf(t, root); !slices.Equal(got, want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", what, got, want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(ax, "f2"), make([]byte, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	step("file created in a/x", ax)
+
+	if err := os.RemoveAll(filepath.Join(a, "y")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(ax, "new"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ax, "new", "n"), make([]byte, 7), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a for the deleted y; a/x for the new directory; a/x/new for its file
+	step("a/y deleted, a/x/new created", a, ax, filepath.Join(ax, "new"))
+}
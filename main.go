@@ -1,587 +1,6969 @@
-package main
-
-//########### IMPORTS ##################
-// Core stdlib + Windows drive info via x/sys/windows.
-// Note: run `go get golang.org/x/sys/windows` once before building.
-import (
-	"context"
-	"encoding/json"
-	"errors"
-	"flag"
-	"fmt"
-	"io/fs"
-	"os"
-	"path/filepath"
-	"runtime"
-	"sort"
-	"strings"
-	"sync"
-	"sync/atomic"
-	"text/tabwriter"
-	"time"
-
-	"golang.org/x/sys/windows"
-)
-
-// ########### TYPES: ITEMS & HEAP ##################
-// item: a path with its total size (file size or aggregated dir size).
-type item struct {
-	Path string
-	Size int64
-}
-
-// minHeap: keeps only top-K largest items using a min-heap.
-// Smallest sits at root so we can evict when a bigger item arrives.
-type minHeap struct {
-	mu   sync.Mutex
-	data []item
-	k    int
-}
-
-// push keeps only the largest k elements overall by using a min-heap behavior.
-func (h *minHeap) push(it item) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if h.k <= 0 {
-		return
-	}
-	if len(h.data) < h.k {
-		h.data = append(h.data, it)
-		h.up(len(h.data) - 1)
-		return
-	}
-	// If new item is larger than smallest, replace root.
-	if it.Size > h.data[0].Size {
-		h.data[0] = it
-		h.down(0)
-	}
-}
-
-func (h *minHeap) up(i int) {
-	for i > 0 {
-		p := (i - 1) / 2
-		if h.data[p].Size <= h.data[i].Size {
-			break
-		}
-		h.data[p], h.data[i] = h.data[i], h.data[p]
-		i = p
-	}
-}
-
-func (h *minHeap) down(i int) {
-	n := len(h.data)
-	for {
-		l := 2*i + 1
-		r := l + 1
-		small := i
-		if l < n && h.data[l].Size < h.data[small].Size {
-			small = l
-		}
-		if r < n && h.data[r].Size < h.data[small].Size {
-			small = r
-		}
-		if small == i {
-			break
-		}
-		h.data[i], h.data[small] = h.data[small], h.data[i]
-		i = small
-	}
-}
-
-// sortedDesc returns a snapshot of heap contents sorted by size (largest first).
-func (h *minHeap) sortedDesc() []item {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	out := make([]item, len(h.data))
-	copy(out, h.data)
-	sort.Slice(out, func(i, j int) bool { return out[i].Size > out[j].Size })
-	return out
-}
-
-// ########### BYTES: HUMAN READABLE ##################
-// humanBytes: legacy helper (kept intact); not used in output table.
-func humanBytes(n int64) string {
-	const (
-		_        = iota
-		KB int64 = 1 << (10 * iota)
-		MB
-		GB
-		TTB
-		PB
-	)
-	f := func(v float64, unit string) string { return fmt.Sprintf("%.2f %s", v, unit) }
-	switch {
-	case n >= PB:
-		return f(float64(n)/float64(PB), "PB")
-	case n >= TTB:
-		return f(float64(n)/float64(TTB), "TB")
-	case n >= GB:
-		return f(float64(n)/float64(GB), "GB")
-	case n >= MB:
-		return f(float64(n)/float64(MB), "MB")
-	case n >= KB:
-		return f(float64(n)/float64(KB), "MB") // Note: mismatch kept to preserve original.
-	}
-	return fmt.Sprintf("%d B", n)
-}
-
-// humanBytesFixed: used for table output; correct units for KB/MB/etc.
-func humanBytesFixed(n int64) string {
-	const (
-		KB = 1 << 10
-		MB = 1 << 20
-		GB = 1 << 30
-		TB = 1 << 40
-		PB = 1 << 50
-	)
-	switch {
-	case n >= PB:
-		return fmt.Sprintf("%.2f PB", float64(n)/float64(PB))
-	case n >= TB:
-		return fmt.Sprintf("%.2f TB", float64(n)/float64(TB))
-	case n >= GB:
-		return fmt.Sprintf("%.2f GB", float64(n)/float64(GB))
-	case n >= MB:
-		return fmt.Sprintf("%.2f MB", float64(n)/float64(MB))
-	case n >= KB:
-		return fmt.Sprintf("%.2f KB", float64(n)/float64(KB))
-	default:
-		return fmt.Sprintf("%d B", n)
-	}
-}
-
-// ########### CONFIG & STATS ##################
-// walkCfg: controls traversal behavior and filtering.
-type walkCfg struct {
-	topK         int
-	workers      int
-	followLinks  bool
-	maxDepth     int // 0 means unlimited
-	skipHidden   bool
-	skipPatterns []string
-	showProgress bool
-}
-
-// stats: atomically tracked counters for progress + summary.
-type stats struct {
-	filesSeen int64
-	dirsSeen  int64
-	skipped   int64
-	errors    int64
-}
-
-// ########### JSON OUTPUT TYPES ##################
-// jsonRow/jsonResult: shapes the -json output for both lists plus summary.
-type jsonRow struct {
-	Rank         int     `json:"rank"`
-	SizeBytes    int64   `json:"sizeBytes"`
-	SizeHuman    string  `json:"sizeHuman"`
-	DrivePercent float64 `json:"drivePercent,omitempty"` // 0 omitted if unknown
-	Drive        string  `json:"drive,omitempty"`        // e.g., "C:\\"
-	Path         string  `json:"path"`
-}
-
-type jsonResult struct {
-	Roots     []string `json:"roots"`
-	TopK      int      `json:"topK"`
-	Generated string   `json:"generated"`
-	Duration  string   `json:"duration"`
-	Summary   struct {
-		FilesSeen int64 `json:"filesSeen"`
-		DirsSeen  int64 `json:"dirsSeen"`
-		Skipped   int64 `json:"skipped"`
-		Errors    int64 `json:"errors"`
-	} `json:"summary"`
-	Directories []jsonRow `json:"directories"`
-	Files       []jsonRow `json:"files"`
-}
-
-// ########### MAIN: FLAGS, ROOTS, SCAN, PRINT ##################
-func main() {
-	// ----- Flags -----
-	var (
-		topK        = flag.Int("top", 20, "number of largest files and directories to keep")
-		workers     = flag.Int("workers", runtime.NumCPU(), "concurrent directory workers")
-		rootsFlag   = flag.String("roots", "", "comma-separated roots to scan (default: detect all drives, e.g. C:\\, D:\\)")
-		followLinks = flag.Bool("followlinks", false, "follow symlinks/junctions (off by default to avoid cycles)")
-		maxDepth    = flag.Int("maxdepth", 0, "max directory depth to scan (0 = unlimited)")
-		skipHidden  = flag.Bool("skiphidden", false, "skip hidden files and directories")
-		skipGlobs   = flag.String("skip", "", "comma-separated filepath.Match patterns to skip (e.g. \"C:\\\\Windows\\\\*,C:\\\\Program Files\\\\*\")")
-		progress    = flag.Bool("progress", true, "periodically print progress to stderr")
-		jsonOut     = flag.Bool("json", false, "output results as JSON")
-	)
-	flag.Parse()
-
-	cfg := walkCfg{
-		topK:         *topK,
-		workers:      *workers,
-		followLinks:  *followLinks,
-		maxDepth:     *maxDepth,
-		skipHidden:   *skipHidden,
-		showProgress: *progress,
-	}
-	if *skipGlobs != "" {
-		parts := strings.Split(*skipGlobs, ",")
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p != "" {
-				cfg.skipPatterns = append(cfg.skipPatterns, p)
-			}
-		}
-	}
-
-	// ----- Roots -----
-	roots := []string{}
-	if *rootsFlag != "" {
-		for _, r := range strings.Split(*rootsFlag, ",") {
-			r = strings.TrimSpace(r)
-			if r == "" {
-				continue
-			}
-			if !strings.HasSuffix(r, `\`) && !strings.HasSuffix(r, "/") {
-				r += `\`
-			}
-			roots = append(roots, r)
-		}
-	} else {
-		roots = detectWindowsDrives()
-		if len(roots) == 0 {
-			fmt.Fprintln(os.Stderr, "No drives detected. Provide -roots like -roots=C:\\,D:\\")
-			os.Exit(2)
-		}
-	}
-
-	// ----- Context + Heaps + Stats -----
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	fileTop := &minHeap{k: cfg.topK}
-	dirTop := &minHeap{k: cfg.topK}
-	var s stats
-
-	// Worker pool controlled by a semaphore channel.
-	sem := make(chan struct{}, cfg.workers)
-
-	// ----- Kick off scans for each root -----
-	start := time.Now()
-	var wg sync.WaitGroup
-	for _, root := range roots {
-		r := root
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if size, err := walkDir(ctx, r, 0, cfg, sem, fileTop, dirTop, &s); err != nil {
-				// Permission / transient errors are fine to ignore in summary.
-				_ = size
-			}
-		}()
-	}
-
-	// ----- Optional progress ticker -----
-	done := make(chan struct{})
-	if cfg.showProgress {
-		go func() {
-			t := time.NewTicker(2 * time.Second)
-			defer t.Stop()
-			for {
-				select {
-				case <-done:
-					return
-				case <-t.C:
-					ff := atomic.LoadInt64(&s.filesSeen)
-					dd := atomic.LoadInt64(&s.dirsSeen)
-					sk := atomic.LoadInt64(&s.skipped)
-					er := atomic.LoadInt64(&s.errors)
-					fmt.Fprintf(os.Stderr, "[%s] scanned files=%d dirs=%d skipped=%d errors=%d\n",
-						time.Since(start).Truncate(time.Millisecond), ff, dd, sk, er)
-				}
-			}
-		}()
-	}
-
-	wg.Wait()
-	close(done)
-
-	// ----- Common post-scan values -----
-	dsc := newDriveSpaceCache() // Total bytes per volume; queried once per drive.
-	ff := atomic.LoadInt64(&s.filesSeen)
-	dd := atomic.LoadInt64(&s.dirsSeen)
-	sk := atomic.LoadInt64(&s.skipped)
-	er := atomic.LoadInt64(&s.errors)
-	elapsed := time.Since(start).Truncate(time.Millisecond)
-
-	// ----- JSON output (if requested) -----
-	if *jsonOut {
-		toRows := func(items []item) []jsonRow {
-			out := make([]jsonRow, 0, len(items))
-			for i, it := range items {
-				tot := dsc.totalFor(it.Path)
-				pct := 0.0
-				if tot > 0 {
-					pct = (float64(it.Size) / float64(tot)) * 100
-				}
-				out = append(out, jsonRow{
-					Rank:         i + 1,
-					SizeBytes:    it.Size,
-					SizeHuman:    humanBytesFixed(it.Size),
-					DrivePercent: pct,
-					Drive:        volumeRoot(it.Path),
-					Path:         it.Path,
-				})
-			}
-			return out
-		}
-
-		res := jsonResult{
-			Roots:       roots,
-			TopK:        cfg.topK,
-			Generated:   time.Now().Format(time.RFC3339),
-			Duration:    elapsed.String(),
-			Directories: toRows(dirTop.sortedDesc()),
-			Files:       toRows(fileTop.sortedDesc()),
-		}
-		res.Summary.FilesSeen = ff
-		res.Summary.DirsSeen = dd
-		res.Summary.Skipped = sk
-		res.Summary.Errors = er
-
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		if err := enc.Encode(res); err != nil {
-			fmt.Fprintln(os.Stderr, "failed to encode JSON:", err)
-			os.Exit(1)
-		}
-		return
-	}
-
-	// ----- Plain-text output (tabwriter tables) -----
-	w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
-	fmt.Println()
-	fmt.Println("Largest Directories")
-	fmt.Fprintln(w, "RANK\tSIZE\tDRIVE%\tPATH")
-	for i, it := range dirTop.sortedDesc() {
-		total := dsc.totalFor(it.Path)
-		pct := "n/a"
-		if total > 0 {
-			p := (float64(it.Size) / float64(total)) * 100
-			pct = fmt.Sprintf("%.2f%%", p)
-		}
-		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, humanBytesFixed(it.Size), pct, it.Path)
-	}
-	w.Flush()
-
-	fmt.Println()
-	fmt.Println("Largest Files")
-	w = tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
-	fmt.Fprintln(w, "RANK\tSIZE\tDRIVE%\tPATH")
-	for i, it := range fileTop.sortedDesc() {
-		total := dsc.totalFor(it.Path)
-		pct := "n/a"
-		if total > 0 {
-			p := (float64(it.Size) / float64(total)) * 100
-			pct = fmt.Sprintf("%.2f%%", p)
-		}
-		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, humanBytesFixed(it.Size), pct, it.Path)
-	}
-	w.Flush()
-
-	// ----- Summary line -----
-	fmt.Println()
-	fmt.Printf("Scanned %d files in %d directories in %s (skipped=%d, errors=%d)\n",
-		ff, dd, elapsed, sk, er)
-}
-
-// ########### WALKER: DIRECTORY RECURSION ##################
-// walkDir: recursively scans a directory, returning the aggregated size.
-// Uses a semaphore for concurrency fan-out control.
-func walkDir(ctx context.Context, path string, depth int, cfg walkCfg, sem chan struct{}, fileTop, dirTop *minHeap, s *stats) (int64, error) {
-	select {
-	case <-ctx.Done():
-		return 0, ctx.Err()
-	default:
-	}
-
-	// Honor depth limit early.
-	if cfg.maxDepth > 0 && depth > cfg.maxDepth {
-		atomic.AddInt64(&s.skipped, 1)
-		return 0, nil
-	}
-
-	entries, err := os.ReadDir(path)
-	if err != nil {
-		atomic.AddInt64(&s.errors, 1)
-		return 0, err
-	}
-	atomic.AddInt64(&s.dirsSeen, 1)
-
-	var total int64
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	for _, de := range entries {
-		name := de.Name()
-		full := filepath.Join(path, name)
-
-		// Skip by glob patterns (e.g., Windows system dirs).
-		if shouldSkipByGlob(full, cfg.skipPatterns) {
-			atomic.AddInt64(&s.skipped, 1)
-			continue
-		}
-
-		info, lerr := de.Info()
-		if lerr != nil {
-			atomic.AddInt64(&s.errors, 1)
-			continue
-		}
-
-		// Skip symlinks unless followLinks is explicitly true.
-		if info.Mode()&fs.ModeSymlink != 0 && !cfg.followLinks {
-			atomic.AddInt64(&s.skipped, 1)
-			continue
-		}
-
-		// Optional skip for "hidden" (dot) files if user asked for it.
-		if cfg.skipHidden && strings.HasPrefix(name, ".") {
-			atomic.AddInt64(&s.skipped, 1)
-			continue
-		}
-
-		if de.IsDir() {
-			// Try parallel subtree processing using the semaphore.
-			select {
-			case sem <- struct{}{}:
-				wg.Add(1)
-				go func(p string) {
-					defer wg.Done()
-					defer func() { <-sem }()
-					size, derr := walkDir(ctx, p, depth+1, cfg, sem, fileTop, dirTop, s)
-					if derr == nil {
-						mu.Lock()
-						total += size
-						mu.Unlock()
-						dirTop.push(item{Path: p, Size: size})
-					} else if !isIgnorable(derr) {
-						atomic.AddInt64(&s.errors, 1)
-					}
-				}(full)
-			default:
-				// No free slot — process synchronously.
-				size, derr := walkDir(ctx, full, depth+1, cfg, sem, fileTop, dirTop, s)
-				if derr == nil {
-					total += size
-					dirTop.push(item{Path: full, Size: size})
-				} else if !isIgnorable(derr) {
-					atomic.AddInt64(&s.errors, 1)
-				}
-			}
-			continue
-		}
-
-		// Regular file: add to totals and top-K.
-		if info.Mode().IsRegular() {
-			fs := info.Size()
-			total += fs
-			atomic.AddInt64(&s.filesSeen, 1)
-			fileTop.push(item{Path: full, Size: fs})
-		}
-	}
-
-	wg.Wait()
-	return total, nil
-}
-
-// ########### HELPERS: ROOTS, ERRORS, SKIPS ##################
-// detectWindowsDrives: enumerates A:\ to Z:\ and returns those that exist.
-func detectWindowsDrives() []string {
-	var roots []string
-	for c := 'A'; c <= 'Z'; c++ {
-		root := string([]rune{c, ':'}) + `\`
-		if _, err := os.Stat(root); err == nil {
-			roots = append(roots, root)
-		}
-	}
-	return roots
-}
-
-// isIgnorable: classify common, non-actionable errors (e.g., permission).
-func isIgnorable(err error) bool {
-	if errors.Is(err, fs.ErrPermission) {
-		return true
-	}
-	// Extend here with Windows sharing violations if needed.
-	return false
-}
-
-// shouldSkipByGlob: filter out paths matching any filepath.Match pattern.
-func shouldSkipByGlob(path string, patterns []string) bool {
-	for _, p := range patterns {
-		ok, _ := filepath.Match(p, path)
-		if ok {
-			return true
-		}
-	}
-	return false
-}
-
-// ########### WINDOWS: DRIVE TOTAL BYTES ##################
-// driveSpaceCache: caches total bytes for each volume root (e.g., "C:\").
-// Used to compute the DRIVE% column without repeated API calls.
-type driveSpaceCache struct {
-	mu     sync.Mutex
-	byRoot map[string]uint64
-}
-
-func newDriveSpaceCache() *driveSpaceCache {
-	return &driveSpaceCache{byRoot: make(map[string]uint64)}
-}
-
-// volumeRoot: returns a normalized Windows volume root for a path.
-// Examples: "C:\" or "\\server\share\".
-func volumeRoot(p string) string {
-	vol := filepath.VolumeName(p)
-	if vol == "" {
-		return ""
-	}
-	// UNC share: \\server\share  ->  \\server\share\
-	if strings.HasPrefix(vol, `\\`) {
-		if strings.HasSuffix(vol, `\`) {
-			return vol
-		}
-		return vol + `\`
-	}
-	// Drive letter: C: -> C:\
-	if strings.HasSuffix(vol, `\`) {
-		return vol
-	}
-	return vol + `\`
-}
-
-// totalFor: total number of bytes on the volume that holds 'path'.
-// Returns 0 if not on Windows or if the total cannot be determined.
-func (c *driveSpaceCache) totalFor(path string) uint64 {
-	if runtime.GOOS != "windows" {
-		return 0
-	}
-	root := volumeRoot(path)
-	if root == "" {
-		return 0
-	}
-
-	c.mu.Lock()
-	if v, ok := c.byRoot[root]; ok {
-		c.mu.Unlock()
-		return v
-	}
-	c.mu.Unlock()
-
-	var freeAvailToCaller, totalBytes, totalFree uint64
-	// windows.GetDiskFreeSpaceEx(path, &freeAvailToCaller, &totalBytes, &totalFree)
-	if err := windows.GetDiskFreeSpaceEx(windows.StringToUTF16Ptr(root), &freeAvailToCaller, &totalBytes, &totalFree); err != nil {
-		return 0
-	}
-
-	c.mu.Lock()
-	c.byRoot[root] = totalBytes
-	c.mu.Unlock()
-	return totalBytes
-}
+package main
+
+//########### IMPORTS ##################
+// Core stdlib + Windows drive info via x/sys/windows.
+// Note: run `go get golang.org/x/sys/windows` once before building.
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"disktop/benchtree"
+)
+
+// ########### TYPES: ITEMS & HEAP ##################
+// item: a path with its total size (file size or aggregated dir size).
+type item struct {
+	Path        string
+	Size        int64
+	IsDir       bool   // distinguishes rows when files and dirs share one heap (-combined)
+	Partial     bool   // dir total is a lower bound: -depth-scan cut off part of the subtree
+	Tag         string // shown after the path, e.g. "mount" -> "C:\Data [mount]"
+	Files       int64  // dirs: files counted in the subtree
+	Median      int64  // dirs with -columns=files: approximate median file size
+	Parent      int64  // with -columns=parent: size of the containing directory; 0 = unknown
+	Largest     string // dirs with -concentration: the biggest direct child
+	LargestSize int64
+	SeenAt      time.Time // when the size was measured; set on push if still zero
+	ID          string    // -show-id: "volume serial:file index", read after the scan
+	Modified    time.Time // files: mtime; dirs: newest of its own mtime and its direct children's
+	Total       int64     // dirs with -rank=exclusive: the full size; Size leaves out the largest subdirectory
+}
+
+// total: the item's full size, whatever it is ranked by.
+func (it item) total() int64 {
+	if it.Total > 0 {
+		return it.Total
+	}
+	return it.Size
+}
+
+// minHeap: keeps only top-K largest items using a min-heap.
+// Smallest sits at root so we can evict when a bigger item arrives.
+type minHeap struct {
+	mu   sync.Mutex
+	data []item
+	k    int
+	keys map[string]bool // pathKey of every kept item
+
+	// floor: the smallest size push would keep; 0 until the heap is full,
+	// then the current minimum + 1. Read without the lock by wouldAccept.
+	floor atomic.Int64
+}
+
+// wouldAccept: whether an item of this size would be kept right now. The
+// floor only rises, so false stays false; true still needs push to decide.
+func (h *minHeap) wouldAccept(size int64) bool {
+	return h.k > 0 && size >= h.floor.Load()
+}
+
+// push keeps only the largest k elements overall by using a min-heap behavior.
+func (h *minHeap) push(it item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.k <= 0 {
+		return
+	}
+	if it.SeenAt.IsZero() {
+		it.SeenAt = time.Now()
+	}
+	if len(h.data) == h.k && it.Size <= h.data[0].Size {
+		return // a kept copy of the same path is at least this large too
+	}
+	if h.keys == nil {
+		h.keys = make(map[string]bool)
+	}
+	key := pathKey(it.Path)
+	switch {
+	case h.keys[key]:
+		h.merge(key, it)
+	case len(h.data) < h.k:
+		h.keys[key] = true
+		h.data = append(h.data, it)
+		h.up(len(h.data) - 1)
+	default:
+		// Larger than the smallest: replace root.
+		delete(h.keys, pathKey(h.data[0].Path))
+		h.keys[key] = true
+		h.data[0] = it
+		h.down(0)
+	}
+	if len(h.data) == h.k {
+		h.floor.Store(h.data[0].Size + 1)
+	}
+}
+
+// merge: it is a path already kept under another casing (c:\ and C:\
+// roots, or a junction). One row stays, with the casing seen first and
+// the larger size.
+func (h *minHeap) merge(key string, it item) {
+	for i := range h.data {
+		if pathKey(h.data[i].Path) != key {
+			continue
+		}
+		if it.Size > h.data[i].Size {
+			it.Path = h.data[i].Path
+			h.data[i] = it
+			h.down(i)
+		}
+		return
+	}
+}
+
+// pathKey: a path's identity for deduplication. NTFS names are
+// case-insensitive, so on Windows c:\Users and C:\USERS are one key.
+func pathKey(p string) string {
+	p = filepath.Clean(p)
+	if runtime.GOOS == "windows" {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// each calls f on every kept item in place; sizes must not change.
+func (h *minHeap) each(f func(*item)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.data {
+		f(&h.data[i])
+	}
+}
+
+func (h *minHeap) up(i int) {
+	for i > 0 {
+		p := (i - 1) / 2
+		if h.data[p].Size <= h.data[i].Size {
+			break
+		}
+		h.data[p], h.data[i] = h.data[i], h.data[p]
+		i = p
+	}
+}
+
+func (h *minHeap) down(i int) {
+	n := len(h.data)
+	for {
+		l := 2*i + 1
+		r := l + 1
+		small := i
+		if l < n && h.data[l].Size < h.data[small].Size {
+			small = l
+		}
+		if r < n && h.data[r].Size < h.data[small].Size {
+			small = r
+		}
+		if small == i {
+			break
+		}
+		h.data[i], h.data[small] = h.data[small], h.data[i]
+		i = small
+	}
+}
+
+// sortedDesc returns a snapshot of heap contents sorted by size (largest first).
+func (h *minHeap) sortedDesc() []item {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]item, len(h.data))
+	copy(out, h.data)
+	sort.Slice(out, func(i, j int) bool { return out[i].Size > out[j].Size })
+	return out
+}
+
+// ########### BYTES: HUMAN READABLE ##################
+// humanBytes: legacy helper (kept intact); not used in output table.
+func humanBytes(n int64) string {
+	const (
+		_        = iota
+		KB int64 = 1 << (10 * iota)
+		MB
+		GB
+		TTB
+		PB
+	)
+	f := func(v float64, unit string) string { return fmt.Sprintf("%.2f %s", v, unit) }
+	switch {
+	case n >= PB:
+		return f(float64(n)/float64(PB), "PB")
+	case n >= TTB:
+		return f(float64(n)/float64(TTB), "TB")
+	case n >= GB:
+		return f(float64(n)/float64(GB), "GB")
+	case n >= MB:
+		return f(float64(n)/float64(MB), "MB")
+	case n >= KB:
+		return f(float64(n)/float64(KB), "MB") // Note: mismatch kept to preserve original.
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// parseByteSize: "500MB", "1.5 GB", "4096" as bytes. Units are binary
+// like humanBytesFixed's; the B is optional ("2G").
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(s, "B")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGTP", s[n-1]); i >= 0 {
+			mult, s = 1<<(10*(i+1)), strings.TrimSpace(s[:n-1])
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%q is not a size like 500MB or 1.5GB", v)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// humanBytesFixed: used for table output; correct units for KB/MB/etc.
+func humanBytesFixed(n int64) string {
+	const (
+		KB = 1 << 10
+		MB = 1 << 20
+		GB = 1 << 30
+		TB = 1 << 40
+		PB = 1 << 50
+	)
+	switch {
+	case n >= PB:
+		return fmt.Sprintf("%.2f PB", float64(n)/float64(PB))
+	case n >= TB:
+		return fmt.Sprintf("%.2f TB", float64(n)/float64(TB))
+	case n >= GB:
+		return fmt.Sprintf("%.2f GB", float64(n)/float64(GB))
+	case n >= MB:
+		return fmt.Sprintf("%.2f MB", float64(n)/float64(MB))
+	case n >= KB:
+		return fmt.Sprintf("%.2f KB", float64(n)/float64(KB))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// displayPath: the path as shown in tables, with its tag if any.
+func displayPath(it item) string {
+	p := it.Path
+	if it.Tag != "" {
+		p += " [" + it.Tag + "]"
+	}
+	if it.ID != "" {
+		p += " (id " + it.ID + ")"
+	}
+	return p
+}
+
+// sizeLabel: human size for tables, prefixed with ≥ when it is a lower bound.
+func sizeLabel(it item) string {
+	if it.Partial {
+		return "≥" + humanBytesFixed(it.Size)
+	}
+	return humanBytesFixed(it.Size)
+}
+
+// fileStatsCells: "FILES\tAVG\t~MEDIAN" cells for -columns=files; the
+// sizes are "-" for a directory with no files.
+func fileStatsCells(it item) string {
+	if it.Files == 0 {
+		return "0\t-\t-"
+	}
+	return fmt.Sprintf("%d\t%s\t~%s", it.Files, humanBytesFixed(it.Size/it.Files), humanBytesFixed(it.Median))
+}
+
+// parentHeader / parentCell: the -columns=parent column, "" when it is
+// off. The cell starts with its tab so it can follow DRIVE%.
+func parentHeader(cfg walkCfg) string {
+	if !cfg.parentPct {
+		return ""
+	}
+	return "%PARENT\t"
+}
+
+func parentCell(cfg walkCfg, it item) string {
+	if !cfg.parentPct {
+		return ""
+	}
+	if it.Parent <= 0 {
+		return "\tn/a"
+	}
+	return fmt.Sprintf("\t%.2f%%", float64(it.Size)/float64(it.Parent)*100)
+}
+
+// seenHeader / seenCell: the -columns=seen column, "" when it is off. The
+// cell is the time since the scan started ("+1m05s"), or the clock time
+// for entries carried over from an earlier run (-resume).
+func seenHeader(cfg walkCfg) string {
+	if !cfg.seenCol {
+		return ""
+	}
+	return "SEEN\t"
+}
+
+func seenCell(cfg walkCfg, start time.Time, it item) string {
+	if !cfg.seenCol {
+		return ""
+	}
+	if it.SeenAt.Before(start) {
+		return "\t" + it.SeenAt.Format("01-02 15:04:05")
+	}
+	return "\t+" + it.SeenAt.Sub(start).Truncate(time.Second).String()
+}
+
+// modifiedCell: a directory's last change for MODIFIED; "-" when unknown.
+// Timestamps ahead of this machine's clock are marked rather than trusted.
+func modifiedCell(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	s := t.Local().Format("2006-01-02 15:04")
+	if t.After(time.Now()) {
+		s += " (future)"
+	}
+	return s
+}
+
+// ########### OUTPUT: TABLE COLUMNS ##################
+// -columns names columns of the Largest Directories and Largest Files
+// tables. Naming only extras (dir, files, parent, seen, modified) adds
+// them to the usual layout, as it always has. Naming any of rank, size,
+// total, delta, drive%, path or name makes the list the whole layout:
+// those columns alone, left to right as given. A column with nothing to
+// show in a table (delta without -baseline, files for files) is left out
+// of that table. NAME:N caps a column at N characters; a longer path or
+// DIR loses its front, anything else its end.
+
+// tableCol: one -columns entry.
+type tableCol struct {
+	name  string
+	width int // 0 = as wide as the widest cell
+}
+
+// columnSpec: the parsed -columns; full when it is the whole layout.
+type columnSpec struct {
+	cols []tableCol
+	full bool
+}
+
+// columnNames: every -columns name; true for those that make a layout.
+var columnNames = map[string]bool{
+	"rank": true, "size": true, "total": true, "delta": true, "drive%": true, "path": true, "name": true,
+	"dir": false, "files": false, "parent": false, "seen": false, "modified": false,
+}
+
+func parseColumns(spec string) (columnSpec, error) {
+	var cs columnSpec
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		name, width, sized := strings.Cut(f, ":")
+		full, ok := columnNames[name]
+		if !ok {
+			return cs, fmt.Errorf("unknown column %q (valid: %s)", name, strings.Join(slices.Sorted(maps.Keys(columnNames)), ", "))
+		}
+		if cs.has(name) {
+			return cs, fmt.Errorf("column %q given twice", name)
+		}
+		c := tableCol{name: name}
+		if sized {
+			n, err := strconv.Atoi(width)
+			if err != nil || n < 2 {
+				return cs, fmt.Errorf("column %q: the width must be a number, at least 2", f)
+			}
+			c.width = n
+		}
+		cs.cols = append(cs.cols, c)
+		cs.full = cs.full || full
+	}
+	return cs, nil
+}
+
+func (cs columnSpec) has(name string) bool {
+	return slices.ContainsFunc(cs.cols, func(c tableCol) bool { return c.name == name })
+}
+
+// tableCtx: what the cells of one table are drawn from.
+type tableCtx struct {
+	cfg   walkCfg
+	dsc   *driveSpaceCache
+	base  *baseline
+	start time.Time
+	color bool
+	dirs  bool // Largest Directories rather than Largest Files
+}
+
+// layout: the columns of tc's table, in order.
+func (cs columnSpec) layout(tc tableCtx) []tableCol {
+	names := []string{"rank", "size", "total", "delta", "drive%", "parent", "seen", "files", "modified", "path"}
+	if !tc.dirs {
+		names = []string{"rank", "size", "delta", "drive%", "parent", "seen", "path"}
+		if cs.has("dir") {
+			names = append(names[:len(names)-1], "dir", "name")
+		}
+	}
+	var out []tableCol
+	if cs.full {
+		out = cs.cols
+	} else {
+		for _, n := range names {
+			c := tableCol{name: n}
+			if i := slices.IndexFunc(cs.cols, func(c tableCol) bool { return c.name == n }); i >= 0 {
+				c = cs.cols[i]
+			}
+			out = append(out, c)
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(out), func(c tableCol) bool { return !tc.shows(c.name) })
+}
+
+// shows: whether column name has anything to show in tc's table.
+func (tc tableCtx) shows(name string) bool {
+	switch name {
+	case "total":
+		return tc.dirs && tc.cfg.rankExclusive
+	case "delta":
+		return tc.base != nil
+	case "parent":
+		return tc.cfg.parentPct
+	case "seen":
+		return tc.cfg.seenCol
+	case "files":
+		return tc.dirs && tc.cfg.fileStats
+	case "modified":
+		return tc.cfg.modifiedCol
+	}
+	return true
+}
+
+func (tc tableCtx) header(name string) string {
+	switch name {
+	case "size":
+		if tc.dirs && tc.cfg.rankExclusive {
+			return "EXCL. SIZE"
+		}
+	case "drive%":
+		return tc.cfg.pctBase.header()
+	case "parent":
+		return "%PARENT"
+	case "files":
+		return "FILES\tAVG\t~MEDIAN"
+	}
+	return strings.ToUpper(name)
+}
+
+// cell: row i's text for column c, cut to its width; sizes are painted
+// after the cut so no escape sequence is split.
+func (tc tableCtx) cell(c tableCol, i int, it item) string {
+	var v string
+	switch c.name {
+	case "rank":
+		v = strconv.Itoa(i + 1)
+	case "size":
+		return paintSize(tc.color, it, cutCell(sizeLabel(it), c.width, false))
+	case "total":
+		v = humanBytesFixed(it.total())
+	case "delta":
+		v = tc.base.label(it)
+	case "drive%":
+		v = tc.cfg.pctBase.cell(it.Size, tc.dsc.spaceFor(it.Path))
+	case "parent":
+		v = strings.TrimPrefix(parentCell(tc.cfg, it), "\t")
+	case "seen":
+		v = strings.TrimPrefix(seenCell(tc.cfg, tc.start, it), "\t")
+	case "files":
+		return fileStatsCells(it) // three cells; a width would cut across them
+	case "modified":
+		v = modifiedCell(it.Modified)
+	case "path":
+		return cutCell(displayPath(it), c.width, true)
+	case "dir":
+		v, _ = splitPath(it.Path)
+		return cutCell(v, c.width, true)
+	case "name":
+		_, v = splitPath(it.Path)
+	}
+	return cutCell(v, c.width, false)
+}
+
+// cutCell: s in at most width runes (0 = any), cut at the front for a
+// path, else at the end, marked with "…".
+func cutCell(s string, width int, path bool) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if path {
+		return truncLeft(s, width)
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// print writes the table of items under cols to out.
+func (tc tableCtx) print(out io.Writer, cols []tableCol, items []item) {
+	w := newTable(out)
+	hdr := make([]string, len(cols))
+	for j, c := range cols {
+		hdr[j] = tc.header(c.name)
+	}
+	fmt.Fprintln(w, paint(tc.color, ansiBold, strings.Join(hdr, "\t")))
+	row := make([]string, len(cols))
+	for i, it := range items {
+		for j, c := range cols {
+			row[j] = tc.cell(c, i, it)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// ########### OUTPUT: COLOR & ALIGNMENT ##################
+// ANSI SGR sequences used by the table output.
+const (
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// paint wraps s in an SGR sequence when color is on.
+func paint(on bool, code, s string) string {
+	if !on {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// sizeCell: sizeLabel, red from 1 GB and yellow from 100 MB when colored.
+func sizeCell(it item, color bool) string {
+	return paintSize(color, it, sizeLabel(it))
+}
+
+// paintSize: text, which shows it's size, in sizeCell's color for it.
+func paintSize(color bool, it item, text string) string {
+	switch {
+	case it.Size >= 1<<30:
+		return paint(color, ansiRed, text)
+	case it.Size >= 100<<20:
+		return paint(color, ansiYellow, text)
+	}
+	return text
+}
+
+// table: a drop-in for the tabwriter setup the tables use (min width 2,
+// padding 2, spaces), except that a cell's width is its visible width.
+// text/tabwriter counts ANSI escape bytes as columns, which skews the
+// padding of colored cells on a terminal or under less -R.
+type table struct {
+	out io.Writer
+	buf []byte
+}
+
+func newTable(out io.Writer) *table { return &table{out: out} }
+
+func (t *table) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	return len(p), nil
+}
+
+// Flush aligns every tab-terminated cell to its column's widest cell; the
+// text after a line's last tab is written as is.
+func (t *table) Flush() error {
+	lines := strings.SplitAfter(string(t.buf), "\n")
+	t.buf = t.buf[:0]
+	var widths []int
+	for _, line := range lines {
+		cells := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+		for i, c := range cells[:len(cells)-1] {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], visibleWidth(c))
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		cells := strings.Split(line, "\t")
+		for i, c := range cells[:len(cells)-1] {
+			b.WriteString(c)
+			b.WriteString(strings.Repeat(" ", widths[i]+2-visibleWidth(c)))
+		}
+		b.WriteString(cells[len(cells)-1])
+	}
+	_, err := io.WriteString(t.out, b.String())
+	return err
+}
+
+// visibleWidth: runes in s, not counting ANSI SGR sequences.
+func visibleWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			for i += 2; i < len(s) && s[i] != 'm'; i++ {
+			}
+			continue
+		}
+		if s[i]&0xC0 != 0x80 { // count rune starts only
+			n++
+		}
+	}
+	return n
+}
+
+// ########### CONFIG & STATS ##################
+// walkCfg: controls traversal behavior and filtering.
+type walkCfg struct {
+	topK          int
+	workers       int
+	autoWorkers   bool             // -auto-workers: workers is the ceiling, tuned by throughput
+	volumes       *volumeLimiter   // -per-volume-workers: directory reads per volume
+	followLinks   bool             // some directory links are followed: reparse has symlink or junction
+	reparse       reparseKinds     // -include-reparse: the kinds of reparse point walked or counted
+	rootVolumes   []string         // volume names of the roots' drives, for reparse[reparseMount]
+	followDepth   int              // -follow-links-depth: levels walked past a link, none past links inside it; 0 = unbounded
+	link          linkWalk         // the links crossed to reach this directory
+	sizeSkipped   string           // -report-skipped-bytes: "", "estimate" or "full"
+	progressPaths bool             // -progress-paths: track the directory entered last for the progress line
+	ntfsMFT       bool             // -ntfs-mft: size NTFS volume roots from the MFT
+	maxDepth      int              // 0 means unlimited; deeper dirs are not read at all
+	reportDepth   int              // 0 means unlimited; deeper dirs are read but not ranked
+	minRankDepth  int              // -min-report-depth: shallower dirs are read but not ranked; 0 = off
+	rankRoots     bool             // each root's total is a dirTop row too (see rootRows)
+	pctBase       percentBase      // -percent-base: what the DRIVE% column measures rows against
+	rankExclusive bool             // -rank=exclusive: rank directories without their largest subdirectory
+	onError       errorHandler     // decides what follows a walk error; nil = defaultOnError
+	abort         func(*scanAbort) // stops the whole scan; set by startScan
+	huge          *hugeLog         // nil unless -skip-if-entries-over is set
+	fileStats     bool             // -columns=files: count and sketch file sizes per directory
+	parentPct     bool             // -columns=parent: record each entry's parent size
+	seenCol       bool             // -columns=seen: show when each entry was measured
+	modifiedCol   bool             // -columns=modified: show each directory's last change
+	naturalPaths  bool             // -sort-paths-natural: "img2" before "img10" in path-ordered lists
+	skipHidden    bool
+	skipPatterns  []string
+	expectDenied  []string // -expected-denied: access denied below these is not an error
+	showProgress  bool
+	combined      bool              // -combined: files and dirs share one heap
+	collapseDirs  bool              // -combined -collapse: drop dirs that are ~one big file
+	owner         *ownerFilter      // nil unless -owner is set
+	metric        metricFunc        // what a file counts for; nil = logical size, like du --apparent-size
+	metricName    string            // -metric name of metric, for the summary and JSON
+	sparse        *sparseFilter     // nil unless -sparse-only is set
+	cloudLocal    *allocSizer       // sizes cloud placeholders by what is stored locally; nil with -cloud-size=logical
+	owners        *ownerTally       // nil unless -group-by-owner is set
+	filesUnder    []string          // -files-under: only files below these rank in fileTop
+	links         *linkLog          // nil unless -report-links is set
+	special       *specialLog       // nil unless -report-special is set
+	sparseFiles   *sparseLog        // nil unless -report-sparse is set
+	children      *childLog         // nil unless -children or -breakdown is set
+	breakdown     map[string]string // -labels; nil unless -breakdown is set
+	caches        *cacheReport      // nil unless -caches is set
+	rollups       *rollupReport     // nil unless -rollup is set
+	winSxS        string            // pathKey of %WINDIR%\WinSxS, tagged in dirTop; "" with -include-winsxs
+	concentration float64           // -concentration as a fraction; 0 = off
+	concTop       *minHeap          // concentrated dirs; set by newScan when concentration > 0
+	changedSince  time.Time         // -changed-since cutoff; zero = off
+	changedTop    *minHeap          // dirs changed after changedSince; set by newScan
+	written       *writtenRange     // -written-between; nil = off
+	find          *findLog          // -find-ext: every matching file, not just the top K; nil = off
+	subtrees      *subtreeLog       // -checkpoint, or -resume from a checkpoint: finished shallow subtrees; nil = off
+	tally         *subtreeTally     // errors and skips in the subtree subtrees records; nil = off
+	writtenDays   *writtenLog       // bytes per day inside written; set by newScan
+	index         *dirIndex         // every directory total; -index-serve's rebuilds only
+	config        *effectiveConfig  // the run's flags, embedded in JSON results
+	sqlite        *sqliteExport     // nil unless -sqlite is set
+	names         *nameCheck        // nil unless -max-name-length or -names-ascii is set
+	maxRoots      int               // roots walked at once; 0 = all
+	mountDirs     bool              // list unfollowed volume mount points in dirTop as "[mount]"
+	mountSizes    bool              // ...and size each one with a separate walk
+	netRate       *time.Ticker      // nil = unlimited; one tick per directory listing on a UNC path
+	dirTimeout    time.Duration     // 0 = wait as long as a listing takes
+}
+
+// collapseRatio: with -collapse, a directory is suppressed when a single file
+// inside it accounts for at least this fraction of its total size.
+const collapseRatio = 0.98
+
+// dirAgg: what a walkDir call reports up to its parent.
+type dirAgg struct {
+	size    int64       // total bytes in the subtree
+	maxFile int64       // largest single file anywhere in the subtree
+	partial bool        // some descendant was cut off by -depth-scan
+	netLost bool        // some descendant couldn't be listed because of a network error
+	files   int64       // files counted in the subtree
+	dirs    int64       // directories walkDir listed in the subtree, itself included
+	sizes   *sizeSketch // file size distribution; nil unless -columns=files
+
+	// Direct children only; not merged upwards.
+	maxChild     int64 // largest direct child (file or subdirectory)
+	maxChildPath string
+	maxDir       int64     // largest direct subdirectory; -rank=exclusive
+	newest       time.Time // newest mtime among the direct children
+	mtime        time.Time // the directory's own mtime, set by the parent's walk
+}
+
+// add merges a child subtree's aggregate into a. maxChild is left alone:
+// walkDir sets it from the direct children only (see child).
+func (a *dirAgg) add(c dirAgg) {
+	a.size += c.size
+	if c.maxFile > a.maxFile {
+		a.maxFile = c.maxFile
+	}
+	a.partial = a.partial || c.partial
+	a.netLost = a.netLost || c.netLost
+	a.files += c.files
+	a.dirs += c.dirs
+	if a.sizes != nil && c.sizes != nil {
+		a.sizes.merge(c.sizes)
+	}
+}
+
+// child notes a direct child of size n, last modified at mtime.
+func (a *dirAgg) child(path string, n int64, mtime time.Time) {
+	if n > a.maxChild {
+		a.maxChild, a.maxChildPath = n, path
+	}
+	if mtime.After(a.newest) {
+		a.newest = mtime
+	}
+}
+
+// childDir: child for a direct subdirectory.
+func (a *dirAgg) childDir(path string, n int64, mtime time.Time) {
+	a.child(path, n, mtime)
+	a.maxDir = max(a.maxDir, n)
+}
+
+// modified: when the directory last changed, as far as its own mtime and
+// its direct children's tell.
+func (a *dirAgg) modified() time.Time {
+	if a.newest.After(a.mtime) {
+		return a.newest
+	}
+	return a.mtime
+}
+
+// sizeSketch: a log-scale histogram of file sizes with four buckets per
+// power of two (sizes below 8 get one bucket each). Merging adds counts,
+// so parallel and inline subtrees combine exactly; the median read from
+// it is a bucket midpoint, within 12.5% of the true value.
+type sizeSketch [8 + 60*4]uint32
+
+// sketchBucket: the bucket holding n.
+func sketchBucket(n int64) int {
+	if n < 8 {
+		return int(max(n, 0))
+	}
+	b := bits.Len64(uint64(n)) // n is in [2^(b-1), 2^b), b >= 4
+	return 8 + (b-4)*4 + int(n>>(b-3)&3)
+}
+
+func (k *sizeSketch) add(n int64) { k[sketchBucket(n)]++ }
+
+func (k *sizeSketch) merge(o *sizeSketch) {
+	for i, c := range o {
+		k[i] += c
+	}
+}
+
+// median: the midpoint of the bucket holding the middle file; 0 when empty.
+func (k *sizeSketch) median() int64 {
+	if k == nil {
+		return 0
+	}
+	var n int64
+	for _, c := range k {
+		n += int64(c)
+	}
+	if n == 0 {
+		return 0
+	}
+	rank := (n + 1) / 2
+	for i, c := range k {
+		if rank -= int64(c); rank > 0 {
+			continue
+		}
+		if i < 8 {
+			return int64(i)
+		}
+		b, sub := (i-8)/4+4, int64((i-8)%4)
+		lo, width := (4+sub)<<(b-3), int64(1)<<(b-3)
+		return lo + width/2
+	}
+	return 0
+}
+
+// stats: atomically tracked counters for progress + summary.
+type stats struct {
+	filesSeen int64
+	dirsSeen  int64
+	bytesSeen int64 // file bytes counted so far; -auto-workers' throughput signal
+	skipped   int64 // total across all skip reasons
+	errors    int64
+	netErrors int64 // the subset of errors from the network layer (share gone, timeouts)
+	denied    int64 // access denied under an -expected-denied pattern; not in errors
+	notOwned  int64 // files ignored by the -owner filter
+	notSparse int64 // files ignored by -sparse-only
+	cloud     int64 // cloud placeholder files seen
+	online    int64 // their logical bytes not stored locally, i.e. left out under -cloud-size=local
+	vanished  int64 // entries deleted between their parent's listing and reading them; not errors
+	retyped   int64 // entries that turned from file to directory or back in that window; not errors
+
+	special [numSpecialKinds]int64 // devices, sockets, pipes...: listed but never sized or opened
+
+	listNanos int64 // worker time in directory listings (and -ntfs-mft reads)
+	statNanos int64 // worker time sizing entries: Info, locked-file fallback, the metric
+
+	skippedBy    [numSkipReasons]int64 // per-reason counts
+	skippedBytes [numSkipReasons]int64 // per-reason bytes, where known at skip time
+
+	current atomic.Value // -progress-paths: the directory walkDir entered last
+
+	excludedDirs   int64 // -report-skipped-bytes: skipped directories sized
+	excludedBytes  int64 // ...and their bytes
+	excludedApprox int32 // 1 once any of them was estimated
+
+	net netLog // directories left unread by network errors, for -resume
+}
+
+// skipReason: why an entry was left out of the totals.
+type skipReason int
+
+const (
+	skipGlob        skipReason = iota // matched a -skip pattern
+	skipHidden                        // dot-prefixed with -skiphidden
+	skipSymlink                       // symlink/junction without -followlinks
+	skipDepth                         // below -maxdepth
+	skipPlaceholder                   // cloud placeholder (online-only file)
+	skipOther
+	skipTimeout // listing took longer than -dir-timeout
+	skipHuge    // listed more than -skip-if-entries-over entries; estimated
+	numSkipReasons
+)
+
+var skipReasonNames = [numSkipReasons]string{"glob", "hidden", "symlink", "depth", "placeholder", "other", "timeout", "huge"}
+
+// skip records one skipped entry; bytes is 0 when the size isn't cheaply known.
+func (s *stats) skip(cfg walkCfg, r skipReason, bytes int64) {
+	atomic.AddInt64(&s.skipped, 1)
+	atomic.AddInt64(&s.skippedBy[r], 1)
+	if bytes > 0 {
+		atomic.AddInt64(&s.skippedBytes[r], bytes)
+	}
+	cfg.tally.skip(r, bytes)
+}
+
+// exclude sizes a skipped directory for -report-skipped-bytes.
+func (s *stats) exclude(ctx context.Context, cfg walkCfg, path string) {
+	if cfg.sizeSkipped == "" {
+		return
+	}
+	b, exact := skippedDirSize(ctx, path, cfg.sizeSkipped == "full")
+	atomic.AddInt64(&s.excludedDirs, 1)
+	atomic.AddInt64(&s.excludedBytes, b)
+	if !exact {
+		atomic.StoreInt32(&s.excludedApprox, 1)
+	}
+}
+
+// excludedLine: "Excluded ~45.00 GB across 12 skipped directories
+// (estimated)", or "" when nothing was sized.
+func (s *stats) excludedLine() string {
+	n := atomic.LoadInt64(&s.excludedDirs)
+	if n == 0 {
+		return ""
+	}
+	b := humanBytesFixed(atomic.LoadInt64(&s.excludedBytes))
+	if atomic.LoadInt32(&s.excludedApprox) == 1 {
+		return fmt.Sprintf("Excluded ~%s across %d skipped directories (estimated)", b, n)
+	}
+	return fmt.Sprintf("Excluded %s across %d skipped directories", b, n)
+}
+
+// estimateListings: directories an estimate lists per skipped directory.
+const estimateListings = 64
+
+// skippedDirSize: logical bytes under path. With full, every directory
+// is listed and the count is exact; otherwise at most estimateListings
+// are, breadth first, and the directories left over are assumed to hold
+// as much on average as the listed ones (exact is then false).
+func skippedDirSize(ctx context.Context, path string, full bool) (bytes int64, exact bool) {
+	queue := []string{path}
+	listed := 0
+	for len(queue) > 0 {
+		if ctx.Err() != nil {
+			return bytes, false
+		}
+		if !full && listed == estimateListings {
+			return bytes + bytes*int64(len(queue))/int64(listed), false
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		entries, err := os.ReadDir(dir)
+		listed++
+		if err != nil {
+			continue
+		}
+		for _, de := range entries {
+			switch {
+			case de.IsDir():
+				queue = append(queue, filepath.Join(dir, de.Name()))
+			case de.Type().IsRegular():
+				if info, err := de.Info(); err == nil {
+					bytes += info.Size()
+				}
+			}
+		}
+	}
+	return bytes, true
+}
+
+// failed records one entry that couldn't be read. Access denied at or
+// below an -expected-denied pattern is counted apart from errors.
+// It returns false for those.
+func (s *stats) failed(cfg walkCfg, path string, err error) bool {
+	if errors.Is(err, fs.ErrPermission) && expectedDenial(path, cfg.expectDenied) {
+		atomic.AddInt64(&s.denied, 1)
+		return false
+	}
+	atomic.AddInt64(&s.errors, 1)
+	cfg.tally.failed()
+	return true
+}
+
+// skipBreakdown: non-empty categories as "glob=3 (1.20 GB), depth=12".
+func (s *stats) skipBreakdown() string {
+	var parts []string
+	for r := skipReason(0); r < numSkipReasons; r++ {
+		n := atomic.LoadInt64(&s.skippedBy[r])
+		if n == 0 {
+			continue
+		}
+		part := fmt.Sprintf("%s=%d", skipReasonNames[r], n)
+		if b := atomic.LoadInt64(&s.skippedBytes[r]); b > 0 {
+			part += fmt.Sprintf(" (%s)", humanBytesFixed(b))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// stringList: a repeatable string flag (e.g. -owner=A -owner=B).
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// skipSizing: the -report-skipped-bytes flag; given bare it means estimate.
+type skipSizing struct{ mode string }
+
+func (f *skipSizing) String() string   { return f.mode }
+func (f *skipSizing) IsBoolFlag() bool { return true }
+
+func (f *skipSizing) Set(v string) error {
+	switch v {
+	case "true", "estimate":
+		f.mode = "estimate"
+	case "full":
+		f.mode = "full"
+	case "false", "":
+		f.mode = ""
+	default:
+		return fmt.Errorf("want estimate or full, not %q", v)
+	}
+	return nil
+}
+
+// plainConflicts: the flags that change what -plain pins down: colors,
+// the table columns and the progress line. Keep -plain's help in step.
+var plainConflicts = map[string]bool{"color": true, "columns": true, "compact": true, "progress-paths": true}
+
+// ########### BUILD INFO ##################
+// version and commit are set by release builds:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// Otherwise -version falls back to what the Go toolchain embedded
+// (module version for go install, VCS revision for builds in a checkout).
+var (
+	version = ""
+	commit  = ""
+)
+
+// printVersion: the -version output.
+func printVersion() {
+	v, c, built, modified := version, commit, "", false
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			v = bi.Main.Version
+		}
+		for _, st := range bi.Settings {
+			switch st.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = st.Value
+				}
+			case "vcs.time":
+				built = st.Value
+			case "vcs.modified":
+				modified = st.Value == "true"
+			}
+		}
+	}
+	if v == "" {
+		v = "dev"
+	}
+	fmt.Printf("gosize %s\n", v)
+	if c != "" {
+		if modified {
+			c += " (modified)"
+		}
+		fmt.Printf("commit: %s\n", c)
+	}
+	if built != "" {
+		fmt.Printf("commit time: %s\n", built)
+	}
+	fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("json schema: %s\n", schemaVersion)
+}
+
+// ########### MAIN: FLAGS, ROOTS, SCAN, PRINT ##################
+func main() {
+	os.Exit(run())
+}
+
+// run is main without the os.Exit, so every deferred step (closing the
+// -net-user connections, saving the baseline, sending the mail) runs on
+// every way out. It returns the exit code: 2 for bad flags or unusable
+// roots, 1 when the scan or the output failed.
+func run() (code int) {
+	// ----- Flags -----
+	var (
+		printCfg      = flag.Bool("print-config", false, "print the effective configuration (every flag's resolved value) as JSON, then exit")
+		withConfig    = flag.String("with-config", "", "run with the configuration in this -print-config dump, ignoring the other flags")
+		showVersion   = flag.Bool("version", false, "print the GoSize version, build commit and Go version, then exit")
+		topK          = flag.Int("top", 20, "number of largest files and directories to keep")
+		workers       = flag.Int("workers-io", 2*runtime.NumCPU(), "concurrent directory workers; walking is IO-bound, so this defaults above the CPU count")
+		maxRoots      = flag.Int("max-roots", 0, "scan at most this many roots at once, the rest in -roots order (0 = all; 1-2 suits spinning disks)")
+		childrenOf    = flag.String("children", "", "scan only this directory and list every direct subdirectory with its full size and share; the top tables are shown only if -top is also given")
+		breakdown     = flag.Bool("breakdown", false, "add a table of totals per top-level folder name under the roots (merged across roots; see -labels)")
+		labelsFlag    = flag.String("labels", "", "with -breakdown, display labels for folder names, e.g. \"projA=Project A,projB=Project B\"; folders with the same label are added together")
+		showID        = flag.Bool("show-id", false, "debug: add each ranked entry's file identity (volume serial:file index) to the output, to tell hard links and aliases apart")
+		showUnderFlag = flag.String("show-under", "", "only show ranked entries under these comma-separated paths (case-insensitive); filters the kept -top entries after the scan, nothing is rescanned")
+		rootsFlag     = flag.String("roots", "", "comma-separated roots to scan, globs allowed (default: detect all drives, e.g. C:\\, D:\\)")
+		followLinks   = flag.Bool("followlinks", false, "follow symlinks/junctions (off by default to avoid cycles); same as -include-reparse=symlink,junction")
+		pctBase       = flag.String("percent-base", "capacity", "what the DRIVE% column measures each row against: capacity (the volume's size), used (its used space; the column becomes USED%) or free (its free space, as \"0.4× free\")")
+		mountsAsRoots = flag.Bool("mounts-as-roots", false, "scan volumes mounted into folders under the roots (C:\\Data instead of a drive letter) as roots of their own, with DRIVE% and capacity checks against the mounted volume")
+		followDepth   = flag.Int("follow-links-depth", 0, "follow symlinks/junctions, but only this many directory levels past each link and not into links inside it (0 = off; implies -followlinks)")
+		maxDepth      = flag.Int("maxdepth", 0, "max directory depth to scan (0 = unlimited); deeper sizes are left out, totals marked ≥")
+		depthReport   = flag.Int("depth-report", 0, "scan everything but only rank directories up to this depth (0 = unlimited)")
+		minRankDepth  = flag.Int("min-report-depth", 0, "scan everything but only rank directories at least this deep (1 = the roots' children; 0 = off)")
+		hugeEntries   = flag.Int("skip-if-entries-over", 0, "don't walk directories that list more than this many entries; their files directly inside are summed from the listing, totals marked ≥ (0 = off)")
+		skipHidden    = flag.Bool("skiphidden", false, "skip hidden files and directories")
+		expDenied     = flag.String("expected-denied", strings.Join(defaultExpectedDenied, ","), "comma-separated patterns (\"?:\" = any drive) whose access-denied errors are counted apart from errors; \"\" to count them all")
+		excludeFrom   = flag.String("exclude-from", "", "file of -skip patterns, one per line; blank lines and lines starting with # are ignored (adds to -skip)")
+		skipGlobs     = flag.String("skip", "", "comma-separated filepath.Match patterns to skip (e.g. \"C:\\\\Windows\\\\*,C:\\\\Program Files\\\\*\")")
+		progress      = flag.Bool("progress", true, "periodically print progress to stderr")
+		abortOnErr    = flag.Bool("abort-on-error", false, "stop the whole scan at the first error (not counting -expected-denied ones), print it, show what was counted up to then and exit 1 (same as -on-error=abort)")
+		onErrorFlag   = flag.String("on-error", "skip", "what a read error does: skip (leave out the entry or unreadable directory), continue (also keep the entries a failed listing did return, marked ≥) or abort (see -abort-on-error)")
+		checkpointTo  = flag.String("checkpoint", "", "every -checkpoint-every, save the rankings and counters so far to this JSON file, so a crashed or killed scan leaves what it had; removed when the run ends normally")
+		ckptEvery     = flag.Duration("checkpoint-every", 30*time.Second, "with -checkpoint, how often to save it")
+		etaOn         = flag.Bool("eta", false, "count the directories to scan in a quick listing-only pass alongside the scan, and add an ETA to the progress line once it is done")
+		jsonOut       = flag.Bool("json", false, "output results as JSON (same as -format=json)")
+		format        = flag.String("format", "table", "output format: table, json, or tsv")
+		outTemplate   = flag.String("output-template", "", "text/template run once per item instead of the tables, e.g. '{{.Rank}} {{.HumanSize}} {{.Path}}'")
+		reconcile     = flag.Bool("reconcile", false, "explain the gap between scanned bytes and the volume's used bytes (table output; stderr for other formats)")
+		cloudSize     = flag.String("cloud-size", "local", "with -include-reparse=cloud, what OneDrive and other cloud placeholder files count for: local (the bytes actually stored on this disk) or logical (their full size); either way they are tagged [cloud]")
+		sparseOnly    = flag.Bool("sparse-only", false, "only count files whose allocated size is well below their logical size (sparse VM images, databases)")
+		sparseRatio   = flag.Float64("sparse-ratio", 0.5, "with -sparse-only, the allocated/logical ratio a file must stay below")
+		baseFile      = flag.String("baseline", "", "JSON file from an earlier run: add a DELTA column against it, then replace it with this run's results")
+		baseRO        = flag.Bool("baseline-readonly", false, "with -baseline, compare but leave the file as it is")
+		stdinPaths    = flag.Bool("stdin-paths", false, "rank the files whose paths are read from stdin (one per line) instead of walking -roots")
+		nul           = flag.Bool("0", false, "NUL-delimited I/O: -stdin-paths reads NUL-separated paths, and results are written as with -print0")
+		print0        = flag.Bool("print0", false, "write results as SIZE<tab>LOWER<tab>PATH records ending in NUL, for xargs -0; no tables")
+		emailTo       = flag.String("email-to", "", "comma-separated addresses to mail the report to after the scan (needs -email-smtp)")
+		emailSMTP     = flag.String("email-smtp", "", "SMTP server host:port for -email-to; STARTTLS when offered, login from GOSIZE_SMTP_USER/GOSIZE_SMTP_PASS")
+		emailFrom     = flag.String("email-from", "", "sender address for -email-to (default gosize@HOSTNAME)")
+		emailJSON     = flag.Bool("email-json", false, "attach the -json document to the report mail")
+		emailReq      = flag.Bool("email-required", false, "exit 1 when the report mail can't be sent (default: warn and carry on)")
+		historyFile   = flag.String("history", "", "append one line per root (time, root, total bytes, largest directory) to this log after each run")
+		historyMax    = flag.Int64("history-max-bytes", 0, "with -history, move the log to FILE.1 once it reaches this size (0 = never)")
+		maxNameLen    = flag.Int("max-name-length", 0, "report names longer than this many UTF-16 units, reserved device names (CON, NUL, COM1...) and characters other filesystems reject (0 = off)")
+		namesASCII    = flag.Bool("names-ascii", false, "also report names with non-ASCII characters (implies the name report)")
+		reportSparse  = flag.Bool("report-sparse", false, "add a \"Sparse files\" table: the sparse files (VM images, databases) whose logical size most exceeds what they have allocated, and the total of those phantom bytes")
+		reportSpecial = flag.Bool("report-special", false, "list every device, socket, named pipe and other non-regular file met (they are counted in the summary either way, never sized)")
+		naturalSort   = flag.Bool("sort-paths-natural", false, "order path-sorted lists (links, special files, bad names) by number where names contain digits, so img2 comes before img10")
+		reportLinks   = flag.Bool("report-links", false, "list every symlink, junction and mount point met, with target and type (not followed unless -followlinks)")
+		byOwner       = flag.Bool("group-by-owner", false, "also print bytes and file counts per owning account (one owner lookup per file)")
+		skipErrs      = flag.Bool("skip-errors-silently", false, "with some roots missing or unreadable, scan the rest without warnings and exit 0 instead of 2")
+		rootOrder     = flag.String("root-order", "given", "order of the per-root results (summary, JSON rootStatus, history): given (-roots order), free (least free space first; also the -max-roots start order) or size (largest total first)")
+		sameVolume    = flag.String("same-volume", "skip", "roots that are the same volume (a drive and its NTFS mount point): skip the later ones, or scan them all (counted twice)")
+		colorMode     = flag.String("color", "auto", "color table output: auto (when stdout is a console and NO_COLOR is unset), always (e.g. for less -R), or never")
+		plain         = flag.Bool("plain", false, "stable output for scripts: no color and the default table columns; can't be combined with -color, -columns, -compact or -progress-paths")
+		columns       = flag.String("columns", "", "comma-separated extra columns (dir: split file paths into DIR and NAME; files: FILES, AVG and ~MEDIAN file size per directory; parent: %PARENT, share of the containing directory; seen; modified), or the whole table layout in order when rank, size, total, delta, drive%, path or name is among them; NAME:N caps a column at N characters")
+		combined      = flag.Bool("combined", false, "rank files and directories together in a single list")
+		includeWinSxS = flag.Bool("include-winsxs", false, "rank %WINDIR%\\WinSxS like any other directory, without the note that its apparent size is mostly hard links")
+		pruneBelow    = flag.Float64("prune-below-percent", 0, "leave out of the printed tables every row below this percent of the total scanned bytes, e.g. 1 (0 = off; JSON keeps them)")
+		compact       = flag.Bool("compact", false, "print each ranking as \"SIZE  PATH\" lines, sizes right-aligned to a fixed width, for narrow terminals; drops RANK, DRIVE% and the other columns")
+		collapse      = flag.Bool("collapse", false, "with -combined, hide directories whose size is almost entirely one file")
+		ownerDirs     = flag.Bool("owner-dirs-only", false, "with -owner, check directory owners only and assume files inherit them")
+		ownerStrict   = flag.Bool("owner-strict", false, "with -owner, check every file's owner (overrides -owner-dirs-only)")
+		metricFlag    = flag.String("metric", "", "file size metric used by every total, table and percent: logical or allocated (default logical, or allocated with -apparent-size=false)")
+		apparent      = flag.Bool("apparent-size", true, "report logical file length like du --apparent-size; false = allocated size on disk like plain du")
+		dryRun        = flag.Bool("dry-run", false, "show resolved roots, rules, settings and a two-level preview without sizing anything")
+		controlPipe   = flag.String("control-pipe", "", "serve a newline-delimited JSON control interface on \\\\.\\pipe\\NAME instead of scanning once")
+		mountDirs     = flag.Bool("include-mountpoints-as-dirs", false, "list volume mount points that the walk stops at in the directory table, tagged [mount]")
+		mountSizes    = flag.Bool("mount-sizes", false, "with -include-mountpoints-as-dirs, size each mount point with its own walk (not added to parent totals)")
+		indexServe    = flag.Bool("index-serve", false, "run as a resident size index of -roots, answering -query on \\\\.\\pipe\\NAME (-index-pipe) instead of scanning once")
+		indexPipe     = flag.String("index-pipe", defaultIndexPipe, "pipe name for -index-serve and -query")
+		indexFile     = flag.String("index-file", "", "with -index-serve, save the index here after each scan and load it at startup")
+		indexRefresh  = flag.Duration("index-refresh", time.Hour, "with -index-serve, rescan roots without a change journal this often (0 = scan once)")
+		queryUnder    = flag.String("query", "", "list the largest directories under this path from the -index-serve service; scans the path instead when the service can't answer")
+		grpcAddr      = flag.String("grpc", "", "serve the gRPC scan API on this address (e.g. :9000, which is 127.0.0.1:9000) instead of scanning once; other hosts than loopback need TLS and -grpc-client-ca or -grpc-token-file")
+		grpcCert      = flag.String("grpc-cert", "", "TLS certificate file for -grpc")
+		grpcKey       = flag.String("grpc-key", "", "TLS key file for -grpc")
+		grpcClientCA  = flag.String("grpc-client-ca", "", "with TLS, only accept -grpc clients with a certificate issued by this CA (PEM file)")
+		grpcToken     = flag.String("grpc-token-file", "", "only accept -grpc calls with the metadata \"authorization: Bearer TOKEN\", TOKEN being this file's first line")
+		grpcMax       = flag.Int("grpc-max-scans", 1, "max concurrent scans served by -grpc")
+		netUser       = flag.String("net-user", "", "connect UNC roots as this account (DOMAIN\\user) for the run, then disconnect; password from GOSIZE_NET_PASS or prompted for")
+		netRate       = flag.Int("net-rate", 0, "max directory listings per second on UNC paths (0 = unlimited)")
+		dirTimeout    = flag.Duration("dir-timeout", 0, "skip (and count) a directory whose listing takes longer than this, e.g. 30s; its parents become lower bounds (0 = no limit)")
+		resumeFile    = flag.String("resume", "", "state file: continue from it if it exists; save the unread frontier there after network errors")
+		ntfsMFT       = flag.Bool("ntfs-mft", false, "experimental: size whole NTFS volume roots (C:\\) from the Master File Table in one pass instead of listing every directory; needs administrator rights, falls back to the normal walk on any problem")
+		progressPaths = flag.Bool("progress-paths", false, "end each progress line with the directory entered last, cut to the console width")
+		changedSince  = flag.Duration("changed-since", 0, "add a \"Recently Changed Directories\" table: the largest directories that themselves, or a direct child, changed within this long, e.g. 24h (0 = off)")
+		writtenFlag   = flag.String("written-between", "", "rank only files modified in FROM,TO (dates, whole days, or RFC3339 times; TO is exclusive for a time) and add a \"Written per Day\" table; directory totals still count every file")
+		writtenUTC    = flag.Bool("utc", false, "read -written-between dates, and bucket its days, in UTC instead of local time")
+		concentration = flag.Float64("concentration", 0, "add a \"Concentrated Directories\" table: the largest directories whose biggest direct child holds at least this percent of them, e.g. 90 (0 = off)")
+		sqlitePath    = flag.String("sqlite", "", "also write every scanned file and directory (path, size, mtime, ext, owner, is_dir) to this SQLite database, table entries")
+		sqliteOwners  = flag.Bool("sqlite-owners", false, "fill the owner column of -sqlite (one security lookup per file)")
+		rankBy        = flag.String("rank", "size", "what Largest Directories ranks by: size (the full total) or exclusive (the total minus the largest direct subdirectory, so containers like C:\\Users\\me give way to directories big in their own right)")
+		findExt       = flag.String("find-ext", "", "list every file with one of these comma-separated extensions (e.g. .pst,.mdb,.bak) in a \"Matching files\" table, however many there are; a search next to the ranking")
+		findMin       = flag.String("find-min-size", "0", "with -find-ext, list only files at least this large, e.g. 500MB or 2GB")
+		cachesOn      = flag.Bool("caches", false, "add a \"Reclaimable caches\" table: temp folders, browser, package manager and Windows Update caches met during the walk")
+		benchmark     = flag.Bool("benchmark", false, "build a synthetic tree in the temp folder, scan it with the other flags' settings, print files/s, dirs/s and a score, then delete it")
+		benchShape    = flag.String("benchmark-shape", benchtree.DefaultShape.String(), "with -benchmark, the tree as BREADTHxDEPTHxFILES: subdirectories per directory, levels, files per directory")
+		benchSeed     = flag.Uint64("benchmark-seed", 1, "with -benchmark, the seed the tree's file sizes come from; the same seed and shape give the same tree")
+		benchExisting = flag.String("benchmark-existing", "", "scan this path twice, cold then warm, and print files/s and dirs/s for each instead of the usual tables")
+		owners        stringList
+		sizeSkipped   skipSizing
+		rootRowsFlag  rootRows
+		reparse       = reparseKinds{}
+		filesUnder    stringList
+		cacheDirs     stringList
+		rollups       stringList
+	)
+	flag.IntVar(maxDepth, "depth-scan", 0, "alias for -maxdepth")
+	perVolume := flag.Int("per-volume-workers", 0, "directory listings at once per volume (0 = auto: 2 on disks that report a seek penalty, i.e. spinning disks, no limit elsewhere; -1 = no limit)")
+	autoWorkers := flag.Bool("auto-workers", false, "experimental: start with a few directory workers and adjust the count by measured bytes/sec, up to -workers-io")
+	flag.DurationVar(dirTimeout, "scan-timeout-per-dir", 0, "alias for -dir-timeout")
+	flag.IntVar(workers, "workers", 2*runtime.NumCPU(), "alias for -workers-io")
+	flag.BoolVar(reportLinks, "list-links", false, "alias for -report-links")
+	flag.Var(&filesUnder, "files-under", "only rank files below this directory in Largest Files; totals still cover everything (repeatable)")
+	flag.Var(reparse, "include-reparse", "reparse points to walk or count, which are all left out by default: comma-separated symlink, junction, mount (volume mount points, unless the volume is a root's), cloud (placeholder files), or all")
+	flag.Var(&rollups, "rollup", "add a \"Rollups\" table summing the directories that match this path with one * segment, e.g. C:\\Users\\*\\Downloads: Downloads across all profiles (repeatable)")
+	flag.Var(&cacheDirs, "cache-dir", "with -caches, one more cache location, globs allowed (repeatable)")
+	flag.Var(&rootRowsFlag, "no-aggregate-root", "leave the roots' own totals out of \"Largest Directories\"; =false keeps them. Unset (auto), they are left out only when the scan has a single root that is a folder rather than a whole volume")
+	flag.Var(&sizeSkipped, "report-skipped-bytes", "size the directories skipped by -skip, -skiphidden and depth limits and add \"Excluded ~X across N skipped directories\" to the summary: estimate (the default when given bare; a quick sample) or full (an exact count)")
+	flag.Var(&owners, "owner", "only count files owned by this account, e.g. DOMAIN\\user (repeatable)")
+	flag.Parse()
+
+	if *showVersion {
+		printVersion()
+		return
+	}
+
+	effCfg, cerr := commandLineConfig(*withConfig)
+	if cerr != nil {
+		fmt.Fprintln(os.Stderr, "config:", cerr)
+		return 2
+	}
+	if *printCfg {
+		printConfig(effCfg)
+		return
+	}
+
+	// ----- Output format -----
+	if *jsonOut {
+		*format = "json"
+	}
+	switch *format {
+	case "table", "json", "tsv":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -format %q (valid: table, json, tsv)\n", *format)
+		return 2
+	}
+
+	switch *colorMode {
+	case "auto", "always", "never":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -color %q (valid: auto, always, never)\n", *colorMode)
+		return 2
+	}
+	if *plain {
+		conflict := ""
+		flag.Visit(func(f *flag.Flag) {
+			if plainConflicts[f.Name] && conflict == "" {
+				conflict = f.Name
+			}
+		})
+		if conflict != "" {
+			fmt.Fprintf(os.Stderr, "-plain can't be combined with -%s\n", conflict)
+			return 2
+		}
+		*colorMode = "never"
+	}
+
+	switch *rootOrder {
+	case "given", "free", "size":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -root-order %q (valid: given, free, size)\n", *rootOrder)
+		return 2
+	}
+
+	switch *sameVolume {
+	case "skip", "scan":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -same-volume %q (valid: skip, scan)\n", *sameVolume)
+		return 2
+	}
+
+	var email *emailCfg
+	if *emailTo != "" {
+		if *emailSMTP == "" {
+			fmt.Fprintln(os.Stderr, "-email-to needs -email-smtp=host:port")
+			return 2
+		}
+		email = &emailCfg{from: *emailFrom, server: *emailSMTP, attachJSON: *emailJSON}
+		for _, a := range strings.Split(*emailTo, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				email.to = append(email.to, a)
+			}
+		}
+	}
+
+	var tmpl *template.Template
+	if *outTemplate != "" {
+		text := *outTemplate
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		var err error
+		if tmpl, err = template.New("output").Parse(text); err != nil {
+			fmt.Fprintln(os.Stderr, "bad -output-template:", err)
+			return 2
+		}
+	}
+
+	var base *baseline
+	if *baseFile != "" {
+		var err error
+		switch base, err = loadBaseline(*baseFile); {
+		case isCorrupt(err):
+			fmt.Fprintln(os.Stderr, "baseline: corrupt, ignoring it:", err)
+			base = &baseline{sizes: make(map[string]int64)}
+		case err != nil:
+			fmt.Fprintln(os.Stderr, "baseline:", err)
+			return 2
+		}
+	}
+
+	// ----- Extra columns -----
+	cols, cerr := parseColumns(*columns)
+	if cerr != nil {
+		fmt.Fprintln(os.Stderr, "-columns:", cerr)
+		return 2
+	}
+	splitDir := cols.has("dir") || cols.has("name")
+	fileStats, parentPct, seenCol, modifiedCol := cols.has("files"), cols.has("parent"), cols.has("seen"), cols.has("modified")
+
+	if *followLinks || *followDepth > 0 {
+		reparse[reparseSymlink], reparse[reparseJunction] = true, true
+	}
+	cfg := walkCfg{
+		topK:          *topK,
+		workers:       *workers,
+		fileStats:     fileStats,
+		parentPct:     parentPct,
+		seenCol:       seenCol,
+		modifiedCol:   modifiedCol,
+		naturalPaths:  *naturalSort,
+		config:        effCfg,
+		autoWorkers:   *autoWorkers,
+		volumes:       newVolumeLimiter(*perVolume),
+		maxRoots:      *maxRoots,
+		followLinks:   reparse[reparseSymlink] || reparse[reparseJunction],
+		reparse:       reparse,
+		followDepth:   *followDepth,
+		sizeSkipped:   sizeSkipped.mode,
+		progressPaths: *progressPaths,
+		ntfsMFT:       *ntfsMFT,
+		maxDepth:      *maxDepth,
+		reportDepth:   *depthReport,
+		minRankDepth:  *minRankDepth,
+		skipHidden:    *skipHidden,
+		showProgress:  *progress,
+		combined:      *combined,
+		mountDirs:     *mountDirs,
+		mountSizes:    *mountDirs && *mountSizes,
+		collapseDirs:  *combined && *collapse,
+	}
+	cfg.dirTimeout = *dirTimeout
+	if *netRate > 0 {
+		cfg.netRate = time.NewTicker(time.Second / time.Duration(*netRate))
+	}
+	cfg.metricName = *metricFlag
+	if cfg.metricName == "" {
+		cfg.metricName = "logical"
+		if !*apparent {
+			cfg.metricName = "allocated"
+		}
+	}
+	newMetric, ok := metrics[cfg.metricName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown -metric %q (valid: %s)\n", cfg.metricName, strings.Join(metricNames(), ", "))
+		return 2
+	}
+	cfg.metric = newMetric()
+	switch *cloudSize {
+	case "local":
+		cfg.cloudLocal = newAllocSizer()
+	case "logical":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -cloud-size %q (valid: local, logical)\n", *cloudSize)
+		return 2
+	}
+	if *sparseOnly {
+		if *sparseRatio <= 0 || *sparseRatio > 1 {
+			fmt.Fprintln(os.Stderr, "-sparse-ratio must be in (0, 1]")
+			return 2
+		}
+		cfg.sparse = &sparseFilter{ratio: *sparseRatio, alloc: newAllocSizer()}
+	}
+	for _, d := range filesUnder {
+		cfg.filesUnder = append(cfg.filesUnder, filepath.Clean(d))
+	}
+	if *reportLinks {
+		cfg.links = &linkLog{}
+	}
+	if *reportSparse {
+		cfg.sparseFiles = &sparseLog{k: *topK, alloc: newAllocSizer()}
+	}
+	if *reportSpecial {
+		cfg.special = &specialLog{}
+	}
+	if *maxNameLen < 0 {
+		fmt.Fprintln(os.Stderr, "-max-name-length must not be negative")
+		return 2
+	}
+	if *maxNameLen > 0 || *namesASCII {
+		cfg.names = &nameCheck{maxLen: *maxNameLen, asciiOnly: *namesASCII}
+	}
+	if *cachesOn {
+		cfg.caches = newCacheReport(cacheDirs)
+	}
+	if w := os.Getenv("WINDIR"); w != "" && !*includeWinSxS {
+		cfg.winSxS = pathKey(filepath.Join(w, "WinSxS"))
+	}
+	if len(rollups) > 0 {
+		var err error
+		if cfg.rollups, err = newRollupReport(rollups); err != nil {
+			fmt.Fprintln(os.Stderr, "-rollup:", err)
+			return 2
+		}
+	}
+	if *minRankDepth < 0 || *hugeEntries < 0 {
+		fmt.Fprintln(os.Stderr, "-min-report-depth and -skip-if-entries-over must be 0 or more")
+		return 2
+	}
+	if *depthReport > 0 && *minRankDepth > *depthReport {
+		fmt.Fprintln(os.Stderr, "-min-report-depth is deeper than -depth-report: nothing would be ranked")
+		return 2
+	}
+	if *hugeEntries > 0 {
+		cfg.huge = &hugeLog{limit: *hugeEntries}
+	}
+	switch cfg.pctBase = percentBase(*pctBase); cfg.pctBase {
+	case baseCapacity, baseUsed, baseFree:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -percent-base %q (valid: capacity, used, free)\n", *pctBase)
+		return 2
+	}
+	if *findExt != "" {
+		minSize, err := parseByteSize(*findMin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-find-min-size:", err)
+			return 2
+		}
+		cfg.find = newFindLog(*findExt, minSize)
+	} else if *findMin != "0" {
+		fmt.Fprintln(os.Stderr, "-find-min-size needs -find-ext")
+		return 2
+	}
+	switch *rankBy {
+	case "size":
+	case "exclusive":
+		if *combined {
+			fmt.Fprintln(os.Stderr, "-rank=exclusive can't be combined with -combined: files have no subdirectories to leave out")
+			return 2
+		}
+		cfg.rankExclusive = true
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -rank %q (valid: size, exclusive)\n", *rankBy)
+		return 2
+	}
+	if *followDepth < 0 {
+		fmt.Fprintln(os.Stderr, "-follow-links-depth must be 0 or more")
+		return 2
+	}
+	if *ckptEvery <= 0 {
+		fmt.Fprintln(os.Stderr, "-checkpoint-every must be more than 0")
+		return 2
+	}
+	if *pruneBelow < 0 || *pruneBelow > 100 {
+		fmt.Fprintln(os.Stderr, "-prune-below-percent must be a percent between 0 and 100")
+		return 2
+	}
+	if *concentration < 0 || *concentration > 100 {
+		fmt.Fprintln(os.Stderr, "-concentration must be a percent between 0 and 100")
+		return 2
+	}
+	cfg.concentration = *concentration / 100
+	if *changedSince > 0 {
+		cfg.changedSince = time.Now().Add(-*changedSince)
+	}
+	if *writtenFlag != "" {
+		loc := time.Local
+		if *writtenUTC {
+			loc = time.UTC
+		}
+		var err error
+		if cfg.written, err = parseWrittenRange(*writtenFlag, loc); err != nil {
+			fmt.Fprintln(os.Stderr, "-written-between:", err)
+			return 2
+		}
+	}
+	if *breakdown {
+		var err error
+		if cfg.breakdown, err = parseLabels(*labelsFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "labels:", err)
+			return 2
+		}
+		cfg.children = &childLog{}
+	}
+	if *byOwner {
+		cfg.owners = newOwnerTally()
+	}
+	if len(owners) > 0 {
+		of, err := newOwnerFilter(owners, *ownerDirs && !*ownerStrict)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		cfg.owner = of
+	}
+	if *skipGlobs != "" {
+		parts := strings.Split(*skipGlobs, ",")
+		for _, p := range parts {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				cfg.skipPatterns = append(cfg.skipPatterns, longForm("-skip", p))
+			}
+		}
+	}
+	if *excludeFrom != "" {
+		pats, err := readPatterns(*excludeFrom)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "exclude-from:", err)
+			return 2
+		}
+		for _, p := range pats {
+			cfg.skipPatterns = append(cfg.skipPatterns, longForm("-exclude-from", p))
+		}
+	}
+
+	for _, p := range strings.Split(*expDenied, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.expectDenied = append(cfg.expectDenied, p)
+		}
+	}
+
+	// ----- Resume state -----
+	var resume *resumeState
+	if *resumeFile != "" {
+		var err error
+		switch resume, err = loadResume(*resumeFile); {
+		case isCorrupt(err):
+			fmt.Fprintln(os.Stderr, "resume: corrupt, ignoring it and scanning from the start:", err)
+			resume = nil
+		case err != nil:
+			fmt.Fprintln(os.Stderr, "resume:", err)
+			return 2
+		}
+	}
+
+	// ----- Roots -----
+	if *childrenOf != "" && (*stdinPaths || resume != nil) {
+		fmt.Fprintln(os.Stderr, "-children can't be combined with -stdin-paths or -resume")
+		return 2
+	}
+	var roots []string
+	var err error
+	switch {
+	case *stdinPaths:
+		roots = []string{stdinRoot} // the paths are read once the scan starts
+	case resume != nil && resume.Kind == checkpointKind:
+		roots = resume.Roots
+		fmt.Fprintf(os.Stderr, "resuming from %s: %d directories already walked\n", *resumeFile, len(resume.Completed))
+	case resume != nil:
+		roots = resume.Roots // the frontier only makes sense against the saved roots
+		fmt.Fprintf(os.Stderr, "resuming %d unread directories from %s\n", len(resume.Frontier), *resumeFile)
+	case *queryUnder != "":
+		under := filepath.Clean(*queryUnder)
+		rep, qerr := queryIndex(*indexPipe, under, cfg.topK)
+		if qerr == nil {
+			if *format == "json" {
+				json.NewEncoder(os.Stdout).Encode(rep)
+			} else {
+				printIndexReply(*indexPipe, rep)
+			}
+			return
+		}
+		fmt.Fprintf(os.Stderr, "index: %v; scanning %s instead\n", qerr, under)
+		roots = []string{under}
+	case *childrenOf != "":
+		if roots, err = resolveRoots(*childrenOf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if len(roots) != 1 {
+			fmt.Fprintf(os.Stderr, "-children needs exactly one directory; %q matches %d\n", *childrenOf, len(roots))
+			return 2
+		}
+		if fi, err := os.Stat(roots[0]); err == nil && !fi.IsDir() {
+			fmt.Fprintf(os.Stderr, "-children: %s is not a directory\n", roots[0])
+			return 2
+		}
+		if cfg.children == nil {
+			cfg.children = &childLog{}
+		}
+	case *benchExisting != "":
+		roots = []string{*benchExisting}
+	case *benchmark:
+		roots = []string{os.TempDir()} // where runBenchmark builds its tree
+	default:
+		if roots, err = resolveRoots(*rootsFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	// Every root is checked up front: with none usable nothing runs (exit
+	// 1); otherwise the bad ones are named in the summary and the run ends
+	// with exit 2. Errors below a root are only counted.
+	var invalid []error
+	if !*stdinPaths {
+		usable := roots[:0:0]
+		for _, r := range roots {
+			if err := checkRoot(r); err != nil {
+				invalid = append(invalid, err)
+				continue
+			}
+			usable = append(usable, r)
+		}
+		if len(usable) == 0 {
+			for _, err := range invalid {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			fmt.Fprintln(os.Stderr, "No usable roots: every root is missing or unreadable.")
+			return 1
+		}
+		roots = usable
+		if len(invalid) > 0 && !*skipErrs {
+			for _, err := range invalid {
+				fmt.Fprintln(os.Stderr, "warning:", err)
+			}
+			// Deferred before anything else, so it runs after every other
+			// deferred step, and only sets 2 if none of them failed.
+			defer func() {
+				if code == 0 {
+					code = 2
+				}
+			}()
+		}
+	}
+
+	if *mountsAsRoots && !*stdinPaths && resume == nil {
+		mountedRoots = volumeMountPaths()
+		roots = append(roots, mountsUnder(roots, mountedRoots, cfg.skipPatterns)...)
+	}
+
+	// A volume reached through two roots would be counted twice.
+	var aliases []volumeAlias
+	if !*stdinPaths && resume == nil {
+		var keep []string
+		keep, aliases = sameVolumeRoots(roots, volumeGUID)
+		for i := range aliases {
+			a := &aliases[i]
+			if a.Skipped = *sameVolume == "skip"; a.Skipped {
+				fmt.Fprintf(os.Stderr, "%s is the same volume as %s (%s); skipping it\n", a.Root, a.Same, a.GUID)
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: %s is the same volume as %s (%s); its files are counted twice\n", a.Root, a.Same, a.GUID)
+			}
+		}
+		if *sameVolume == "skip" {
+			roots = keep
+		}
+	}
+
+	if *rootOrder == "free" {
+		byFreeSpace(roots, newDriveSpaceCache())
+	}
+
+	cfg.rankRoots = rootRowsFlag.ranked(roots)
+	if *abortOnErr {
+		*onErrorFlag = "abort"
+	}
+	if h, ok := errorHandlers[*onErrorFlag]; ok {
+		cfg.onError = h
+	} else {
+		fmt.Fprintf(os.Stderr, "unknown -on-error %q (valid: skip, continue, abort)\n", *onErrorFlag)
+		return 2
+	}
+	if cfg.links != nil {
+		cfg.links.setRoots(roots)
+	}
+	if cfg.reparse[reparseMount] {
+		for _, r := range roots {
+			cfg.rootVolumes = append(cfg.rootVolumes, volumeGUID(volumeRoot(r)))
+		}
+	}
+
+	// ----- UNC credentials -----
+	if *netUser != "" {
+		pass := os.Getenv(envNetPass)
+		if pass == "" {
+			if pass, err = promptPassword(*netUser); err != nil {
+				fmt.Fprintln(os.Stderr, "password:", err)
+				return 2
+			}
+		}
+		nc, err := connectShares(roots, *netUser, pass)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer nc.close()
+	}
+
+	if *dryRun {
+		printDryRun(os.Stdout, roots, cfg)
+		return
+	}
+
+	if *benchmark || *benchExisting != "" {
+		var err error
+		cfg.showProgress = false
+		if *benchExisting != "" {
+			err = runBenchmarkExisting(os.Stdout, *benchExisting, cfg)
+		} else {
+			var sh benchtree.Shape
+			if sh, err = benchtree.ParseShape(*benchShape); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			err = runBenchmark(os.Stdout, sh, *benchSeed, cfg)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "benchmark:", err)
+			return 1
+		}
+		return
+	}
+
+	if *sqlitePath != "" && (*grpcAddr != "" || *indexServe || *controlPipe != "") {
+		fmt.Fprintln(os.Stderr, errNoSQLite)
+		return 2
+	}
+
+	if *grpcAddr != "" {
+		o := grpcOptions{addr: *grpcAddr, certFile: *grpcCert, keyFile: *grpcKey, clientCA: *grpcClientCA, tokenFile: *grpcToken, maxScans: *grpcMax}
+		if err := serveGRPC(o, roots, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "grpc:", err)
+			return 1
+		}
+		return
+	}
+
+	if *indexServe {
+		if err := serveIndex(*indexPipe, roots, cfg, *indexFile, *indexRefresh); err != nil {
+			fmt.Fprintln(os.Stderr, "index:", err)
+			return 1
+		}
+		return
+	}
+
+	if *controlPipe != "" {
+		if err := serveControlPipe(*controlPipe, roots, cfg, splitDir); err != nil {
+			fmt.Fprintln(os.Stderr, "control pipe:", err)
+			return 1
+		}
+		return
+	}
+
+	// ----- Scan -----
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *sqlitePath != "" {
+		var err error
+		if cfg.sqlite, err = newSQLiteExport(*sqlitePath, *sqliteOwners); err != nil {
+			fmt.Fprintln(os.Stderr, "sqlite:", err)
+			return 1
+		}
+	}
+
+	if *checkpointTo != "" && !*stdinPaths {
+		cfg.subtrees = newSubtreeLog(nil) // so a checkpoint can be resumed
+	}
+	var sc *scan
+	switch {
+	case *stdinPaths:
+		sep := byte('\n')
+		if *nul {
+			sep = 0
+		}
+		sc = startPathsScan(ctx, os.Stdin, sep, cfg)
+	case resume != nil:
+		sc = resumeScan(ctx, resume, cfg)
+	default:
+		sc = startScan(ctx, roots, cfg)
+	}
+
+	// ----- Optional progress ticker -----
+	if *etaOn && cfg.showProgress && !*stdinPaths && resume == nil {
+		sc.dirCount = startDirCount(ctx, roots, cfg)
+	}
+	done := make(chan struct{})
+	if cfg.showProgress {
+		go func() {
+			t := time.NewTicker(2 * time.Second)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					fmt.Fprintln(os.Stderr, sc.progressLine())
+				}
+			}
+		}()
+	}
+
+	var checkpointed chan struct{}
+	if *checkpointTo != "" {
+		checkpointed = sc.checkpoints(*checkpointTo, *ckptEvery)
+	}
+
+	sc.wait()
+	close(done)
+	if checkpointed != nil {
+		<-checkpointed
+		// After the output: a crash while printing still leaves it.
+		defer os.Remove(*checkpointTo)
+	}
+	if a := sc.abortErr.Load(); a != nil {
+		fmt.Fprintln(os.Stderr, a)
+		code = 1 // the output of what was counted still follows
+	}
+	if *rootOrder == "size" {
+		sc.bySize()
+	}
+
+	if cfg.sqlite != nil {
+		if err := cfg.sqlite.close(); err != nil {
+			fmt.Fprintln(os.Stderr, "sqlite:", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%d entries written to %s\n", cfg.sqlite.count, *sqlitePath)
+		}
+	}
+
+	if *resumeFile != "" {
+		if n, err := sc.saveResume(*resumeFile); err != nil {
+			fmt.Fprintln(os.Stderr, "resume:", err)
+		} else if n > 0 {
+			fmt.Fprintf(os.Stderr, "%d directories left unread by network errors; rerun with -resume=%s to continue\n", n, *resumeFile)
+		}
+	}
+
+	// ----- Nothing scanned -----
+	// Every root failed during the scan (access denied, offline): two
+	// empty tables and a summary would read as a clean run, so there is
+	// no output and the exit code is 1, as when no root is usable at all.
+	if !*stdinPaths && sc.nothingScanned() {
+		for i, r := range sc.roots {
+			if sc.rootErrs[i] != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", r, sc.rootErrs[i])
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Nothing was scanned: no file or directory under any root could be read.")
+		return 1
+	}
+
+	if *historyFile != "" {
+		if err := sc.appendHistory(*historyFile, *historyMax); err != nil {
+			fmt.Fprintln(os.Stderr, "history:", err)
+		}
+	}
+
+	// ----- Common post-scan values -----
+	dsc := newDriveSpaceCache() // Total bytes per volume; queried once per drive.
+	sc.renderStart = time.Now()
+	var showUnder []string
+	for _, p := range strings.Split(*showUnderFlag, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			showUnder = append(showUnder, filepath.Clean(p))
+		}
+	}
+	sc.baseline = base
+	sc.sameVolume = aliases
+	sc.invalidRoots = invalid
+	if email != nil {
+		// Deferred first, so it runs last: after the output and the baseline.
+		defer func() {
+			if err := sc.sendReport(*email, dsc); err != nil {
+				fmt.Fprintln(os.Stderr, "email:", err)
+				if *emailReq {
+					code = 1
+				}
+			}
+		}()
+	}
+	if *baseFile != "" && !*baseRO {
+		// After the output below: today's results become the next baseline.
+		defer func() {
+			sc.baseline = nil // the saved document carries sizes, not deltas
+			sc.showAll()      // and every entry, whatever -show-under hid
+			if err := saveBaseline(*baseFile, sc.jsonResult(dsc, false)); err != nil {
+				fmt.Fprintln(os.Stderr, "baseline:", err)
+			}
+		}()
+	}
+	if *reconcile && (*format != "table" || tmpl != nil) {
+		sc.printReconcile(os.Stderr, dsc) // keep stdout machine-readable
+	}
+	if len(showUnder) > 0 {
+		sc.showOnly(showUnder)
+	}
+	if *showID {
+		sc.resolveIDs()
+	}
+
+	// ----- JSON output (if requested) -----
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sc.jsonResult(dsc, splitDir)); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to encode JSON:", err)
+			return 1
+		}
+		return
+	}
+
+	// ----- TSV output (if requested) -----
+	if *format == "tsv" {
+		if cfg.combined {
+			writeTSV(os.Stdout, "items", sc.fileTop.sortedDesc(), dsc, cfg.pctBase)
+		} else {
+			writeTSV(os.Stdout, "directories", sc.dirTop.sortedDesc(), dsc, cfg.pctBase)
+			writeTSV(os.Stdout, "files", sc.fileTop.sortedDesc(), dsc, cfg.pctBase)
+		}
+		if sc.cfg.find != nil {
+			writeTSV(os.Stdout, "matches", sc.cfg.find.sorted(), dsc, cfg.pctBase)
+		}
+		return
+	}
+
+	// ----- NUL-delimited output (if requested) -----
+	if *print0 || *nul {
+		lists := [][]item{sc.dirTop.sortedDesc(), sc.fileTop.sortedDesc()}
+		if cfg.combined {
+			lists = lists[1:]
+		}
+		bw := bufio.NewWriter(os.Stdout)
+		for _, items := range lists {
+			writeNUL(bw, items)
+		}
+		bw.Flush()
+		return
+	}
+
+	// ----- Template output (if requested) -----
+	if tmpl != nil {
+		lists := [][]item{sc.dirTop.sortedDesc(), sc.fileTop.sortedDesc()}
+		if cfg.combined {
+			lists = lists[1:]
+		}
+		for _, items := range lists {
+			if err := writeTemplate(os.Stdout, tmpl, items, dsc); err != nil {
+				fmt.Fprintln(os.Stderr, "-output-template:", err)
+				return 1
+			}
+		}
+		return
+	}
+
+	// ----- Plain-text output (aligned tables) -----
+	useColor := colorEnabled(*colorMode)
+	if *childrenOf != "" {
+		printChildren(roots[0], sc.cfg.children.ranked())
+	}
+	if cfg.breakdown != nil {
+		printBreakdown(bucketChildren(sc.cfg.children.ranked(), cfg.breakdown))
+	}
+	topAsked := false
+	flag.Visit(func(f *flag.Flag) { topAsked = topAsked || f.Name == "top" })
+	shownNone := len(showUnder) > 0 && len(sc.fileTop.sortedDesc()) == 0 && len(sc.dirTop.sortedDesc()) == 0
+	dirRows, fileRows := sc.dirTop.sortedDesc(), sc.fileTop.sortedDesc()
+	pruned := 0
+	if *pruneBelow > 0 {
+		least := int64(float64(sc.scannedBytes()) * *pruneBelow / 100)
+		dirRows, pruned = pruneRows(dirRows, least)
+		if !cfg.combined {
+			var n int
+			fileRows, n = pruneRows(fileRows, least)
+			pruned += n
+		} else {
+			fileRows = dirRows
+		}
+	}
+	if cfg.combined || (*childrenOf != "" && !topAsked) || shownNone {
+		if shownNone {
+			fmt.Printf("\nNothing under %s is among the directories and files this scan ranked (-top %d).\n",
+				strings.Join(showUnder, ", "), cfg.topK)
+		} else if cfg.combined && *compact {
+			printCompact("Largest Items", fileRows, useColor)
+		} else if cfg.combined {
+			printCombined(fileRows, dsc, cfg.pctBase, base, splitDir, useColor)
+		}
+		printPruned(pruned, *pruneBelow)
+		printExtras(sc.cfg)
+		sc.printSummary(dsc)
+		if *reconcile {
+			sc.printReconcile(os.Stdout, dsc)
+		}
+		return
+	}
+
+	dirTitle := "Largest Directories"
+	if cfg.rankExclusive {
+		dirTitle += " (ranked without each one's largest subdirectory)"
+	}
+	if *compact {
+		if !*stdinPaths {
+			printCompact(dirTitle, dirRows, useColor)
+		}
+		printCompact("Largest Files", fileRows, useColor)
+		printPruned(pruned, *pruneBelow)
+		printExtras(sc.cfg)
+		sc.printSummary(dsc)
+		if *reconcile {
+			sc.printReconcile(os.Stdout, dsc)
+		}
+		return
+	}
+
+	tc := tableCtx{cfg: cfg, dsc: dsc, base: base, start: sc.start, color: useColor}
+	printTables(os.Stdout, tc, cols, dirTitle, dirRows, fileRows, !*stdinPaths) // -stdin-paths walks no directories
+	printPruned(pruned, *pruneBelow)
+	printExtras(sc.cfg)
+
+	// ----- Summary line -----
+	sc.printSummary(dsc)
+	if *reconcile {
+		sc.printReconcile(os.Stdout, dsc)
+	}
+	return code
+}
+
+// printTables: the Largest Directories (if withDirs) and Largest Files
+// tables, the part of the output -plain holds still.
+func printTables(out io.Writer, tc tableCtx, cols columnSpec, dirTitle string, dirRows, fileRows []item, withDirs bool) {
+	if withDirs {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, dirTitle)
+		tc.dirs = true
+		tc.print(out, cols.layout(tc), dirRows)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Largest Files")
+	tc.dirs = false
+	tc.print(out, cols.layout(tc), fileRows)
+}
+
+// printExtras: the optional tables after the rankings (-group-by-owner,
+// -report-links, -max-name-length).
+func printExtras(cfg walkCfg) {
+	if cfg.owners != nil {
+		printOwners(cfg.owners.ranked())
+	}
+	if cfg.links != nil {
+		printLinks(cfg.links.sorted(cfg.pathLess))
+	}
+	if cfg.special != nil {
+		printSpecial(cfg.special.sorted(cfg.pathLess))
+	}
+	if cfg.sparseFiles != nil {
+		printSparse(cfg.sparseFiles.report())
+	}
+	if cfg.names != nil {
+		printNames(cfg.names.sorted(cfg.pathLess))
+	}
+	if cfg.caches != nil {
+		printCaches(cfg.caches.rows())
+	}
+	if cfg.rollups != nil {
+		printRollups(cfg.rollups.rows())
+	}
+	if cfg.concTop != nil {
+		printConcentrated(cfg.concTop.sortedDesc())
+	}
+	if cfg.find != nil {
+		printMatches(cfg.find)
+	}
+	if cfg.changedTop != nil {
+		printChanged(cfg.changedSince, cfg.changedTop.sortedDesc())
+	}
+	if cfg.writtenDays != nil {
+		printWritten(cfg.written, cfg.writtenDays.rows())
+	}
+	if cfg.huge != nil {
+		printHuge(cfg.huge.sorted())
+	}
+}
+
+// ########### SCAN: PHASE TIMES ##################
+// Where a run's time went. The scan, drive-space and output phases are
+// wall-clock and follow one another; listing and stat are summed over
+// the walkers, so with several workers they add up to more than the
+// scan took, and their ratio is what shows which one to speed up.
+
+// phases: the phase times of a finished scan.
+func (sc *scan) phases(dsc *driveSpaceCache) jsonPhases {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	p := jsonPhases{
+		ScanMs:       ms(sc.elapsed),
+		ListingMs:    ms(time.Duration(atomic.LoadInt64(&sc.stats.listNanos))),
+		StatMs:       ms(time.Duration(atomic.LoadInt64(&sc.stats.statNanos))),
+		DriveSpaceMs: ms(time.Duration(dsc.nanos.Load())),
+	}
+	if !sc.renderStart.IsZero() {
+		p.OutputMs = ms(time.Since(sc.renderStart)) - p.DriveSpaceMs
+	}
+	return p
+}
+
+// phasesLine: the phases for the summary, e.g. "Phases: scan 4.2s
+// (worker time: listing 11.3s, stat 6.1s), drive space 12ms, output 30ms".
+func (sc *scan) phasesLine(dsc *driveSpaceCache) string {
+	p := sc.phases(dsc)
+	d := func(ms float64) time.Duration {
+		return time.Duration(ms * float64(time.Millisecond)).Round(time.Millisecond)
+	}
+	return fmt.Sprintf("Phases: scan %s (worker time: listing %s, stat %s), drive space %s, output %s",
+		d(p.ScanMs), d(p.ListingMs), d(p.StatMs), d(p.DriveSpaceMs), d(p.OutputMs))
+}
+
+// ########### SCAN: ONE RUN OVER A SET OF ROOTS ##################
+// scan: heaps, counters and per-root outcome of one run. Counters may be
+// read (atomically) while the walk is still going, e.g. for progress.
+type scan struct {
+	roots        []string
+	cfg          walkCfg
+	fileTop      *minHeap
+	dirTop       *minHeap
+	stats        stats
+	rootErrs     []error                   // top-level error per root, for the status line
+	rootSizes    []int64                   // bytes counted under each root
+	rootState    []int32                   // rootQueued/rootActive/rootDone, for progress
+	runID        string                    // UUID shared by every structured output of this scan
+	fileRoots    []string                  // roots that are files, sized directly
+	baseline     *baseline                 // -baseline: earlier sizes for the delta column; nil if unused
+	sameVolume   []volumeAlias             // roots found to be a volume already among the roots
+	tuner        *workerTuner              // -auto-workers; nil otherwise
+	dirCount     *dirCounter               // -eta; nil otherwise
+	abortErr     atomic.Pointer[scanAbort] // the error an errAbort stopped the scan at
+	invalidRoots []error                   // checkRoot errors for -roots left out of the scan
+	shownUnder   []string                  // -show-under prefixes; the heaps hold only entries below them
+	allTops      [2]*minHeap               // fileTop and dirTop before -show-under filtered them
+	start        time.Time
+	elapsed      time.Duration // set once the scan has finished
+	renderStart  time.Time     // when output began, for the phases line
+	done         chan struct{}
+}
+
+// startScan kicks off one walker goroutine per root and returns at once;
+// call wait (or select on done) for completion. Cancelling ctx stops it.
+func startScan(ctx context.Context, roots []string, cfg walkCfg) *scan {
+	sc := newScan(roots, cfg)
+	ctx = sc.abortable(ctx)
+	cfg = sc.cfg // with the per-scan collectors newScan adds
+
+	// Worker pool controlled by a semaphore channel.
+	sem := sc.walkerSem()
+
+	for _, root := range roots {
+		if fi, err := os.Stat(root); err == nil && fi.Mode().IsRegular() {
+			sc.fileRoots = append(sc.fileRoots, root)
+		}
+	}
+
+	sc.launch(func(i int, root string) error {
+		if fi, err := os.Stat(root); err == nil && fi.Mode().IsRegular() {
+			sc.rootSizes[i] = walkFileRoot(root, fi, cfg, sc.fileTop, &sc.stats)
+			if cfg.sqlite != nil {
+				cfg.sqlite.file(root, sc.rootSizes[i], fi.ModTime())
+			}
+			return nil
+		}
+		if cfg.ntfsMFT && isVolumeRootPath(root) {
+			t := time.Now()
+			size, err := mftScan(root, cfg, sc.fileTop, sc.dirTop, &sc.stats)
+			atomic.AddInt64(&sc.stats.listNanos, int64(time.Since(t)))
+			if err == nil {
+				sc.rootSizes[i] = size
+				if cfg.rankRoots {
+					pushDir(sc.dirTop, root, 0, dirAgg{size: size}, cfg)
+				}
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "ntfs-mft: %s: %v; walking it instead\n", root, err)
+		}
+		rctx, guard := withRootGuard(ctx)
+		agg, err := walkDir(rctx, root, 0, cfg, sem, sc.fileTop, sc.dirTop, &sc.stats)
+		sc.rootSizes[i] = agg.size
+		if cfg.rankRoots && err == nil {
+			pushDir(sc.dirTop, root, 0, agg, cfg)
+		}
+		if cfg.sqlite != nil && err == nil {
+			if fi, serr := os.Stat(root); serr == nil {
+				agg.mtime = fi.ModTime()
+			}
+			cfg.sqlite.dir(root, agg.size, agg.modified())
+		}
+		return guard.result(err)
+	})
+	return sc
+}
+
+// walkerSem: the directory-worker budget for this scan. With
+// -auto-workers a tuner resizes it until the scan is done.
+func (sc *scan) walkerSem() *workSem {
+	sem := newWorkSem(sc.cfg.workers)
+	if sc.cfg.autoWorkers {
+		sc.tuner = newWorkerTuner(sem)
+		go sc.tuner.run(&sc.stats.bytesSeen, sc.done)
+	}
+	return sem
+}
+
+// newScan: empty heaps and counters for a scan over roots.
+func newScan(roots []string, cfg walkCfg) *scan {
+	cfg = cfg.fresh()
+	sc := &scan{
+		roots:     roots,
+		cfg:       cfg,
+		fileTop:   &minHeap{k: cfg.topK},
+		dirTop:    &minHeap{k: cfg.topK},
+		rootErrs:  make([]error, len(roots)),
+		rootSizes: make([]int64, len(roots)),
+		rootState: make([]int32, len(roots)),
+		runID:     newRunID(),
+		start:     time.Now(),
+		done:      make(chan struct{}),
+	}
+	if cfg.combined {
+		// One heap for both kinds; item.IsDir tells them apart at print time.
+		sc.dirTop = sc.fileTop
+	}
+	if cfg.concentration > 0 {
+		sc.cfg.concTop = &minHeap{k: cfg.topK}
+	}
+	if !cfg.changedSince.IsZero() {
+		sc.cfg.changedTop = &minHeap{k: cfg.topK}
+	}
+	if cfg.written != nil {
+		sc.cfg.writtenDays = &writtenLog{days: make(map[string]*writtenDay)}
+	}
+	return sc
+}
+
+// fresh: cfg with an empty copy of each collector for the extra reports
+// (-report-links, -children, -caches and the like), keeping its settings.
+// main builds them once, but the control pipe, gRPC and -index-serve
+// start many scans from one cfg, and each must report only what it saw.
+func (cfg walkCfg) fresh() walkCfg {
+	if l := cfg.links; l != nil {
+		cfg.links = &linkLog{roots: l.roots, rootVG: l.rootVG}
+	}
+	if cfg.special != nil {
+		cfg.special = &specialLog{}
+	}
+	if l := cfg.sparseFiles; l != nil {
+		cfg.sparseFiles = &sparseLog{k: l.k, alloc: l.alloc}
+	}
+	if n := cfg.names; n != nil {
+		cfg.names = &nameCheck{maxLen: n.maxLen, asciiOnly: n.asciiOnly}
+	}
+	if c := cfg.caches; c != nil {
+		cfg.caches = c.empty()
+	}
+	if r := cfg.rollups; r != nil {
+		cfg.rollups = r.empty()
+	}
+	if h := cfg.huge; h != nil {
+		cfg.huge = &hugeLog{limit: h.limit}
+	}
+	if f := cfg.find; f != nil {
+		cfg.find = &findLog{exts: f.exts, min: f.min}
+	}
+	if cfg.children != nil {
+		cfg.children = &childLog{}
+	}
+	if cfg.owners != nil {
+		cfg.owners = newOwnerTally()
+	}
+	return cfg
+}
+
+// launch runs walk for each root on its own goroutine and closes done once
+// all have returned. With -max-roots, at most that many roots run at once
+// and the rest start in -roots order as slots free up.
+func (sc *scan) launch(walk func(i int, root string) error) {
+	n := sc.cfg.maxRoots
+	if n <= 0 || n > len(sc.roots) {
+		n = len(sc.roots)
+	}
+	slots := make(chan struct{}, max(n, 1))
+	go func() {
+		var wg sync.WaitGroup
+		for i, root := range sc.roots {
+			slots <- struct{}{} // wait for a running root to finish
+			atomic.StoreInt32(&sc.rootState[i], rootActive)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				defer atomic.StoreInt32(&sc.rootState[i], rootDone)
+				defer sc.stats.recoverDir(sc.cfg, root, &sc.rootErrs[i])
+				if err := walk(i, root); err != nil {
+					// Deeper errors are only counted; a failing root is reported by name.
+					sc.rootErrs[i] = err
+				}
+			}()
+		}
+		wg.Wait()
+		sc.elapsed = time.Since(sc.start).Truncate(time.Millisecond)
+		close(sc.done)
+	}()
+}
+
+// Root states for progress; a root is queued until launch starts it.
+const (
+	rootQueued int32 = iota
+	rootActive
+	rootDone
+)
+
+// rootProgress: " roots: active=D:\ queued=2 done=1", or "" when every
+// root runs at once and there is nothing to tell.
+func (sc *scan) rootProgress() string {
+	if sc.cfg.maxRoots <= 0 || sc.cfg.maxRoots >= len(sc.roots) {
+		return ""
+	}
+	var active []string
+	queued, done := 0, 0
+	for i, r := range sc.roots {
+		switch atomic.LoadInt32(&sc.rootState[i]) {
+		case rootQueued:
+			queued++
+		case rootActive:
+			active = append(active, r)
+		case rootDone:
+			done++
+		}
+	}
+	return fmt.Sprintf(" roots: active=%s queued=%d done=%d", strings.Join(active, ","), queued, done)
+}
+
+// stdinRoot: the single pseudo-root of a -stdin-paths scan.
+const stdinRoot = "(stdin)"
+
+// startPathsScan: a scan over an explicit list of paths read from r, each
+// terminated by sep. Every path is stat'd and sized like a file root, with
+// no directory traversal; directories and other non-files are skipped.
+func startPathsScan(ctx context.Context, r io.Reader, sep byte, cfg walkCfg) *scan {
+	sc := newScan([]string{stdinRoot}, cfg)
+	cfg = sc.cfg // with the per-scan collectors newScan adds
+	sc.launch(func(_ int, _ string) error {
+		var total int64
+		var wg sync.WaitGroup
+		sem := newWorkSem(cfg.workers)
+		br := bufio.NewReader(r)
+		for ctx.Err() == nil {
+			line, err := br.ReadString(sep)
+			if p := strings.TrimRight(line, "\r\n\x00"); p != "" {
+				sem.acquire()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer sem.release()
+					fi, serr := os.Stat(p)
+					switch {
+					case serr != nil:
+						atomic.AddInt64(&sc.stats.errors, 1)
+					case !fi.Mode().IsRegular():
+						sc.stats.skip(cfg, skipOther, 0)
+					default:
+						atomic.AddInt64(&total, walkFileRoot(p, fi, cfg, sc.fileTop, &sc.stats))
+					}
+				}()
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				wg.Wait()
+				return err
+			}
+		}
+		wg.Wait()
+		sc.rootSizes[0] = total
+		return ctx.Err()
+	})
+	return sc
+}
+
+func (sc *scan) wait() { <-sc.done }
+
+// nothingScanned: whether the finished scan read no file and no
+// directory at all, i.e. every root failed.
+func (sc *scan) nothingScanned() bool {
+	return atomic.LoadInt64(&sc.stats.filesSeen) == 0 && atomic.LoadInt64(&sc.stats.dirsSeen) == 0
+}
+
+// showOnly swaps in heaps holding only the entries under one of the
+// prefixes (-show-under); ranks follow from the smaller heaps. The walk
+// must be over. showAll puts the full heaps back.
+func (sc *scan) showOnly(prefixes []string) {
+	keep := func(h *minHeap) *minHeap {
+		out := &minHeap{k: h.k}
+		for _, it := range h.sortedDesc() {
+			for _, p := range prefixes {
+				if isUnder(it.Path, p) {
+					out.push(it)
+					break
+				}
+			}
+		}
+		return out
+	}
+	sc.allTops = [2]*minHeap{sc.fileTop, sc.dirTop}
+	sc.shownUnder = prefixes
+	sc.fileTop = keep(sc.fileTop)
+	sc.dirTop = sc.fileTop // -combined: one heap for both
+	if sc.allTops[1] != sc.allTops[0] {
+		sc.dirTop = keep(sc.allTops[1])
+	}
+}
+
+// resolveIDs fills item.ID for every ranked entry (-show-id). An entry
+// that can't be opened any more shows "?".
+func (sc *scan) resolveIDs() {
+	set := func(it *item) {
+		it.ID = "?"
+		if id, err := fileID(it.Path); err == nil {
+			it.ID = id
+		}
+	}
+	sc.fileTop.each(set)
+	if sc.dirTop != sc.fileTop {
+		sc.dirTop.each(set)
+	}
+}
+
+func (sc *scan) showAll() {
+	if sc.shownUnder != nil {
+		sc.fileTop, sc.dirTop = sc.allTops[0], sc.allTops[1]
+		sc.shownUnder = nil
+	}
+}
+
+// progressLine: the periodic "[2s] scanned files=..." status text.
+func (sc *scan) progressLine() string {
+	s := &sc.stats
+	line := fmt.Sprintf("[%s] scanned files=%d dirs=%d skipped=%d errors=%d%s",
+		time.Since(sc.start).Truncate(time.Millisecond),
+		atomic.LoadInt64(&s.filesSeen), atomic.LoadInt64(&s.dirsSeen),
+		atomic.LoadInt64(&s.skipped), atomic.LoadInt64(&s.errors), sc.rootProgress()) + sc.tuner.progress() +
+		sc.dirCount.eta(atomic.LoadInt64(&s.dirsSeen), time.Since(sc.start))
+	if p, ok := s.current.Load().(string); ok && sc.cfg.progressPaths {
+		line += " in " + truncLeft(p, consoleWidth(os.Stderr)-len([]rune(line))-5)
+	}
+	return line
+}
+
+// ########### PROGRESS: ETA ##################
+// -eta: a second pass lists the directories the scan will read, without
+// sizing anything, so the progress line can say how long the rest will
+// take at the rate directories have been read so far. It follows the
+// same skip rules, but not links or mount points, so it is a close
+// count rather than an exact one; until it is done there is no ETA.
+
+// dirCounter: the pre-count. n is final once done is set.
+type dirCounter struct {
+	n    atomic.Int64
+	done atomic.Bool
+}
+
+// startDirCount counts the directories under roots in the background,
+// on at most half the walker budget.
+func startDirCount(ctx context.Context, roots []string, cfg walkCfg) *dirCounter {
+	c := &dirCounter{}
+	slots := make(chan struct{}, max(cfg.workers/2, 1))
+	var wg sync.WaitGroup
+	var count func(path string, depth int)
+	count = func(path string, depth int) {
+		defer wg.Done()
+		if ctx.Err() != nil || cfg.maxDepth > 0 && depth > cfg.maxDepth {
+			return
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return
+		}
+		c.n.Add(1)
+		if cfg.huge != nil && len(entries) > cfg.huge.limit {
+			return
+		}
+		for _, de := range entries {
+			if !de.IsDir() {
+				continue
+			}
+			full := filepath.Join(path, de.Name())
+			info, err := de.Info()
+			if err != nil || isMountPoint(full, info) {
+				continue
+			}
+			if _, skip := entrySkip(cfg, full, de.Name(), info); skip {
+				continue
+			}
+			wg.Add(1)
+			select {
+			case slots <- struct{}{}:
+				go func() {
+					defer func() { <-slots }()
+					count(full, depth+1)
+				}()
+			default:
+				count(full, depth+1)
+			}
+		}
+	}
+	wg.Add(len(roots))
+	for _, r := range roots {
+		go count(r, 0)
+	}
+	go func() {
+		wg.Wait()
+		c.done.Store(ctx.Err() == nil)
+	}()
+	return c
+}
+
+// eta: " ETA 3m12s" for the directories left after seen at the rate of
+// the first elapsed; "" without a finished pre-count.
+func (c *dirCounter) eta(seen int64, elapsed time.Duration) string {
+	if c == nil || !c.done.Load() || seen == 0 {
+		return ""
+	}
+	left := max(c.n.Load()-seen, 0)
+	d := time.Duration(float64(elapsed) * float64(left) / float64(seen))
+	return " ETA " + d.Round(time.Second).String()
+}
+
+// truncLeft: s cut to at most n runes by dropping the front, which for a
+// path is the part the reader can best spare.
+func truncLeft(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n < 2 {
+		n = 2
+	}
+	return "…" + string(r[len(r)-n+1:])
+}
+
+// printSummary: the lines under the tables.
+func (sc *scan) printSummary(dsc *driveSpaceCache) {
+	s := &sc.stats
+	sk := atomic.LoadInt64(&s.skipped)
+	fmt.Println()
+	fmt.Printf("Scanned %d files in %d directories in %s (skipped=%d, errors=%d)\n",
+		atomic.LoadInt64(&s.filesSeen), atomic.LoadInt64(&s.dirsSeen), sc.elapsed, sk, atomic.LoadInt64(&s.errors))
+	fmt.Println(sc.phasesLine(dsc))
+	if sk > 0 {
+		fmt.Printf("Skipped: %s\n", s.skipBreakdown())
+	}
+	if ex := s.excludedLine(); ex != "" {
+		fmt.Println(ex)
+	}
+	if ch := s.changedLine(); ch != "" {
+		fmt.Println(ch)
+	}
+	if cl := s.cloudLine(sc.cfg); cl != "" {
+		fmt.Println(cl)
+	}
+	if sp := s.specialBreakdown(); sp != "" {
+		fmt.Printf("Special files (not sized): %s\n", sp)
+	}
+	if n := atomic.LoadInt64(&s.denied); n > 0 {
+		fmt.Printf("Expected access denied: %d (matched -expected-denied; not counted in errors)\n", n)
+	}
+	if ne := atomic.LoadInt64(&s.netErrors); ne > 0 {
+		fmt.Printf("Network errors: %d (%d directories unread; their parents' totals are lower bounds)\n", ne, s.net.pending())
+	}
+	if caps := sc.cfg.volumes.limited(); caps != "" {
+		fmt.Printf("Per-volume workers: %s\n", caps)
+	}
+	if t := sc.tuner; t != nil {
+		fmt.Printf("Auto workers: %d at the end (range 1-%d, peak %d)\n", t.workers(), t.max, atomic.LoadInt32(&t.peak))
+	}
+	if m := sc.cfg.metricName; m != "logical" {
+		fmt.Printf("Sizes are by the %s metric (-metric=%s)\n", m, m)
+	}
+	fmt.Printf("Roots: %s\n", rootStatusLine(sc.roots, sc.rootErrs))
+	sc.printOverCapacity(dsc)
+	for _, err := range sc.invalidRoots {
+		fmt.Printf("NOT SCANNED: %v\n", err)
+	}
+	for _, a := range sc.sameVolume {
+		what := "skipped"
+		if !a.Skipped {
+			what = "scanned twice"
+		}
+		fmt.Printf("Same volume: %s = %s (%s), %s\n", a.Root, a.Same, a.GUID, what)
+	}
+	if len(sc.fileRoots) > 0 {
+		fmt.Printf("Files scanned directly: %s\n", strings.Join(sc.fileRoots, ", "))
+	}
+	if of := sc.cfg.owner; of != nil {
+		fmt.Printf("Owner filter: %s (%s); ignored %d files with other owners\n",
+			strings.Join(of.names, ", "), of.mode(), atomic.LoadInt64(&s.notOwned))
+	}
+	if sf := sc.cfg.sparse; sf != nil {
+		fmt.Printf("Sparse filter: allocated below %.0f%% of logical size; ignored %d other files\n",
+			sf.ratio*100, atomic.LoadInt64(&s.notSparse))
+	}
+}
+
+// drivePctCell: the DRIVE% cell. Above 100% the scan counted something
+// twice (see overCapacity), so it shows a flag instead of a precise
+// wrong number.
+func drivePctCell(size int64, total uint64) string {
+	if total == 0 {
+		return "n/a"
+	}
+	p := float64(size) / float64(total) * 100
+	if p > 100 {
+		return ">100%?"
+	}
+	return fmt.Sprintf("%.2f%%", p)
+}
+
+// percentBase: -percent-base, what the DRIVE% column measures a row
+// against. A percent of raw capacity understates urgency: 5% of a drive
+// that is 98% full is most of what is left. used makes the column the
+// row's share of the bytes on the volume; free makes it a multiple of
+// the space still free ("0.4× free"). JSON, TSV and templates keep the
+// capacity percent; JSON rows and volumes carry the figures for the rest.
+type percentBase string
+
+const (
+	baseCapacity percentBase = "capacity"
+	baseUsed     percentBase = "used"
+	baseFree     percentBase = "free"
+)
+
+// header: the column's header.
+func (b percentBase) header() string {
+	switch b {
+	case baseUsed:
+		return "USED%"
+	case baseFree:
+		return "VS FREE"
+	}
+	return "DRIVE%"
+}
+
+// cell: the column for a row of size bytes on a volume with space sp.
+// An unknown volume, or one with nothing used, is "n/a"; a row on a full
+// volume can't be a multiple of nothing and reads "no free".
+func (b percentBase) cell(size int64, sp driveSpace) string {
+	switch {
+	case b == baseCapacity || sp.total == 0:
+		return drivePctCell(size, sp.total)
+	case b == baseUsed:
+		if sp.free >= sp.total {
+			return "n/a"
+		}
+		return drivePctCell(size, sp.total-sp.free)
+	case sp.free == 0:
+		return "no free"
+	}
+	return fmt.Sprintf("%.1f× free", float64(size)/float64(sp.free))
+}
+
+// overTolerance: how far a volume's scanned bytes may exceed its used
+// bytes before overCapacity complains; used space moves during a scan.
+const overTolerance = 0.02
+
+// volumeBytes: the bytes counted under the roots, per volume root.
+// Roots not on a volume (none on Windows) are left out.
+func (sc *scan) volumeBytes() map[string]int64 {
+	out := make(map[string]int64)
+	for i, r := range sc.roots {
+		if v := volumeRoot(r); v != "" {
+			out[v] += sc.rootSizes[i]
+		}
+	}
+	return out
+}
+
+// overCapacity: the volumes whose scanned bytes exceed their used bytes
+// (total - free) by more than overTolerance, sorted. No volume holds more
+// than it uses, so something was counted twice.
+func (sc *scan) overCapacity(dsc *driveSpaceCache) []string {
+	var out []string
+	for v, n := range sc.volumeBytes() {
+		sp := dsc.spaceFor(v)
+		if used := sp.total - sp.free; sp.total > 0 && float64(n) > float64(used)*(1+overTolerance) {
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// printOverCapacity: the warning for overCapacity's volumes.
+func (sc *scan) printOverCapacity(dsc *driveSpaceCache) {
+	over := sc.overCapacity(dsc)
+	if len(over) == 0 {
+		return
+	}
+	vols := sc.volumeBytes()
+	for _, v := range over {
+		sp := dsc.spaceFor(v)
+		fmt.Printf("WARNING: %s scanned %s but only %s of it is in use: something was counted more than once.\n",
+			v, humanBytesFixed(vols[v]), humanBytesFixed(int64(sp.total-sp.free)))
+	}
+	fmt.Println("  Likely causes: link or junction loops (-followlinks), hard links counted once per name, roots that overlap,")
+	fmt.Println("  or compressed/sparse files under -metric=logical (-metric=allocated counts them as stored).")
+}
+
+// scannedBytes: the bytes counted under all roots.
+func (sc *scan) scannedBytes() int64 {
+	var n int64
+	for _, b := range sc.rootSizes {
+		n += b
+	}
+	return n
+}
+
+// pruneRows: a sorted ranking without the rows below least bytes
+// (-prune-below-percent), and how many were dropped.
+func pruneRows(items []item, least int64) ([]item, int) {
+	n := sort.Search(len(items), func(i int) bool { return items[i].Size < least })
+	return items[:n], len(items) - n
+}
+
+// printPruned: the note under the tables when -prune-below-percent hid rows.
+func printPruned(n int, pct float64) {
+	if n > 0 {
+		fmt.Printf("\n%d rows below %g%% of the scanned total not shown (-prune-below-percent)\n", n, pct)
+	}
+}
+
+// printReconcile: the -reconcile block. Starts from the bytes counted under
+// the roots, adds what the skip rules left out where its size is known,
+// and sets the sum against the used bytes (total - free) of the volumes the
+// roots are on. Whatever is left is labeled unaccounted.
+func (sc *scan) printReconcile(out io.Writer, dsc *driveSpaceCache) {
+	s := &sc.stats
+	scanned := sc.scannedBytes()
+
+	w := tabwriter.NewWriter(out, 2, 4, 2, ' ', 0)
+	mode := sc.cfg.metricName + " sizes"
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Reconciliation (%s)\n", mode)
+	fmt.Fprintf(w, "  scanned\t%s\t%d files\n", humanBytesFixed(scanned), atomic.LoadInt64(&s.filesSeen))
+	accounted := scanned
+	for r := skipReason(0); r < numSkipReasons; r++ {
+		n := atomic.LoadInt64(&s.skippedBy[r])
+		if n == 0 {
+			continue
+		}
+		b := atomic.LoadInt64(&s.skippedBytes[r])
+		size := humanBytesFixed(b)
+		if b == 0 {
+			size = "unknown"
+		}
+		accounted += b
+		fmt.Fprintf(w, "+ skipped: %s\t%s\t%d entries\n", skipReasonNames[r], size, n)
+	}
+	if n := atomic.LoadInt64(&s.notOwned); n > 0 {
+		fmt.Fprintf(w, "+ other owners\tunknown\t%d files\n", n)
+	}
+	if n := atomic.LoadInt64(&s.notSparse); n > 0 {
+		fmt.Fprintf(w, "+ not sparse\tunknown\t%d files\n", n)
+	}
+	if n := atomic.LoadInt64(&s.errors); n > 0 {
+		fmt.Fprintf(w, "+ unreadable\tunknown\t%d errors\n", n)
+	}
+	if n := atomic.LoadInt64(&s.denied); n > 0 {
+		fmt.Fprintf(w, "+ expected denied\tunknown\t%d entries\n", n)
+	}
+	fmt.Fprintf(w, "+ ADS / hard links\tnot measured\n")
+	fmt.Fprintf(w, "= accounted\t%s\n", humanBytesFixed(accounted))
+
+	// Used bytes, once per volume.
+	var used int64
+	var vols []string
+	wholeVolumes := true
+	seen := make(map[string]bool)
+	for _, r := range sc.roots {
+		vr := volumeRoot(r)
+		if !strings.EqualFold(vr, r) {
+			wholeVolumes = false
+		}
+		if vr == "" || seen[strings.ToLower(vr)] {
+			continue
+		}
+		seen[strings.ToLower(vr)] = true
+		if sp := dsc.spaceFor(vr); sp.total > 0 {
+			used += int64(sp.total - sp.free)
+			vols = append(vols, vr)
+		}
+	}
+	if len(vols) == 0 {
+		fmt.Fprintf(w, "  volume used\tn/a\n")
+		w.Flush()
+		return
+	}
+	fmt.Fprintf(w, "  volume used\t%s\t%s\n", humanBytesFixed(used), strings.Join(vols, ", "))
+	gap := used - accounted
+	note := "NTFS metadata, system-protected files, cluster slack"
+	if sc.cfg.metricName == "allocated" {
+		note = "NTFS metadata, system-protected files"
+	}
+	if !wholeVolumes {
+		note = "everything outside the roots, plus " + note
+	}
+	if gap < 0 {
+		fmt.Fprintf(w, "  unaccounted\t-%s\tcounted more than is used: hard links, sparse or compressed files\n", humanBytesFixed(-gap))
+	} else {
+		fmt.Fprintf(w, "  unaccounted\t%s\t%s\n", humanBytesFixed(gap), note)
+	}
+	w.Flush()
+}
+
+// appendHistory: adds this run's -history lines, one per root:
+// time, root, total bytes, largest directory under it and its bytes,
+// tab-separated ("-" when no directory under the root made the table),
+// the paths escaped as in writeTSV. The log moves to path+".1" first if it has reached maxBytes.
+func (sc *scan) appendHistory(path string, maxBytes int64) error {
+	if fi, err := os.Stat(path); err == nil && maxBytes > 0 && fi.Size() >= maxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	dirs := sc.dirTop.sortedDesc()
+	for i, r := range sc.roots {
+		top, topSize := "-", int64(0)
+		for _, it := range dirs {
+			if it.IsDir && isUnder(it.Path, r) {
+				top, topSize = it.Path, it.Size
+				break
+			}
+		}
+		fmt.Fprintf(f, "%s\t%s\t%d\t%s\t%d\n", now, tsvEscaper.Replace(r), sc.rootSizes[i], tsvEscaper.Replace(top), topSize)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeTSV: one "# section" comment line, then a row per item:
+// rank, size_bytes, size_human, drive_pct (the table's DRIVE% cell under
+// base, empty if the drive is unknown), lower_bound (1 when -depth-scan
+// left part of the size out, else 0), path. The path is last and
+// unquoted, escaped by tsvEscaper.
+func writeTSV(w io.Writer, section string, items []item, dsc *driveSpaceCache, base percentBase) {
+	fmt.Fprintf(w, "# %s\n", section)
+	for i, it := range items {
+		pct := ""
+		if sp := dsc.spaceFor(it.Path); sp.total > 0 {
+			pct = base.cell(it.Size, sp)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\n", i+1, it.Size, humanBytesFixed(it.Size), pct, lowerBound(it), tsvEscaper.Replace(it.Path))
+	}
+}
+
+// lowerBound: the lower_bound column of TSV and -print0 records.
+func lowerBound(it item) int {
+	if it.Partial {
+		return 1
+	}
+	return 0
+}
+
+// templateRow: the fields available to -output-template.
+type templateRow struct {
+	Rank      int // within its list; directories and files are ranked separately
+	Size      int64
+	HumanSize string
+	DrivePct  float64 // 0 if unknown
+	Path      string
+	Type      string // "dir" or "file"
+}
+
+// writeTemplate: executes t once per item of one ranked list.
+func writeTemplate(w io.Writer, t *template.Template, items []item, dsc *driveSpaceCache) error {
+	for i, it := range items {
+		row := templateRow{Rank: i + 1, Size: it.Size, HumanSize: sizeLabel(it), Path: displayPath(it), Type: "file"}
+		if it.IsDir {
+			row.Type = "dir"
+		}
+		if total := dsc.totalFor(it.Path); total > 0 {
+			row.DrivePct = (float64(it.Size) / float64(total)) * 100
+		}
+		if err := t.Execute(w, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNUL: one "size<tab>lower_bound<tab>path" record per item, each
+// ended by NUL. Paths are written verbatim; NUL is the one byte a Windows
+// path can't contain.
+func writeNUL(w io.Writer, items []item) {
+	for _, it := range items {
+		fmt.Fprintf(w, "%d\t%d\t%s\x00", it.Size, lowerBound(it), it.Path)
+	}
+}
+
+// tsvEscaper: how -format=tsv and -history write a path. A backslash is
+// doubled and tab, LF and CR become \t, \n and \r, so every path reads
+// back unambiguously: `a\tb` is the name a<TAB>b, `a\\tb` the name a\tb.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// ########### ROOT ROWS ##################
+// A root's own total is a "Largest Directories" row as well, so roots can
+// be weighed against each other and against what is below them. A scan
+// of one folder is the exception: its root's total is everything else
+// added up and would only top the table, so by default it is left out.
+// Whole volumes (C:\, a share, a -mounts-as-roots mount point) keep
+// their row even alone, as does every root of a scan with several.
+
+// rootRows: the -no-aggregate-root flag. Given bare it means leave the
+// roots out; unset, the rule above decides.
+type rootRows struct{ set, exclude bool }
+
+func (f *rootRows) IsBoolFlag() bool { return true }
+
+func (f *rootRows) String() string {
+	if !f.set {
+		return "auto"
+	}
+	return strconv.FormatBool(f.exclude)
+}
+
+func (f *rootRows) Set(v string) error {
+	if v == "auto" {
+		*f = rootRows{}
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("want true, false or auto, not %q", v)
+	}
+	*f = rootRows{set: true, exclude: b}
+	return nil
+}
+
+// ranked: whether the roots of a scan over roots get rows of their own.
+func (f *rootRows) ranked(roots []string) bool {
+	if f.set {
+		return !f.exclude
+	}
+	if len(roots) != 1 {
+		return true
+	}
+	return strings.EqualFold(strings.TrimRight(volumeRoot(roots[0]), `\`), strings.TrimRight(roots[0], `\/`))
+}
+
+// ########### SCAN: CHECKPOINTS ##################
+// -checkpoint=FILE: a scan of a large or flaky volume can run for hours,
+// and a panic or a kill loses everything it counted. The rankings and
+// counters so far are saved to FILE every -checkpoint-every, replacing it
+// in one rename, and the file is removed once the run has printed its
+// results. A checkpoint left behind is what the failed run had, and
+// -resume=FILE picks the scan up from it: the roots' children and
+// grandchildren that had been walked completely are listed with their
+// totals, and only the rest is walked again.
+
+// checkpoint: the -checkpoint file. Totals are lower bounds: the
+// directories being walked when it was written aren't in it yet.
+type checkpoint struct {
+	SchemaVersion string    `json:"schemaVersion"`
+	Kind          string    `json:"kind"` // checkpointKind, which tells -resume it isn't a network state file
+	RunID         string    `json:"runId"`
+	Roots         []string  `json:"roots"`
+	Started       time.Time `json:"started"`
+	Saved         time.Time `json:"saved"`
+	FilesSeen     int64     `json:"filesSeen"`
+	DirsSeen      int64     `json:"dirsSeen"`
+	BytesSeen     int64     `json:"bytesSeen"`
+	Errors        int64     `json:"errors"`
+	Skipped       int64     `json:"skipped"`
+	Files         []item    `json:"files"`
+	Dirs          []item    `json:"dirs,omitempty"` // empty with -combined: Files holds both
+	Completed     []doneDir `json:"completed"`
+}
+
+const checkpointKind = "checkpoint"
+
+// checkpoint: sc as it stands. The finished subtrees are read before the
+// heaps, so every entry of a subtree listed as done is already ranked.
+func (sc *scan) checkpoint() checkpoint {
+	s := &sc.stats
+	done, held := sc.cfg.subtrees.snapshot()
+	c := checkpoint{
+		SchemaVersion: schemaVersion,
+		Kind:          checkpointKind,
+		RunID:         sc.runID,
+		Roots:         sc.roots,
+		Started:       sc.start,
+		Saved:         time.Now(),
+		FilesSeen:     atomic.LoadInt64(&s.filesSeen),
+		DirsSeen:      atomic.LoadInt64(&s.dirsSeen),
+		BytesSeen:     atomic.LoadInt64(&s.bytesSeen),
+		Errors:        atomic.LoadInt64(&s.errors),
+		Skipped:       atomic.LoadInt64(&s.skipped),
+		Files:         sc.fileTop.sortedDesc(),
+		Completed:     done,
+	}
+	if !sc.cfg.combined {
+		c.Dirs = sc.dirTop.sortedDesc()
+	}
+	for _, it := range held {
+		if it.IsDir && !sc.cfg.combined {
+			c.Dirs = append(c.Dirs, it)
+		} else {
+			c.Files = append(c.Files, it)
+		}
+	}
+	return c
+}
+
+// checkpoints saves sc's checkpoint to path every interval until sc is
+// done. The returned channel is closed once no save is in progress or
+// will follow. A failed save is reported once; the scan goes on.
+func (sc *scan) checkpoints(path string, interval time.Duration) chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		warned := false
+		for {
+			select {
+			case <-sc.done:
+				return
+			case <-t.C:
+			}
+			data, err := json.Marshal(sc.checkpoint())
+			if err == nil {
+				err = writeFileAtomic(path, data)
+			}
+			if err != nil && !warned {
+				fmt.Fprintln(os.Stderr, "checkpoint:", err)
+				warned = true
+			}
+		}
+	}()
+	return stopped
+}
+
+// subtreeDepth: how deep -checkpoint records finished directories. The
+// roots' children and grandchildren keep the file small and still spare
+// a resumed scan most of what was done.
+const subtreeDepth = 2
+
+// savedAgg: a dirAgg as -checkpoint and -resume files keep it, with all
+// its parent and its ranking need from it.
+type savedAgg struct {
+	Size         int64     `json:"size"`
+	Files        int64     `json:"files"`
+	Dirs         int64     `json:"dirs"`
+	MaxFile      int64     `json:"maxFile,omitempty"`
+	MaxChild     int64     `json:"maxChild,omitempty"`
+	MaxChildPath string    `json:"maxChildPath,omitempty"`
+	MaxDir       int64     `json:"maxDir,omitempty"`
+	Partial      bool      `json:"partial,omitempty"`
+	Newest       time.Time `json:"newest,omitzero"`
+}
+
+func saveAgg(a dirAgg) savedAgg {
+	return savedAgg{Size: a.size, Files: a.files, Dirs: a.dirs, MaxFile: a.maxFile, MaxChild: a.maxChild,
+		MaxChildPath: a.maxChildPath, MaxDir: a.maxDir, Partial: a.partial, Newest: a.newest}
+}
+
+func (d savedAgg) agg() dirAgg {
+	return dirAgg{size: d.Size, files: d.Files, dirs: d.Dirs, maxFile: d.MaxFile, partial: d.Partial,
+		maxChild: d.MaxChild, maxChildPath: d.MaxChildPath, maxDir: d.MaxDir, newest: d.Newest}
+}
+
+// doneDir: a finished directory in a checkpoint, with its total.
+type doneDir struct {
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+	savedAgg
+
+	// What the summary counted inside it.
+	Errors       int64            `json:"errors,omitempty"`
+	SkippedBy    map[string]int64 `json:"skippedBy,omitempty"`    // by skipReasonNames
+	SkippedBytes map[string]int64 `json:"skippedBytes,omitempty"` // by skipReasonNames, where known
+}
+
+// subtreeTally: the errors and skips counted below one directory
+// subtreeLog records, so a resumed scan's summary has them again. Each
+// count also goes to the tallies of the directories above it.
+type subtreeTally struct {
+	up           *subtreeTally
+	errors       int64
+	skippedBy    [numSkipReasons]int64
+	skippedBytes [numSkipReasons]int64
+}
+
+func (t *subtreeTally) failed() {
+	for ; t != nil; t = t.up {
+		atomic.AddInt64(&t.errors, 1)
+	}
+}
+
+func (t *subtreeTally) skip(r skipReason, bytes int64) {
+	for ; t != nil; t = t.up {
+		atomic.AddInt64(&t.skippedBy[r], 1)
+		if bytes > 0 {
+			atomic.AddInt64(&t.skippedBytes[r], bytes)
+		}
+	}
+}
+
+// record: t's counts into d.
+func (t *subtreeTally) record(d *doneDir) {
+	if t == nil {
+		return
+	}
+	d.Errors = atomic.LoadInt64(&t.errors)
+	for r := range numSkipReasons {
+		if n := atomic.LoadInt64(&t.skippedBy[r]); n > 0 {
+			if d.SkippedBy == nil {
+				d.SkippedBy, d.SkippedBytes = make(map[string]int64), make(map[string]int64)
+			}
+			d.SkippedBy[skipReasonNames[r]] = n
+			if b := atomic.LoadInt64(&t.skippedBytes[r]); b > 0 {
+				d.SkippedBytes[skipReasonNames[r]] = b
+			}
+		}
+	}
+}
+
+// replay: d's counts, from a checkpoint, into s and t as if counted again.
+func (t *subtreeTally) replay(d doneDir, s *stats) {
+	atomic.AddInt64(&s.errors, d.Errors)
+	for u := t; u != nil; u = u.up {
+		atomic.AddInt64(&u.errors, d.Errors)
+	}
+	for r, name := range skipReasonNames {
+		n, b := d.SkippedBy[name], d.SkippedBytes[name]
+		if n == 0 {
+			continue
+		}
+		atomic.AddInt64(&s.skipped, n)
+		atomic.AddInt64(&s.skippedBy[r], n)
+		atomic.AddInt64(&s.skippedBytes[r], b)
+		for u := t; u != nil; u = u.up {
+			atomic.AddInt64(&u.skippedBy[r], n)
+			atomic.AddInt64(&u.skippedBytes[r], b)
+		}
+	}
+}
+
+// subtreeLog: the directories down to subtreeDepth whose walk finished,
+// and, when resuming, those the checkpoint says were finished already.
+type subtreeLog struct {
+	mu      sync.Mutex
+	done    map[string]doneDir     // by pathKey
+	resumed map[string]*resumedDir // by pathKey; each taken at most once
+}
+
+// resumedDir: a finished directory from a checkpoint and the ranked
+// entries inside it, which go back into the heaps when it is taken.
+type resumedDir struct {
+	dir   doneDir
+	items []item
+}
+
+// newSubtreeLog: an empty log, or with st one that resumes it. Entries of
+// st's heaps outside every finished directory are dropped: they will be
+// walked, and ranked, again.
+func newSubtreeLog(st *resumeState) *subtreeLog {
+	l := &subtreeLog{done: make(map[string]doneDir), resumed: make(map[string]*resumedDir)}
+	if st == nil {
+		return l
+	}
+	for _, d := range st.Completed {
+		l.resumed[pathKey(d.Path)] = &resumedDir{dir: d}
+	}
+	for _, it := range slices.Concat(st.Files, st.Dirs) {
+		for _, r := range l.resumed {
+			if isUnder(it.Path, r.dir.Path) && pathKey(it.Path) != pathKey(r.dir.Path) {
+				r.items = append(r.items, it)
+				break
+			}
+		}
+	}
+	return l
+}
+
+// resuming: whether a checkpoint still has directories to hand out.
+func (l *subtreeLog) resuming() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.resumed) > 0
+}
+
+// resume: for a directory the checkpoint lists as finished, its total;
+// its ranked entries are pushed and its counts added to s and to t, the
+// tally of the directory above it. false for any other directory, and
+// for one already taken, so nothing is merged twice.
+func (l *subtreeLog) resume(path string, depth int, t *subtreeTally, fileTop, dirTop *minHeap, s *stats) (dirAgg, bool) {
+	if l == nil || depth < 1 || depth > subtreeDepth {
+		return dirAgg{}, false
+	}
+	key := pathKey(path)
+	l.mu.Lock()
+	r, ok := l.resumed[key]
+	if ok {
+		delete(l.resumed, key)
+		l.done[key] = r.dir
+	}
+	l.mu.Unlock()
+	if !ok {
+		return dirAgg{}, false
+	}
+	for _, it := range r.items {
+		if it.IsDir {
+			dirTop.push(it)
+		} else {
+			fileTop.push(it)
+		}
+	}
+	atomic.AddInt64(&s.filesSeen, r.dir.Files)
+	atomic.AddInt64(&s.dirsSeen, r.dir.Dirs)
+	atomic.AddInt64(&s.bytesSeen, r.dir.Size)
+	t.replay(r.dir, s)
+	return r.dir.agg(), true
+}
+
+// finish records a directory walked to the end, with t its tally.
+func (l *subtreeLog) finish(path string, depth int, agg dirAgg, t *subtreeTally) {
+	if l == nil || depth < 1 || depth > subtreeDepth {
+		return
+	}
+	d := doneDir{Path: path, Depth: depth, savedAgg: saveAgg(agg)}
+	t.record(&d)
+	l.mu.Lock()
+	l.done[pathKey(path)] = d
+	l.mu.Unlock()
+}
+
+// snapshot: the finished directories for a checkpoint, by path, leaving
+// out those inside another one; and the ranked entries of resumed ones
+// not reached yet, which the heaps don't hold.
+func (l *subtreeLog) snapshot() ([]doneDir, []item) {
+	if l == nil {
+		return nil, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	all := make(map[string]doneDir, len(l.done)+len(l.resumed))
+	maps.Copy(all, l.done)
+	var held []item
+	for key, r := range l.resumed {
+		all[key] = r.dir
+		held = append(held, r.items...)
+	}
+	var out []doneDir
+	for _, d := range all {
+		if _, inside := all[pathKey(filepath.Dir(d.Path))]; inside && d.Depth > 1 {
+			continue
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, held
+}
+
+// resumeCheckpoint: -resume with a -checkpoint file. The roots are
+// walked from the top again, but a directory the checkpoint lists as
+// finished is taken from it when the walk gets there.
+func resumeCheckpoint(ctx context.Context, st *resumeState, cfg walkCfg) *scan {
+	cfg.subtrees = newSubtreeLog(st)
+	sc := startScan(ctx, st.Roots, cfg)
+	if st.RunID != "" {
+		sc.runID = st.RunID
+	}
+	return sc
+}
+
+// ########### ROOT ORDER ##################
+// -root-order: the sequence per-root results are reported in. By free
+// space it is known up front and the scan starts roots in that order
+// too; by size only once the scan is done.
+
+// byFreeSpace sorts roots by the free space of their volume, least
+// first; roots whose free space is unknown keep their order at the end.
+func byFreeSpace(roots []string, dsc *driveSpaceCache) {
+	free := make(map[string]uint64, len(roots))
+	for _, r := range roots {
+		free[r] = dsc.spaceFor(r).free
+	}
+	sort.SliceStable(roots, func(i, j int) bool {
+		fi, fj := free[roots[i]], free[roots[j]]
+		if fi == 0 || fj == 0 {
+			return fj == 0 && fi != 0
+		}
+		return fi < fj
+	})
+}
+
+// bySize reorders the finished scan's per-root results, largest root
+// first.
+func (sc *scan) bySize() {
+	idx := make([]int, len(sc.roots))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return sc.rootSizes[idx[a]] > sc.rootSizes[idx[b]] })
+	roots, errs, sizes, state := make([]string, len(idx)), make([]error, len(idx)), make([]int64, len(idx)), make([]int32, len(idx))
+	for to, from := range idx {
+		roots[to], errs[to], sizes[to], state[to] = sc.roots[from], sc.rootErrs[from], sc.rootSizes[from], sc.rootState[from]
+	}
+	sc.roots, sc.rootErrs, sc.rootSizes, sc.rootState = roots, errs, sizes, state
+}
+
+// rootStatusLine: "C:\ ok, \\nas\share failed: <err>" in -root-order.
+func rootStatusLine(roots []string, errs []error) string {
+	parts := make([]string, len(roots))
+	for i, r := range roots {
+		if errors.Is(errs[i], errDeviceRemoved) {
+			parts[i] = r + " " + errDeviceRemoved.Error()
+		} else if errs[i] != nil {
+			parts[i] = fmt.Sprintf("%s failed: %v", r, errs[i])
+		} else {
+			parts[i] = r + " ok"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// compactWidth: the width sizes are right-aligned to in -compact,
+// enough for "≥1023.99 MB".
+const compactWidth = 11
+
+// printCompact: a -compact ranking, one "SIZE  PATH" line per item.
+func printCompact(title string, items []item, color bool) {
+	fmt.Println()
+	fmt.Println(title)
+	for _, it := range items {
+		pad := compactWidth - utf8.RuneCountInString(sizeLabel(it))
+		fmt.Printf("%s%s  %s\n", strings.Repeat(" ", max(pad, 0)), sizeCell(it, color), displayPath(it))
+	}
+}
+
+// printCombined: the -combined table, files and directories ranked together.
+func printCombined(items []item, dsc *driveSpaceCache, pb percentBase, base *baseline, splitDir, color bool) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Largest Items")
+	if splitDir {
+		fmt.Fprintln(w, paint(color, ansiBold, "RANK\tTYPE\tSIZE\t"+base.header()+pb.header()+"\tDIR\tNAME"))
+	} else {
+		fmt.Fprintln(w, paint(color, ansiBold, "RANK\tTYPE\tSIZE\t"+base.header()+pb.header()+"\tPATH"))
+	}
+	for i, it := range items {
+		pct := pb.cell(it.Size, dsc.spaceFor(it.Path))
+		typ := "file"
+		if it.IsDir {
+			typ = "dir"
+		}
+		if splitDir && !it.IsDir {
+			dir, name := splitPath(it.Path)
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s%s\t%s\t%s\n", i+1, typ, sizeCell(it, color), base.cell(it), pct, dir, name)
+			continue
+		}
+		if splitDir {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s%s\t%s\t\n", i+1, typ, sizeCell(it, color), base.cell(it), pct, displayPath(it))
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s%s\t%s\n", i+1, typ, sizeCell(it, color), base.cell(it), pct, displayPath(it))
+	}
+	w.Flush()
+}
+
+// ########### WALKER: DIRECTORY RECURSION ##################
+// walkDir: recursively scans a directory, returning the aggregated size.
+// Uses a semaphore for concurrency fan-out control.
+func walkDir(ctx context.Context, path string, depth int, cfg walkCfg, sem *workSem, fileTop, dirTop *minHeap, s *stats) (dirAgg, error) {
+	select {
+	case <-ctx.Done():
+		return dirAgg{}, ctx.Err()
+	default:
+	}
+
+	// Honor depth limit early.
+	if cfg.maxDepth > 0 && depth > cfg.maxDepth {
+		s.skip(cfg, skipDepth, 0)
+		s.exclude(ctx, cfg, path)
+		return dirAgg{partial: true}, nil
+	}
+	if cfg.followDepth > 0 && cfg.link.from != "" {
+		if cfg.link.left == 0 {
+			s.skip(cfg, skipDepth, 0)
+			s.exclude(ctx, cfg, path)
+			return dirAgg{partial: true}, nil
+		}
+		cfg.link.left--
+	}
+
+	if agg, ok := cfg.subtrees.resume(path, depth, cfg.tally, fileTop, dirTop, s); ok {
+		return agg, nil // walked before the checkpoint this run resumes
+	}
+	if cfg.subtrees != nil && depth >= 1 && depth <= subtreeDepth {
+		cfg.tally = &subtreeTally{up: cfg.tally}
+	}
+
+	if cfg.netRate != nil && strings.HasPrefix(path, `\\`) {
+		select {
+		case <-cfg.netRate.C:
+		case <-ctx.Done():
+			return dirAgg{}, ctx.Err()
+		}
+	}
+
+	if cfg.progressPaths {
+		s.current.Store(path)
+	}
+	release := cfg.volumes.acquire(ctx, path)
+	listStart := time.Now()
+	entries, err := readDir(ctx, path, cfg.dirTimeout)
+	atomic.AddInt64(&s.listNanos, int64(time.Since(listStart)))
+	release()
+	if errors.Is(err, errDirTimeout) {
+		s.skip(cfg, skipTimeout, 0)
+		return dirAgg{partial: true}, nil
+	}
+	if err != nil {
+		if cerr := changedUnder(path, s); cerr != nil {
+			return dirAgg{}, cerr
+		}
+		act := s.onError(cfg, path, "list", err)
+		if isDeviceGone(err) {
+			deviceGone(ctx)
+		}
+		if isNetworkError(err) {
+			atomic.AddInt64(&s.netErrors, 1)
+			s.net.lost(path, depth)
+		}
+		if act != errContinue || len(entries) == 0 || isNetworkError(err) {
+			return dirAgg{}, err
+		}
+		// Carry on with what was listed; the total is a lower bound.
+	}
+	listed := err == nil
+	atomic.AddInt64(&s.dirsSeen, 1)
+	if cfg.huge != nil && len(entries) > cfg.huge.limit {
+		s.skip(cfg, skipHuge, 0)
+		return cfg.huge.add(path, entries), nil
+	}
+
+	// -owner-dirs-only: one owner lookup per directory, inherited by its files.
+	dirOwned := false
+	if cfg.owner != nil && cfg.owner.dirsOnly {
+		dirOwned = cfg.owner.owns(path)
+	}
+
+	total := dirAgg{partial: !listed, dirs: 1}
+	if cfg.fileStats {
+		total.sizes = &sizeSketch{}
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	// rank offers a child to top; with -columns=parent it is held until
+	// this directory's total, its parent size, is known.
+	var held []heldItem
+	rank := func(top *minHeap, it item) {
+		if !cfg.parentPct {
+			top.push(it)
+			return
+		}
+		it.SeenAt = time.Now() // measured now, ranked once the parent is done
+		mu.Lock()
+		held = append(held, heldItem{top, it})
+		mu.Unlock()
+	}
+
+	// addFile counts a sized file towards this directory. full is "" on
+	// the lean path, where nothing reads maxChildPath.
+	addFile := func(full string, size int64, mtime time.Time) {
+		mu.Lock()
+		total.add(dirAgg{size: size, maxFile: size, files: 1})
+		total.child(full, size, mtime)
+		if total.sizes != nil {
+			total.sizes.add(size)
+		}
+		mu.Unlock()
+		atomic.AddInt64(&s.filesSeen, 1)
+		atomic.AddInt64(&s.bytesSeen, size)
+		if cfg.sqlite != nil {
+			cfg.sqlite.file(full, size, mtime)
+		}
+		if cfg.writtenDays != nil {
+			cfg.writtenDays.add(cfg.written, mtime, size)
+		}
+	}
+
+	// subdir walks one subdirectory and merges it into this one; scfg is
+	// cfg, or cfg past a followed link.
+	subdir := func(p string, mtime time.Time, scfg walkCfg) {
+		defer s.recoverDir(scfg, p, nil)
+		sub, derr := walkDir(ctx, p, depth+1, scfg, sem, fileTop, dirTop, s)
+		if depth == 0 && cfg.children != nil {
+			cfg.children.add(p, sub, derr)
+		}
+		var bf *becameFile
+		switch {
+		case errors.As(derr, &bf):
+			if it, ok := sizeFile(cfg, p, bf.info, dirOwned, s); ok {
+				addFile(p, it.Size, bf.info.ModTime())
+				if fileEligible(cfg, p, bf.info.ModTime()) {
+					rank(fileTop, it)
+				}
+			}
+		case errors.Is(derr, errVanished):
+		case derr == nil:
+			sub.mtime = mtime
+			mu.Lock()
+			total.add(sub)
+			total.childDir(p, sub.size, sub.modified())
+			mu.Unlock()
+			if cfg.sqlite != nil {
+				cfg.sqlite.dir(p, sub.size, sub.modified())
+			}
+			if it, ok := dirItem(p, depth+1, sub, cfg); ok {
+				rank(dirTop, rankedDir(it, sub, cfg))
+				if cfg.changedTop != nil && it.Modified.After(cfg.changedSince) {
+					cfg.changedTop.push(it)
+				}
+			}
+		case isNetworkError(derr):
+			mu.Lock()
+			total.netLost = true
+			mu.Unlock()
+		case ctx.Err() != nil:
+			// Stopped by an errAbort or a cancel: whatever it held is
+			// missing, so this total is a lower bound.
+			mu.Lock()
+			total.partial = true
+			mu.Unlock()
+		case !isIgnorable(derr):
+			atomic.AddInt64(&s.errors, 1)
+			cfg.tally.failed()
+		}
+	}
+
+	lean := leanFiles(cfg)
+	var statTime time.Duration // sizing entries; added to s.statNanos once
+	stopped := false           // an errAbort: the rest of the entries are left
+	for _, de := range entries {
+		name := de.Name()
+
+		// Lean path: when only the size of a plain file matters, its full
+		// path is built just for the few that can still make fileTop.
+		if lean && de.Type().IsRegular() && !(cfg.skipHidden && strings.HasPrefix(name, ".")) {
+			t := time.Now()
+			info, err := de.Info()
+			statTime += time.Since(t)
+			if err == nil && !isCloudPlaceholder(info) && !(cfg.sparseFiles != nil && isSparse(info)) {
+				addFile("", info.Size(), info.ModTime())
+				if fileTop.wouldAccept(info.Size()) {
+					rank(fileTop, item{Path: filepath.Join(path, name), Size: info.Size(), Modified: info.ModTime()})
+				}
+				continue
+			}
+			// Locked, vanished, a placeholder or a reported sparse file:
+			// the full path below sorts it out.
+		}
+
+		full := filepath.Join(path, name)
+		t := time.Now()
+		info, lerr := de.Info()
+		if lerr != nil {
+			// Exclusively locked files (databases, VM disks) may still be sized.
+			info, lerr = lockedInfo(full, lerr)
+		}
+		statTime += time.Since(t)
+		if errors.Is(lerr, fs.ErrNotExist) {
+			atomic.AddInt64(&s.vanished, 1)
+			continue
+		}
+		if lerr != nil {
+			if s.onError(cfg, full, "stat", lerr) == errAbort {
+				stopped = true
+				break
+			}
+			continue
+		}
+
+		if cfg.links != nil {
+			cfg.links.add(full, info)
+		}
+
+		// Skip rules: -skip globs, symlinks, hidden (see entrySkip).
+		if r, skip := entrySkip(cfg, full, name, info); skip {
+			s.skip(cfg, r, regularSize(info))
+			if info.IsDir() {
+				s.exclude(ctx, cfg, full)
+			}
+			continue
+		}
+
+		if cfg.names != nil {
+			cfg.names.check(full, name)
+		}
+
+		scfg, isDir := cfg, de.IsDir()
+		if info.IsDir() != isDir && info.Mode()&fs.ModeSymlink == 0 {
+			// Replaced since the listing; info is the newer of the two.
+			atomic.AddInt64(&s.retyped, 1)
+			isDir = info.IsDir()
+		}
+
+		// Volume mount points are walked into with -include-reparse=mount
+		// when their volume isn't scanned anyway; else optionally listed.
+		if isMountPoint(full, info) {
+			switch {
+			case cfg.reparse[reparseMount] && !cfg.volumeScanned(full):
+				isDir = true
+			case cfg.mountDirs:
+				var size int64
+				if cfg.mountSizes {
+					size = mountSize(ctx, full, cfg, sem)
+				}
+				dirTop.push(item{Path: full, Size: size, IsDir: true, Tag: "mount"})
+				continue
+			default:
+				continue
+			}
+		}
+
+		if cfg.followLinks && isDirLink(full, info) && cfg.reparse[linkKind(info)] {
+			var ok bool
+			if scfg, ok = crossLink(cfg, full); !ok {
+				s.skip(cfg, skipSymlink, 0)
+				continue
+			}
+			isDir = true
+		}
+
+		if isDir {
+			// Try parallel subtree processing using the worker budget.
+			if sem.tryAcquire() {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer sem.release()
+					subdir(full, info.ModTime(), scfg)
+				}()
+			} else {
+				// No free slot — process synchronously.
+				subdir(full, info.ModTime(), scfg)
+			}
+			continue
+		}
+
+		// Regular file: add to totals and top-K.
+		if info.Mode().IsRegular() {
+			t := time.Now()
+			it, ok := sizeFile(cfg, full, info, dirOwned, s)
+			statTime += time.Since(t)
+			if !ok {
+				continue
+			}
+			addFile(full, it.Size, info.ModTime())
+			if fileEligible(cfg, full, info.ModTime()) {
+				rank(fileTop, it)
+			}
+			cfg.find.add(it)
+		} else if k, ok := specialOf(info); ok {
+			s.noteSpecial(cfg, full, k)
+		}
+	}
+
+	atomic.AddInt64(&s.statNanos, int64(statTime))
+	wg.Wait()
+	if stopped {
+		total.partial = true
+	}
+	for _, h := range held {
+		h.it.Parent = total.size
+		h.top.push(h.it)
+	}
+	if depth == 0 && cfg.children != nil {
+		cfg.children.addRoot(total)
+	}
+	if cfg.caches != nil {
+		cfg.caches.record(path, total)
+	}
+	if cfg.rollups != nil {
+		cfg.rollups.record(path, total)
+	}
+	if cfg.index != nil {
+		cfg.index.record(path, total)
+	}
+	if cfg.concTop != nil && total.size > 0 && float64(total.maxChild) >= cfg.concentration*float64(total.size) {
+		if it, ok := dirItem(path, depth, total, cfg); ok {
+			it.Largest, it.LargestSize = total.maxChildPath, total.maxChild
+			cfg.concTop.push(it)
+		}
+	}
+	if total.netLost {
+		s.net.incomplete(path, depth, total)
+	} else if ctx.Err() == nil {
+		cfg.subtrees.finish(path, depth, total, cfg.tally) // a stopped scan may have skipped children
+	}
+	return total, nil
+}
+
+// ########### WALKER: ENTRIES CHANGED MID-SCAN ##################
+// A listing is a snapshot: by the time an entry is read it may have been
+// deleted, or replaced by one of the other kind. Neither is an error of
+// the scan; both are counted apart and the entry is taken as it is now.
+
+// errVanished: a directory was deleted or renamed away after its parent
+// listed it. It adds nothing to the parent.
+var errVanished = errors.New("deleted during the scan")
+
+// becameFile: a directory was replaced by a file after its parent listed
+// it; info is the file, which the parent counts instead.
+type becameFile struct{ info fs.FileInfo }
+
+func (e *becameFile) Error() string { return "replaced by a file during the scan" }
+
+// changedUnder: for a directory that couldn't be listed, whether that is
+// because it changed: errVanished or a *becameFile, counted in s. nil
+// means it is still a directory and the listing error is real.
+func changedUnder(path string, s *stats) error {
+	fi, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		atomic.AddInt64(&s.vanished, 1)
+		return errVanished
+	case err == nil && fi.Mode().IsRegular():
+		atomic.AddInt64(&s.retyped, 1)
+		return &becameFile{info: fi}
+	}
+	return nil
+}
+
+// changedLine: the summary line for entries that changed mid-scan, or
+// "" when none did.
+func (s *stats) changedLine() string {
+	gone, retyped := atomic.LoadInt64(&s.vanished), atomic.LoadInt64(&s.retyped)
+	if gone+retyped == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Changed during the scan: %d deleted, %d changed type (not counted as errors)", gone, retyped)
+}
+
+// leanFiles: whether plain files can take walkDir's lean path, i.e. no
+// option needs a file's path for anything but the ranking: no -skip
+// globs, per-file filters or reports, and a size that doesn't depend on
+// the path (-metric=logical). -sqlite records every path, so it opts out.
+func leanFiles(cfg walkCfg) bool {
+	return len(cfg.skipPatterns) == 0 && cfg.metricName == "logical" &&
+		cfg.owner == nil && cfg.sparse == nil && cfg.owners == nil &&
+		cfg.names == nil && cfg.concTop == nil && len(cfg.filesUnder) == 0 &&
+		cfg.sqlite == nil && cfg.written == nil && cfg.find == nil
+}
+
+// sizeFile: a regular file as an item, after the per-file filters (-owner,
+// -sparse-only) and the size mode; ok is false when a filter left it out.
+// dirOwned is the parent's owner verdict, used with -owner-dirs-only.
+func sizeFile(cfg walkCfg, full string, info fs.FileInfo, dirOwned bool, s *stats) (item, bool) {
+	if cfg.owner != nil {
+		owned := dirOwned
+		if !cfg.owner.dirsOnly {
+			owned = cfg.owner.owns(full)
+		}
+		if !owned {
+			atomic.AddInt64(&s.notOwned, 1)
+			return item{}, false
+		}
+	}
+	it := item{Path: full, Size: info.Size(), Modified: info.ModTime()}
+	if cfg.sparse != nil {
+		onDisk, ok := cfg.sparse.check(full, it.Size)
+		if !ok {
+			atomic.AddInt64(&s.notSparse, 1)
+			return item{}, false
+		}
+		it.Tag = humanBytesFixed(onDisk) + " on disk"
+	}
+	if cfg.metric != nil {
+		it.Size = cfg.metric(full, info)
+	}
+	if isSparse(info) {
+		if cfg.metricName == "allocated" && it.Tag == "" {
+			it.Tag = "sparse"
+		}
+		if cfg.sparseFiles != nil {
+			cfg.sparseFiles.add(full, info.Size())
+		}
+	}
+	if isCloudPlaceholder(info) {
+		it.Tag = "cloud"
+		local := it.Size
+		if cfg.cloudLocal != nil {
+			local = min(cfg.cloudLocal.size(full, info.Size()), it.Size)
+			it.Size = local
+		}
+		atomic.AddInt64(&s.cloud, 1)
+		atomic.AddInt64(&s.online, max(info.Size()-local, 0))
+	}
+	if cfg.owners != nil {
+		cfg.owners.add(full, it.Size)
+	}
+	return it, true
+}
+
+// walkFileRoot: a root that is a file rather than a directory. It is
+// sized on its own and counted like any other file.
+func walkFileRoot(root string, info fs.FileInfo, cfg walkCfg, fileTop *minHeap, s *stats) int64 {
+	owned := cfg.owner != nil && cfg.owner.dirsOnly && cfg.owner.owns(root)
+	it, ok := sizeFile(cfg, root, info, owned, s)
+	if !ok {
+		return 0
+	}
+	atomic.AddInt64(&s.filesSeen, 1)
+	atomic.AddInt64(&s.bytesSeen, it.Size)
+	if cfg.writtenDays != nil {
+		cfg.writtenDays.add(cfg.written, info.ModTime(), it.Size)
+	}
+	if fileEligible(cfg, root, info.ModTime()) {
+		fileTop.push(it)
+	}
+	cfg.find.add(it)
+	return it.Size
+}
+
+// fileEligible: whether a counted file may be ranked, per -files-under
+// and -written-between. Totals and stats include it either way.
+func fileEligible(cfg walkCfg, path string, mtime time.Time) bool {
+	if cfg.written != nil && !cfg.written.contains(mtime) {
+		return false
+	}
+	if len(cfg.filesUnder) == 0 {
+		return true
+	}
+	for _, dir := range cfg.filesUnder {
+		if isUnder(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// regularSize: size of a regular file for skip accounting; 0 for
+// directories, links and other entries whose real size isn't known.
+func regularSize(info fs.FileInfo) int64 {
+	if !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// mountSize: total bytes under a mount point, from a walk of its own whose
+// files and subdirectories don't compete for the main tables or stats.
+func mountSize(ctx context.Context, path string, cfg walkCfg, sem *workSem) int64 {
+	var s stats
+	// Not part of the scan's results:
+	cfg.owners, cfg.links, cfg.special, cfg.names, cfg.children, cfg.caches, cfg.concTop, cfg.index, cfg.changedTop, cfg.sqlite = nil, nil, nil, nil, nil, nil, nil, nil, nil, nil
+	cfg.rollups, cfg.sparseFiles, cfg.find, cfg.writtenDays, cfg.subtrees = nil, nil, nil, nil, nil
+	if cfg.huge != nil {
+		// Still estimated rather than read, but not listed as the scan's own.
+		cfg.huge = &hugeLog{limit: cfg.huge.limit}
+	}
+	discard := &minHeap{} // k=0 keeps nothing
+	agg, _ := walkDir(ctx, path, 0, cfg, sem, discard, discard, &s)
+	return agg.size
+}
+
+// errDirTimeout: a listing ran past -dir-timeout.
+var errDirTimeout = errors.New("directory listing timed out")
+
+// listDir: the listing readDir makes; tests swap it for one that fails.
+var listDir = os.ReadDir
+
+// readDir: listDir, given up on after timeout (if > 0) or when ctx is
+// done. A read that is given up on keeps its goroutine until the OS call
+// returns; its result is dropped.
+func readDir(ctx context.Context, path string, timeout time.Duration) ([]os.DirEntry, error) {
+	if timeout <= 0 {
+		return listDir(path)
+	}
+	type result struct {
+		entries []os.DirEntry
+		err     error
+	}
+	ch := make(chan result, 1) // buffered: an abandoned read must not block
+	go func() {
+		entries, err := listDir(path)
+		ch <- result{entries, err}
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		return r.entries, r.err
+	case <-t.C:
+		return nil, errDirTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pushDir: offer a finished subtree to dirTop (see dirItem).
+func pushDir(dirTop *minHeap, path string, depth int, agg dirAgg, cfg walkCfg) {
+	if it, ok := dirItem(path, depth, agg, cfg); ok {
+		dirTop.push(rankedDir(it, agg, cfg))
+	}
+}
+
+// rankedDir: it as Largest Directories ranks it. With -rank=exclusive,
+// Size leaves out the largest direct subdirectory and Total keeps the
+// full size. The other directory tables rank by the full size.
+func rankedDir(it item, agg dirAgg, cfg walkCfg) item {
+	if cfg.rankExclusive {
+		it.Total, it.Size = it.Size, it.Size-agg.maxDir
+	}
+	return it
+}
+
+// dirItem: the dirTop entry for a finished subtree; false when it sits
+// below -depth-report or above -min-report-depth, or -collapse says it is
+// just a wrapper around one big file.
+func dirItem(path string, depth int, agg dirAgg, cfg walkCfg) (item, bool) {
+	if cfg.reportDepth > 0 && depth > cfg.reportDepth {
+		return item{}, false
+	}
+	if depth < cfg.minRankDepth {
+		return item{}, false // still counted in its parents, like any other
+	}
+	if cfg.maxDepth > 0 && depth > cfg.maxDepth {
+		return item{}, false // never read; its 0 B would only be noise
+	}
+	if cfg.collapseDirs && agg.size > 0 && float64(agg.maxFile) >= collapseRatio*float64(agg.size) {
+		return item{}, false
+	}
+	it := item{Path: path, Size: agg.size, IsDir: true, Partial: agg.partial || agg.netLost,
+		Files: agg.files, Median: agg.sizes.median(), Modified: agg.modified()}
+	if cfg.winSxS != "" && pathKey(path) == cfg.winSxS {
+		it.Tag = componentStoreNote
+	}
+	return it, true
+}
+
+// componentStoreNote: the tag on %WINDIR%\WinSxS. Most of the component
+// store is hard links into System32 and elsewhere, so its apparent size
+// counts files that live on the disk once; deleting from it by hand
+// breaks servicing. It is still counted, just not taken at face value.
+const componentStoreNote = "mostly hardlinks — apparent size overstated"
+
+// heldItem: a child entry waiting for its parent's total (-columns=parent).
+type heldItem struct {
+	top *minHeap
+	it  item
+}
+
+// ########### WALKER: ERROR HANDLING ##################
+// Every error walkDir counts goes to cfg.onError, which decides what the
+// walk does next. Access denied under -expected-denied is counted apart
+// and never reaches it. The default keeps GoSize's old behaviour;
+// -on-error picks another: continue keeps what a failed listing did read,
+// and abort (-abort-on-error) stops the whole scan at the first error,
+// with the tables showing what was counted up to then.
+
+// errAction: what the walk does after an error.
+type errAction int
+
+const (
+	errContinue    errAction = iota // go on; a failed listing keeps the entries it read, marked ≥
+	errSkipSubtree                  // leave out the entry, or the directory that couldn't be listed
+	errAbort                        // stop the scan; scan.abortErr records why
+)
+
+// errorHandler: the action for err, met doing op ("list" a directory or
+// "stat" an entry) at path. For a stat both Continue and SkipSubtree
+// skip the entry: there is nothing of it left to count.
+type errorHandler func(path, op string, err error) errAction
+
+// defaultOnError: an unreadable directory is left out, as are entries
+// that can't be read.
+func defaultOnError(_, _ string, _ error) errAction { return errSkipSubtree }
+
+// continueOnError: -on-error=continue.
+func continueOnError(_, _ string, _ error) errAction { return errContinue }
+
+// abortOnError: -on-error=abort, or -abort-on-error.
+func abortOnError(_, _ string, _ error) errAction { return errAbort }
+
+// errorHandlers: -on-error's choices.
+var errorHandlers = map[string]errorHandler{
+	"skip":     defaultOnError,
+	"continue": continueOnError,
+	"abort":    abortOnError,
+}
+
+// scanAbort: the error a scan was stopped at.
+type scanAbort struct {
+	path, op string
+	err      error
+}
+
+func (a *scanAbort) Error() string {
+	return fmt.Sprintf("scan aborted at %s (%s): %v", a.path, a.op, a.err)
+}
+
+func (a *scanAbort) Unwrap() error { return a.err }
+
+// abortable: ctx, cancelled by an errAbort in any of sc's walks (see
+// walkCfg.abort) and released once sc is done.
+func (sc *scan) abortable(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		<-sc.done
+		cancel(nil)
+	}()
+	sc.cfg.abort = func(a *scanAbort) {
+		sc.abortErr.CompareAndSwap(nil, a)
+		cancel(a)
+	}
+	return ctx
+}
+
+// onError counts err (see failed) and returns cfg's action for it,
+// stopping the scan on errAbort.
+func (s *stats) onError(cfg walkCfg, path, op string, err error) errAction {
+	if !s.failed(cfg, path, err) {
+		return errSkipSubtree
+	}
+	handle := cfg.onError
+	if handle == nil {
+		handle = defaultOnError
+	}
+	act := handle(path, op, err)
+	if act == errAbort && cfg.abort != nil {
+		cfg.abort(&scanAbort{path: path, op: op, err: err})
+	}
+	return act
+}
+
+// recoverDir: deferred around every directory walkDir descends into and
+// every root. A panic while reading path (a damaged volume or an odd
+// network share handing back something nothing expected) is printed with
+// the path and stack and counted as an error; that subtree is lost but
+// the rest of the scan goes on. errp, when set, gets the panic as an
+// error, so a root that panics is reported like one that failed.
+func (s *stats) recoverDir(cfg walkCfg, path string, errp *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := fmt.Errorf("panic: %v", r)
+	fmt.Fprintf(os.Stderr, "\n%s: %v\n%s", path, err, debug.Stack())
+	s.onError(cfg, path, "walk", err)
+	if errp != nil {
+		*errp = err
+	}
+}
+
+// ########### WALKER: WORKER BUDGET ##################
+// workSem: a counting semaphore for walker goroutines whose limit can be
+// changed mid-scan. Lowering it doesn't stop running walkers; the new
+// limit takes hold as they return their slots.
+type workSem struct {
+	mu    sync.Mutex
+	freed *sync.Cond // signalled when a slot frees up or the limit rises
+	limit int
+	used  int
+}
+
+func newWorkSem(n int) *workSem {
+	s := &workSem{limit: max(n, 1)}
+	s.freed = sync.NewCond(&s.mu)
+	return s
+}
+
+// tryAcquire takes a slot if one is free, without waiting.
+func (s *workSem) tryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used >= s.limit {
+		return false
+	}
+	s.used++
+	return true
+}
+
+// acquire waits for a free slot and takes it.
+func (s *workSem) acquire() {
+	s.mu.Lock()
+	for s.used >= s.limit {
+		s.freed.Wait()
+	}
+	s.used++
+	s.mu.Unlock()
+}
+
+func (s *workSem) release() {
+	s.mu.Lock()
+	s.used--
+	s.mu.Unlock()
+	s.freed.Signal()
+}
+
+// resize sets the limit, at least 1.
+func (s *workSem) resize(n int) {
+	s.mu.Lock()
+	s.limit = max(n, 1)
+	s.mu.Unlock()
+	s.freed.Broadcast()
+}
+
+// size: the current limit.
+func (s *workSem) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// ########### WALKER: AUTO WORKERS ##################
+// workerTuner: -auto-workers. Resizes the walkers' workSem between 1 and
+// the -workers-io ceiling as throughput changes.
+type workerTuner struct {
+	sem  *workSem
+	max  int
+	peak int32
+}
+
+// tuneWindow: how long each throughput sample runs.
+const tuneWindow = time.Second
+
+// newWorkerTuner: starts at a quarter of the ceiling, at least 2.
+func newWorkerTuner(sem *workSem) *workerTuner {
+	t := &workerTuner{sem: sem, max: sem.size()}
+	start := min(t.max, max(2, t.max/4))
+	sem.resize(start)
+	t.peak = int32(start)
+	return t
+}
+
+// run samples the bytes counted per tuneWindow until done is closed and
+// hill-climbs the worker count: a rise of 5% or more keeps it moving the
+// same way, a drop of 5% or more turns it around, anything else holds.
+func (t *workerTuner) run(bytes *int64, done <-chan struct{}) {
+	tick := time.NewTicker(tuneWindow)
+	defer tick.Stop()
+	var last, lastRate int64
+	step := 1
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+		}
+		total := atomic.LoadInt64(bytes)
+		rate := total - last
+		last = total
+		switch {
+		case rate*100 < lastRate*95:
+			step = -step
+		case rate*100 < lastRate*105 || rate == 0:
+			lastRate = rate
+			continue
+		}
+		lastRate = rate
+		n := min(max(t.workers()+step, 1), t.max)
+		t.sem.resize(n)
+		if int32(n) > atomic.LoadInt32(&t.peak) {
+			atomic.StoreInt32(&t.peak, int32(n))
+		}
+	}
+}
+
+func (t *workerTuner) workers() int { return t.sem.size() }
+
+// progress: " workers=N" for the progress line; "" without -auto-workers.
+func (t *workerTuner) progress() string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf(" workers=%d", t.workers())
+}
+
+// ########### WALKER: PER-VOLUME LIMIT ##################
+// volumeLimiter: -per-volume-workers. Caps how many directory listings
+// run at once on one volume, on top of the global -workers-io budget, so
+// a spinning disk isn't made to seek between dozens of directories. Only
+// the listing holds a slot; sizing and recursion don't touch the disk.
+type volumeLimiter struct {
+	n     int // > 0: every volume; 0: auto by seek penalty; < 0: off
+	mu    sync.Mutex
+	slots map[string]chan struct{} // nil channel: no limit on that volume
+}
+
+// hddWorkers: the auto limit for a volume that reports a seek penalty.
+const hddWorkers = 2
+
+func newVolumeLimiter(n int) *volumeLimiter {
+	if n < 0 {
+		return nil
+	}
+	return &volumeLimiter{n: n, slots: make(map[string]chan struct{})}
+}
+
+// acquire waits for a slot on path's volume and returns its release. A
+// nil limiter, an unlimited volume or a done ctx returns at once.
+func (v *volumeLimiter) acquire(ctx context.Context, path string) (release func()) {
+	if v == nil {
+		return func() {}
+	}
+	ch := v.slotsFor(volumeRoot(path))
+	if ch == nil {
+		return func() {}
+	}
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }
+	case <-ctx.Done():
+		return func() {} // readDir sees ctx and returns at once
+	}
+}
+
+func (v *volumeLimiter) slotsFor(root string) chan struct{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if ch, ok := v.slots[root]; ok {
+		return ch
+	}
+	n := v.n
+	if n == 0 && root != "" && seekPenalty(root) {
+		n = hddWorkers
+	}
+	var ch chan struct{}
+	if n > 0 && root != "" {
+		ch = make(chan struct{}, n)
+	}
+	v.slots[root] = ch
+	return ch
+}
+
+// limited: the volumes with a cap, e.g. "D:\=2, E:\=2"; "" when none.
+func (v *volumeLimiter) limited() string {
+	if v == nil {
+		return ""
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var parts []string
+	for root, ch := range v.slots {
+		if ch != nil {
+			parts = append(parts, fmt.Sprintf("%s=%d", root, cap(ch)))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// ########### WALKER: PER-ROOT ABORT ##################
+// errDeviceRemoved: a root's walk was stopped because its device went away
+// (a USB drive pulled mid-scan).
+var errDeviceRemoved = errors.New("aborted: device removed")
+
+// deviceGoneLimit: device-gone errors on one root before it is aborted.
+// One can be a fluke; a burst means the volume is gone.
+const deviceGoneLimit = 3
+
+// rootGuard: one root's cancellation. walkDir finds it in the context and
+// reports device-gone errors to it; past deviceGoneLimit it cancels that
+// root's subtree only, and the other roots carry on.
+type rootGuard struct {
+	cancel context.CancelCauseFunc
+	hits   int64
+}
+
+type rootGuardKey struct{}
+
+func withRootGuard(ctx context.Context) (context.Context, *rootGuard) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	g := &rootGuard{cancel: cancel}
+	return context.WithValue(ctx, rootGuardKey{}, g), g
+}
+
+// deviceGone: counts one device-gone error against ctx's root, if any.
+func deviceGone(ctx context.Context) {
+	if g, ok := ctx.Value(rootGuardKey{}).(*rootGuard); ok {
+		if atomic.AddInt64(&g.hits, 1) == deviceGoneLimit {
+			g.cancel(errDeviceRemoved)
+		}
+	}
+}
+
+// result: the root's error for the status line: errDeviceRemoved if the
+// guard aborted it, else the walk's own error. Releases the context.
+func (g *rootGuard) result(walkErr error) error {
+	aborted := atomic.LoadInt64(&g.hits) >= deviceGoneLimit
+	g.cancel(nil)
+	if aborted {
+		return errDeviceRemoved
+	}
+	return walkErr
+}
+
+// isDeviceGone: errors a volume returns once its device has been removed.
+func isDeviceGone(err error) bool {
+	return errors.Is(err, windows.ERROR_DEVICE_NOT_CONNECTED) ||
+		errors.Is(err, windows.ERROR_NOT_READY) ||
+		errors.Is(err, windows.ERROR_DEV_NOT_EXIST)
+}
+
+// ########### RULES: SKIP DECISIONS ##################
+// entrySkip: applies the per-entry skip rules in evaluation order and
+// returns the first that matches: -skip globs, symlinks, hidden names.
+// The depth rule applies to whole directories and lives in walkDir.
+func entrySkip(cfg walkCfg, full, name string, info fs.FileInfo) (skipReason, bool) {
+	if shouldSkipByGlob(full, cfg.skipPatterns) {
+		return skipGlob, true
+	}
+	if info.Mode()&fs.ModeSymlink != 0 && (!cfg.reparse[reparseSymlink] || cfg.followDepth > 0 && cfg.link.from != "") {
+		return skipSymlink, true // not followed, or a link inside a link with -follow-links-depth
+	}
+	if !cfg.reparse[reparseCloud] && isCloudPlaceholder(info) {
+		return skipPlaceholder, true
+	}
+	if cfg.skipHidden && strings.HasPrefix(name, ".") {
+		return skipHidden, true
+	}
+	return 0, false
+}
+
+// ruleSummary: the active rules, in the order entrySkip evaluates them.
+func ruleSummary(cfg walkCfg) []string {
+	var rules []string
+	for _, p := range cfg.skipPatterns {
+		rules = append(rules, "glob     skip paths matching "+p)
+	}
+	if off := cfg.reparse.excluded(); len(off) > 0 {
+		rules = append(rules, "reparse  skip "+strings.Join(off, ", ")+" reparse points (-include-reparse)")
+	}
+	switch {
+	case !cfg.followLinks:
+	case cfg.followDepth > 0:
+		rules = append(rules, fmt.Sprintf("symlink  skip links inside followed links, and cycles (-follow-links-depth=%d)", cfg.followDepth))
+		rules = append(rules, fmt.Sprintf("depth    skip directories more than %d levels past a followed link", cfg.followDepth))
+	default:
+		rules = append(rules, "symlink  skip links that loop back to a parent")
+	}
+	if cfg.skipHidden {
+		rules = append(rules, "hidden   skip dot-prefixed names")
+	}
+	if cfg.maxDepth > 0 {
+		rules = append(rules, fmt.Sprintf("depth    skip directories deeper than %d", cfg.maxDepth))
+	}
+	if cfg.huge != nil {
+		rules = append(rules, fmt.Sprintf("huge     skip directories listing more than %d entries, estimated from the listing", cfg.huge.limit))
+	}
+	return rules
+}
+
+// ########### DRY RUN ##################
+// printDryRun: everything a scan would do short of sizing: resolved roots
+// with drive type and capacity, the rule list, effective settings, and a
+// two-level directory preview annotated with the rule that would apply.
+func printDryRun(out io.Writer, roots []string, cfg walkCfg) {
+	dsc := newDriveSpaceCache()
+	fmt.Fprintln(out, "Dry run: nothing will be sized.")
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Roots")
+	w := tabwriter.NewWriter(out, 2, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOT\tTYPE\tCAPACITY\tFREE")
+	for _, r := range roots {
+		capacity, free := "n/a", "n/a"
+		if sp := dsc.spaceFor(r); sp.total > 0 {
+			capacity, free = humanBytesFixed(int64(sp.total)), humanBytesFixed(int64(sp.free))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r, driveType(r), capacity, free)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Rules (evaluated in order; first match skips)")
+	rules := ruleSummary(cfg)
+	if len(rules) == 0 {
+		fmt.Fprintln(out, "  (none)")
+	}
+	for i, r := range rules {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, r)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Settings")
+	workers := fmt.Sprint(cfg.workers)
+	if cfg.autoWorkers {
+		workers = "auto(max " + workers + ")"
+	}
+	fmt.Fprintf(out, "  workers=%s max-roots=%d top=%d maxdepth=%d depth-report=%d\n",
+		workers, cfg.maxRoots, cfg.topK, cfg.maxDepth, cfg.reportDepth)
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Preview (two levels)")
+	for _, r := range roots {
+		fmt.Fprintln(out, r)
+		if fi, err := os.Stat(r); err == nil && fi.Mode().IsRegular() {
+			fmt.Fprintln(out, "  (file root, sized directly)")
+			continue
+		}
+		previewDir(out, r, 1, cfg)
+	}
+}
+
+// previewDir: lists subdirectories of path as scan/skip(reason), recursing
+// once more for the ones that would be scanned. Files are only counted.
+func previewDir(out io.Writer, path string, depth int, cfg walkCfg) {
+	indent := strings.Repeat("  ", depth)
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		fmt.Fprintf(out, "%serror: %v\n", indent, err)
+		return
+	}
+	files, skippedFiles := 0, 0
+	for _, de := range entries {
+		full := filepath.Join(path, de.Name())
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		r, skip := entrySkip(cfg, full, de.Name(), info)
+		if !de.IsDir() {
+			files++
+			if skip {
+				skippedFiles++
+			}
+			continue
+		}
+		switch {
+		case skip:
+			fmt.Fprintf(out, "%sskip(%s)  %s\n", indent, skipReasonNames[r], full)
+		case cfg.maxDepth > 0 && depth > cfg.maxDepth:
+			fmt.Fprintf(out, "%sskip(%s)  %s\n", indent, skipReasonNames[skipDepth], full)
+		default:
+			fmt.Fprintf(out, "%sscan  %s\n", indent, full)
+			if depth < 2 {
+				previewDir(out, full, depth+1, cfg)
+			}
+		}
+	}
+	if files > 0 {
+		fmt.Fprintf(out, "%s(%d files, %d skipped)\n", indent, files, skippedFiles)
+	}
+}
+
+// ########### BENCHMARK ##################
+// benchRefFilesPerSec: the scan rate that scores 100. It is fixed, not
+// measured, so scores from different machines and versions compare.
+const benchRefFilesPerSec = 50_000
+
+// benchRun: what one benchmark scan read and how long it took.
+type benchRun struct {
+	files, dirs int64
+	elapsed     time.Duration
+}
+
+// benchScan: one scan of root with cfg, tables and all, thrown away.
+func benchScan(root string, cfg walkCfg) (benchRun, error) {
+	sc := startScan(context.Background(), []string{root}, cfg)
+	sc.wait()
+	if err := sc.rootErrs[0]; err != nil {
+		return benchRun{}, err
+	}
+	return benchRun{
+		files:   atomic.LoadInt64(&sc.stats.filesSeen),
+		dirs:    atomic.LoadInt64(&sc.stats.dirsSeen),
+		elapsed: sc.elapsed,
+	}, nil
+}
+
+func (b benchRun) filesPerSec() float64 {
+	return float64(b.files) / max(b.elapsed.Seconds(), 1e-3)
+}
+
+func (b benchRun) String() string {
+	return fmt.Sprintf("%d files, %d directories in %s: %.0f files/s, %.0f dirs/s",
+		b.files, b.dirs, b.elapsed, b.filesPerSec(), float64(b.dirs)/max(b.elapsed.Seconds(), 1e-3))
+}
+
+// runBenchmark: -benchmark. Builds the tree in a fresh temp folder, scans
+// it once and removes it again.
+func runBenchmark(w io.Writer, sh benchtree.Shape, seed uint64, cfg walkCfg) error {
+	dir, err := os.MkdirTemp("", "gosize-benchmark-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	dirs, files := sh.Counts()
+	fmt.Fprintf(w, "Building %s tree (seed %d): %d directories, %d files in %s\n", sh, seed, dirs, files, dir)
+	t := time.Now()
+	bytes, err := benchtree.Build(dir, sh, seed)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Built in %s (%s of file data)\n", time.Since(t).Truncate(time.Millisecond), humanBytesFixed(bytes))
+
+	run, err := benchScan(dir, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Scan: %s\n", run)
+	fmt.Fprintf(w, "Score: %.0f (100 = %d files/s)\n", 100*run.filesPerSec()/benchRefFilesPerSec, benchRefFilesPerSec)
+	return nil
+}
+
+// runBenchmarkExisting: -benchmark-existing. The first scan is cold only
+// as far as nothing else read the tree lately; the second finds whatever
+// the first left in the file system cache.
+func runBenchmarkExisting(w io.Writer, path string, cfg walkCfg) error {
+	for _, pass := range []string{"Cold", "Warm"} {
+		run, err := benchScan(path, cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: %s\n", pass, run)
+	}
+	return nil
+}
+
+// ########### HELPERS: ROOTS, ERRORS, SKIPS ##################
+// resolveRoots: turns the -roots value into the list of roots to scan,
+// expanding globs and normalizing each to end in a separator. An empty
+// spec means every detected drive.
+func resolveRoots(spec string) ([]string, error) {
+	if spec == "" {
+		roots := detectWindowsDrives()
+		if len(roots) == 0 {
+			return nil, errors.New("No drives detected. Provide -roots like -roots=C:\\,D:\\")
+		}
+		return roots, nil
+	}
+	var roots []string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		for _, r := range expandRoot(longForm("root", part)) {
+			if fi, err := os.Stat(r); err == nil && fi.Mode().IsRegular() {
+				roots = append(roots, r) // file root: no trailing separator
+				continue
+			}
+			if !strings.HasSuffix(r, `\`) && !strings.HasSuffix(r, "/") {
+				r += `\`
+			}
+			roots = append(roots, r)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("No roots left to scan after expanding -roots patterns.")
+	}
+	return roots, nil
+}
+
+// checkRoot: a root-level setup problem (missing, not a file or directory,
+// not listable) as a clear error, as opposed to errors deeper in the walk,
+// which are only counted.
+func checkRoot(root string) error {
+	fi, err := os.Stat(root)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return &rootError{root: root, reason: "does not exist"}
+	case err != nil:
+		return &rootError{root: root, reason: "is not accessible", err: err}
+	case fi.Mode().IsRegular():
+		return nil // a file root; sized directly
+	case !fi.IsDir():
+		return &rootError{root: root, reason: "is neither a file nor a directory"}
+	}
+	if err := probeDir(root); err != nil {
+		return &rootError{root: root, reason: "is not accessible", err: err}
+	}
+	return nil
+}
+
+// probeDir: the read checkRoot makes, of one entry at most, so a huge
+// root isn't listed twice; tests swap it for one that fails.
+var probeDir = func(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Readdirnames(1); err == io.EOF {
+		return nil // empty, but listable
+	}
+	return err
+}
+
+// rootError: why checkRoot turned a root down.
+type rootError struct {
+	root   string
+	reason string // "does not exist", "is not accessible", ...
+	err    error  // the underlying error, if any
+}
+
+func (e *rootError) Error() string {
+	msg := "root " + e.root + " " + e.reason
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *rootError) Unwrap() error { return e.err }
+
+// detectWindowsDrives: enumerates A:\ to Z:\ and returns those that exist.
+func detectWindowsDrives() []string {
+	var roots []string
+	for c := 'A'; c <= 'Z'; c++ {
+		root := string([]rune{c, ':'}) + `\`
+		if _, err := os.Stat(root); err == nil {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// expandRoot: fans a -roots entry containing glob metacharacters out to the
+// directories and files it matches (e.g. C:\Users\*\Downloads). Plain
+// entries pass through untouched; a pattern matching nothing is warned
+// about and dropped.
+func expandRoot(spec string) []string {
+	if !strings.ContainsAny(spec, "*?[") {
+		return []string{spec}
+	}
+	matches, err := filepath.Glob(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: bad -roots pattern %q: %v\n", spec, err)
+		return nil
+	}
+	var dirs []string
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && (fi.IsDir() || fi.Mode().IsRegular()) {
+			dirs = append(dirs, m)
+		}
+	}
+	if len(dirs) == 0 {
+		fmt.Fprintf(os.Stderr, "warning: -roots pattern %q matched no files or directories\n", spec)
+	}
+	return dirs
+}
+
+// isIgnorable: classify common, non-actionable errors (e.g., permission).
+func isIgnorable(err error) bool {
+	if errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) {
+		return true // the scan or its root was stopped; not a new failure
+	}
+	// Extend here with Windows sharing violations if needed.
+	return false
+}
+
+// isUnder: dir is path or one of its ancestors. Case-insensitive, and
+// only at separator boundaries, so C:\Users does not cover C:\Users2.
+func isUnder(path, dir string) bool {
+	lp, ld := strings.ToLower(path), strings.ToLower(strings.TrimRight(dir, `\/`))
+	return lp == ld || strings.HasPrefix(lp, ld+`\`) || strings.HasPrefix(lp, ld+"/")
+}
+
+// pathLess: the order of path-sorted lists, per -sort-paths-natural.
+func (cfg walkCfg) pathLess(a, b string) bool {
+	if cfg.naturalPaths {
+		return naturalLess(a, b)
+	}
+	return a < b
+}
+
+// naturalLess: a < b with runs of digits compared by value, so "img2"
+// sorts before "img10". Equal values with more leading zeros sort first
+// ("x01" < "x1"); everything else compares byte by byte.
+func naturalLess(a, b string) bool {
+	zerosTie := 0
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			da, db := digitRun(a), digitRun(b)
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			if zerosTie == 0 && len(da) != len(db) {
+				zerosTie = len(db) - len(da)
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	if a != b {
+		return a == ""
+	}
+	return zerosTie < 0
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// digitRun: the leading run of ASCII digits in s.
+func digitRun(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// writeFileAtomic: writes data to a temporary file next to path, syncs
+// it to disk, then renames it over path, so a crash or a reboot leaves
+// either the old file or the new one, never half of it. A path ending in
+// .gz gets data gzipped. Every file GoSize writes for later goes
+// through here, except the -sqlite database (see sqliteExport.write)
+// and the -history log, which is appended to.
+func writeFileAtomic(path string, data []byte) error {
+	return copyFileAtomic(path, bytes.NewReader(data))
+}
+
+// copyFileAtomic: writeFileAtomic for the contents of r, streamed.
+func copyFileAtomic(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := writeMaybeGzip(tmp, path, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// ########### OUTPUT FILES: GZIP ##################
+// The files GoSize writes for later (-sqlite, -baseline, the
+// -index-serve index) are gzipped when their name ends in .gz, to keep
+// archived multi-million-entry scans small. Reading them back takes
+// either form. Reports go to stdout; pipe them through gzip.
+
+// isGzipPath: whether path asks for a gzipped file.
+func isGzipPath(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gz")
+}
+
+// writeMaybeGzip copies r to w, gzipped when path is a .gz name. The
+// gzip stream is closed, i.e. complete, when it returns nil.
+func writeMaybeGzip(w io.Writer, path string, r io.Reader) error {
+	if !isGzipPath(path) {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// readMaybeGzip: os.ReadFile, gunzipped when the file starts with the
+// gzip magic bytes, whatever its name.
+func readMaybeGzip(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+// isCorrupt: whether err from reading back one of those files means it
+// was cut short or damaged (a reboot mid-write by an older version, a
+// disk error) rather than missing or from another schema version. Such
+// a file is ignored with a warning instead of failing the run.
+func isCorrupt(err error) bool {
+	var syntax *json.SyntaxError
+	return errors.As(err, &syntax) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum)
+}
+
+// defaultExpectedDenied: system folders a non-elevated account can't list.
+var defaultExpectedDenied = []string{
+	`?:\System Volume Information`,
+	`?:\$Recycle.Bin\*`, // other accounts' bins
+	`?:\Documents and Settings`,
+	`?:\Windows\System32\config`,
+	`?:\Windows\System32\LogFiles\WMI\RtBackup`,
+	`?:\Windows\CSC`,
+	`?:\Windows\ServiceProfiles`,
+	`?:\Windows\Temp`,
+	`?:\ProgramData\Microsoft\Windows Defender`,
+}
+
+// expectedDenial: path or one of its ancestors matches a pattern,
+// case-insensitively, so a whole denied tree is covered by its top folder.
+func expectedDenial(path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for p := strings.ToLower(path); ; {
+		for _, pat := range patterns {
+			if ok, _ := filepath.Match(strings.ToLower(pat), p); ok {
+				return true
+			}
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false
+		}
+		p = parent
+	}
+}
+
+// shouldSkipByGlob: filter out paths matching any filepath.Match pattern.
+func shouldSkipByGlob(path string, patterns []string) bool {
+	for _, p := range patterns {
+		ok, _ := filepath.Match(p, path)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readPatterns: the -exclude-from file, one glob per line. Surrounding
+// spaces are trimmed; blank lines and # comments are skipped. Each
+// pattern is checked so a typo fails now rather than matching nothing.
+func readPatterns(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pats []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q: %v", path, i+1, line, err)
+		}
+		pats = append(pats, line)
+	}
+	return pats, nil
+}
+
+// splitPath: parent directory and base name of p for the DIR/NAME columns.
+// Root-level files keep the volume root as parent ("C:\pagefile.sys" ->
+// "C:\", "pagefile.sys"); UNC paths keep the share root ("\\srv\share\").
+func splitPath(p string) (dir, name string) {
+	if root := volumeRoot(p); root != "" && len(p) <= len(root) {
+		return root, "" // p is itself a volume root
+	}
+	dir, name = filepath.Dir(p), filepath.Base(p)
+	if dir == filepath.VolumeName(p) {
+		dir = volumeRoot(p)
+	}
+	return dir, name
+}
+
+// extKey: the extension of p's base name as every feature keys it:
+// lowercased with the dot, since NTFS names differ in case only for show
+// ("clip.MP4" and "clip.mp4" are one type). "" when there is none: no dot,
+// a trailing dot, or only a leading one, as ".gitignore" is a name.
+func extKey(p string) string {
+	ext := filepath.Ext(strings.TrimLeft(filepath.Base(p), "."))
+	if ext == "." {
+		return ""
+	}
+	return strings.ToLower(ext)
+}
+
+// ########### CHILDREN MODE ##################
+// childLog: -children=PATH and -breakdown. Every direct subdirectory of
+// the roots with its full recursive size, whatever -top is, plus the
+// roots' combined total.
+type childLog struct {
+	mu   sync.Mutex
+	kids []childInfo
+	root dirAgg
+}
+
+// childInfo: one row of the children table.
+type childInfo struct {
+	Path       string  `json:"path"`
+	SizeBytes  int64   `json:"sizeBytes"`
+	Files      int64   `json:"files"`
+	Percent    float64 `json:"parentPercent"`
+	LowerBound bool    `json:"lowerBound,omitempty"` // part of the subtree was cut off or unreadable
+	Error      string  `json:"error,omitempty"`      // the directory itself couldn't be listed
+}
+
+// filesRow: the Path of the row for files directly in the root.
+const filesRow = "(files)"
+
+func (c *childLog) add(path string, agg dirAgg, err error) {
+	ci := childInfo{Path: path, SizeBytes: agg.size, Files: agg.files, LowerBound: agg.partial || agg.netLost}
+	if err != nil {
+		ci.Error, ci.LowerBound = err.Error(), true
+	}
+	c.mu.Lock()
+	c.kids = append(c.kids, ci)
+	c.mu.Unlock()
+}
+
+func (c *childLog) addRoot(agg dirAgg) {
+	agg.sizes = nil
+	c.mu.Lock()
+	c.root.add(agg)
+	c.mu.Unlock()
+}
+
+// ranked: the children largest first, with a (files) row for whatever the
+// roots hold outside them, and each one's share of the roots' total.
+func (c *childLog) ranked() []childInfo {
+	c.mu.Lock()
+	out := append([]childInfo(nil), c.kids...)
+	root := c.root
+	c.mu.Unlock()
+	loose := childInfo{Path: filesRow, SizeBytes: root.size, Files: root.files}
+	for _, ci := range out {
+		loose.SizeBytes -= ci.SizeBytes
+		loose.Files -= ci.Files
+	}
+	if loose.Files > 0 {
+		out = append(out, loose)
+	}
+	for i := range out {
+		if root.size > 0 {
+			out[i].Percent = float64(out[i].SizeBytes) / float64(root.size) * 100
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].SizeBytes != out[j].SizeBytes {
+			return out[i].SizeBytes > out[j].SizeBytes
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// printChildren: the -children table.
+func printChildren(root string, kids []childInfo) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Printf("Children of %s (%d)\n", root, len(kids))
+	fmt.Fprintln(w, "RANK\tSIZE\t%PARENT\tFILES\tPATH")
+	for i, ci := range kids {
+		size := humanBytesFixed(ci.SizeBytes)
+		if ci.LowerBound {
+			size = "≥" + size
+		}
+		path := ci.Path
+		if ci.Error != "" {
+			path += " (unreadable: " + ci.Error + ")"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.2f%%\t%d\t%s\n", i+1, size, ci.Percent, ci.Files, path)
+	}
+	w.Flush()
+}
+
+// ########### CACHE REPORT ##################
+// cacheReport: -caches. Known cache folders are matched against every
+// directory walkDir finishes, so their sizes come from the normal walk.
+type cacheReport struct {
+	mu   sync.Mutex
+	locs []cacheLoc
+	byID map[string]int // lowercased clean path -> index in locs
+}
+
+// cacheLoc: one cache folder, and its size once the walk has finished it.
+type cacheLoc struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Scanned    bool   `json:"scanned"` // false: outside the roots or skipped
+	SizeBytes  int64  `json:"sizeBytes"`
+	LowerBound bool   `json:"lowerBound,omitempty"`
+}
+
+// knownCaches: built-in cache folders as environment variable + relative
+// path; rel may hold globs (one row per match). Folders that don't exist
+// on this machine are left out of the report.
+var knownCaches = []struct{ name, env, rel string }{
+	{"User temp", "LOCALAPPDATA", `Temp`},
+	{"Windows temp", "WINDIR", `Temp`},
+	{"Windows Update downloads", "WINDIR", `SoftwareDistribution\Download`},
+	{"Chrome cache", "LOCALAPPDATA", `Google\Chrome\User Data\*\Cache`},
+	{"Edge cache", "LOCALAPPDATA", `Microsoft\Edge\User Data\*\Cache`},
+	{"Firefox cache", "LOCALAPPDATA", `Mozilla\Firefox\Profiles\*\cache2`},
+	{"pip cache", "LOCALAPPDATA", `pip\Cache`},
+	{"npm cache", "LOCALAPPDATA", `npm-cache`},
+	{"NuGet packages", "USERPROFILE", `.nuget\packages`},
+	{"NuGet HTTP cache", "LOCALAPPDATA", `NuGet\v3-cache`},
+	{"Gradle caches", "USERPROFILE", `.gradle\caches`},
+	{"Go build cache", "LOCALAPPDATA", `go-build`},
+}
+
+// newCacheReport: the built-in locations plus extra (-cache-dir), expanded
+// and deduplicated.
+func newCacheReport(extra []string) *cacheReport {
+	c := &cacheReport{byID: make(map[string]int)}
+	addGlob := func(name, pattern string) {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || !fi.IsDir() {
+				continue
+			}
+			id := strings.ToLower(filepath.Clean(m))
+			if _, dup := c.byID[id]; dup {
+				continue
+			}
+			c.byID[id] = len(c.locs)
+			c.locs = append(c.locs, cacheLoc{Name: name, Path: m})
+		}
+	}
+	for _, k := range knownCaches {
+		if base := os.Getenv(k.env); base != "" {
+			addGlob(k.name, filepath.Join(base, k.rel))
+		}
+	}
+	for _, p := range extra {
+		addGlob("custom", p)
+	}
+	return c
+}
+
+// record notes the total of a finished directory if it is a cache folder.
+func (c *cacheReport) record(path string, agg dirAgg) {
+	i, ok := c.byID[strings.ToLower(filepath.Clean(path))]
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.locs[i].Scanned, c.locs[i].SizeBytes = true, agg.size
+	c.locs[i].LowerBound = agg.partial || agg.netLost
+	c.mu.Unlock()
+}
+
+// empty: the same locations, none of them scanned yet.
+func (c *cacheReport) empty() *cacheReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &cacheReport{byID: c.byID} // read-only once built
+	for _, l := range c.locs {
+		out.locs = append(out.locs, cacheLoc{Name: l.Name, Path: l.Path})
+	}
+	return out
+}
+
+// rows: every location, scanned ones largest first, then the rest.
+func (c *cacheReport) rows() []cacheLoc {
+	c.mu.Lock()
+	out := append([]cacheLoc(nil), c.locs...)
+	c.mu.Unlock()
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Scanned != out[j].Scanned {
+			return out[i].Scanned
+		}
+		return out[i].SizeBytes > out[j].SizeBytes
+	})
+	return out
+}
+
+// printCaches: the "Reclaimable caches" table with a total of what was scanned.
+func printCaches(locs []cacheLoc) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Reclaimable caches")
+	fmt.Fprintln(w, "CACHE\tSIZE\tPATH")
+	var total int64
+	for _, l := range locs {
+		size := "not scanned"
+		if l.Scanned {
+			total += l.SizeBytes
+			size = humanBytesFixed(l.SizeBytes)
+			if l.LowerBound {
+				size = "≥" + size
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", l.Name, size, l.Path)
+	}
+	fmt.Fprintf(w, "total\t%s\n", humanBytesFixed(total))
+	w.Flush()
+}
+
+// breakdownRow: one -breakdown bucket: every top-level folder with the
+// same label, across all roots.
+type breakdownRow struct {
+	Label      string   `json:"label"`
+	SizeBytes  int64    `json:"sizeBytes"`
+	Files      int64    `json:"files"`
+	Percent    float64  `json:"percent"`
+	Folders    []string `json:"folders"`
+	LowerBound bool     `json:"lowerBound,omitempty"`
+}
+
+// parseLabels: "name=Label,name2=Label 2" as lowercased name -> label.
+func parseLabels(spec string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, label, ok := strings.Cut(part, "=")
+		name, label = strings.TrimSpace(name), strings.TrimSpace(label)
+		if !ok || name == "" || label == "" {
+			return nil, fmt.Errorf("%q is not name=Label", part)
+		}
+		labels[strings.ToLower(name)] = label
+	}
+	return labels, nil
+}
+
+// bucketChildren: kids grouped by folder name, or by its label when one
+// is mapped, largest first. The (files) row stays a bucket of its own.
+func bucketChildren(kids []childInfo, labels map[string]string) []breakdownRow {
+	var out []breakdownRow
+	at := make(map[string]int)
+	for _, ci := range kids {
+		label := ci.Path
+		if ci.Path != filesRow {
+			label = filepath.Base(ci.Path)
+			if l, ok := labels[strings.ToLower(label)]; ok {
+				label = l
+			}
+		}
+		i, ok := at[strings.ToLower(label)]
+		if !ok {
+			i = len(out)
+			at[strings.ToLower(label)] = i
+			out = append(out, breakdownRow{Label: label})
+		}
+		b := &out[i]
+		b.SizeBytes += ci.SizeBytes
+		b.Files += ci.Files
+		b.Percent += ci.Percent
+		b.LowerBound = b.LowerBound || ci.LowerBound
+		b.Folders = append(b.Folders, ci.Path)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].SizeBytes > out[j].SizeBytes })
+	return out
+}
+
+// printBreakdown: the -breakdown table.
+func printBreakdown(rows []breakdownRow) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Breakdown by top-level folder")
+	fmt.Fprintln(w, "LABEL\tSIZE\tSHARE\tFILES\tFOLDERS")
+	for _, b := range rows {
+		size := humanBytesFixed(b.SizeBytes)
+		if b.LowerBound {
+			size = "≥" + size
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%d\t%d\n", b.Label, size, b.Percent, b.Files, len(b.Folders))
+	}
+	w.Flush()
+}
+
+// ########### ROLLUPS ##################
+// -rollup=C:\Users\*\Downloads: one bucket for a folder that every user
+// profile (or every project, every VM...) has. The * segment names the
+// member; the bucket is named after what follows it. Like -caches, the
+// totals come from the directories walkDir finishes.
+
+// rollupReport: the buckets of one run.
+type rollupReport struct {
+	mu      sync.Mutex
+	buckets []*rollupBucket
+}
+
+// rollupBucket: one -rollup pattern, split around its * segment, and the
+// totals of the directories that matched it, by member.
+type rollupBucket struct {
+	name, pattern string
+	prefix        string // up to and including the separator before *
+	suffix        string // from the separator after *; "" when * is last
+	members       map[string]rollupMember
+}
+
+// rollupMember: one matched directory.
+type rollupMember struct {
+	Member     string `json:"member"` // what the * matched, e.g. the user
+	Path       string `json:"path"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	LowerBound bool   `json:"lowerBound,omitempty"`
+}
+
+// rollupRow: one bucket for the table and JSON, members largest first.
+type rollupRow struct {
+	Name       string         `json:"name"`
+	Pattern    string         `json:"pattern"`
+	SizeBytes  int64          `json:"sizeBytes"`
+	LowerBound bool           `json:"lowerBound,omitempty"`
+	Members    []rollupMember `json:"members"`
+}
+
+// newRollupReport parses the -rollup patterns. Each needs exactly one *,
+// as a whole path segment.
+func newRollupReport(patterns []string) (*rollupReport, error) {
+	r := &rollupReport{}
+	for _, p := range patterns {
+		clean := filepath.Clean(p)
+		star := strings.Index(clean, "*")
+		if star < 0 || strings.Count(clean, "*") > 1 {
+			return nil, fmt.Errorf("%s: want exactly one *", p)
+		}
+		prefix, suffix := clean[:star], clean[star+1:]
+		if prefix == "" || !os.IsPathSeparator(prefix[len(prefix)-1]) || (suffix != "" && !os.IsPathSeparator(suffix[0])) {
+			return nil, fmt.Errorf("%s: the * must be a whole path segment below a directory", p)
+		}
+		name := strings.TrimLeft(suffix, `\/`)
+		if name == "" {
+			name = clean
+		}
+		r.buckets = append(r.buckets, &rollupBucket{name: name, pattern: p, prefix: prefix, suffix: suffix, members: make(map[string]rollupMember)})
+	}
+	return r, nil
+}
+
+// empty: the same patterns with no directories matched yet.
+func (r *rollupReport) empty() *rollupReport {
+	out := &rollupReport{}
+	for _, b := range r.buckets {
+		out.buckets = append(out.buckets, &rollupBucket{name: b.name, pattern: b.pattern, prefix: b.prefix, suffix: b.suffix, members: make(map[string]rollupMember)})
+	}
+	return out
+}
+
+// match: what the * stands for when path is one of b's directories,
+// or "" when it isn't.
+func (b *rollupBucket) match(path string) string {
+	if len(path) <= len(b.prefix)+len(b.suffix) || !strings.EqualFold(path[:len(b.prefix)], b.prefix) {
+		return ""
+	}
+	rest := path[len(b.prefix):]
+	member := rest[:len(rest)-len(b.suffix)]
+	if !strings.EqualFold(rest[len(member):], b.suffix) || strings.ContainsAny(member, `\/`) {
+		return ""
+	}
+	return member
+}
+
+// record notes the total of a finished directory in every bucket it matches.
+func (r *rollupReport) record(path string, agg dirAgg) {
+	for _, b := range r.buckets {
+		if m := b.match(path); m != "" {
+			r.mu.Lock()
+			b.members[strings.ToLower(m)] = rollupMember{Member: m, Path: path, SizeBytes: agg.size, LowerBound: agg.partial || agg.netLost}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// rows: every bucket in -rollup order, members largest first.
+func (r *rollupReport) rows() []rollupRow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]rollupRow, 0, len(r.buckets))
+	for _, b := range r.buckets {
+		row := rollupRow{Name: b.name, Pattern: b.pattern, Members: make([]rollupMember, 0, len(b.members))}
+		for _, m := range b.members {
+			row.SizeBytes += m.SizeBytes
+			row.LowerBound = row.LowerBound || m.LowerBound
+			row.Members = append(row.Members, m)
+		}
+		sort.Slice(row.Members, func(i, j int) bool {
+			if row.Members[i].SizeBytes != row.Members[j].SizeBytes {
+				return row.Members[i].SizeBytes > row.Members[j].SizeBytes
+			}
+			return row.Members[i].Member < row.Members[j].Member
+		})
+		out = append(out, row)
+	}
+	return out
+}
+
+// printRollups: the -rollup table, e.g. "Downloads  310.00 GB  14
+// j.smith (120.00 GB)".
+func printRollups(rows []rollupRow) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Rollups")
+	fmt.Fprintln(w, "BUCKET\tSIZE\tMATCHES\tLARGEST")
+	for _, r := range rows {
+		size, largest := humanBytesFixed(r.SizeBytes), "-"
+		if r.LowerBound {
+			size = "≥" + size
+		}
+		if len(r.Members) > 0 {
+			largest = fmt.Sprintf("%s (%s)", r.Members[0].Member, humanBytesFixed(r.Members[0].SizeBytes))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", r.Name, size, len(r.Members), largest)
+	}
+	w.Flush()
+}
+
+// ########### CONCENTRATED DIRECTORIES ##################
+// -concentration=90 lists the largest directories whose single biggest
+// child holds at least 90% of them: a 50 GB folder that is one 49 GB
+// subfolder is worth drilling into, one of a thousand equal parts isn't.
+
+// concentrated: one -concentration row for JSON.
+type concentrated struct {
+	Path         string  `json:"path"`
+	SizeBytes    int64   `json:"sizeBytes"`
+	LargestChild string  `json:"largestChild"`
+	ChildBytes   int64   `json:"largestChildBytes"`
+	Percent      float64 `json:"percent"` // largest child's share of the directory
+	LowerBound   bool    `json:"lowerBound,omitempty"`
+}
+
+func concentratedRows(items []item) []concentrated {
+	out := make([]concentrated, 0, len(items))
+	for _, it := range items {
+		out = append(out, concentrated{
+			Path:         it.Path,
+			SizeBytes:    it.Size,
+			LargestChild: it.Largest,
+			ChildBytes:   it.LargestSize,
+			Percent:      float64(it.LargestSize) / float64(it.Size) * 100,
+			LowerBound:   it.Partial,
+		})
+	}
+	return out
+}
+
+// printConcentrated: the -concentration table.
+func printConcentrated(items []item) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Concentrated Directories")
+	fmt.Fprintln(w, "RANK\tSIZE\tLARGEST\tPATH\tLARGEST CHILD")
+	for i, c := range concentratedRows(items) {
+		fmt.Fprintf(w, "%d\t%s\t%.1f%%\t%s\t%s\n", i+1, sizeLabel(items[i]), c.Percent, c.Path, filepath.Base(c.LargestChild))
+	}
+	w.Flush()
+}
+
+// ########### RECENTLY CHANGED DIRECTORIES ##################
+// -changed-since=24h: the largest directories whose own mtime, or a
+// direct child's, falls inside the window; "what grew overnight". A
+// directory's mtime moves when entries are added, removed or renamed in
+// it, not when a file inside is rewritten, hence the children's too.
+
+// printChanged: the -changed-since table.
+func printChanged(since time.Time, items []item) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Printf("Recently Changed Directories (since %s)\n", since.Local().Format("2006-01-02 15:04"))
+	fmt.Fprintln(w, "RANK\tSIZE\tMODIFIED\tPATH")
+	for i, it := range items {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, sizeLabel(it), modifiedCell(it.Modified), displayPath(it))
+	}
+	w.Flush()
+}
+
+// ########### WRITTEN BETWEEN ##################
+// -written-between=2024-05-01,2024-05-02: "what got written to this drive
+// yesterday?". Only files modified in the range are ranked, and their
+// bytes are summed per calendar day of their mtime. Directory totals are
+// untouched, so a folder's share of the drive still adds up.
+
+// writtenRange: the instants [from, to) and the zone days are cut in.
+type writtenRange struct {
+	from, to time.Time
+	loc      *time.Location
+}
+
+// parseWrittenRange parses "FROM,TO". A date means the whole day in loc
+// (as TO, up to the next midnight); an RFC3339 time is taken as is.
+func parseWrittenRange(v string, loc *time.Location) (*writtenRange, error) {
+	a, b, ok := strings.Cut(v, ",")
+	if !ok {
+		return nil, fmt.Errorf("want FROM,TO, not %q", v)
+	}
+	from, err := writtenBound(strings.TrimSpace(a), loc, false)
+	if err != nil {
+		return nil, err
+	}
+	to, err := writtenBound(strings.TrimSpace(b), loc, true)
+	if err != nil {
+		return nil, err
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%s is not before %s", a, b)
+	}
+	return &writtenRange{from: from, to: to, loc: loc}, nil
+}
+
+// writtenBound: one end of the range; end moves a date to the midnight
+// after it, so the day is included.
+func writtenBound(v string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date (2024-05-01) nor an RFC3339 time", v)
+	}
+	return t, nil
+}
+
+func (r *writtenRange) contains(t time.Time) bool {
+	return !t.Before(r.from) && t.Before(r.to)
+}
+
+// writtenDay: one row of the per-day table.
+type writtenDay struct {
+	Day   string `json:"day"` // 2006-01-02 in the range's zone
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+}
+
+// writtenLog: bytes per day for one scan; safe for concurrent use.
+type writtenLog struct {
+	mu   sync.Mutex
+	days map[string]*writtenDay
+}
+
+// add counts a file modified at mtime, if it is in r.
+func (l *writtenLog) add(r *writtenRange, mtime time.Time, size int64) {
+	if !r.contains(mtime) {
+		return
+	}
+	day := mtime.In(r.loc).Format("2006-01-02")
+	l.mu.Lock()
+	d := l.days[day]
+	if d == nil {
+		d = &writtenDay{Day: day}
+		l.days[day] = d
+	}
+	d.Bytes += size
+	d.Files++
+	l.mu.Unlock()
+}
+
+// rows: the days that saw writes, oldest first.
+func (l *writtenLog) rows() []writtenDay {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]writtenDay, 0, len(l.days))
+	for _, d := range l.days {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out
+}
+
+// printWritten: the -written-between table.
+func printWritten(r *writtenRange, days []writtenDay) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	const layout = "2006-01-02 15:04 MST"
+	fmt.Printf("Written per Day (%s to %s)\n", r.from.In(r.loc).Format(layout), r.to.In(r.loc).Format(layout))
+	fmt.Fprintln(w, "DAY\tSIZE\tFILES")
+	var total, files int64
+	for _, d := range days {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", d.Day, humanBytesFixed(d.Bytes), d.Files)
+		total += d.Bytes
+		files += d.Files
+	}
+	fmt.Fprintf(w, "total\t%s\t%d\n", humanBytesFixed(total), files)
+	w.Flush()
+}
+
+// ########### HUGE DIRECTORIES ##################
+// -skip-if-entries-over=N: a directory listing more than N entries (a
+// print spool, a mail queue) dominates scan time without being
+// interesting. It is listed but not walked: the files directly inside
+// are summed from the listing, which on Windows needs no further reads,
+// and its subdirectories are left out. That sum is a lower bound, so
+// the directory and every parent above it are marked ≥.
+
+// hugeLog: the directories left unwalked by -skip-if-entries-over.
+type hugeLog struct {
+	limit int
+	mu    sync.Mutex
+	dirs  []hugeDir
+}
+
+// hugeDir: one of them.
+type hugeDir struct {
+	Path          string `json:"path"`
+	Entries       int    `json:"entries"`
+	EstimateBytes int64  `json:"estimateBytes"` // the files directly inside; subdirectories not read
+}
+
+// add records path with its listing and returns its shallow total.
+func (h *hugeLog) add(path string, entries []fs.DirEntry) dirAgg {
+	var size int64
+	for _, de := range entries {
+		if !de.Type().IsRegular() {
+			continue
+		}
+		if info, err := de.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	h.mu.Lock()
+	h.dirs = append(h.dirs, hugeDir{Path: path, Entries: len(entries), EstimateBytes: size})
+	h.mu.Unlock()
+	return dirAgg{size: size, partial: true, dirs: 1}
+}
+
+// sorted: the directories, most entries first.
+func (h *hugeLog) sorted() []hugeDir {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := slices.Clone(h.dirs)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Entries != out[j].Entries {
+			return out[i].Entries > out[j].Entries
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// printHuge: the -skip-if-entries-over table; nothing when none was hit.
+func printHuge(dirs []hugeDir) {
+	if len(dirs) == 0 {
+		return
+	}
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Skipped huge directories")
+	fmt.Fprintln(w, "ENTRIES\tESTIMATE\tPATH")
+	for _, d := range dirs {
+		fmt.Fprintf(w, "%d\t≥%s\t%s\n", d.Entries, humanBytesFixed(d.EstimateBytes), d.Path)
+	}
+	w.Flush()
+}
+
+// ########### FIND BY EXTENSION ##################
+// -find-ext=.pst,.mdb,.bak with -find-min-size=1GB: a search rather than
+// a ranking, for audits that need every such file on the volume. Matches
+// bypass the top-K heaps and are all kept; only path, size and mtime are
+// held per file, so even tens of thousands cost little.
+
+// findLog: the files matched so far.
+type findLog struct {
+	exts    map[string]bool // as extKey gives them
+	min     int64
+	mu      sync.Mutex
+	matches []item
+	bytes   int64
+}
+
+// newFindLog: a log for the comma-separated extensions in spec, given
+// with or without the dot.
+func newFindLog(spec string, min int64) *findLog {
+	l := &findLog{exts: make(map[string]bool), min: min}
+	for _, e := range strings.Split(spec, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			l.exts["."+strings.TrimPrefix(e, ".")] = true
+		}
+	}
+	return l
+}
+
+// add records it if it matches; nil-safe, so callers needn't check -find-ext.
+func (l *findLog) add(it item) {
+	if l == nil || it.Size < l.min || !l.exts[extKey(it.Path)] {
+		return
+	}
+	l.mu.Lock()
+	l.matches = append(l.matches, it)
+	l.bytes += it.Size
+	l.mu.Unlock()
+}
+
+// sorted: every match, largest first.
+func (l *findLog) sorted() []item {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := slices.Clone(l.matches)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Size != out[j].Size {
+			return out[i].Size > out[j].Size
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// printMatches: the -find-ext table, printed even when empty so a clean
+// audit says so.
+func printMatches(l *findLog) {
+	matches := l.sorted()
+	exts := slices.Sorted(maps.Keys(l.exts))
+	fmt.Println()
+	fmt.Printf("Matching files (%s", strings.Join(exts, " "))
+	if l.min > 0 {
+		fmt.Printf(", at least %s", humanBytesFixed(l.min))
+	}
+	fmt.Printf("): %d, %s\n", len(matches), humanBytesFixed(l.bytes))
+	if len(matches) == 0 {
+		return
+	}
+	w := newTable(os.Stdout)
+	fmt.Fprintln(w, "SIZE\tMODIFIED\tPATH")
+	for _, it := range matches {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", humanBytesFixed(it.Size), modifiedCell(it.Modified), it.Path)
+	}
+	w.Flush()
+}
+
+// ########### NAME CHECK ##################
+// nameCheck: -max-name-length / -names-ascii. Collects entries whose base
+// name would trip up backup tools or a move to another filesystem.
+type nameCheck struct {
+	maxLen    int  // in UTF-16 units, as Windows counts; 0 = no length check
+	asciiOnly bool // also report non-ASCII names
+
+	mu     sync.Mutex
+	issues []nameIssue
+}
+
+// nameIssue: one row of the name report.
+type nameIssue struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// reservedNames: device names Windows won't open as files, with or
+// without an extension ("nul.txt" is NUL too).
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// check records path when its base name has a problem; the first one
+// found is the reason given.
+func (c *nameCheck) check(path, name string) {
+	reason := ""
+	stem, _, _ := strings.Cut(name, ".")
+	if n := len(utf16.Encode([]rune(name))); c.maxLen > 0 && n > c.maxLen {
+		reason = fmt.Sprintf("length %d > %d", n, c.maxLen)
+	} else if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		reason = "reserved name " + strings.ToUpper(stem)
+	} else if strings.HasSuffix(name, " ") || strings.HasSuffix(name, ".") {
+		reason = "trailing space or dot"
+	} else {
+		for _, r := range name {
+			if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+				reason = fmt.Sprintf("character %q", r)
+				break
+			}
+			if c.asciiOnly && r > 0x7e {
+				reason = fmt.Sprintf("non-ASCII %q", r)
+				break
+			}
+		}
+	}
+	if reason == "" {
+		return
+	}
+	c.mu.Lock()
+	c.issues = append(c.issues, nameIssue{Path: path, Reason: reason})
+	c.mu.Unlock()
+}
+
+// sorted: the recorded names by path.
+func (c *nameCheck) sorted(less func(a, b string) bool) []nameIssue {
+	c.mu.Lock()
+	out := append([]nameIssue(nil), c.issues...)
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return less(out[i].Path, out[j].Path) })
+	return out
+}
+
+// printNames: the "Problem names" table.
+func printNames(issues []nameIssue) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Printf("Problem names (%d)\n", len(issues))
+	fmt.Fprintln(w, "REASON\tPATH")
+	for _, ni := range issues {
+		fmt.Fprintf(w, "%s\t%s\n", ni.Reason, ni.Path)
+	}
+	w.Flush()
+}
+
+// ########### WINDOWS: ATTRIBUTES & MOUNT POINTS ##################
+// fileAttributes: FILE_ATTRIBUTE_* bits behind a FileInfo; 0 if unavailable.
+func fileAttributes(info fs.FileInfo) uint32 {
+	if d, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return d.FileAttributes
+	}
+	return 0
+}
+
+// cloudAttrs: the attributes of a cloud placeholder (OneDrive "online
+// only" and other cloud-files providers): contents are fetched from the
+// provider when opened or read, so the logical size isn't on this disk.
+const cloudAttrs = windows.FILE_ATTRIBUTE_OFFLINE | windows.FILE_ATTRIBUTE_RECALL_ON_OPEN | windows.FILE_ATTRIBUTE_RECALL_ON_DATA_ACCESS
+
+func isCloudPlaceholder(info fs.FileInfo) bool {
+	return fileAttributes(info)&cloudAttrs != 0
+}
+
+// cloudLine: the summary line for cloud placeholders, or "" when none
+// were seen.
+func (s *stats) cloudLine(cfg walkCfg) string {
+	n := atomic.LoadInt64(&s.cloud)
+	if n == 0 {
+		return ""
+	}
+	online := humanBytesFixed(atomic.LoadInt64(&s.online))
+	if cfg.cloudLocal == nil {
+		return fmt.Sprintf("Cloud placeholders: %d files, %s of them only online (counted at full size: -cloud-size=logical)", n, online)
+	}
+	return fmt.Sprintf("Cloud placeholders: %d files, %s of them only online (not counted)", n, online)
+}
+
+// ########### WALKER: FOLLOWING LINKS ##################
+// With -followlinks, walkDir descends into symlinks and junctions that
+// point at directories (volume mount points stay separate roots). A link
+// whose target holds the link, or any link crossed on the way to it, is a
+// cycle and is skipped. -follow-links-depth=N also caps the walk at N
+// levels past the link and follows no link met inside it.
+
+// linkWalk: the links crossed to reach a directory. walkCfg carries it by
+// value, so every subtree sees only its own chain.
+type linkWalk struct {
+	from, to string   // innermost link crossed and its target: walk paths under from lie under to
+	chain    []string // real paths of every link crossed, outermost first
+	left     int      // -follow-links-depth: levels still allowed below from
+}
+
+// real: where walk path p actually is.
+func (lw linkWalk) real(p string) string {
+	if lw.from == "" || !isUnder(p, lw.from) {
+		return p
+	}
+	return filepath.Join(lw.to, p[len(lw.from):])
+}
+
+// isDirLink: a symlink or junction to a directory, but not a volume
+// mount point.
+func isDirLink(path string, info fs.FileInfo) bool {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+	case info.Mode()&fs.ModeIrregular != 0 && fileAttributes(info)&windows.FILE_ATTRIBUTE_DIRECTORY != 0:
+		if isMountPoint(path, info) {
+			return false
+		}
+	default:
+		return false
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// crossLink: cfg for walking into the link at path, or false when it
+// mustn't be followed: a cycle, an unreadable target, or a link inside a
+// link under -follow-links-depth.
+func crossLink(cfg walkCfg, path string) (walkCfg, bool) {
+	if cfg.followDepth > 0 && cfg.link.from != "" {
+		return cfg, false
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return cfg, false
+	}
+	at := cfg.link.real(path)
+	t := strings.TrimPrefix(target, `\??\`)
+	if !filepath.IsAbs(t) {
+		t = filepath.Join(filepath.Dir(at), t)
+	}
+	chain := append(cfg.link.chain[:len(cfg.link.chain):len(cfg.link.chain)], at)
+	for _, l := range chain {
+		if isUnder(l, t) {
+			return cfg, false
+		}
+	}
+	cfg.link = linkWalk{from: path, to: t, chain: chain, left: cfg.followDepth}
+	return cfg, true
+}
+
+// isMountPoint: a directory reparse point whose target is a volume
+// (\\?\Volume{GUID}\), as opposed to a junction to another folder. Go
+// reports both as ModeIrregular rather than ModeDir, so walkDir never
+// descends into them.
+func isMountPoint(path string, info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeIrregular == 0 || fileAttributes(info)&windows.FILE_ATTRIBUTE_DIRECTORY == 0 {
+		return false
+	}
+	target, err := os.Readlink(path)
+	return err == nil && strings.Contains(target, `Volume{`)
+}
+
+// ########### REPARSE POLICY ##################
+// Every kind of reparse point is left out unless -include-reparse names
+// it: links can loop or lead to data counted elsewhere, a mount point is
+// another volume, and a cloud placeholder's size isn't on this disk.
+//   symlink   symbolic links (followed to directories, cycle-checked)
+//   junction  directory junctions (likewise)
+//   mount     volume mount points (walked as part of the tree, unless
+//             the volume is also under a root)
+//   cloud     OneDrive and other cloud placeholder files (sized per
+//             -cloud-size, tagged [cloud])
+// -followlinks and -follow-links-depth include symlink and junction.
+
+const (
+	reparseSymlink  = "symlink"
+	reparseJunction = "junction"
+	reparseMount    = "mount"
+	reparseCloud    = "cloud"
+)
+
+// reparseKindNames: every kind, in the order they are listed.
+var reparseKindNames = []string{reparseSymlink, reparseJunction, reparseMount, reparseCloud}
+
+// reparseKinds: the -include-reparse flag; a set of kinds.
+type reparseKinds map[string]bool
+
+func (k reparseKinds) String() string {
+	var on []string
+	for _, n := range reparseKindNames {
+		if k[n] {
+			on = append(on, n)
+		}
+	}
+	return strings.Join(on, ",")
+}
+
+func (k reparseKinds) Set(v string) error {
+	for _, n := range strings.Split(v, ",") {
+		switch n = strings.TrimSpace(n); {
+		case n == "all":
+			for _, n := range reparseKindNames {
+				k[n] = true
+			}
+		case slices.Contains(reparseKindNames, n):
+			k[n] = true
+		case n != "":
+			return fmt.Errorf("unknown kind %q (valid: %s, all)", n, strings.Join(reparseKindNames, ", "))
+		}
+	}
+	return nil
+}
+
+// excluded: the kinds not included, in listing order.
+func (k reparseKinds) excluded() []string {
+	var off []string
+	for _, n := range reparseKindNames {
+		if !k[n] {
+			off = append(off, n)
+		}
+	}
+	return off
+}
+
+// withLinks: k plus symlink and junction, as -followlinks asks; k itself
+// is left alone, since walkCfg copies share it.
+func (k reparseKinds) withLinks() reparseKinds {
+	out := maps.Clone(k)
+	if out == nil {
+		out = reparseKinds{}
+	}
+	out[reparseSymlink], out[reparseJunction] = true, true
+	return out
+}
+
+// linkKind: symlink or junction, for a directory link (see isDirLink).
+func linkKind(info fs.FileInfo) string {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return reparseSymlink
+	}
+	return reparseJunction
+}
+
+// volumeScanned: whether the volume mounted at path is one a root is on,
+// so walking into it would count it twice (or loop, for a volume
+// mounted inside itself).
+func (cfg walkCfg) volumeScanned(path string) bool {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return true // unknown: don't risk it
+	}
+	target = strings.TrimRight(strings.Replace(target, `\??\`, `\\?\`, 1), `\`)
+	for _, v := range cfg.rootVolumes {
+		if strings.EqualFold(strings.TrimRight(v, `\`), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ########### WINDOWS: MOUNTED VOLUMES AS ROOTS ##################
+// -mounts-as-roots: a volume mounted into a folder (C:\Data) rather than
+// at a drive letter is left out of the walk of the root above it, like
+// every mount point. This option finds those under the roots up front,
+// from the system's list of volume mount paths, and scans each as a root
+// of its own. volumeRoot then resolves paths below one to the mount
+// point, so DRIVE%, the volumes list and the capacity checks measure
+// them against the mounted volume rather than C:.
+
+// mountedRoots: every folder a volume is mounted at, each ending in \;
+// set once at startup by -mounts-as-roots and only read after that.
+var mountedRoots []string
+
+// volumeMountPaths: the folders volumes are mounted at, deepest first,
+// drive letters left out.
+func volumeMountPaths() []string {
+	vol := make([]uint16, windows.MAX_PATH)
+	h, err := windows.FindFirstVolume(&vol[0], uint32(len(vol)))
+	if err != nil {
+		return nil
+	}
+	defer windows.FindVolumeClose(h)
+	var out []string
+	for {
+		names := make([]uint16, windows.MAX_PATH)
+		var n uint32
+		err := windows.GetVolumePathNamesForVolumeName(&vol[0], &names[0], uint32(len(names)), &n)
+		if err == windows.ERROR_MORE_DATA {
+			names = make([]uint16, n)
+			err = windows.GetVolumePathNamesForVolumeName(&vol[0], &names[0], n, &n)
+		}
+		// A list of NUL-terminated paths, ended by an empty one.
+		for rest := names[:n]; err == nil && len(rest) > 0 && rest[0] != 0; {
+			i := slices.Index(rest, 0)
+			if i < 0 {
+				i = len(rest)
+			}
+			if p := windows.UTF16ToString(rest[:i]); !isVolumeRootPath(p) {
+				out = append(out, p)
+			}
+			rest = rest[min(i+1, len(rest)):]
+		}
+		if windows.FindNextVolume(h, &vol[0], uint32(len(vol))) != nil {
+			break
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return len(out[i]) > len(out[j]) })
+	return out
+}
+
+// mountsUnder: the mount paths below one of roots that aren't roots
+// already or matched by a -skip pattern, in mounts order.
+func mountsUnder(roots, mounts, skip []string) []string {
+	var out []string
+	for _, m := range mounts {
+		under, given := false, shouldSkipByGlob(strings.TrimRight(m, `\`), skip)
+		for _, r := range roots {
+			under = under || isUnder(m, r)
+			given = given || isUnder(r, m) && isUnder(m, r) // the same folder
+		}
+		if under && !given {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// ########### WINDOWS: 8.3 SHORT NAMES ##################
+// Legacy tools print paths in 8.3 form (C:\PROGRA~1), but the walk only
+// ever sees long names, so a short-form root or -skip pattern would
+// silently not match. Both are expanded to long form at startup.
+
+// hasShortName: whether p contains a tilde-digit sequence, as 8.3
+// names do.
+func hasShortName(p string) bool {
+	for i := 0; i+1 < len(p); i++ {
+		if p[i] == '~' && isDigit(p[i+1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// longPathName: path via GetLongPathName; path itself when it doesn't
+// exist or can't be resolved.
+func longPathName(path string) string {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return path
+	}
+	buf := make([]uint16, windows.MAX_PATH)
+	for {
+		n, err := windows.GetLongPathName(p, &buf[0], uint32(len(buf)))
+		switch {
+		case err != nil || n == 0:
+			return path
+		case int(n) > len(buf): // n is the size needed
+			buf = make([]uint16, n)
+		default:
+			return windows.UTF16ToString(buf[:n])
+		}
+	}
+}
+
+// longForm: p (a path or glob) with its literal leading directories in
+// long form. Short names after the first glob metacharacter can't be
+// resolved and stay as they are. what ("root", "-skip") names p in the
+// warning printed when p looks like it holds 8.3 names.
+func longForm(what, p string) string {
+	if !hasShortName(p) {
+		return p
+	}
+	lit, rest := p, ""
+	if i := strings.IndexAny(p, "*?["); i >= 0 {
+		j := strings.LastIndexAny(p[:i], `\/`)
+		if j < 0 {
+			j = 0
+		}
+		lit, rest = p[:j], p[j:]
+	}
+	long := longPathName(lit) + rest
+	switch {
+	case long != p:
+		fmt.Fprintf(os.Stderr, "%s %s uses 8.3 short names; expanded to %s\n", what, p, long)
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s looks like an 8.3 short name but doesn't resolve; it only matches paths spelled that way, and GoSize reports long names\n", what, p)
+	}
+	return long
+}
+
+// ########### WINDOWS: SAME-VOLUME ROOTS ##################
+// volumeAlias: a root that is the top of a volume an earlier root already
+// covers, e.g. C:\Data\ mounting the volume that is also D:\.
+type volumeAlias struct {
+	Root    string `json:"root"`
+	Same    string `json:"sameAs"`
+	GUID    string `json:"volume"` // \\?\Volume{GUID}\
+	Skipped bool   `json:"skipped"`
+}
+
+// volumeGUID: the \\?\Volume{GUID}\ name of root when root is a drive
+// root or an NTFS mount point; "" for any other folder.
+func volumeGUID(root string) string {
+	p, err := windows.UTF16PtrFromString(strings.TrimRight(root, `\`) + `\`)
+	if err != nil {
+		return ""
+	}
+	buf := make([]uint16, windows.MAX_PATH)
+	if err := windows.GetVolumeNameForVolumeMountPoint(p, &buf[0], uint32(len(buf))); err != nil {
+		return ""
+	}
+	return windows.UTF16ToString(buf)
+}
+
+// sameVolumeRoots: roots with every later root that names an already seen
+// volume taken out, and those roots as aliases of the first. guid maps a
+// root to its volume name ("" = not a volume top, never an alias).
+func sameVolumeRoots(roots []string, guid func(string) string) ([]string, []volumeAlias) {
+	var keep []string
+	var dups []volumeAlias
+	first := make(map[string]string) // volume GUID -> first root on it
+	for _, r := range roots {
+		g := guid(r)
+		if g == "" {
+			keep = append(keep, r)
+			continue
+		}
+		if prev, ok := first[strings.ToLower(g)]; ok {
+			dups = append(dups, volumeAlias{Root: r, Same: prev, GUID: g})
+			continue
+		}
+		first[strings.ToLower(g)] = r
+		keep = append(keep, r)
+	}
+	return keep, dups
+}
+
+// ########### WINDOWS: LOCKED FILES ##################
+// lockedInfo: when err is a sharing or lock violation, rebuilds the entry's
+// FileInfo from metadata that doesn't open the file, trying lockedSources
+// in order. Returns err unchanged when it is some other error or every
+// source fails.
+func lockedInfo(path string, err error) (fs.FileInfo, error) {
+	if !errors.Is(err, windows.ERROR_SHARING_VIOLATION) && !errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return nil, err
+	}
+	p, perr := windows.UTF16PtrFromString(path)
+	if perr != nil {
+		return nil, err
+	}
+	fi := &attrInfo{name: filepath.Base(path)}
+	for _, src := range lockedSources {
+		if src(p, &fi.d) == nil {
+			return fi, nil
+		}
+	}
+	return nil, err
+}
+
+// lockedSources: where lockedInfo looks, in order: GetFileAttributesEx,
+// then the directory's own FindFirstFile record. A variable for tests.
+var lockedSources = []func(p *uint16, d *syscall.Win32FileAttributeData) error{attributeData, findData}
+
+func attributeData(p *uint16, d *syscall.Win32FileAttributeData) error {
+	return windows.GetFileAttributesEx(p, windows.GetFileExInfoStandard, (*byte)(unsafe.Pointer(d)))
+}
+
+func findData(p *uint16, d *syscall.Win32FileAttributeData) error {
+	var fd windows.Win32finddata
+	h, err := windows.FindFirstFile(p, &fd)
+	if err != nil {
+		return err
+	}
+	windows.FindClose(h)
+	*d = syscall.Win32FileAttributeData{
+		FileAttributes: fd.FileAttributes,
+		CreationTime:   syscall.Filetime(fd.CreationTime),
+		LastAccessTime: syscall.Filetime(fd.LastAccessTime),
+		LastWriteTime:  syscall.Filetime(fd.LastWriteTime),
+		FileSizeHigh:   fd.FileSizeHigh,
+		FileSizeLow:    fd.FileSizeLow,
+	}
+	return nil
+}
+
+// attrInfo: fs.FileInfo over WIN32_FILE_ATTRIBUTE_DATA, for lockedInfo.
+type attrInfo struct {
+	name string
+	d    syscall.Win32FileAttributeData
+}
+
+func (a *attrInfo) Name() string { return a.name }
+func (a *attrInfo) Size() int64  { return int64(a.d.FileSizeHigh)<<32 | int64(a.d.FileSizeLow) }
+func (a *attrInfo) IsDir() bool  { return a.Mode().IsDir() }
+func (a *attrInfo) Sys() any     { return &a.d }
+
+func (a *attrInfo) Mode() fs.FileMode {
+	switch {
+	case a.d.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0:
+		return fs.ModeIrregular
+	case a.d.FileAttributes&windows.FILE_ATTRIBUTE_DIRECTORY != 0:
+		return fs.ModeDir | 0o777
+	}
+	return 0o666
+}
+
+func (a *attrInfo) ModTime() time.Time {
+	return time.Unix(0, a.d.LastWriteTime.Nanoseconds())
+}
+
+// ########### WINDOWS: CONSOLE COLOR ##################
+// colorEnabled: resolves -color against stdout. auto colors only a console,
+// and only once it accepts VT sequences; always also tries to switch the
+// console over but colors regardless, for pipes into less -R.
+func colorEnabled(mode string) bool {
+	if mode == "never" || mode == "auto" && os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	h := windows.Handle(os.Stdout.Fd())
+	var m uint32
+	vt := windows.GetConsoleMode(h, &m) == nil &&
+		windows.SetConsoleMode(h, m|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+	return vt || mode == "always"
+}
+
+// consoleWidth: the visible width of the console behind f, or 80 when f
+// isn't a console.
+func consoleWidth(f *os.File) int {
+	var info windows.ConsoleScreenBufferInfo
+	if windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info) != nil {
+		return 80
+	}
+	return int(info.Window.Right-info.Window.Left) + 1
+}
+
+// ########### WINDOWS: LINK REPORT ##################
+// linkLog: -report-links (-list-links). Every symlink, junction and mount
+// point the walk meets, whether or not it is followed, so the effect of
+// -followlinks can be judged before turning it on.
+type linkLog struct {
+	mu     sync.Mutex
+	links  []linkInfo
+	roots  []string // scanned roots, for linkInfo.Scope
+	rootVG []string // their volume names, for mount point targets
+}
+
+// linkInfo: one row of the link report.
+type linkInfo struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"` // symlink, junction, or mount
+	Target string `json:"target"`
+	Scope  string `json:"scope,omitempty"`      // target inside or outside the scanned roots; "" if unresolved
+	Tag    string `json:"reparseTag,omitempty"` // raw reparse tag when the target couldn't be read
+	Loop   bool   `json:"loop,omitempty"`       // target is the link itself or one of its parents
+}
+
+// setRoots tells the log which roots are scanned, so targets can be
+// placed inside or outside them.
+func (l *linkLog) setRoots(roots []string) {
+	l.roots = roots
+	for _, r := range roots {
+		l.rootVG = append(l.rootVG, volumeGUID(r))
+	}
+}
+
+// add records path if info describes a link; other entries are ignored.
+func (l *linkLog) add(path string, info fs.FileInfo) {
+	typ := ""
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		typ = "symlink"
+	case isMountPoint(path, info):
+		typ = "mount"
+	case info.Mode()&fs.ModeIrregular != 0 && fileAttributes(info)&windows.FILE_ATTRIBUTE_DIRECTORY != 0:
+		typ = "junction"
+	default:
+		return
+	}
+	li := linkInfo{Path: path, Type: typ}
+	target, err := os.Readlink(path)
+	switch {
+	case err != nil:
+		li.Target = "(unreadable: " + err.Error() + ")"
+		if tag, terr := reparseTag(path); terr == nil {
+			li.Tag = reparseTagName(tag)
+			li.Target = "(reparse tag " + li.Tag + ")"
+		}
+	case typ == "mount":
+		li.Target, li.Scope = target, "outside"
+		for _, vg := range l.rootVG {
+			if vg != "" && strings.EqualFold(vg, target) {
+				li.Scope = "inside"
+			}
+		}
+	default:
+		li.Target = target
+		t := strings.TrimPrefix(target, `\??\`)
+		if !filepath.IsAbs(t) {
+			t = filepath.Join(filepath.Dir(path), t)
+		}
+		li.Loop = isUnder(path, t)
+		li.Scope = "outside"
+		for _, r := range l.roots {
+			if isUnder(t, r) {
+				li.Scope = "inside"
+			}
+		}
+	}
+	l.mu.Lock()
+	l.links = append(l.links, li)
+	l.mu.Unlock()
+}
+
+// sorted: the recorded links by path.
+func (l *linkLog) sorted(less func(a, b string) bool) []linkInfo {
+	l.mu.Lock()
+	out := append([]linkInfo(nil), l.links...)
+	l.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return less(out[i].Path, out[j].Path) })
+	return out
+}
+
+// reparseTag: the raw reparse tag of path, read with FSCTL_GET_REPARSE_POINT.
+func reparseTag(path string) (uint32, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	h, err := windows.CreateFile(p, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_OPEN_REPARSE_POINT|windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(h)
+	buf := make([]byte, windows.MAXIMUM_REPARSE_DATA_BUFFER_SIZE)
+	var n uint32
+	if err := windows.DeviceIoControl(h, windows.FSCTL_GET_REPARSE_POINT, nil, 0, &buf[0], uint32(len(buf)), &n, nil); err != nil {
+		return 0, err
+	}
+	if n < 4 {
+		return 0, errors.New("short reparse data")
+	}
+	return *(*uint32)(unsafe.Pointer(&buf[0])), nil
+}
+
+// reparseTagNames: the tags worth a name in the link report.
+var reparseTagNames = map[uint32]string{
+	windows.IO_REPARSE_TAG_MOUNT_POINT: "MOUNT_POINT",
+	windows.IO_REPARSE_TAG_SYMLINK:     "SYMLINK",
+	0x80000013:                         "DEDUP",
+	0x80000014:                         "NFS",
+	0x80000017:                         "WOF",
+	0x80000018:                         "WCI",
+	0x8000001B:                         "APPEXECLINK",
+	0x80000023:                         "AF_UNIX",
+	0x9000001A:                         "CLOUD",
+}
+
+// reparseTagName: "SYMLINK", or the tag in hex when it has no name here.
+func reparseTagName(tag uint32) string {
+	if name, ok := reparseTagNames[tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08X", tag)
+}
+
+// printLinks: the "Links" table, titled with the count per type.
+func printLinks(links []linkInfo) {
+	counts := map[string]int{}
+	for _, li := range links {
+		counts[li.Type]++
+	}
+	var parts []string
+	for _, typ := range []string{"symlink", "junction", "mount"} {
+		if counts[typ] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", typ, counts[typ]))
+		}
+	}
+	w := newTable(os.Stdout)
+	fmt.Println()
+	if len(parts) > 0 {
+		fmt.Printf("Links (%s)\n", strings.Join(parts, ", "))
+	} else {
+		fmt.Println("Links (none)")
+	}
+	fmt.Fprintln(w, "TYPE\tPATH\tTARGET\tSCOPE")
+	for _, li := range links {
+		target := li.Target
+		if li.Loop {
+			target += " (loop)"
+		}
+		scope := li.Scope
+		if scope == "" {
+			scope = "?"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", li.Type, li.Path, target, scope)
+	}
+	w.Flush()
+}
+
+// ########### SPECIAL FILES ##################
+// Entries that are neither regular files nor directories: devices,
+// sockets (AF_UNIX), named pipes and reparse points Go can't classify.
+// They are counted from the directory listing's Lstat data alone and
+// never opened, so a FIFO can't block the walker.
+type specialKind int
+
+const (
+	specialDevice specialKind = iota
+	specialSocket
+	specialPipe
+	specialOther
+	numSpecialKinds
+)
+
+var specialKindNames = [numSpecialKinds]string{"device", "socket", "pipe", "other"}
+
+// specialOf: the kind of a non-regular, non-directory entry; false for
+// regular files, directories, and links (symlinks, junctions, mount
+// points), which the walk and -report-links handle.
+func specialOf(info fs.FileInfo) (specialKind, bool) {
+	m := info.Mode()
+	switch {
+	case m.IsRegular(), m.IsDir(), m&fs.ModeSymlink != 0:
+		return 0, false
+	case m&fs.ModeDevice != 0:
+		return specialDevice, true
+	case m&fs.ModeSocket != 0:
+		return specialSocket, true
+	case m&fs.ModeNamedPipe != 0:
+		return specialPipe, true
+	case fileAttributes(info)&windows.FILE_ATTRIBUTE_DIRECTORY != 0:
+		return 0, false // junction or mount point
+	}
+	return specialOther, true
+}
+
+// noteSpecial counts one special file, and lists it with -report-special.
+func (s *stats) noteSpecial(cfg walkCfg, path string, k specialKind) {
+	atomic.AddInt64(&s.special[k], 1)
+	if cfg.special != nil {
+		cfg.special.add(path, k)
+	}
+}
+
+// specialBreakdown: "device=2, pipe=1"; empty when none were met.
+func (s *stats) specialBreakdown() string {
+	var parts []string
+	for k := specialKind(0); k < numSpecialKinds; k++ {
+		if n := atomic.LoadInt64(&s.special[k]); n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", specialKindNames[k], n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// specialCounts: the special file counts by kind name, for JSON.
+func (s *stats) specialCounts() map[string]int64 {
+	var out map[string]int64
+	for k := specialKind(0); k < numSpecialKinds; k++ {
+		if n := atomic.LoadInt64(&s.special[k]); n > 0 {
+			if out == nil {
+				out = make(map[string]int64)
+			}
+			out[specialKindNames[k]] = n
+		}
+	}
+	return out
+}
+
+// specialLog: -report-special. Every special file the walk meets.
+type specialLog struct {
+	mu    sync.Mutex
+	files []specialFile
+}
+
+// specialFile: one row of the special file report.
+type specialFile struct {
+	Path string `json:"path"`
+	Type string `json:"type"` // device, socket, pipe, or other
+}
+
+func (l *specialLog) add(path string, k specialKind) {
+	l.mu.Lock()
+	l.files = append(l.files, specialFile{Path: path, Type: specialKindNames[k]})
+	l.mu.Unlock()
+}
+
+// sorted: the recorded special files by path.
+func (l *specialLog) sorted(less func(a, b string) bool) []specialFile {
+	l.mu.Lock()
+	out := append([]specialFile(nil), l.files...)
+	l.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return less(out[i].Path, out[j].Path) })
+	return out
+}
+
+// printSpecial: the "Special files" table.
+func printSpecial(files []specialFile) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Printf("Special files (%d, not sized)\n", len(files))
+	fmt.Fprintln(w, "TYPE\tPATH")
+	for _, f := range files {
+		fmt.Fprintf(w, "%s\t%s\n", f.Type, f.Path)
+	}
+	w.Flush()
+}
+
+// ########### WINDOWS: OWNER FILTER ##################
+// ownerFilter: the -owner accounts, resolved to SIDs once at startup.
+// Owners are read with GetNamedSecurityInfo, so this costs one extra
+// syscall per file (or per directory with -owner-dirs-only).
+type ownerFilter struct {
+	names    []string // as given on the command line, for the summary
+	sids     []*windows.SID
+	dirsOnly bool
+}
+
+func newOwnerFilter(names []string, dirsOnly bool) (*ownerFilter, error) {
+	of := &ownerFilter{names: names, dirsOnly: dirsOnly}
+	for _, n := range names {
+		sid, _, _, err := windows.LookupSID("", n)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve -owner %q: %w", n, err)
+		}
+		of.sids = append(of.sids, sid)
+	}
+	return of, nil
+}
+
+// owns: true when path's owner SID is one of the filter's accounts.
+// Unreadable owners count as a mismatch.
+func (of *ownerFilter) owns(path string) bool {
+	owner := fileOwner(path)
+	if owner == nil {
+		return false
+	}
+	for _, sid := range of.sids {
+		if owner.Equals(sid) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileOwner: the owner SID of path, or nil if it can't be read.
+func fileOwner(path string) *windows.SID {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return nil
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return nil
+	}
+	return owner
+}
+
+// mode: short description for the summary line.
+func (of *ownerFilter) mode() string {
+	if of.dirsOnly {
+		return "directory owners, inherited"
+	}
+	return "per-file owners"
+}
+
+// ########### WINDOWS: USAGE BY OWNER ##################
+// ownerTally: -group-by-owner. Bytes and file counts per owner SID, from
+// one owner lookup per counted file.
+type ownerTally struct {
+	mu    sync.Mutex
+	bySID map[string]*ownerUsage
+}
+
+// ownerUsage: one row of the owner report.
+type ownerUsage struct {
+	Owner string `json:"owner"` // DOMAIN\user, or the SID if it doesn't resolve
+	SID   string `json:"sid"`
+	Bytes int64  `json:"sizeBytes"`
+	Files int64  `json:"files"`
+}
+
+func newOwnerTally() *ownerTally {
+	return &ownerTally{bySID: make(map[string]*ownerUsage)}
+}
+
+// add charges size bytes to path's owner; unreadable owners share one row.
+func (t *ownerTally) add(path string, size int64) {
+	key := "(unknown)"
+	if sid := fileOwner(path); sid != nil {
+		key = sid.String()
+	}
+	t.mu.Lock()
+	u := t.bySID[key]
+	if u == nil {
+		u = &ownerUsage{SID: key}
+		t.bySID[key] = u
+	}
+	u.Bytes += size
+	u.Files++
+	t.mu.Unlock()
+}
+
+// ranked: owners by bytes, largest first, with account names resolved.
+func (t *ownerTally) ranked() []ownerUsage {
+	t.mu.Lock()
+	out := make([]ownerUsage, 0, len(t.bySID))
+	for _, u := range t.bySID {
+		out = append(out, *u)
+	}
+	t.mu.Unlock()
+	for i := range out {
+		out[i].Owner = out[i].SID
+		if sid, err := windows.StringToSid(out[i].SID); err == nil {
+			if acct, dom, _, err := sid.LookupAccount(""); err == nil {
+				out[i].Owner = dom + `\` + acct
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Bytes > out[j].Bytes })
+	return out
+}
+
+// printOwners: the "Usage by Owner" table.
+func printOwners(rows []ownerUsage) {
+	w := newTable(os.Stdout)
+	fmt.Println()
+	fmt.Println("Usage by Owner")
+	fmt.Fprintln(w, "RANK\tSIZE\tFILES\tOWNER")
+	for i, u := range rows {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", i+1, humanBytesFixed(u.Bytes), u.Files, u.Owner)
+	}
+	w.Flush()
+}
+
+// ########### SIZE METRICS ##################
+// metricFunc: the bytes a regular file counts for. Every total, heap,
+// percent and delta is built from it; skipped entries keep their logical
+// size, since that is all that is known without reading them.
+type metricFunc func(path string, info fs.FileInfo) int64
+
+// metrics: the -metric choices, each building the metricFunc for a run.
+// Add an entry here for other attributions (e.g. weighted by age).
+var metrics = map[string]func() metricFunc{
+	"logical":   func() metricFunc { return logicalSize },
+	"allocated": func() metricFunc { return newAllocSizer().metric },
+}
+
+// metricNames: the -metric choices, sorted, for messages.
+func metricNames() []string {
+	var names []string
+	for n := range metrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// logicalSize: the file's length, like du --apparent-size.
+func logicalSize(_ string, info fs.FileInfo) int64 { return info.Size() }
+
+// ########### WINDOWS: ALLOCATED SIZE ##################
+// allocSizer: size on disk for -apparent-size=false, the equivalent of du's
+// default block accounting. GetCompressedFileSize already reflects NTFS
+// compression and sparse ranges; the result is rounded up to whole clusters.
+type allocSizer struct {
+	mu        sync.Mutex
+	byRoot    map[string]int64 // cluster size per volume root
+	getSize   *windows.LazyProc
+	getFreeSp *windows.LazyProc
+}
+
+func newAllocSizer() *allocSizer {
+	k32 := windows.NewLazySystemDLL("kernel32.dll")
+	return &allocSizer{
+		byRoot:    make(map[string]int64),
+		getSize:   k32.NewProc("GetCompressedFileSizeW"),
+		getFreeSp: k32.NewProc("GetDiskFreeSpaceW"),
+	}
+}
+
+// size: allocated bytes for path; falls back to the logical size if the
+// API call fails so totals never silently drop a file.
+func (a *allocSizer) size(path string, logical int64) int64 {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return logical
+	}
+	var high uint32
+	low, _, callErr := a.getSize.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&high)))
+	if uint32(low) == 0xFFFFFFFF && callErr != windows.ERROR_SUCCESS {
+		return logical
+	}
+	n := int64(high)<<32 | int64(uint32(low))
+	if c := a.cluster(volumeRoot(path)); c > 0 && n%c != 0 {
+		n += c - n%c
+	}
+	return n
+}
+
+// metric: size as a metricFunc, for -metric=allocated.
+func (a *allocSizer) metric(path string, info fs.FileInfo) int64 {
+	return a.size(path, info.Size())
+}
+
+// cluster: bytes per cluster for a volume root, cached; 0 if unknown.
+func (a *allocSizer) cluster(root string) int64 {
+	if root == "" {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.byRoot[root]; ok {
+		return c
+	}
+	var c int64
+	var sectorsPerCluster, bytesPerSector, freeClusters, totalClusters uint32
+	if p, err := windows.UTF16PtrFromString(root); err == nil {
+		r, _, _ := a.getFreeSp.Call(uintptr(unsafe.Pointer(p)),
+			uintptr(unsafe.Pointer(&sectorsPerCluster)), uintptr(unsafe.Pointer(&bytesPerSector)),
+			uintptr(unsafe.Pointer(&freeClusters)), uintptr(unsafe.Pointer(&totalClusters)))
+		if r != 0 {
+			c = int64(sectorsPerCluster) * int64(bytesPerSector)
+		}
+	}
+	a.byRoot[root] = c
+	return c
+}
+
+// sparseFilter: -sparse-only. Keeps files whose allocated size is below
+// ratio × logical size, i.e. files that look far bigger than they are.
+type sparseFilter struct {
+	ratio float64
+	alloc *allocSizer
+}
+
+// check: the file's allocated size and whether it passes the filter.
+func (f *sparseFilter) check(path string, logical int64) (int64, bool) {
+	onDisk := f.alloc.size(path, logical)
+	return onDisk, logical > 0 && float64(onDisk) < f.ratio*float64(logical)
+}
+
+// ########### WINDOWS: SPARSE FILES ##################
+// -report-sparse: files with FILE_ATTRIBUTE_SPARSE_FILE set (VM images,
+// databases, download preallocations) can claim far more than they have
+// allocated. Each is sized both ways; the gap is "phantom" bytes, there
+// under -metric=logical and gone under -metric=allocated.
+
+func isSparse(info fs.FileInfo) bool {
+	return fileAttributes(info)&windows.FILE_ATTRIBUTE_SPARSE_FILE != 0
+}
+
+// sparseLog: the sparse files of one run. top keeps the k with the
+// largest gap; the totals cover them all.
+type sparseLog struct {
+	k       int
+	alloc   *allocSizer
+	mu      sync.Mutex
+	top     []sparseFile
+	files   int64
+	phantom int64
+}
+
+// sparseFile: one sparse file, sized both ways.
+type sparseFile struct {
+	Path           string `json:"path"`
+	LogicalBytes   int64  `json:"logicalBytes"`
+	AllocatedBytes int64  `json:"allocatedBytes"`
+	PhantomBytes   int64  `json:"phantomBytes"` // logical minus allocated, never below 0
+}
+
+// sparseReport: -report-sparse in JSON and for printSparse.
+type sparseReport struct {
+	Files        int64        `json:"files"`
+	PhantomBytes int64        `json:"phantomBytes"`
+	Top          []sparseFile `json:"top"` // largest gap first
+}
+
+// add sizes one sparse file on disk and records it.
+func (l *sparseLog) add(path string, logical int64) {
+	onDisk := l.alloc.size(path, logical)
+	f := sparseFile{Path: path, LogicalBytes: logical, AllocatedBytes: onDisk, PhantomBytes: max(logical-onDisk, 0)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files++
+	l.phantom += f.PhantomBytes
+	l.top = append(l.top, f)
+	if len(l.top) > 2*l.k {
+		l.trim()
+	}
+}
+
+// trim sorts top by gap and drops all but the first k.
+func (l *sparseLog) trim() {
+	sort.Slice(l.top, func(i, j int) bool {
+		if l.top[i].PhantomBytes != l.top[j].PhantomBytes {
+			return l.top[i].PhantomBytes > l.top[j].PhantomBytes
+		}
+		return l.top[i].Path < l.top[j].Path
+	})
+	l.top = l.top[:min(len(l.top), l.k)]
+}
+
+func (l *sparseLog) report() sparseReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trim()
+	return sparseReport{Files: l.files, PhantomBytes: l.phantom, Top: append([]sparseFile{}, l.top...)}
+}
+
+// printSparse: the -report-sparse table and its phantom total.
+func printSparse(r sparseReport) {
+	fmt.Println()
+	fmt.Printf("Sparse files (%d, %s phantom: counted under -metric=logical, not allocated)\n", r.Files, humanBytesFixed(r.PhantomBytes))
+	if len(r.Top) == 0 {
+		return
+	}
+	w := newTable(os.Stdout)
+	fmt.Fprintln(w, "PHANTOM\tLOGICAL\tALLOCATED\tPATH")
+	for _, f := range r.Top {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", humanBytesFixed(f.PhantomBytes), humanBytesFixed(f.LogicalBytes), humanBytesFixed(f.AllocatedBytes), f.Path)
+	}
+	w.Flush()
+}
+
+// ########### WINDOWS: DRIVE TOTAL BYTES ##################
+// driveSpaceCache: caches total/free bytes for each volume root (e.g., "C:\").
+// Used to compute the DRIVE% column without repeated API calls.
+type driveSpaceCache struct {
+	mu     sync.Mutex
+	byRoot map[string]driveSpace
+	nanos  atomic.Int64 // time spent in GetDiskFreeSpaceEx
+}
+
+// driveSpace: capacity figures for one volume; zero when unknown.
+type driveSpace struct {
+	total uint64
+	free  uint64
+}
+
+func newDriveSpaceCache() *driveSpaceCache {
+	return &driveSpaceCache{byRoot: make(map[string]driveSpace)}
+}
+
+// volumeRoot: returns a normalized Windows volume root for a path.
+// Examples: "C:\" or "\\server\share\", or with -mounts-as-roots the
+// mount point of a volume mounted into a folder, "C:\Data\".
+func volumeRoot(p string) string {
+	for _, m := range mountedRoots {
+		if isUnder(p, m) {
+			return m
+		}
+	}
+	vol := filepath.VolumeName(p)
+	if vol == "" {
+		return ""
+	}
+	// UNC share: \\server\share  ->  \\server\share\
+	if strings.HasPrefix(vol, `\\`) {
+		if strings.HasSuffix(vol, `\`) {
+			return vol
+		}
+		return vol + `\`
+	}
+	// Drive letter: C: -> C:\
+	if strings.HasSuffix(vol, `\`) {
+		return vol
+	}
+	return vol + `\`
+}
+
+// totalFor: total number of bytes on the volume that holds 'path'.
+// Returns 0 if not on Windows or if the total cannot be determined.
+func (c *driveSpaceCache) totalFor(path string) uint64 {
+	return c.spaceFor(path).total
+}
+
+// spaceFor: total and free bytes on the volume that holds 'path'.
+// Returns zeros if not on Windows or if the figures cannot be determined.
+func (c *driveSpaceCache) spaceFor(path string) driveSpace {
+	if runtime.GOOS != "windows" {
+		return driveSpace{}
+	}
+	root := volumeRoot(path)
+	if root == "" {
+		return driveSpace{}
+	}
+
+	c.mu.Lock()
+	if v, ok := c.byRoot[root]; ok {
+		c.mu.Unlock()
+		return v
+	}
+	c.mu.Unlock()
+
+	var freeAvailToCaller, totalBytes, totalFree uint64
+	t := time.Now()
+	// windows.GetDiskFreeSpaceEx(path, &freeAvailToCaller, &totalBytes, &totalFree)
+	err := windows.GetDiskFreeSpaceEx(windows.StringToUTF16Ptr(root), &freeAvailToCaller, &totalBytes, &totalFree)
+	c.nanos.Add(int64(time.Since(t)))
+	if err != nil {
+		return driveSpace{}
+	}
+
+	sp := driveSpace{total: totalBytes, free: totalFree}
+	c.mu.Lock()
+	c.byRoot[root] = sp
+	c.mu.Unlock()
+	return sp
+}
+
+// fileID: the identity NTFS gives path, as "volume serial:file index" in
+// hex. Hard links to one file share it; a junction and its target don't.
+func fileID(path string) (string, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	h, err := windows.CreateFile(p, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OPEN_REPARSE_POINT, 0)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(h)
+	var fi windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &fi); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08X:%08X%08X", fi.VolumeSerialNumber, fi.FileIndexHigh, fi.FileIndexLow), nil
+}
+
+// seekPenalty: whether the disk under a drive root reports a seek penalty
+// (IOCTL_STORAGE_QUERY_PROPERTY, StorageDeviceSeekPenaltyProperty), i.e.
+// is a spinning disk. False for SSDs, shares, and anything that can't say.
+func seekPenalty(root string) bool {
+	if runtime.GOOS != "windows" || strings.HasPrefix(root, `\\`) {
+		return false
+	}
+	dev, err := windows.UTF16PtrFromString(`\\.\` + strings.TrimRight(root, `\`))
+	if err != nil {
+		return false
+	}
+	h, err := windows.CreateFile(dev, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+
+	const (
+		ioctlStorageQueryProperty = 0x2D1400
+		storageDeviceSeekPenalty  = 7
+	)
+	query := struct {
+		PropertyID uint32
+		QueryType  uint32 // PropertyStandardQuery
+		Extra      [1]byte
+	}{PropertyID: storageDeviceSeekPenalty}
+	var desc struct {
+		Version, Size     uint32
+		IncursSeekPenalty byte
+	}
+	var n uint32
+	err = windows.DeviceIoControl(h, ioctlStorageQueryProperty,
+		(*byte)(unsafe.Pointer(&query)), uint32(unsafe.Sizeof(query)),
+		(*byte)(unsafe.Pointer(&desc)), uint32(unsafe.Sizeof(desc)), &n, nil)
+	return err == nil && n >= 9 && desc.IncursSeekPenalty != 0
+}
+
+// driveType: GetDriveType as a short word ("fixed", "network", ...).
+func driveType(path string) string {
+	root := volumeRoot(path)
+	if runtime.GOOS != "windows" || root == "" {
+		return "n/a"
+	}
+	switch windows.GetDriveType(windows.StringToUTF16Ptr(root)) {
+	case windows.DRIVE_REMOVABLE:
+		return "removable"
+	case windows.DRIVE_FIXED:
+		return "fixed"
+	case windows.DRIVE_REMOTE:
+		return "network"
+	case windows.DRIVE_CDROM:
+		return "cdrom"
+	case windows.DRIVE_RAMDISK:
+		return "ramdisk"
+	case windows.DRIVE_NO_ROOT_DIR:
+		return "no-root"
+	}
+	return "unknown"
+}
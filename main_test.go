@@ -0,0 +1,1999 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"disktop/benchtree"
+
+	"golang.org/x/sys/windows"
+)
+
+// testCfg: the walk settings main would pass for a plain scan.
+func testCfg() walkCfg {
+	return walkCfg{topK: 15, workers: 3, metricName: "logical", metric: logicalSize}
+}
+
+// ----- checkpoints -----
+
+// checkpointTree: a benchtree fixture with a hidden file in every
+// directory two levels down, so -skiphidden has something to count.
+func checkpointTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	if _, err := benchtree.Build(root, benchtree.Shape{Breadth: 4, Depth: 4, Files: 12}, 7); err != nil {
+		t.Fatal(err)
+	}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || strings.Count(strings.TrimPrefix(p, root), string(filepath.Separator)) != 2 {
+			return err
+		}
+		return os.WriteFile(filepath.Join(p, ".hidden"), make([]byte, 100), 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func checkpointCfg() walkCfg {
+	cfg := testCfg()
+	cfg.skipHidden = true
+	cfg.maxDepth = 3
+	return cfg
+}
+
+// scanSignature: what a resumed scan must reproduce. Of the entries tied
+// at the top-K cut, which are kept depends on timing, so their paths are
+// left out.
+func scanSignature(sc *scan) string {
+	s := &sc.stats
+	var b strings.Builder
+	fmt.Fprintln(&b, "files", s.filesSeen, "dirs", s.dirsSeen, "bytes", s.bytesSeen, "roots", sc.rootSizes)
+	fmt.Fprintln(&b, "errors", s.errors, "skipped", s.skipped, s.skippedBy, s.skippedBytes)
+	var lines []string
+	files := sc.fileTop.sortedDesc()
+	for _, it := range files {
+		if it.Size == files[len(files)-1].Size {
+			it.Path = "(tie)"
+		}
+		lines = append(lines, fmt.Sprintln("F", it.Size, it.Path))
+	}
+	for _, it := range sc.dirTop.sortedDesc() {
+		lines = append(lines, fmt.Sprintln("D", it.Size, it.Path, it.Partial))
+	}
+	slices.Sort(lines)
+	b.WriteString(strings.Join(lines, ""))
+	return b.String()
+}
+
+// interruptedScan: a -checkpoint scan of root, or of st resumed, cancelled
+// once it has listed after directories; its checkpoint as -resume reads it.
+func interruptedScan(t *testing.T, st *resumeState, root string, after int64) *resumeState {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := checkpointCfg()
+	var sc *scan
+	if st == nil {
+		cfg.subtrees = newSubtreeLog(nil)
+		sc = startScan(ctx, []string{root}, cfg)
+	} else {
+		sc = resumeCheckpoint(ctx, st, cfg)
+	}
+wait:
+	for atomic.LoadInt64(&sc.stats.dirsSeen) < after {
+		select {
+		case <-sc.done:
+			break wait
+		case <-time.After(50 * time.Microsecond):
+		}
+	}
+	cancel()
+	sc.wait()
+	data, err := json.Marshal(sc.checkpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next resumeState
+	if err := json.Unmarshal(data, &next); err != nil {
+		t.Fatal(err)
+	}
+	return &next
+}
+
+func TestCheckpointResumeMatchesUninterrupted(t *testing.T) {
+	root := checkpointTree(t)
+	ref := startScan(context.Background(), []string{root}, checkpointCfg())
+	ref.wait()
+	want := scanSignature(ref)
+	if ref.stats.skippedBy[skipHidden] == 0 || ref.stats.skippedBy[skipDepth] == 0 {
+		t.Fatalf("fixture skips nothing: %v", ref.stats.skippedBy)
+	}
+	total := ref.stats.dirsSeen
+	for _, after := range []int64{1, total / 8, total / 3, total / 2, total - 2} {
+		st := interruptedScan(t, nil, root, after)
+		st = interruptedScan(t, st, root, after/2+1) // killed again while resuming
+		sc := resumeCheckpoint(context.Background(), st, checkpointCfg())
+		sc.wait()
+		if got := scanSignature(sc); got != want {
+			t.Errorf("killed after %d directories (%d completed): resumed scan\n%s\nwant\n%s", after, len(st.Completed), got, want)
+		}
+	}
+}
+
+func TestSubtreeLogSnapshotAndResume(t *testing.T) {
+	a, ab, cd := filepath.Join("r", "a"), filepath.Join("r", "a", "b"), filepath.Join("r", "c", "d")
+	l := newSubtreeLog(nil)
+	tally := &subtreeTally{}
+	tally.failed()
+	tally.skip(skipGlob, 10)
+	l.finish(ab, 2, dirAgg{size: 5, files: 1, dirs: 1}, nil)
+	l.finish(a, 1, dirAgg{size: 7, files: 2, dirs: 2}, tally)
+	l.finish(cd, 2, dirAgg{size: 3, files: 1, dirs: 1}, nil)
+	l.finish(filepath.Join("r", "c", "d", "e"), 3, dirAgg{size: 1}, nil) // too deep to record
+
+	done, held := l.snapshot()
+	var paths []string
+	for _, d := range done {
+		paths = append(paths, d.Path)
+	}
+	if want := []string{a, cd}; !slices.Equal(paths, want) || len(held) != 0 {
+		t.Fatalf("snapshot = %v, %v; want %v and nothing held", paths, held, want)
+	}
+	if done[0].Errors != 1 || done[0].SkippedBy["glob"] != 1 || done[0].SkippedBytes["glob"] != 10 {
+		t.Errorf("snapshot lost %s's tally: %+v", a, done[0])
+	}
+
+	st := &resumeState{Completed: done, Files: []item{
+		{Path: filepath.Join(ab, "f"), Size: 4},
+		{Path: filepath.Join("r", "x"), Size: 9}, // outside every finished directory: walked again
+	}}
+	r := newSubtreeLog(st)
+	if !r.resuming() {
+		t.Fatal("resuming() = false with directories to hand out")
+	}
+	fileTop, dirTop := &minHeap{k: 5}, &minHeap{k: 5}
+	var s stats
+	up := &subtreeTally{}
+	if _, ok := r.resume(a, subtreeDepth+1, up, fileTop, dirTop, &s); ok {
+		t.Error("resume took a directory below subtreeDepth")
+	}
+	agg, ok := r.resume(a, 1, up, fileTop, dirTop, &s)
+	if !ok || agg.size != 7 || agg.dirs != 2 {
+		t.Fatalf("resume(%s) = %+v, %v", a, agg, ok)
+	}
+	if _, ok := r.resume(a, 1, up, fileTop, dirTop, &s); ok {
+		t.Error("a resumed directory was handed out twice")
+	}
+	if got := fileTop.sortedDesc(); len(got) != 1 || got[0].Size != 4 {
+		t.Errorf("resumed entries = %v, want the one under %s", got, a)
+	}
+	if s.filesSeen != 2 || s.dirsSeen != 2 || s.bytesSeen != 7 || s.errors != 1 || s.skippedBy[skipGlob] != 1 || up.errors != 1 {
+		t.Errorf("resumed counts: files %d dirs %d bytes %d errors %d skipped %v, tally above %+v",
+			s.filesSeen, s.dirsSeen, s.bytesSeen, s.errors, s.skippedBy, up)
+	}
+
+	done, held = r.snapshot()
+	if len(done) != 2 || len(held) != 0 {
+		t.Errorf("after resuming %s: snapshot = %v, held %v; want it and %s, nothing held", a, done, held, cd)
+	}
+}
+
+// ----- error handling -----
+
+// brokenEntry: a listed entry that can't be read.
+type brokenEntry struct {
+	fs.DirEntry
+	err error
+}
+
+func (e brokenEntry) Info() (fs.FileInfo, error) { return nil, e.err }
+
+// failListing makes dir's listing fail with err after its first entry,
+// until the test ends.
+func failListing(t *testing.T, dir string, err error) {
+	t.Helper()
+	listDir = func(p string) ([]os.DirEntry, error) {
+		entries, lerr := os.ReadDir(p)
+		if lerr == nil && pathKey(p) == pathKey(dir) {
+			return entries[:1], err
+		}
+		return entries, lerr
+	}
+	t.Cleanup(func() { listDir = os.ReadDir })
+}
+
+// failStat makes reading the listed file fail with err, until the test
+// ends.
+func failStat(t *testing.T, file string, err error) {
+	t.Helper()
+	listDir = func(p string) ([]os.DirEntry, error) {
+		entries, lerr := os.ReadDir(p)
+		for i, de := range entries {
+			if pathKey(filepath.Join(p, de.Name())) == pathKey(file) {
+				entries[i] = brokenEntry{de, err}
+			}
+		}
+		return entries, lerr
+	}
+	t.Cleanup(func() { listDir = os.ReadDir })
+}
+
+// writeTree creates files (relative path -> size) under a new temporary
+// directory, with their parent directories, and returns it.
+func writeTree(t *testing.T, files map[string]int) string {
+	t.Helper()
+	root := t.TempDir()
+	for rel, size := range files {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// rankedDirs: the sizes of the ranked directories by path, "≥" marking
+// lower bounds.
+func rankedDirs(sc *scan) map[string]string {
+	out := make(map[string]string)
+	for _, it := range sc.dirTop.sortedDesc() {
+		v := fmt.Sprint(it.Size)
+		if it.Partial {
+			v = "≥" + v
+		}
+		out[it.Path] = v
+	}
+	return out
+}
+
+func TestErrorActions(t *testing.T) {
+	root := writeTree(t, map[string]int{"a/f1": 100, "a/f2": 200, "a/sub/g": 50, "b/h": 300})
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	denied := &fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}
+	tests := []struct {
+		name    string
+		handler errorHandler
+		fail    func(t *testing.T)
+		dirs    map[string]string
+		root    int64
+		aborted string // op of the scanAbort, "" for none
+	}{
+		{"list skip", defaultOnError, func(t *testing.T) { failListing(t, a, denied) },
+			map[string]string{b: "300"}, 300, ""},
+		{"list continue", continueOnError, func(t *testing.T) { failListing(t, a, denied) },
+			map[string]string{a: "≥100", b: "300"}, 400, ""},
+		{"stat skip", defaultOnError, func(t *testing.T) { failStat(t, filepath.Join(a, "f2"), denied) },
+			map[string]string{a: "150", filepath.Join(a, "sub"): "50", b: "300"}, 450, ""},
+		{"stat continue", continueOnError, func(t *testing.T) { failStat(t, filepath.Join(a, "f2"), denied) },
+			map[string]string{a: "150", filepath.Join(a, "sub"): "50", b: "300"}, 450, ""},
+		{"list abort", abortOnError, func(t *testing.T) { failListing(t, a, denied) }, nil, 0, "list"},
+		{"stat abort", abortOnError, func(t *testing.T) { failStat(t, filepath.Join(a, "f2"), denied) }, nil, 0, "stat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fail(t)
+			cfg := testCfg()
+			cfg.workers = 1 // one order, so an abort leaves the same behind every time
+			cfg.onError = tt.handler
+			sc := startScan(context.Background(), []string{root}, cfg)
+			sc.wait()
+			if got := atomic.LoadInt64(&sc.stats.errors); got != 1 {
+				t.Errorf("errors = %d, want 1", got)
+			}
+			a := sc.abortErr.Load()
+			switch {
+			case tt.aborted == "" && a != nil:
+				t.Fatalf("aborted: %v", a)
+			case tt.aborted != "" && (a == nil || a.op != tt.aborted || !errors.Is(a, fs.ErrPermission)):
+				t.Fatalf("abortErr = %v, want a %s error", a, tt.aborted)
+			case tt.aborted != "":
+				return // TestAbortLeavesConsistentRankings checks what is left
+			}
+			if got := rankedDirs(sc); !maps.Equal(got, tt.dirs) {
+				t.Errorf("ranked dirs = %v, want %v", got, tt.dirs)
+			}
+			if sc.rootSizes[0] != tt.root {
+				t.Errorf("root = %d, want %d", sc.rootSizes[0], tt.root)
+			}
+		})
+	}
+}
+
+// An entry deleted or replaced between its parent's listing and its own
+// read is counted apart, not as an error, and the totals are as the tree
+// is now.
+func TestChangedMidScan(t *testing.T) {
+	gone := &fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}
+	// changeDuring fails dir's listing as failListing does, after running
+	// change on the tree, so the scan finds dir changed when it checks.
+	changeDuring := func(t *testing.T, dir string, change func() error) {
+		failListing(t, dir, gone)
+		list := listDir
+		listDir = func(p string) ([]os.DirEntry, error) {
+			entries, err := list(p)
+			if pathKey(p) == pathKey(dir) {
+				if cerr := change(); cerr != nil {
+					t.Error(cerr)
+				}
+			}
+			return entries, err
+		}
+	}
+	tests := []struct {
+		name              string
+		change            func(t *testing.T, root string)
+		root              int64
+		vanished, retyped int64
+	}{
+		{"file deleted", func(t *testing.T, root string) {
+			failStat(t, filepath.Join(root, "a", "f2"), gone)
+		}, 450, 1, 0},
+		{"dir deleted", func(t *testing.T, root string) {
+			sub := filepath.Join(root, "a", "sub")
+			changeDuring(t, sub, func() error { return os.RemoveAll(sub) })
+		}, 600, 1, 0},
+		{"dir replaced by a file", func(t *testing.T, root string) {
+			sub := filepath.Join(root, "a", "sub")
+			changeDuring(t, sub, func() error {
+				if err := os.RemoveAll(sub); err != nil {
+					return err
+				}
+				return os.WriteFile(sub, make([]byte, 70), 0o644)
+			})
+		}, 670, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeTree(t, map[string]int{"a/f1": 100, "a/f2": 200, "a/sub/g": 50, "b/h": 300})
+			tt.change(t, root)
+			sc := startScan(context.Background(), []string{root}, testCfg())
+			sc.wait()
+			if got := atomic.LoadInt64(&sc.stats.errors); got != 0 {
+				t.Errorf("errors = %d, want 0", got)
+			}
+			if v, r := atomic.LoadInt64(&sc.stats.vanished), atomic.LoadInt64(&sc.stats.retyped); v != tt.vanished || r != tt.retyped {
+				t.Errorf("%d deleted, %d changed type, want %d and %d", v, r, tt.vanished, tt.retyped)
+			}
+			want := fmt.Sprintf("Changed during the scan: %d deleted, %d changed type (not counted as errors)", tt.vanished, tt.retyped)
+			if got := sc.stats.changedLine(); got != want {
+				t.Errorf("changedLine = %q, want %q", got, want)
+			}
+			if sc.rootSizes[0] != tt.root {
+				t.Errorf("root = %d, want %d", sc.rootSizes[0], tt.root)
+			}
+		})
+	}
+}
+
+// An abort part way through leaves rankings that are right as far as
+// they go: every file at its size, and every directory at its total or,
+// marked partial, below it.
+func TestAbortLeavesConsistentRankings(t *testing.T) {
+	root := t.TempDir()
+	if _, err := benchtree.Build(root, benchtree.Shape{Breadth: 3, Depth: 4, Files: 6}, 3); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testCfg()
+	cfg.topK = 10000 // every entry
+	ref := startScan(context.Background(), []string{root}, cfg)
+	ref.wait()
+	want := make(map[string]int64)
+	for _, it := range slices.Concat(ref.fileTop.sortedDesc(), ref.dirTop.sortedDesc()) {
+		want[it.Path] = it.Size
+	}
+
+	var broken []string
+	for _, it := range ref.dirTop.sortedDesc() {
+		if strings.Count(strings.TrimPrefix(it.Path, root), string(filepath.Separator)) == 3 {
+			broken = append(broken, it.Path)
+		}
+	}
+	slices.Sort(broken)
+	for _, dir := range []string{broken[0], broken[len(broken)/2], broken[len(broken)-1]} {
+		failListing(t, dir, &fs.PathError{Op: "open", Path: dir, Err: fs.ErrPermission})
+		cfg.onError = abortOnError
+		sc := startScan(context.Background(), []string{root}, cfg)
+		sc.wait()
+		if sc.abortErr.Load() == nil {
+			t.Fatalf("%s: not aborted", dir)
+		}
+		for _, it := range sc.fileTop.sortedDesc() {
+			if it.Size != want[it.Path] {
+				t.Errorf("%s: file %s = %d, want %d", dir, it.Path, it.Size, want[it.Path])
+			}
+		}
+		var sawPartial bool
+		for _, it := range sc.dirTop.sortedDesc() {
+			switch {
+			case it.Partial && it.Size > want[it.Path], !it.Partial && it.Size != want[it.Path]:
+				t.Errorf("%s: dir %s = %d (partial %v), want %d", dir, it.Path, it.Size, it.Partial, want[it.Path])
+			}
+			sawPartial = sawPartial || it.Partial
+		}
+		if !sawPartial {
+			t.Errorf("%s: nothing ranked is marked partial after the abort", dir)
+		}
+	}
+}
+
+// ----- depth limits -----
+
+// depthTree: three levels below the root, a file at each.
+func depthTree(t *testing.T) string {
+	return writeTree(t, map[string]int{"l1/f": 1, "l1/l2/f": 10, "l1/l2/l3/f": 100, "other/f": 1000})
+}
+
+// -depth-scan stops reading and marks what it cut short; -depth-report
+// reads everything and only ranks less. Both rank the same directories.
+func TestDepthScanVersusReport(t *testing.T) {
+	root := depthTree(t)
+	l1, l2 := filepath.Join(root, "l1"), filepath.Join(root, "l1", "l2")
+	tests := []struct {
+		name  string
+		scan  int // -depth-scan
+		rep   int // -depth-report
+		want  map[string]string
+		total int64
+	}{
+		{"no limit", 0, 0, map[string]string{l1: "111", l2: "110", filepath.Join(l2, "l3"): "100", filepath.Join(root, "other"): "1000"}, 1111},
+		{"-depth-scan=2", 2, 0, map[string]string{l1: "≥11", l2: "≥10", filepath.Join(root, "other"): "1000"}, 1011},
+		{"-depth-report=2", 0, 2, map[string]string{l1: "111", l2: "110", filepath.Join(root, "other"): "1000"}, 1111},
+		{"-depth-scan=1", 1, 0, map[string]string{l1: "≥1", filepath.Join(root, "other"): "1000"}, 1001},
+		{"-depth-report=1", 0, 1, map[string]string{l1: "111", filepath.Join(root, "other"): "1000"}, 1111},
+	}
+	for _, tt := range tests {
+		cfg := testCfg()
+		cfg.maxDepth, cfg.reportDepth = tt.scan, tt.rep
+		sc := startScan(context.Background(), []string{root}, cfg)
+		sc.wait()
+		if got := rankedDirs(sc); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: ranked %v, want %v", tt.name, got, tt.want)
+		}
+		if sc.rootSizes[0] != tt.total {
+			t.Errorf("%s: root total %d, want %d", tt.name, sc.rootSizes[0], tt.total)
+		}
+	}
+}
+
+// TSV and -print0 carry the ≥ of the table as a lower_bound column.
+func TestLowerBoundColumn(t *testing.T) {
+	items := []item{
+		{Path: `dir\cut`, Size: 2048, IsDir: true, Partial: true},
+		{Path: "dir\\exact\tname", Size: 10, IsDir: true},
+	}
+	var b strings.Builder
+	writeTSV(&b, "directories", items, newDriveSpaceCache(), baseCapacity)
+	want := "# directories\n" +
+		"1\t2048\t" + humanBytesFixed(2048) + "\t\t1\tdir\\\\cut\n" +
+		"2\t10\t" + humanBytesFixed(10) + "\t\t0\tdir\\\\exact\\tname\n"
+	if b.String() != want {
+		t.Errorf("TSV:\n%q\nwant\n%q", b.String(), want)
+	}
+
+	b.Reset()
+	writeNUL(&b, items)
+	if want := "2048\t1\tdir\\cut\x0010\t0\tdir\\exact\tname\x00"; b.String() != want {
+		t.Errorf("-print0: %q, want %q", b.String(), want)
+	}
+}
+
+// ----- plain output -----
+
+// plainRows: directory and file rows with fixed sizes. The paths have no
+// drive, so DRIVE% reads n/a wherever the test runs.
+func plainRows() (dirs, files []item) {
+	dirs = []item{
+		{Path: `Data\Videos`, Size: 5 << 30, IsDir: true},
+		{Path: `Data\Projects\gosize`, Size: 734003200, IsDir: true, Partial: true},
+		{Path: `Data\Mount`, Size: 1 << 20, IsDir: true, Tag: "mount"},
+	}
+	files = []item{
+		{Path: `Data\Videos\holiday.mkv`, Size: 3 << 30},
+		{Path: `Data\Projects\gosize\build.log`, Size: 1536},
+		{Path: `Data\empty.txt`},
+	}
+	return dirs, files
+}
+
+// TestPlainGolden: the default tables as -plain prints them. Scripts parse
+// this format; a change to testdata/plain.golden breaks them.
+func TestPlainGolden(t *testing.T) {
+	cols, err := parseColumns("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testCfg()
+	cfg.pctBase = "capacity"
+	dirs, files := plainRows()
+	tc := tableCtx{cfg: cfg, dsc: newDriveSpaceCache(), start: time.Now()}
+	var b strings.Builder
+	printTables(&b, tc, cols, "Largest Directories", dirs, files, true)
+	golden(t, "plain.golden", []byte(b.String()))
+	if strings.Contains(b.String(), "\x1b[") {
+		t.Error("escape sequence in uncolored output")
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	for _, mode := range []string{"auto", "never"} {
+		if colorEnabled(mode) {
+			t.Errorf("-color=%s colors with NO_COLOR set", mode)
+		}
+	}
+	if !colorEnabled("always") {
+		t.Error("-color=always doesn't override NO_COLOR")
+	}
+}
+
+// ----- lean file path -----
+
+// leanOff: cfg with a file option that needs every path but changes no
+// ranking (a name check nothing fails), so walkDir takes the full path.
+func leanOff(cfg walkCfg) walkCfg {
+	cfg.names = &nameCheck{maxLen: 1 << 20}
+	return cfg
+}
+
+// The lean path builds a file's path only for sizes the heap would keep;
+// the rankings and counts must be what the full path gives.
+func TestLeanPathUnchanged(t *testing.T) {
+	root := t.TempDir()
+	if _, err := benchtree.Build(root, benchtree.Shape{Breadth: 3, Depth: 3, Files: 40}, 11); err != nil {
+		t.Fatal(err)
+	}
+	lean, full := testCfg(), leanOff(testCfg())
+	if !leanFiles(lean) || leanFiles(full) {
+		t.Fatalf("leanFiles: %v for the plain config, %v with a name check", leanFiles(lean), leanFiles(full))
+	}
+	a := startScan(context.Background(), []string{root}, lean)
+	a.wait()
+	b := startScan(context.Background(), []string{root}, full)
+	b.wait()
+	if sa, sb := scanSignature(a), scanSignature(b); sa != sb {
+		t.Errorf("lean path:\n%s\nfull path:\n%s", sa, sb)
+	}
+}
+
+// BenchmarkFileBranch: allocations per scan with and without the lean
+// path; most files never make the top K, so lean should allocate far less.
+func BenchmarkFileBranch(b *testing.B) {
+	root := b.TempDir()
+	if _, err := benchtree.Build(root, benchtree.Shape{Breadth: 4, Depth: 3, Files: 200}, 1); err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name string
+		cfg  walkCfg
+	}{{"lean", testCfg()}, {"full", leanOff(testCfg())}} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				startScan(context.Background(), []string{root}, bc.cfg).wait()
+			}
+		})
+	}
+}
+
+// ----- locked files -----
+
+// lockedSource: a lockedSources entry that fails with err, or reports a
+// file of size bytes when err is nil, and counts its calls.
+func lockedSource(size uint32, err error, calls *int) func(*uint16, *syscall.Win32FileAttributeData) error {
+	return func(_ *uint16, d *syscall.Win32FileAttributeData) error {
+		*calls++
+		if err != nil {
+			return err
+		}
+		d.FileSizeLow = size
+		return nil
+	}
+}
+
+// A file whose stat fails with a sharing or lock violation is sized from
+// the first metadata source that answers; only when none does, or for any
+// other error, is it counted as an error.
+func TestLockedFileFallbacks(t *testing.T) {
+	fail := errors.New("no metadata")
+	for _, tc := range []struct {
+		name       string
+		statErr    error
+		attr, find error
+		size       int64 // the locked file's counted size; -1 = an error
+		attrCalls  int
+		findCalls  int
+	}{
+		{"attributes", windows.ERROR_SHARING_VIOLATION, nil, nil, 4000, 1, 0},
+		{"find data", windows.ERROR_SHARING_VIOLATION, fail, nil, 5000, 1, 1},
+		{"lock violation", windows.ERROR_LOCK_VIOLATION, fail, nil, 5000, 1, 1},
+		{"every source fails", windows.ERROR_SHARING_VIOLATION, fail, fail, -1, 1, 1},
+		{"not a lock", windows.ERROR_ACCESS_DENIED, nil, nil, -1, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := writeTree(t, map[string]int{"db/data.mdf": 10, "db/other": 100})
+			failStat(t, filepath.Join(root, "db", "data.mdf"), &os.PathError{Op: "stat", Path: "data.mdf", Err: tc.statErr})
+			var attrCalls, findCalls int
+			defer func(s []func(*uint16, *syscall.Win32FileAttributeData) error) { lockedSources = s }(lockedSources)
+			lockedSources = []func(*uint16, *syscall.Win32FileAttributeData) error{
+				lockedSource(4000, tc.attr, &attrCalls), lockedSource(5000, tc.find, &findCalls),
+			}
+			cfg := testCfg()
+			cfg.workers = 1 // the counters above aren't shared safely
+			sc := startScan(context.Background(), []string{root}, cfg)
+			sc.wait()
+
+			wantErrs, wantBytes := int64(0), 100+tc.size
+			if tc.size < 0 {
+				wantErrs, wantBytes = 1, 100
+			}
+			if sc.stats.errors != wantErrs || sc.stats.bytesSeen != wantBytes {
+				t.Errorf("%d errors, %d bytes; want %d, %d", sc.stats.errors, sc.stats.bytesSeen, wantErrs, wantBytes)
+			}
+			if attrCalls != tc.attrCalls || findCalls != tc.findCalls {
+				t.Errorf("sources called %d, %d times; want %d, %d", attrCalls, findCalls, tc.attrCalls, tc.findCalls)
+			}
+		})
+	}
+}
+
+// ----- table alignment -----
+
+// stripSGR: s without ANSI SGR sequences.
+func stripSGR(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			for i += 2; i < len(s) && s[i] != 'm'; i++ {
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// A colored table must line up exactly as the same table uncolored.
+func TestTableIgnoresEscapes(t *testing.T) {
+	rows := [][2]string{{"SIZE", "PATH"}, {"5.00 GB", `C:\Videos`}, {"≥700.00 MB", `C:\Projects`}, {"12 B", `C:\x`}}
+	render := func(color bool) string {
+		var b strings.Builder
+		w := newTable(&b)
+		for i, r := range rows {
+			code := ansiRed
+			if i == 0 {
+				code = ansiBold
+			}
+			fmt.Fprintf(w, "%s\t%s\n", paint(color, code, r[0]), r[1])
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	plain, colored := render(false), render(true)
+	if colored == plain {
+		t.Fatal("no color written")
+	}
+	if got := stripSGR(colored); got != plain {
+		t.Errorf("colored table, escapes removed:\n%s\nuncolored:\n%s", got, plain)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(plain), "\n") {
+		if i := strings.Index(line, `C:\`); i >= 0 && visibleWidth(line[:i]) != visibleWidth("≥700.00 MB")+2 {
+			t.Errorf("path column misaligned: %q", line)
+		}
+	}
+}
+
+type failWriter struct{ err error }
+
+func (w failWriter) Write([]byte) (int, error) { return 0, w.err }
+
+// Flush reports the underlying writer's error, e.g. a closed pipe.
+func TestTableWriteError(t *testing.T) {
+	w := newTable(failWriter{fs.ErrClosed})
+	fmt.Fprintln(w, "a\tb")
+	if err := w.Flush(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Flush = %v, want %v", err, fs.ErrClosed)
+	}
+}
+
+// ----- files under -----
+
+func TestIsUnder(t *testing.T) {
+	for _, c := range []struct {
+		path, dir string
+		want      bool
+	}{
+		{`C:\Users\bob\a.txt`, `C:\Users`, true},
+		{`C:\Users`, `C:\Users`, true},
+		{`c:\users\BOB`, `C:\Users\`, true},
+		{`C:\Users2\a.txt`, `C:\Users`, false},
+		{`C:\Users2\a.txt`, `C:\Users\`, false},
+		{`C:\Use`, `C:\Users`, false},
+		{`C:\x\y`, `C:\`, true},
+		{`D:\Users\a`, `C:\Users`, false},
+		{`\\srv\share\dir\f`, `\\srv\share`, true},
+		{`\\srv\share2\f`, `\\srv\share`, false},
+	} {
+		if got := isUnder(c.path, c.dir); got != c.want {
+			t.Errorf("isUnder(%q, %q) = %v, want %v", c.path, c.dir, got, c.want)
+		}
+	}
+}
+
+// -files-under limits Largest Files and nothing else.
+func TestFilesUnder(t *testing.T) {
+	root := writeTree(t, map[string]int{"Users/bob/a": 10, "Users2/b": 500, "other/c": 300, "d": 50})
+	cfg := testCfg()
+	cfg.filesUnder = []string{filepath.Join(root, "USERS")}
+	sc := startScan(context.Background(), []string{root}, cfg)
+	sc.wait()
+
+	var files []string
+	for _, it := range sc.fileTop.sortedDesc() {
+		files = append(files, it.Path)
+	}
+	if want := []string{filepath.Join(root, "Users", "bob", "a")}; !slices.Equal(files, want) {
+		t.Errorf("Largest Files %q, want %q", files, want)
+	}
+	if sc.stats.filesSeen != 4 || sc.stats.bytesSeen != 860 {
+		t.Errorf("%d files, %d bytes counted; want 4, 860", sc.stats.filesSeen, sc.stats.bytesSeen)
+	}
+	dirs := rankedDirs(sc)
+	if dirs[filepath.Join(root, "Users2")] != "500" || dirs[filepath.Join(root, "other")] != "300" {
+		t.Errorf("directory totals changed: %v", dirs)
+	}
+}
+
+// ----- root scheduling -----
+
+// With -max-roots, roots start in the order given and no more than the cap
+// run at once, however they finish.
+func TestLaunchCap(t *testing.T) {
+	roots := []string{`A:\`, `B:\`, `C:\`, `D:\`, `E:\`}
+	cfg := testCfg()
+	cfg.maxRoots = 2
+	sc := newScan(roots, cfg)
+	var running, peak int32
+	sc.launch(func(i int, root string) error {
+		for j := range i {
+			if atomic.LoadInt32(&sc.rootState[j]) == rootQueued {
+				t.Errorf("%s started before %s", root, roots[j])
+			}
+		}
+		n := atomic.AddInt32(&running, 1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		time.Sleep(time.Duration(len(roots)-i) * 5 * time.Millisecond) // later roots finish sooner
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	sc.wait()
+	if peak != 2 {
+		t.Errorf("%d roots ran at once, want 2", peak)
+	}
+	if got := sc.rootProgress(); got != " roots: active= queued=0 done=5" {
+		t.Errorf("progress at the end: %q", got)
+	}
+}
+
+// Results list roots in the order given, whichever finished first.
+func TestRootOrder(t *testing.T) {
+	big := writeTree(t, map[string]int{"a/b/c/d": 5000, "e": 10})
+	small := writeTree(t, map[string]int{"f": 1})
+	for _, maxRoots := range []int{0, 1} {
+		cfg := testCfg()
+		cfg.maxRoots = maxRoots
+		sc := startScan(context.Background(), []string{big, small}, cfg)
+		sc.wait()
+		res := sc.jsonResult(newDriveSpaceCache(), false)
+		if len(res.RootStatus) != 2 || res.RootStatus[0].Root != big || res.RootStatus[0].Size != 5010 ||
+			res.RootStatus[1].Root != small || res.RootStatus[1].Size != 1 {
+			t.Errorf("-max-roots=%d: root status %+v", maxRoots, res.RootStatus)
+		}
+	}
+}
+
+// ----- removed devices -----
+
+// removeDevice makes every listing under root but root's own fail as a
+// pulled USB drive's would, until the test ends.
+func removeDevice(t *testing.T, root string, err error) {
+	t.Helper()
+	listDir = func(p string) ([]os.DirEntry, error) {
+		if isUnder(p, root) && pathKey(p) != pathKey(root) {
+			return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+		}
+		return os.ReadDir(p)
+	}
+	t.Cleanup(func() { listDir = os.ReadDir })
+}
+
+// A burst of device-gone errors aborts that root only, without an error
+// per remaining directory; one such error is a fluke and aborts nothing.
+func TestDeviceRemoved(t *testing.T) {
+	files := map[string]int{}
+	for i := range 40 {
+		files[fmt.Sprintf("d%02d/f", i)] = 10
+	}
+	for _, c := range []struct {
+		name    string
+		err     error
+		dirs    int
+		aborted bool
+	}{
+		{"not connected", windows.ERROR_DEVICE_NOT_CONNECTED, 40, true},
+		{"not ready", windows.ERROR_NOT_READY, 40, true},
+		{"no longer exists", windows.ERROR_DEV_NOT_EXIST, 40, true},
+		{"one fluke", windows.ERROR_NOT_READY, 1, false},
+		{"not a device error", windows.ERROR_ACCESS_DENIED, 40, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			fixture := map[string]int{}
+			for i := range c.dirs {
+				fixture[fmt.Sprintf("d%02d/f", i)] = 10
+			}
+			usb, disk := writeTree(t, fixture), writeTree(t, files)
+			removeDevice(t, usb, c.err)
+			sc := startScan(context.Background(), []string{usb, disk}, testCfg())
+			sc.wait()
+			res := sc.jsonResult(newDriveSpaceCache(), false)
+
+			st := res.RootStatus[0]
+			if st.Aborted != c.aborted || c.aborted && st.Error != errDeviceRemoved.Error() {
+				t.Errorf("removed root: %+v, aborted should be %v", st, c.aborted)
+			}
+			if c.aborted && sc.stats.errors >= int64(c.dirs) {
+				t.Errorf("%d errors counted for %d directories; the abort should cut them short", sc.stats.errors, c.dirs)
+			}
+			if !c.aborted && sc.stats.errors < int64(c.dirs) {
+				t.Errorf("%d errors, want one or more for each of %d directories", sc.stats.errors, c.dirs)
+			}
+			if other := res.RootStatus[1]; !other.OK || other.Size != 400 {
+				t.Errorf("the other root: %+v, want OK with 400 bytes", other)
+			}
+		})
+	}
+}
+
+// ----- same-volume roots -----
+
+func TestSameVolumeRoots(t *testing.T) {
+	const v1, v2 = `\\?\Volume{11111111-0000-0000-0000-000000000001}\`, `\\?\Volume{22222222-0000-0000-0000-000000000002}\`
+	guids := map[string]string{
+		`D:\`:       v1,
+		`C:\Data\`:  strings.ToUpper(v1), // the same volume, its name in another case
+		`E:\`:       v2,
+		`C:\Mnt\D\`: v1,
+		`C:\`:       v2[:len(v2)-2] + `3}\`,
+	}
+	guid := func(r string) string { return guids[r] } // "" for plain folders
+	for _, c := range []struct {
+		roots []string
+		keep  []string
+		dups  []volumeAlias
+	}{
+		{[]string{`C:\`, `D:\`, `E:\`}, []string{`C:\`, `D:\`, `E:\`}, nil},
+		{
+			[]string{`D:\`, `C:\Data\`, `E:\`, `C:\Mnt\D\`},
+			[]string{`D:\`, `E:\`},
+			[]volumeAlias{{Root: `C:\Data\`, Same: `D:\`, GUID: strings.ToUpper(v1)}, {Root: `C:\Mnt\D\`, Same: `D:\`, GUID: v1}},
+		},
+		{ // the first root given wins, whichever it is
+			[]string{`C:\Data\`, `D:\`},
+			[]string{`C:\Data\`},
+			[]volumeAlias{{Root: `D:\`, Same: `C:\Data\`, GUID: v1}},
+		},
+		{ // plain folders are never aliases, even of each other
+			[]string{`C:\Users\`, `C:\Users\`, `D:\`},
+			[]string{`C:\Users\`, `C:\Users\`, `D:\`},
+			nil,
+		},
+	} {
+		keep, dups := sameVolumeRoots(c.roots, guid)
+		if !slices.Equal(keep, c.keep) || !slices.Equal(dups, c.dups) {
+			t.Errorf("sameVolumeRoots(%q):\n got %q %+v\nwant %q %+v", c.roots, keep, dups, c.keep, c.dups)
+		}
+	}
+}
+
+// ----- size metrics -----
+
+// A tree whose rankings flip with the metric: many tiny files against one
+// larger one. Every total must follow the metric in use.
+func TestMetricDivergence(t *testing.T) {
+	files := map[string]int{"one/big": 5000}
+	for i := range 100 {
+		files[fmt.Sprintf("many/f%03d", i)] = 10
+	}
+	root := writeTree(t, files)
+	// An embedder's metric: whole 4 KiB blocks, like a simple allocation count.
+	blocks := func(_ string, info fs.FileInfo) int64 { return roundUp(info.Size(), 4096) }
+
+	for _, c := range []struct {
+		name      string
+		metric    metricFunc
+		one, many int64
+		first     string // the top directory
+	}{
+		{"logical", logicalSize, 5000, 1000, "one"},
+		{"blocks", blocks, 8192, 409600, "many"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := testCfg()
+			cfg.metricName, cfg.metric = c.name, c.metric
+			sc := startScan(context.Background(), []string{root}, cfg)
+			sc.wait()
+			dirs := sc.dirTop.sortedDesc()
+			if len(dirs) < 2 || dirs[0].Path != filepath.Join(root, c.first) {
+				t.Fatalf("top directories %+v, want %s first", dirs, c.first)
+			}
+			got := rankedDirs(sc)
+			if got[filepath.Join(root, "one")] != fmt.Sprint(c.one) || got[filepath.Join(root, "many")] != fmt.Sprint(c.many) {
+				t.Errorf("directory totals %v, want one=%d many=%d", got, c.one, c.many)
+			}
+			if total := c.one + c.many; sc.rootSizes[0] != total || sc.stats.bytesSeen != total {
+				t.Errorf("root %d, bytes seen %d; want %d", sc.rootSizes[0], sc.stats.bytesSeen, total)
+			}
+			if f := sc.fileTop.sortedDesc()[0]; f.Size != c.metric("", mustStat(t, f.Path)) {
+				t.Errorf("largest file %s ranked at %d, not its metric size", f.Path, f.Size)
+			}
+		})
+	}
+}
+
+func mustStat(t *testing.T, p string) fs.FileInfo {
+	t.Helper()
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
+
+// ----- file size sketch -----
+
+// exactMedian: the element sizeSketch.median estimates, the (n+1)/2-th
+// smallest.
+func exactMedian(sizes []int64) int64 {
+	s := slices.Clone(sizes)
+	slices.Sort(s)
+	return s[(len(s)+1)/2-1]
+}
+
+// withinSketch: whether est is within the sketch's 12.5% of exact.
+func withinSketch(est, exact int64) bool {
+	return est == exact || math.Abs(float64(est-exact)) <= float64(exact)/8
+}
+
+func TestSketchBuckets(t *testing.T) {
+	prev := 0
+	for n := int64(0); n < 1<<16; n++ {
+		b := sketchBucket(n)
+		if b < prev || b > prev+1 {
+			t.Fatalf("bucket(%d) = %d after %d", n, b, prev)
+		}
+		prev = b
+	}
+	if b := sketchBucket(math.MaxInt64); b >= len(sizeSketch{}) {
+		t.Errorf("bucket(MaxInt64) = %d, past the sketch", b)
+	}
+	if b := sketchBucket(-1); b != 0 {
+		t.Errorf("bucket(-1) = %d", b)
+	}
+}
+
+func TestSketchMedian(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, c := range []struct {
+		name string
+		size func() int64
+	}{
+		{"tiny", func() int64 { return r.Int64N(8) }},
+		{"uniform", func() int64 { return r.Int64N(1 << 20) }},
+		{"log-uniform", func() int64 { return 1 << r.IntN(40) * (1 + r.Int64N(1000)) / 1000 }},
+		{"bimodal", func() int64 { return []int64{100, 1 << 30}[r.IntN(2)] + r.Int64N(50) }},
+	} {
+		for _, n := range []int{1, 2, 7, 1000} {
+			sizes := make([]int64, n)
+			var whole, a, b sizeSketch
+			for i := range sizes {
+				sizes[i] = c.size()
+				whole.add(sizes[i])
+				if i%3 == 0 {
+					a.add(sizes[i])
+				} else {
+					b.add(sizes[i])
+				}
+			}
+			a.merge(&b)
+			if a != whole {
+				t.Errorf("%s/%d: merged halves differ from one sketch", c.name, n)
+			}
+			if est, exact := whole.median(), exactMedian(sizes); !withinSketch(est, exact) {
+				t.Errorf("%s/%d: median %d, exact %d", c.name, n, est, exact)
+			}
+		}
+	}
+	var empty *sizeSketch
+	if empty.median() != 0 || (&sizeSketch{}).median() != 0 {
+		t.Error("empty sketch: median not 0")
+	}
+}
+
+// The FILES, AVG and ~MEDIAN columns over a fixture tree, the same whether
+// subdirectories are walked inline or in parallel.
+func TestDirFileStats(t *testing.T) {
+	files := map[string]int{}
+	var all, sub []int64
+	for i := range 60 {
+		n := 100 + i*i*37
+		files[fmt.Sprintf("d/f%02d", i)] = n
+		all = append(all, int64(n))
+		if i%2 == 0 {
+			files[fmt.Sprintf("d/s/g%02d", i)] = 3 * n
+			all, sub = append(all, int64(3*n)), append(sub, int64(3*n))
+		}
+	}
+	root := writeTree(t, files)
+	var runs []map[string]item
+	for _, workers := range []int{1, 8} {
+		cfg := testCfg()
+		cfg.fileStats, cfg.workers = true, workers
+		sc := startScan(context.Background(), []string{root}, cfg)
+		sc.wait()
+		got := make(map[string]item)
+		for _, it := range sc.dirTop.sortedDesc() {
+			it.SeenAt = time.Time{}
+			got[it.Path] = it
+		}
+		runs = append(runs, got)
+	}
+	if !maps.Equal(runs[0], runs[1]) {
+		t.Errorf("one worker:\n%+v\neight:\n%+v", runs[0], runs[1])
+	}
+	for dir, sizes := range map[string][]int64{"d": all, filepath.Join("d", "s"): sub} {
+		it := runs[0][filepath.Join(root, dir)]
+		if it.Files != int64(len(sizes)) {
+			t.Errorf("%s: %d files, want %d", dir, it.Files, len(sizes))
+		}
+		if exact := exactMedian(sizes); !withinSketch(it.Median, exact) {
+			t.Errorf("%s: median %d, exact %d", dir, it.Median, exact)
+		}
+	}
+}
+
+// ----- written between -----
+
+func TestParseWrittenRange(t *testing.T) {
+	est := time.FixedZone("EST", -5*3600)
+	r, err := parseWrittenRange("2024-05-01, 2024-05-02", est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := time.Date(2024, 5, 1, 0, 0, 0, 0, est), time.Date(2024, 5, 3, 0, 0, 0, 0, est)
+	if !r.from.Equal(from) || !r.to.Equal(to) {
+		t.Errorf("range %s to %s, want %s to %s", r.from, r.to, from, to)
+	}
+	for _, c := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{from, true}, // midnight starting the first day
+		{from.Add(-time.Nanosecond), false},
+		{to.Add(-time.Nanosecond), true}, // the last instant of the last day
+		{to, false},                      // midnight after it
+		{time.Date(2024, 5, 1, 4, 59, 0, 0, time.UTC), false}, // 23:59 the day before, in EST
+		{time.Date(2024, 5, 3, 4, 59, 0, 0, time.UTC), true},  // 23:59 on the 2nd, in EST
+	} {
+		if got := r.contains(c.t); got != c.want {
+			t.Errorf("contains(%s) = %v, want %v", c.t, got, c.want)
+		}
+	}
+
+	// RFC3339 times are taken as written, whatever the zone.
+	r, err = parseWrittenRange("2024-05-01T12:00:00Z,2024-05-01T15:00:00+01:00", est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC); !r.from.Equal(noon) || !r.to.Equal(noon.Add(2*time.Hour)) {
+		t.Errorf("RFC3339 range %s to %s", r.from, r.to)
+	}
+
+	for _, bad := range []string{"2024-05-01", "2024-05-02,2024-05-01", "2024-05-01,yesterday", "2024-13-01,2024-05-01"} {
+		if _, err := parseWrittenRange(bad, time.UTC); err == nil {
+			t.Errorf("parseWrittenRange(%q): no error", bad)
+		}
+	}
+	if _, err := parseWrittenRange("2024-05-01T12:00:00Z,2024-05-01T13:00:00+01:00", time.UTC); err == nil {
+		t.Error("a range that ends where it starts: no error")
+	}
+}
+
+// Files written in the range are ranked and bucketed by their day in the
+// range's zone; every file still counts toward the directory totals.
+func TestWrittenBetween(t *testing.T) {
+	root := writeTree(t, map[string]int{"a/before": 1000, "a/first": 10, "a/late": 20, "b/second": 30, "b/after": 2000})
+	est := time.FixedZone("EST", -5*3600)
+	for name, mt := range map[string]time.Time{
+		"a/before": time.Date(2024, 4, 30, 23, 59, 59, 0, est),
+		"a/first":  time.Date(2024, 5, 1, 0, 0, 0, 0, est),
+		"a/late":   time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC), // still the 1st in EST
+		"b/second": time.Date(2024, 5, 2, 23, 59, 59, 0, est),
+		"b/after":  time.Date(2024, 5, 3, 0, 0, 0, 0, est),
+	} {
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(name)), mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := testCfg()
+	var err error
+	if cfg.written, err = parseWrittenRange("2024-05-01,2024-05-02", est); err != nil {
+		t.Fatal(err)
+	}
+	sc := startScan(context.Background(), []string{root}, cfg)
+	sc.wait()
+
+	var ranked []string
+	for _, it := range sc.fileTop.sortedDesc() {
+		ranked = append(ranked, filepath.Base(it.Path))
+	}
+	if want := []string{"second", "late", "first"}; !slices.Equal(ranked, want) {
+		t.Errorf("Largest Files %q, want %q", ranked, want)
+	}
+	if want := []writtenDay{{"2024-05-01", 30, 2}, {"2024-05-02", 30, 1}}; !slices.Equal(sc.cfg.writtenDays.rows(), want) {
+		t.Errorf("per day %+v, want %+v", sc.cfg.writtenDays.rows(), want)
+	}
+	if dirs := rankedDirs(sc); dirs[filepath.Join(root, "a")] != "1030" || dirs[filepath.Join(root, "b")] != "2030" {
+		t.Errorf("directory totals %v, want a=1030 b=2030", dirs)
+	}
+}
+
+// ----- heap deduplication -----
+
+// heapRows: a heap's rows as "path=size", largest first.
+func heapRows(h *minHeap) []string {
+	var out []string
+	for _, it := range h.sortedDesc() {
+		out = append(out, fmt.Sprintf("%s=%d", it.Path, it.Size))
+	}
+	return out
+}
+
+func TestHeapPathDedup(t *testing.T) {
+	p := filepath.FromSlash
+	h := &minHeap{k: 3}
+	h.push(item{Path: p("/data/users"), Size: 100})
+	h.push(item{Path: p("/data/users/"), Size: 150}) // the same path, a trailing separator
+	h.push(item{Path: p("/data/./users"), Size: 120})
+	h.push(item{Path: p("/data/x"), Size: 50})
+	if got, want := heapRows(h), []string{p("/data/users=150"), p("/data/x=50")}; !slices.Equal(got, want) {
+		t.Errorf("rows %q, want %q", got, want)
+	}
+
+	// An evicted path can come back as a row of its own.
+	h = &minHeap{k: 2}
+	for _, it := range []item{{Path: "a", Size: 10}, {Path: "b", Size: 20}, {Path: "c", Size: 30}, {Path: "a", Size: 40}} {
+		h.push(it)
+	}
+	if got, want := heapRows(h), []string{"a=40", "c=30"}; !slices.Equal(got, want) {
+		t.Errorf("after eviction: rows %q, want %q", got, want)
+	}
+	if h.wouldAccept(30) || !h.wouldAccept(31) {
+		t.Errorf("floor %d, want 31", h.floor.Load())
+	}
+}
+
+// On Windows, C:\Users and c:\USERS are one row, with the casing seen
+// first.
+func TestHeapCaseDedup(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("paths are case-sensitive here")
+	}
+	h := &minHeap{k: 5}
+	h.push(item{Path: `C:\Users`, Size: 100})
+	h.push(item{Path: `c:\users`, Size: 150})
+	h.push(item{Path: `C:\USERS\`, Size: 120})
+	h.push(item{Path: `C:\Users\Bob`, Size: 90})
+	h.push(item{Path: `c:\users\bob`, Size: 80})
+	if got, want := heapRows(h), []string{`C:\Users=150`, `C:\Users\Bob=90`}; !slices.Equal(got, want) {
+		t.Errorf("rows %q, want %q", got, want)
+	}
+
+	// Two roots that differ only in case give one row per directory.
+	root := writeTree(t, map[string]int{"Sub/f": 10})
+	sc := startScan(context.Background(), []string{root, strings.ToUpper(root)}, testCfg())
+	sc.wait()
+	var subs int
+	for _, it := range sc.dirTop.sortedDesc() {
+		if strings.EqualFold(it.Path, filepath.Join(root, "Sub")) {
+			subs++
+		}
+	}
+	if subs != 1 {
+		t.Errorf("%d rows for %s, want 1", subs, filepath.Join(root, "Sub"))
+	}
+}
+
+// ----- sparse files -----
+
+// The -report-sparse table keeps the k largest gaps, ties by path.
+func TestSparseReportOrder(t *testing.T) {
+	l := &sparseLog{k: 2, files: 4, phantom: 60}
+	l.top = []sparseFile{
+		{Path: "b", PhantomBytes: 10}, {Path: "d", PhantomBytes: 30}, {Path: "a", PhantomBytes: 10}, {Path: "c", PhantomBytes: 10},
+	}
+	r := l.report()
+	var got []string
+	for _, f := range r.Top {
+		got = append(got, fmt.Sprint(f.Path, f.PhantomBytes))
+	}
+	if want := []string{"d30", "a10"}; !slices.Equal(got, want) || r.Files != 4 || r.PhantomBytes != 60 {
+		t.Errorf("report %+v, top %q; want %q", r, got, want)
+	}
+}
+
+// makeSparse creates path as a sparse file of size bytes with only its
+// first data bytes written.
+func makeSparse(t *testing.T, path string, size int64, data int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var n uint32
+	if err := windows.DeviceIoControl(windows.Handle(f.Fd()), windows.FSCTL_SET_SPARSE, nil, 0, nil, 0, &n, nil); err != nil {
+		t.Skipf("FSCTL_SET_SPARSE: %v", err) // e.g. a temp dir on FAT
+	}
+	if _, err := f.Write(make([]byte, data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSparseFiles(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("sparse files are made with FSCTL_SET_SPARSE")
+	}
+	root := writeTree(t, map[string]int{"plain.bin": 100000})
+	vhd := filepath.Join(root, "disk.vhdx")
+	const logical = 256 << 20
+	makeSparse(t, vhd, logical, 4096)
+
+	cfg := testCfg()
+	cfg.sparseFiles = &sparseLog{k: 5, alloc: newAllocSizer()}
+	startScan(context.Background(), []string{root}, cfg).wait()
+	r := cfg.sparseFiles.report()
+	if r.Files != 1 || len(r.Top) != 1 || r.Top[0].Path != vhd {
+		t.Fatalf("report %+v, want disk.vhdx alone", r)
+	}
+	f := r.Top[0]
+	if f.LogicalBytes != logical || f.AllocatedBytes >= 1<<20 || f.PhantomBytes != logical-f.AllocatedBytes || r.PhantomBytes != f.PhantomBytes {
+		t.Errorf("disk.vhdx sized %+v, total phantom %d", f, r.PhantomBytes)
+	}
+
+	// Under -metric=allocated the file ranks at its allocation, marked.
+	cfg = testCfg()
+	cfg.metricName, cfg.metric = "allocated", metrics["allocated"]()
+	sc := startScan(context.Background(), []string{root}, cfg)
+	sc.wait()
+	for _, it := range sc.fileTop.sortedDesc() {
+		if sparse := it.Path == vhd; sparse != (it.Tag == "sparse") || sparse && it.Size != f.AllocatedBytes {
+			t.Errorf("%s: size %d, tag %q", it.Path, it.Size, it.Tag)
+		}
+	}
+}
+
+// ----- exit status -----
+
+// runMain runs gosize with args: this test binary, re-executed to run
+// the calling test again, which hands over to main at once through
+// asChild. Listing a directory named deny ("" for every one) fails with
+// access denied.
+func runMain(t *testing.T, deny string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), "GOSIZE_TEST_MAIN="+strings.Join(args, "\n"), "GOSIZE_TEST_DENY="+deny)
+	var out, errOut strings.Builder
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.ExitCode(), out.String(), errOut.String()
+}
+
+// asChild: in a runMain child, runs main and exits; otherwise returns.
+func asChild() {
+	args := os.Getenv("GOSIZE_TEST_MAIN")
+	if args == "" {
+		return
+	}
+	deny := os.Getenv("GOSIZE_TEST_DENY")
+	denied := func(p string) error {
+		return &os.PathError{Op: "open", Path: p, Err: windows.ERROR_ACCESS_DENIED}
+	}
+	listDir = func(p string) ([]os.DirEntry, error) {
+		if deny == "" || filepath.Base(p) == deny {
+			return nil, denied(p)
+		}
+		return os.ReadDir(p)
+	}
+	if deny != "" { // with every listing denied, the roots still pass checkRoot
+		probe := probeDir
+		probeDir = func(p string) error {
+			if filepath.Base(p) == deny {
+				return denied(p)
+			}
+			return probe(p)
+		}
+	}
+	os.Args = append([]string{"gosize"}, strings.Split(args, "\n")...)
+	main()
+}
+
+// TestNothingScannedExit: a root that passes the startup check but can't
+// be listed, as when access is denied on every directory, gives no
+// tables, the reason on stderr and exit 1.
+func TestNothingScannedExit(t *testing.T) {
+	asChild()
+	root := writeTree(t, map[string]int{"a/f": 10})
+	for _, format := range []string{"-progress=false", "-json"} {
+		code, stdout, stderr := runMain(t, "", "-roots="+root, format)
+		if code != 1 {
+			t.Errorf("%s: exit status %d, want 1\n%s", format, code, stderr)
+		}
+		if stdout != "" {
+			t.Errorf("%s: output on stdout:\n%s", format, stdout)
+		}
+		if !strings.Contains(stderr, "Nothing was scanned") {
+			t.Errorf("%s: stderr doesn't say why:\n%s", format, stderr)
+		}
+	}
+}
+
+// Exit status 1 after an abort or an empty scan must still run main's
+// deferred steps; the -checkpoint file's removal is one that shows.
+func TestExitRunsDeferred(t *testing.T) {
+	asChild()
+	root := writeTree(t, map[string]int{"a/f": 10, "b/g": 20})
+	for _, tt := range []struct {
+		name, deny string
+		args       []string
+	}{
+		{"abort", "b", []string{"-abort-on-error"}},
+		{"nothing scanned", "", nil},
+	} {
+		ckpt := filepath.Join(t.TempDir(), "scan.ckpt")
+		if err := os.WriteFile(ckpt, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		args := append([]string{"-roots=" + root, "-progress=false", "-checkpoint=" + ckpt}, tt.args...)
+		code, _, stderr := runMain(t, tt.deny, args...)
+		if code != 1 {
+			t.Errorf("%s: exit status %d, want 1\n%s", tt.name, code, stderr)
+		}
+		if _, err := os.Stat(ckpt); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: the checkpoint is still there (%v): deferred steps skipped", tt.name, err)
+		}
+	}
+}
+
+// ----- root checks -----
+
+func TestCheckRoot(t *testing.T) {
+	root := writeTree(t, map[string]int{"dir/f": 1, "file.txt": 5})
+	empty := filepath.Join(root, "empty")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	locked := filepath.Join(root, "dir")
+	denied := &os.PathError{Op: "open", Path: locked, Err: windows.ERROR_ACCESS_DENIED}
+	probe := probeDir
+	probeDir = func(p string) error {
+		if p == locked {
+			return denied
+		}
+		return probe(p)
+	}
+	t.Cleanup(func() { probeDir = probe })
+
+	for _, tt := range []struct {
+		root, want string
+	}{
+		{root, ""},
+		{empty, ""},
+		{filepath.Join(root, "file.txt"), ""}, // a file root is sized directly
+		{filepath.Join(root, "missing"), "root " + filepath.Join(root, "missing") + " does not exist"},
+		{filepath.Join(root, "missing", "deeper"), "does not exist"},
+		{locked, "root " + locked + " is not accessible: "},
+	} {
+		err := checkRoot(tt.root)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.root, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: %v, want %q", tt.root, err, tt.want)
+		}
+	}
+	if err := checkRoot(locked); !errors.Is(err, denied) {
+		t.Errorf("the listing error is not wrapped: %v", err)
+	}
+}
+
+// A root that is missing or can't be listed is named on stderr, never an
+// empty table: with no usable root the run exits 1 without output, with
+// another root to scan it warns and exits 2.
+func TestBadRootExit(t *testing.T) {
+	asChild()
+	root := writeTree(t, map[string]int{"good/f": 10, "locked/g": 20})
+	good, locked, missing := filepath.Join(root, "good"), filepath.Join(root, "locked"), filepath.Join(root, "missing")
+	for _, tt := range []struct {
+		name  string
+		roots string
+		code  int
+		why   string
+	}{
+		{"missing", missing, 1, missing + " does not exist"},
+		{"unreadable", locked, 1, locked + " is not accessible"},
+		{"missing and good", missing + "," + good, 2, "warning: root " + missing + " does not exist"},
+		{"unreadable and good", locked + "," + good, 2, "warning: root " + locked + " is not accessible"},
+	} {
+		code, stdout, stderr := runMain(t, "locked", "-roots="+tt.roots, "-progress=false")
+		if code != tt.code {
+			t.Errorf("%s: exit status %d, want %d\n%s", tt.name, code, tt.code, stderr)
+		}
+		if !strings.Contains(stderr, tt.why) {
+			t.Errorf("%s: stderr doesn't say %q:\n%s", tt.name, tt.why, stderr)
+		}
+		if tt.code == 1 && (stdout != "" || !strings.Contains(stderr, "No usable roots")) {
+			t.Errorf("%s: output with no usable root:\n%s%s", tt.name, stdout, stderr)
+		}
+		if tt.code == 2 && !strings.Contains(stdout, filepath.Join(good, "f")) {
+			t.Errorf("%s: the good root isn't in the output:\n%s", tt.name, stdout)
+		}
+	}
+}
+
+// ----- percent base -----
+
+func TestPercentBaseCell(t *testing.T) {
+	const gb = 1 << 30
+	full := driveSpace{total: 100 * gb, free: 0}
+	nearly := driveSpace{total: 100 * gb, free: 2 * gb}
+	empty := driveSpace{total: 100 * gb, free: 100 * gb}
+	for _, c := range []struct {
+		base percentBase
+		size int64
+		sp   driveSpace
+		want string
+	}{
+		{baseCapacity, 5 * gb, nearly, "5.00%"},
+		{baseUsed, 49 * gb, nearly, "50.00%"},
+		{baseFree, 1 * gb, nearly, "0.5× free"},
+		{baseFree, 5 * gb, nearly, "2.5× free"},
+		{baseFree, 5 * gb, full, "no free"},         // zero free
+		{baseUsed, 5 * gb, full, "5.00%"},           // all of it is used
+		{baseUsed, 0, empty, "n/a"},                 // nothing used
+		{baseCapacity, 5 * gb, driveSpace{}, "n/a"}, // zero total: unknown volume
+		{baseUsed, 5 * gb, driveSpace{}, "n/a"},
+		{baseFree, 5 * gb, driveSpace{}, "n/a"},
+		{baseCapacity, 200 * gb, nearly, ">100%?"},
+	} {
+		if got := c.base.cell(c.size, c.sp); got != c.want {
+			t.Errorf("%s.cell(%d, %+v) = %q, want %q", c.base, c.size, c.sp, got, c.want)
+		}
+	}
+	for b, want := range map[percentBase]string{baseCapacity: "DRIVE%", baseUsed: "USED%", baseFree: "VS FREE"} {
+		if got := b.header(); got != want {
+			t.Errorf("%s header %q, want %q", b, got, want)
+		}
+	}
+}
+
+// ----- torn files -----
+
+// TestTornArtifacts cuts a -baseline, a -resume state and an -index-file,
+// plain and gzipped, at every offset. Each cut must read back as corrupt,
+// the case main ignores with a warning, never as a good file or an error
+// that fails the run.
+func TestTornArtifacts(t *testing.T) {
+	root := goldenTree(t)
+	sc := startScan(context.Background(), []string{root}, testCfg())
+	sc.wait()
+	res := sc.jsonResult(newDriveSpaceCache(), false)
+	dir := t.TempDir()
+	for _, a := range []struct {
+		name string
+		save func(path string) error
+		load func(path string) error
+	}{
+		{"baseline.json", func(p string) error { return saveBaseline(p, res) }, func(p string) error {
+			_, err := loadBaseline(p)
+			return err
+		}},
+		{"resume.json", func(p string) error {
+			st := resumeState{
+				SchemaVersion: schemaVersion, RunID: res.RunID, Roots: []string{root},
+				Frontier:  []resumeDir{{Path: filepath.Join(root, "docs"), Depth: 1}},
+				FilesSeen: res.Summary.FilesSeen,
+			}
+			for _, r := range res.Files {
+				st.Files = append(st.Files, item{Path: r.Path, Size: r.SizeBytes})
+			}
+			b, err := json.Marshal(st)
+			if err != nil {
+				return err
+			}
+			return writeFileAtomic(p, b)
+		}, func(p string) error {
+			_, err := loadResume(p)
+			return err
+		}},
+		{"index.json", func(p string) error {
+			x := &dirIndex{Version: indexVersion, Roots: []string{root}, BuiltAt: time.Now()}
+			for _, r := range res.Directories {
+				x.Dirs = append(x.Dirs, indexRow{Path: r.Path, SizeBytes: r.SizeBytes})
+			}
+			return x.save(p)
+		}, func(p string) error {
+			_, err := loadIndex(p)
+			return err
+		}},
+	} {
+		for _, name := range []string{a.name, a.name + ".gz"} {
+			path := filepath.Join(dir, name)
+			if err := a.save(path); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.load(path); err != nil {
+				t.Fatalf("%s as written: %v", name, err)
+			}
+			whole, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			torn := filepath.Join(dir, "torn-"+name)
+			for n := range len(whole) {
+				if err := os.WriteFile(torn, whole[:n], 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := a.load(torn); !isCorrupt(err) {
+					t.Errorf("%s cut at %d of %d bytes: %v, want a corrupt-file error", name, n, len(whole), err)
+					break
+				}
+			}
+		}
+	}
+	// writeFileAtomic leaves nothing of its temporary files behind.
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) > 0 {
+		t.Errorf("temporary files left: %v", tmps)
+	}
+}
+
+// ----- natural path order -----
+
+func TestNaturalLess(t *testing.T) {
+	// In order; each entry sorts strictly before the next.
+	sorted := []string{
+		"",
+		"file",
+		"file0",
+		"file01", // more leading zeros first
+		"file1",
+		"file2",
+		"file2a",
+		"file2b",
+		"file10",
+		"file10.1",
+		"file10.01x",
+		"file10.10",
+		"file000000000000000000000123", // longer than an int64, compared as digits
+		"file1000000000000000000000000",
+		"fileA",
+		"filea",
+		"img2",
+		`img\2\a`, // "2" < "\"
+		`img\10\a`,
+	}
+	for i, a := range sorted {
+		if naturalLess(a, a) {
+			t.Errorf("naturalLess(%q, %q) = true", a, a)
+		}
+		for _, b := range sorted[i+1:] {
+			if !naturalLess(a, b) || naturalLess(b, a) {
+				t.Errorf("%q and %q out of order", a, b)
+			}
+		}
+	}
+	shuffled := slices.Clone(sorted)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	if slices.SortFunc(shuffled, func(a, b string) int {
+		if naturalLess(a, b) {
+			return -1
+		}
+		if naturalLess(b, a) {
+			return 1
+		}
+		return 0
+	}); !slices.Equal(shuffled, sorted) {
+		t.Errorf("sorted to %q", shuffled)
+	}
+
+	cfg := testCfg()
+	if !cfg.pathLess("img10", "img2") {
+		t.Error("byte order without -sort-paths-natural")
+	}
+	cfg.naturalPaths = true
+	if !cfg.pathLess("img2", "img10") {
+		t.Error("byte order with -sort-paths-natural")
+	}
+}
+
+// ----- column layout -----
+
+// colNames: the column names of cols, comma-separated, width after a colon.
+func colNames(cols []tableCol) string {
+	var out []string
+	for _, c := range cols {
+		if c.width > 0 {
+			out = append(out, fmt.Sprintf("%s:%d", c.name, c.width))
+		} else {
+			out = append(out, c.name)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func TestParseColumns(t *testing.T) {
+	cfg := testCfg()
+	cfg.fileStats = true
+	dirs, files := tableCtx{cfg: cfg, dirs: true}, tableCtx{cfg: cfg}
+	tests := []struct {
+		spec                string
+		wantDirs, wantFiles string
+	}{
+		{"", "rank,size,drive%,files,path", "rank,size,drive%,path"},
+		// Extras only: added to the usual layout, in its order.
+		{"files", "rank,size,drive%,files,path", "rank,size,drive%,path"},
+		{"dir", "rank,size,drive%,files,path", "rank,size,drive%,dir,name"},
+		// A layout column: exactly these, in this order.
+		{"path,size", "path,size", "path,size"},
+		{" PATH:40 , Size ,, rank", "path:40,size,rank", "path:40,size,rank"},
+		{"path,files,size", "path,files,size", "path,size"}, // no FILES in the files table
+		{"path,delta", "path", "path"},                      // no DELTA without -baseline
+	}
+	for _, tt := range tests {
+		cs, err := parseColumns(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if got := colNames(cs.layout(dirs)); got != tt.wantDirs {
+			t.Errorf("%q directories: %s, want %s", tt.spec, got, tt.wantDirs)
+		}
+		if got := colNames(cs.layout(files)); got != tt.wantFiles {
+			t.Errorf("%q files: %s, want %s", tt.spec, got, tt.wantFiles)
+		}
+	}
+	for _, spec := range []string{"paths", "size,path,size", "path:1", "path:x", "path:", "size:-3"} {
+		if _, err := parseColumns(spec); err == nil {
+			t.Errorf("%q: accepted", spec)
+		}
+	}
+}
+
+// ----- TSV escaping -----
+
+// tsvUnescape: what a TSV reader does with a path cell.
+func tsvUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Every path must survive a write and a read: on Windows each one has
+// backslashes, and `a\tb` is a name as good as a<TAB>b.
+func TestTSVPathsRoundTrip(t *testing.T) {
+	paths := []string{`C:\a\tb`, "C:\\a\tb", `C:\dir\`, `\\srv\share\n`, "C:\\x\r\ny", `C:\trailing\\`}
+	var items []item
+	for _, p := range paths {
+		items = append(items, item{Path: p, Size: 1})
+	}
+	var b strings.Builder
+	writeTSV(&b, "files", items, newDriveSpaceCache(), baseCapacity)
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")[1:]
+	seen := make(map[string]bool)
+	for i, line := range lines {
+		cells := strings.Split(line, "\t")
+		if len(cells) != 6 {
+			t.Fatalf("row %q: %d cells", line, len(cells))
+		}
+		if got := tsvUnescape(cells[5]); got != paths[i] {
+			t.Errorf("%q reads back as %q", paths[i], got)
+		}
+		if seen[cells[5]] {
+			t.Errorf("two paths written as %q", cells[5])
+		}
+		seen[cells[5]] = true
+	}
+
+	// -history escapes the root as well as the largest directory.
+	root := "C:\\tab\there"
+	sc := newScan([]string{root}, testCfg())
+	sc.rootSizes[0] = 42
+	pushDir(sc.dirTop, root+`\sub`, 1, dirAgg{size: 42}, sc.cfg)
+	hist := filepath.Join(t.TempDir(), "history.tsv")
+	if err := sc.appendHistory(hist, 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(hist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := strings.Split(strings.TrimSuffix(string(data), "\n"), "\t")
+	if len(cells) != 5 || tsvUnescape(cells[1]) != root || tsvUnescape(cells[3]) != root+`\sub` || cells[2] != "42" {
+		t.Errorf("history line %q", data)
+	}
+}
+
+// The drive_pct column is the table's DRIVE% cell under -percent-base.
+func TestTSVPercentBase(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("drive space is looked up on Windows only")
+	}
+	dsc := newDriveSpaceCache()
+	dsc.byRoot[`Z:\`] = driveSpace{total: 1000, free: 500}
+	items := []item{{Path: `Z:\big`, Size: 100, IsDir: true}}
+	for base, want := range map[percentBase]string{baseCapacity: "10.00%", baseUsed: "20.00%", baseFree: "0.2× free"} {
+		var b strings.Builder
+		writeTSV(&b, "directories", items, dsc, base)
+		if cells := strings.Split(strings.Split(b.String(), "\n")[1], "\t"); cells[3] != want || cells[3] != base.cell(100, dsc.spaceFor(`Z:\big`)) {
+			t.Errorf("-percent-base=%s: drive_pct %q, want %q", base, cells[3], want)
+		}
+	}
+}
+
+// ----- DIR and NAME columns -----
+
+func TestSplitPath(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("Windows path syntax")
+	}
+	for _, tt := range []struct{ path, dir, name string }{
+		{`C:\pagefile.sys`, `C:\`, "pagefile.sys"}, // root-level file: the parent is the volume root
+		{`C:\Users\me\notes.txt`, `C:\Users\me`, "notes.txt"},
+		{`C:\Users`, `C:\`, "Users"},
+		{`C:\`, `C:\`, ""},
+		{`C:`, `C:\`, ""},
+		{`d:\x`, `d:\`, "x"},
+		{`\\srv\share\file.iso`, `\\srv\share\`, "file.iso"}, // root-level on a share
+		{`\\srv\share\dir\sub\f.bin`, `\\srv\share\dir\sub`, "f.bin"},
+		{`\\srv\share`, `\\srv\share\`, ""},
+		{`\\srv\share\`, `\\srv\share\`, ""},
+	} {
+		if dir, name := splitPath(tt.path); dir != tt.dir || name != tt.name {
+			t.Errorf("splitPath(%q) = %q, %q, want %q, %q", tt.path, dir, name, tt.dir, tt.name)
+		}
+	}
+}
+
+// ----- file roots -----
+
+// A file given as a root next to a directory root is ranked with the
+// directory's files and has a root total of its own, but is not a
+// directory row, and leaves the directory's totals alone.
+func TestFileAndDirRoots(t *testing.T) {
+	dir := writeTree(t, map[string]int{"a.bin": 100, "sub/b.bin": 50, "sub/deeper/c.bin": 25})
+	file := filepath.Join(writeTree(t, map[string]int{"disk.vhdx": 400}), "disk.vhdx")
+	cfg := testCfg()
+	cfg.rankRoots = true
+	sc := startScan(context.Background(), []string{dir, file}, cfg)
+	sc.wait()
+
+	var files []string
+	for _, it := range sc.fileTop.sortedDesc() {
+		files = append(files, fmt.Sprintf("%s %d", it.Path, it.Size))
+	}
+	want := []string{file + " 400", filepath.Join(dir, "a.bin") + " 100", filepath.Join(dir, "sub", "b.bin") + " 50", filepath.Join(dir, "sub", "deeper", "c.bin") + " 25"}
+	if !slices.Equal(files, want) {
+		t.Errorf("files %q, want %q", files, want)
+	}
+	wantDirs := map[string]string{dir: "175", filepath.Join(dir, "sub"): "75", filepath.Join(dir, "sub", "deeper"): "25"}
+	if got := rankedDirs(sc); !maps.Equal(got, wantDirs) {
+		t.Errorf("directories %v, want %v", got, wantDirs)
+	}
+	if sc.rootSizes[0] != 175 || sc.rootSizes[1] != 400 {
+		t.Errorf("root totals %v, want [175 400]", sc.rootSizes)
+	}
+	if !slices.Equal(sc.fileRoots, []string{file}) {
+		t.Errorf("file roots %q", sc.fileRoots)
+	}
+	if s := &sc.stats; s.filesSeen != 4 || s.bytesSeen != 575 || s.errors != 0 {
+		t.Errorf("%d files, %d bytes, %d errors; want 4, 575, 0", s.filesSeen, s.bytesSeen, s.errors)
+	}
+	for i := range sc.roots {
+		if sc.rootErrs[i] != nil {
+			t.Errorf("%s: %v", sc.roots[i], sc.rootErrs[i])
+		}
+	}
+}
+
+// ----- 8.3 short names -----
+
+// shortName: p's 8.3 form, or p when the volume makes no short names.
+func shortName(t *testing.T, p string) string {
+	t.Helper()
+	u, err := windows.UTF16PtrFromString(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]uint16, windows.MAX_PATH)
+	n, err := windows.GetShortPathName(u, &buf[0], uint32(len(buf)))
+	if err != nil || n == 0 || int(n) > len(buf) {
+		t.Fatalf("GetShortPathName(%s): %v", p, err)
+	}
+	return windows.UTF16ToString(buf[:n])
+}
+
+// A root or -skip pattern spelled with 8.3 names is expanded to the long
+// form the walk reports, so it matches what is scanned.
+func TestShortNameExpansion(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("8.3 names are a Windows feature")
+	}
+	base := writeTree(t, map[string]int{"longname directory/keep.bin": 10, "longname directory/temporary files/drop.bin": 20})
+	long := filepath.Join(longPathName(base), "longname directory")
+	short := shortName(t, long)
+	if strings.EqualFold(filepath.Base(short), filepath.Base(long)) {
+		t.Skip("8.3 names are disabled on this volume")
+	}
+	if !hasShortName(short) {
+		t.Fatalf("%s doesn't look like a short name", short)
+	}
+
+	if got := longForm("root", short); !strings.EqualFold(got, long) {
+		t.Errorf("longForm(%s) = %s, want %s", short, got, long)
+	}
+	roots, err := resolveRoots(short)
+	if err != nil || len(roots) != 1 || !strings.EqualFold(roots[0], long+`\`) {
+		t.Errorf("resolveRoots(%s) = %q, %v; want %s", short, roots, err, long+`\`)
+	}
+	// A glob keeps its pattern part; the literal directories before it expand.
+	if got := longForm("-skip", short+`\*.tmp`); !strings.EqualFold(got, long+`\*.tmp`) {
+		t.Errorf("longForm(%s) = %s, want %s", short+`\*.tmp`, got, long+`\*.tmp`)
+	}
+
+	cfg := testCfg()
+	cfg.skipPatterns = []string{longForm("-skip", shortName(t, filepath.Join(long, "temporary files")))}
+	sc := startScan(context.Background(), roots, cfg)
+	sc.wait()
+	if sc.rootSizes[0] != 10 {
+		t.Errorf("root total %d with %s skipped, want 10", sc.rootSizes[0], cfg.skipPatterns[0])
+	}
+	for _, it := range sc.fileTop.sortedDesc() {
+		if hasShortName(it.Path) {
+			t.Errorf("%s reported with a short name", it.Path)
+		}
+	}
+}
+
+// ----- phase times -----
+
+// The wall-clock phases (scan, drive space, output) follow one another,
+// so they add up to the run's duration; listing is worker time and at
+// least what the listings took.
+func TestPhasesSumToDuration(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	root := writeTree(t, map[string]int{"a/f": 1, "a/b/f": 2, "a/b/c/f": 3, "a/b/c/d/f": 4})
+	listDir = func(p string) ([]os.DirEntry, error) {
+		time.Sleep(delay)
+		return os.ReadDir(p)
+	}
+	t.Cleanup(func() { listDir = os.ReadDir })
+
+	sc := startScan(context.Background(), []string{root}, testCfg())
+	sc.wait()
+	dsc := newDriveSpaceCache()
+	sc.renderStart = time.Now()
+	if err := json.NewEncoder(io.Discard).Encode(sc.jsonResult(dsc, false)); err != nil {
+		t.Fatal(err)
+	}
+	printTables(io.Discard, tableCtx{cfg: sc.cfg, dsc: dsc, start: sc.start}, columnSpec{}, "Largest Directories", sc.dirTop.sortedDesc(), sc.fileTop.sortedDesc(), true)
+	p := sc.phases(dsc)
+	total := float64(time.Since(sc.start)) / float64(time.Millisecond)
+
+	// Five listings one below the other: none can start before its parent's ends.
+	levels := float64(5 * delay / time.Millisecond)
+	if p.ListingMs < levels || p.ScanMs < levels {
+		t.Errorf("listing %.1fms, scan %.1fms; the listings alone took %.0fms", p.ListingMs, p.ScanMs, levels)
+	}
+	sum := p.ScanMs + p.DriveSpaceMs + p.OutputMs
+	if sum > total || sum < total*0.95-5 {
+		t.Errorf("phases %+v sum to %.1fms, the run took %.1fms", p, sum, total)
+	}
+	if p.OutputMs < 0 || p.DriveSpaceMs < 0 || p.StatMs < 0 {
+		t.Errorf("negative phase in %+v", p)
+	}
+	if line := sc.phasesLine(dsc); !strings.HasPrefix(line, "Phases: scan ") {
+		t.Errorf("summary line %q", line)
+	}
+}
+
+// ----- extensions -----
+
+func TestExtKey(t *testing.T) {
+	for _, tt := range []struct{ path, ext string }{
+		{"clip.MP4", ".mp4"},
+		{"clip.mp4", ".mp4"},
+		{filepath.Join("Videos", "Clip.Mp4"), ".mp4"},
+		{"archive.tar.GZ", ".gz"},
+		{"Makefile", ""},
+		{"trailing.", ""},
+		{".gitignore", ""}, // a name, not an extension
+		{".config.JSON", ".json"},
+		{filepath.Join("dir.d", "noext"), ""}, // the parent's dot doesn't count
+	} {
+		if got := extKey(tt.path); got != tt.ext {
+			t.Errorf("extKey(%q) = %q, want %q", tt.path, got, tt.ext)
+		}
+	}
+}
+
+// -find-ext matches an extension whatever its case, in the spec or on
+// disk, and never a file without one.
+func TestFindExtCase(t *testing.T) {
+	root := writeTree(t, map[string]int{"a.MP4": 30, "b.mp4": 20, "mp4": 10, ".mp4": 10, "c.mp4.bak": 10})
+	cfg := testCfg()
+	cfg.find = newFindLog("MP4", 0)
+	sc := startScan(context.Background(), []string{root}, cfg)
+	sc.wait()
+	var got []string
+	for _, it := range sc.cfg.find.sorted() {
+		got = append(got, filepath.Base(it.Path))
+	}
+	if want := []string{"a.MP4", "b.mp4"}; !slices.Equal(got, want) {
+		t.Errorf("matches %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/sys/windows"
+)
+
+// ########### NTFS MFT: VOLUME ##################
+// -ntfs-mft sizes a whole NTFS volume root (C:\) from its Master File
+// Table, read from \\.\C: in one sequential pass, instead of opening and
+// listing every directory. Reading the raw volume needs administrator
+// rights. Any problem (not NTFS, access denied, a record that doesn't
+// parse, an option the MFT can't answer) makes the caller fall back to the
+// normal walk with a note.
+//
+// Differences from the walk: a hard-linked file is counted once rather
+// than once per name, and directories the walk can't open (System Volume
+// Information, other accounts' profiles) are counted too.
+
+// Records below mftFirstUser are NTFS metadata ($MFT, $LogFile, $Extend,
+// ...), which directory listings don't show; record 5 is the root.
+const (
+	mftRoot      = 5
+	mftFirstUser = 24
+)
+
+// mftVolume: an open volume and where its MFT records are.
+type mftVolume struct {
+	f          *os.File
+	cluster    int64 // bytes per cluster
+	recordSize int64
+	extents    []mftExtent // the $MFT data, in record order
+	records    int64
+}
+
+// mftExtent: a run of the $MFT data on the volume, in bytes.
+type mftExtent struct{ offset, length int64 }
+
+var errNotNTFS = errors.New("not an NTFS volume")
+
+// openMFT reads root's boot sector and the $MFT's own record, which
+// says where the rest of the table lies.
+func openMFT(root string) (*mftVolume, error) {
+	f, err := os.Open(`\\.\` + strings.TrimRight(root, `\`))
+	if err != nil {
+		return nil, err
+	}
+	v := &mftVolume{f: f}
+	boot := make([]byte, 4096) // a whole sector on 4Kn disks too
+	if _, err := f.ReadAt(boot, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if string(boot[3:11]) != "NTFS    " {
+		f.Close()
+		return nil, errNotNTFS
+	}
+	sector := int64(binary.LittleEndian.Uint16(boot[0x0B:]))
+	spc := int64(boot[0x0D])
+	if spc > 0x80 {
+		spc = 1 << (256 - spc)
+	}
+	v.cluster = sector * spc
+	if c := int8(boot[0x40]); c < 0 {
+		v.recordSize = 1 << -c
+	} else {
+		v.recordSize = int64(c) * v.cluster
+	}
+	if v.cluster == 0 || v.recordSize < 512 || v.recordSize%512 != 0 {
+		f.Close()
+		return nil, fmt.Errorf("implausible NTFS geometry (cluster %d, record %d)", v.cluster, v.recordSize)
+	}
+
+	mftAt := int64(binary.LittleEndian.Uint64(boot[0x30:])) * v.cluster
+	buf := make([]byte, max(v.recordSize, v.cluster))
+	if _, err := f.ReadAt(buf, mftAt); err != nil {
+		f.Close()
+		return nil, err
+	}
+	rec := buf[:v.recordSize]
+	if err := mftFixup(rec); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("$MFT record: %w", err)
+	}
+	err = mftAttrs(rec, func(typ uint32, a []byte) error {
+		if typ != 0x80 || a[8] == 0 || a[9] != 0 { // unnamed, non-resident $DATA
+			return nil
+		}
+		runs, err := mftRuns(a)
+		if err != nil {
+			return err
+		}
+		for _, r := range runs {
+			e := mftExtent{r.lcn * v.cluster, r.clusters * v.cluster}
+			if e.length%v.recordSize != 0 {
+				return errors.New("$MFT extent splits a record")
+			}
+			v.extents = append(v.extents, e)
+		}
+		if le64(a, 0x10) == 0 {
+			v.records = le64(a, 0x30) / v.recordSize
+		}
+		return nil
+	})
+	if err == nil && (v.records == 0 || len(v.extents) == 0) {
+		err = errors.New("$MFT record has no data runs")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+// ########### NTFS MFT: RECORDS ##################
+// mftRecord: what one FILE record says about its file. An extension
+// record (base != 0) carries attributes that didn't fit in its base.
+type mftRecord struct {
+	base    uint64
+	dir     bool
+	parent  uint64
+	name    string
+	nameNS  byte // 0 POSIX, 1 Win32, 2 DOS, 3 Win32 and DOS
+	hasName bool
+	size    int64
+	alloc   int64
+	hasData bool
+	mtime   int64 // Unix nanoseconds; 0 if absent
+}
+
+// mftFixup undoes NTFS's update sequence: the last two bytes of every
+// 512-byte stride were swapped out for a check value when written.
+func mftFixup(rec []byte) error {
+	if string(rec[:4]) != "FILE" {
+		return errors.New("bad record signature")
+	}
+	off, n := int(le16(rec, 4)), int(le16(rec, 6))
+	if n < 1 || off+2*n > len(rec) || (n-1)*512 > len(rec) {
+		return errors.New("bad update sequence")
+	}
+	for i := 1; i < n; i++ {
+		end := i*512 - 2
+		if rec[end] != rec[off] || rec[end+1] != rec[off+1] {
+			return errors.New("torn record")
+		}
+		rec[end], rec[end+1] = rec[off+2*i], rec[off+2*i+1]
+	}
+	return nil
+}
+
+// mftAttrs calls f with each attribute of a fixed-up record.
+func mftAttrs(rec []byte, f func(typ uint32, a []byte) error) error {
+	off := int(le16(rec, 0x14))
+	for off+8 <= len(rec) {
+		typ := uint32(le32(rec, off))
+		if typ == 0xFFFFFFFF {
+			return nil
+		}
+		n := int(le32(rec, off+4))
+		if n < 0x18 || off+n > len(rec) {
+			return errors.New("bad attribute length")
+		}
+		if err := f(typ, rec[off:off+n]); err != nil {
+			return err
+		}
+		off += n
+	}
+	return errors.New("attributes run past the record")
+}
+
+// parseRecord decodes an in-use record; ok is false for free records.
+func (v *mftVolume) parseRecord(rec []byte) (r mftRecord, ok bool, err error) {
+	if err := mftFixup(rec); err != nil {
+		return r, false, err
+	}
+	flags := le16(rec, 0x16)
+	if flags&1 == 0 {
+		return r, false, nil
+	}
+	r.dir = flags&2 != 0
+	r.base = uint64(le64(rec, 0x20)) & (1<<48 - 1)
+	err = mftAttrs(rec, func(typ uint32, a []byte) error {
+		resident := a[8] == 0
+		switch typ {
+		case 0x10: // $STANDARD_INFORMATION
+			if val, ok := residentValue(a); ok && len(val) >= 0x10 {
+				ft := windows.Filetime{LowDateTime: uint32(le32(val, 8)), HighDateTime: uint32(le32(val, 12))}
+				r.mtime = ft.Nanoseconds()
+			}
+		case 0x30: // $FILE_NAME
+			val, ok := residentValue(a)
+			if !ok || len(val) < 0x42 {
+				return errors.New("bad $FILE_NAME")
+			}
+			ns := val[0x41]
+			if r.hasName && (ns == 2 || r.nameNS != 2) {
+				return nil // keep the long name; the first of several links
+			}
+			n := int(val[0x40])
+			if 0x42+2*n > len(val) {
+				return errors.New("bad $FILE_NAME length")
+			}
+			u := make([]uint16, n)
+			for i := range u {
+				u[i] = le16(val, 0x42+2*i)
+			}
+			r.parent = uint64(le64(val, 0)) & (1<<48 - 1)
+			r.name, r.nameNS, r.hasName = string(utf16.Decode(u)), ns, true
+		case 0x80: // $DATA; named streams aren't counted, as in the walk
+			if a[9] != 0 {
+				return nil
+			}
+			if resident {
+				val, _ := residentValue(a)
+				r.size, r.alloc, r.hasData = int64(len(val)), roundUp(int64(len(val)), v.cluster), true
+				return nil
+			}
+			if len(a) < 0x40 || le64(a, 0x10) != 0 {
+				return nil // a later piece of a fragmented stream; sizes live in the first
+			}
+			r.size, r.alloc, r.hasData = le64(a, 0x30), le64(a, 0x28), true
+			if le16(a, 0x0C)&0x8001 != 0 && len(a) >= 0x48 { // compressed or sparse
+				r.alloc = le64(a, 0x40)
+			}
+		}
+		return nil
+	})
+	return r, err == nil, err
+}
+
+// residentValue: a resident attribute's value.
+func residentValue(a []byte) ([]byte, bool) {
+	if a[8] != 0 || len(a) < 0x18 {
+		return nil, false
+	}
+	n, off := int(le32(a, 0x10)), int(le16(a, 0x14))
+	if off+n > len(a) {
+		return nil, false
+	}
+	return a[off : off+n], true
+}
+
+// mftRun: one data run; sparse runs are left out.
+type mftRun struct{ lcn, clusters int64 }
+
+// mftRuns decodes a non-resident attribute's mapping pairs.
+func mftRuns(a []byte) ([]mftRun, error) {
+	if len(a) < 0x40 {
+		return nil, errors.New("short non-resident attribute")
+	}
+	p := int(le16(a, 0x20))
+	var runs []mftRun
+	var lcn int64
+	for p < len(a) && a[p] != 0 {
+		nl, no := int(a[p]&0x0F), int(a[p]>>4)
+		p++
+		if nl == 0 || nl > 8 || no > 8 || p+nl+no > len(a) {
+			return nil, errors.New("bad data run")
+		}
+		length := leVar(a[p:p+nl], false)
+		p += nl
+		if no == 0 {
+			continue // sparse
+		}
+		lcn += leVar(a[p:p+no], true)
+		p += no
+		runs = append(runs, mftRun{lcn, length})
+	}
+	return runs, nil
+}
+
+// ########### NTFS MFT: SCAN ##################
+// mftNode: one file or directory, indexed by record number.
+type mftNode struct {
+	parent uint32
+	used   bool
+	dir    bool
+	name   string
+	size   int64 // by the scan's metric
+	mtime  int64
+}
+
+// mftUnsupported: the first option set in cfg that the MFT scan can't
+// honor, or "".
+func mftUnsupported(cfg walkCfg) string {
+	switch {
+	case cfg.metricName != "logical" && cfg.metricName != "allocated":
+		return "-metric=" + cfg.metricName
+	case len(cfg.skipPatterns) > 0:
+		return "-skip"
+	case cfg.skipHidden:
+		return "-skiphidden"
+	case cfg.maxDepth > 0:
+		return "-maxdepth"
+	case cfg.huge != nil:
+		return "-skip-if-entries-over"
+	case cfg.followLinks:
+		return "-followlinks"
+	case cfg.owner != nil || cfg.owners != nil:
+		return "owner options"
+	case cfg.sparse != nil:
+		return "-sparse-only"
+	case len(cfg.filesUnder) > 0:
+		return "-files-under"
+	case cfg.written != nil:
+		return "-written-between"
+	case cfg.find != nil:
+		return "-find-ext"
+	case cfg.subtrees.resuming():
+		return "-resume from a checkpoint"
+	case cfg.fileStats:
+		return "-columns=files"
+	case cfg.mountDirs:
+		return "-include-mountpoints-as-dirs"
+	case cfg.links != nil || cfg.special != nil || cfg.sparseFiles != nil || cfg.names != nil || cfg.caches != nil || cfg.rollups != nil:
+		return "per-entry reports"
+	case cfg.children != nil || cfg.concTop != nil || cfg.changedTop != nil:
+		return "per-directory reports"
+	case cfg.index != nil || cfg.sqlite != nil:
+		return "-index-serve or -sqlite"
+	}
+	return ""
+}
+
+// isVolumeRootPath: "C:\" and the like.
+func isVolumeRootPath(p string) bool {
+	return len(p) == 3 && p[1] == ':' && (p[2] == '\\' || p[2] == '/')
+}
+
+// mftScan sizes the volume root from its MFT, pushing into the heaps and
+// stats like a walk would, and returns the root's total. Nothing is
+// pushed unless the whole table was read.
+func mftScan(root string, cfg walkCfg, fileTop, dirTop *minHeap, s *stats) (int64, error) {
+	if why := mftUnsupported(cfg); why != "" {
+		return 0, fmt.Errorf("not supported with %s", why)
+	}
+	v, err := openMFT(root)
+	if err != nil {
+		return 0, err
+	}
+	defer v.f.Close()
+	if v.records > 1<<32-1 {
+		return 0, fmt.Errorf("%d records", v.records)
+	}
+	nodes, err := v.readNodes(cfg.metricName == "allocated")
+	if err != nil {
+		return 0, err
+	}
+	return mftTotals(root, nodes, cfg, fileTop, dirTop, s), nil
+}
+
+// readNodes reads every record, extents in order, a few MiB at a time.
+func (v *mftVolume) readNodes(alloc bool) ([]mftNode, error) {
+	nodes := make([]mftNode, v.records)
+	chunk := (4 << 20) / v.recordSize * v.recordSize
+	var num int64
+	for _, e := range v.extents {
+		for off := int64(0); off < e.length && num < v.records; off += chunk {
+			n := min(chunk, e.length-off)
+			buf := make([]byte, n)
+			if _, err := v.f.ReadAt(buf, e.offset+off); err != nil {
+				return nil, err
+			}
+			for p := int64(0); p+v.recordSize <= n && num < v.records; p, num = p+v.recordSize, num+1 {
+				rec := buf[p : p+v.recordSize]
+				if string(rec[:4]) != "FILE" {
+					continue // never used, or zeroed
+				}
+				r, ok, err := v.parseRecord(rec)
+				if err != nil {
+					return nil, fmt.Errorf("record %d: %w", num, err)
+				}
+				if !ok {
+					continue
+				}
+				v.merge(nodes, num, r, alloc)
+			}
+		}
+	}
+	if num < v.records {
+		return nil, fmt.Errorf("read %d of %d records", num, v.records)
+	}
+	return nodes, nil
+}
+
+// merge folds record num into nodes; an extension record adds to its base.
+func (v *mftVolume) merge(nodes []mftNode, num int64, r mftRecord, alloc bool) {
+	i := num
+	if r.base != 0 {
+		i = int64(r.base)
+		if i >= int64(len(nodes)) {
+			return
+		}
+	} else {
+		nodes[i].used, nodes[i].dir, nodes[i].mtime = true, r.dir, r.mtime
+	}
+	n := &nodes[i]
+	if r.hasName && (n.name == "" || r.nameNS != 2) {
+		n.name, n.parent = r.name, uint32(r.parent)
+	}
+	if r.hasData && !n.dir {
+		n.size = r.size
+		if alloc {
+			n.size = r.alloc
+		}
+	}
+}
+
+// mftTotals: directory totals from the parent links, deepest first, and
+// the items for the heaps. Records not connected to the root (metadata,
+// orphans) are left out.
+func mftTotals(root string, nodes []mftNode, cfg walkCfg, fileTop, dirTop *minHeap, s *stats) int64 {
+	const unknown, orphan, visiting = -1, -2, -3
+	depth := make([]int32, len(nodes))
+	for i := range depth {
+		depth[i] = unknown
+	}
+	depth[mftRoot] = 0
+	var chain []int
+	var maxDepth int32
+	for i := range nodes {
+		// Climb to a record of known depth, then number the way back down.
+		chain = chain[:0]
+		j := i
+		for depth[j] == unknown {
+			if !nodes[j].used || (j < mftFirstUser && j != mftRoot) || int(nodes[j].parent) >= len(nodes) {
+				depth[j] = orphan
+				break
+			}
+			depth[j] = visiting
+			chain = append(chain, j)
+			j = int(nodes[j].parent)
+		}
+		d := depth[j]
+		if d == visiting {
+			d = orphan // a parent loop
+		}
+		for k := len(chain) - 1; k >= 0; k-- {
+			if d != orphan {
+				d++
+			}
+			depth[chain[k]] = d
+		}
+		maxDepth = max(maxDepth, depth[i])
+	}
+
+	byDepth := make([][]int32, maxDepth+1)
+	for i, d := range depth {
+		if d > 0 {
+			byDepth[d] = append(byDepth[d], int32(i))
+		}
+	}
+	aggs := make(map[int32]*dirAgg)
+	agg := func(i int32) *dirAgg {
+		a := aggs[i]
+		if a == nil {
+			a = &dirAgg{mtime: unixNano(nodes[i].mtime)}
+			aggs[i] = a
+		}
+		return a
+	}
+	for d := maxDepth; d > 0; d-- {
+		for _, i := range byDepth[d] {
+			n, p := &nodes[i], agg(int32(nodes[i].parent))
+			if n.dir {
+				a := agg(i)
+				p.add(*a)
+				p.childDir("", a.size, a.modified())
+				atomic.AddInt64(&s.dirsSeen, 1)
+				continue
+			}
+			p.add(dirAgg{size: n.size, maxFile: n.size, files: 1})
+			p.child("", n.size, unixNano(n.mtime))
+			atomic.AddInt64(&s.filesSeen, 1)
+			atomic.AddInt64(&s.bytesSeen, n.size)
+		}
+	}
+	atomic.AddInt64(&s.dirsSeen, 1) // the root
+
+	paths := map[int32]string{mftRoot: root}
+	var path func(i int32) string
+	path = func(i int32) string {
+		if p, ok := paths[i]; ok {
+			return p
+		}
+		p := path(int32(nodes[i].parent))
+		if !strings.HasSuffix(p, `\`) {
+			p += `\`
+		}
+		p += nodes[i].name
+		if nodes[i].dir {
+			paths[i] = p
+		}
+		return p
+	}
+	for d := int32(1); d <= maxDepth; d++ {
+		for _, i := range byDepth[d] {
+			n := &nodes[i]
+			var parent int64
+			if cfg.parentPct {
+				parent = agg(int32(n.parent)).size
+			}
+			if !n.dir {
+				if fileTop.wouldAccept(n.size) {
+					fileTop.push(item{Path: path(i), Size: n.size, Parent: parent, Modified: unixNano(n.mtime)})
+				}
+				continue
+			}
+			a := agg(i)
+			if !dirTop.wouldAccept(a.size) {
+				continue
+			}
+			if it, ok := dirItem(path(i), int(d), *a, cfg); ok {
+				it.Parent = parent
+				dirTop.push(it)
+			}
+		}
+	}
+	return agg(mftRoot).size
+}
+
+// ########### NTFS MFT: BYTES ##################
+func le16(b []byte, off int) uint16 { return binary.LittleEndian.Uint16(b[off:]) }
+func le32(b []byte, off int) int64  { return int64(binary.LittleEndian.Uint32(b[off:])) }
+func le64(b []byte, off int) int64  { return int64(binary.LittleEndian.Uint64(b[off:])) }
+
+// leVar: a little-endian integer of len(b) bytes, sign-extended if signed.
+func leVar(b []byte, signed bool) int64 {
+	var v int64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | int64(b[i])
+	}
+	if signed && len(b) < 8 && b[len(b)-1]&0x80 != 0 {
+		v -= 1 << (8 * len(b))
+	}
+	return v
+}
+
+// unixNano: t as a time, zero for 0.
+func unixNano(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+// roundUp: n rounded up to a multiple of unit.
+func roundUp(n, unit int64) int64 {
+	return (n + unit - 1) / unit * unit
+}
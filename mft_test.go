@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+// Byte-level fixtures for the MFT record parser, laid out as NTFS writes
+// them: 1024-byte FILE records with the update sequence array at 0x30 and
+// the first attribute at 0x38.
+
+const testRecordSize = 1024
+
+// fileRecord: an in-use FILE record holding attrs, for record base (0 for
+// a base record), before the update sequence is applied.
+func fileRecord(dir bool, base uint64, attrs ...[]byte) []byte {
+	rec := make([]byte, testRecordSize)
+	copy(rec, "FILE")
+	binary.LittleEndian.PutUint16(rec[4:], 0x30)                 // update sequence offset
+	binary.LittleEndian.PutUint16(rec[6:], testRecordSize/512+1) // check value + one per stride
+	binary.LittleEndian.PutUint16(rec[0x14:], 0x38)              // first attribute
+	flags := uint16(1)
+	if dir {
+		flags |= 2
+	}
+	binary.LittleEndian.PutUint16(rec[0x16:], flags)
+	binary.LittleEndian.PutUint64(rec[0x20:], base)
+	off := 0x38
+	for _, a := range attrs {
+		off += copy(rec[off:], a)
+	}
+	binary.LittleEndian.PutUint32(rec[off:], 0xFFFFFFFF)
+	return rec
+}
+
+// protect applies the update sequence: the last two bytes of each stride
+// move into the array and the check value takes their place.
+func protect(rec []byte, check uint16) []byte {
+	off := int(binary.LittleEndian.Uint16(rec[4:]))
+	binary.LittleEndian.PutUint16(rec[off:], check)
+	for i := 1; i*512 <= len(rec); i++ {
+		end := i*512 - 2
+		copy(rec[off+2*i:], rec[end:end+2])
+		binary.LittleEndian.PutUint16(rec[end:], check)
+	}
+	return rec
+}
+
+// attr: an attribute of type typ with header bytes hdr (from 0x08 on),
+// padded to 8 bytes, its length filled in.
+func attr(typ uint32, hdr []byte) []byte {
+	a := make([]byte, (8+len(hdr)+7)&^7)
+	binary.LittleEndian.PutUint32(a, typ)
+	binary.LittleEndian.PutUint32(a[4:], uint32(len(a)))
+	copy(a[8:], hdr)
+	return a
+}
+
+// resident: a resident attribute holding val.
+func resident(typ uint32, val []byte) []byte {
+	hdr := make([]byte, 0x10+len(val))
+	binary.LittleEndian.PutUint32(hdr[0x08:], uint32(len(val))) // value length at 0x10
+	binary.LittleEndian.PutUint16(hdr[0x0C:], 0x18)             // value offset at 0x14
+	copy(hdr[0x10:], val)
+	return attr(typ, hdr)
+}
+
+// nonResident: a non-resident unnamed $DATA piece starting at vcn, with
+// the sizes (only read from the first piece) and mapping pairs runs.
+func nonResident(vcn int64, flags uint16, alloc, size, compressed int64, runs []byte) []byte {
+	hdr := make([]byte, 0x40+len(runs)+1)
+	hdr[0] = 1                                               // non-resident
+	binary.LittleEndian.PutUint16(hdr[0x04:], flags)         // 0x0C
+	binary.LittleEndian.PutUint64(hdr[0x08:], uint64(vcn))   // 0x10
+	binary.LittleEndian.PutUint16(hdr[0x18:], 0x48)          // runs at 0x20
+	binary.LittleEndian.PutUint64(hdr[0x20:], uint64(alloc)) // 0x28
+	binary.LittleEndian.PutUint64(hdr[0x28:], uint64(size))  // 0x30
+	binary.LittleEndian.PutUint64(hdr[0x30:], uint64(size))  // 0x38, initialized
+	binary.LittleEndian.PutUint64(hdr[0x38:], uint64(compressed))
+	copy(hdr[0x40:], runs)
+	return attr(0x80, hdr)
+}
+
+// fileName: a $FILE_NAME attribute for name in namespace ns under parent.
+func fileName(parent uint64, ns byte, name string) []byte {
+	u := utf16.Encode([]rune(name))
+	val := make([]byte, 0x42+2*len(u))
+	binary.LittleEndian.PutUint64(val, parent|5<<48) // sequence number in the top bits
+	val[0x40], val[0x41] = byte(len(u)), ns
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(val[0x42+2*i:], c)
+	}
+	return resident(0x30, val)
+}
+
+// standardInfo: $STANDARD_INFORMATION with the given FILETIME as mtime.
+func standardInfo(ft uint64) []byte {
+	val := make([]byte, 0x48)
+	binary.LittleEndian.PutUint64(val[8:], ft)
+	return resident(0x10, val)
+}
+
+func TestMFTFixup(t *testing.T) {
+	rec := fileRecord(false, 0)
+	copy(rec[510:], "ab")
+	copy(rec[1022:], "cd")
+	want := bytes.Clone(rec)
+	protect(rec, 0x0707)
+	if bytes.Equal(rec, want) {
+		t.Fatal("protect changed nothing")
+	}
+	if err := mftFixup(rec); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec[510:512], want[510:512]) || !bytes.Equal(rec[1022:], want[1022:]) {
+		t.Errorf("stride ends %q %q, want ab cd", rec[510:512], rec[1022:])
+	}
+
+	for name, bad := range map[string]func([]byte){
+		"torn second stride": func(r []byte) { r[1022] ^= 0xFF },
+		"signature":          func(r []byte) { copy(r, "BAAD") },
+		"no sequence":        func(r []byte) { binary.LittleEndian.PutUint16(r[6:], 0) },
+		"sequence too long":  func(r []byte) { binary.LittleEndian.PutUint16(r[6:], 9) },
+		"array past the end": func(r []byte) { binary.LittleEndian.PutUint16(r[4:], testRecordSize-2) },
+	} {
+		r := protect(fileRecord(false, 0), 0x0707)
+		bad(r)
+		if err := mftFixup(r); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestMFTRuns(t *testing.T) {
+	runs := []byte{
+		0x31, 0x10, 0x00, 0x10, 0x00, // 16 clusters at +0x1000
+		0x01, 0x08, // 8 sparse clusters: no offset, LCN unchanged
+		0x11, 0x04, 0xF0, // 4 clusters at -16
+		0x12, 0x00, 0x01, 0x7F, // 256 clusters at +0x7F: a two-byte length
+		0x00,
+	}
+	a := nonResident(0, 0, 0, 0, 0, runs)
+	got, err := mftRuns(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mftRun{{0x1000, 16}, {0x1000 - 16, 4}, {0x1000 - 16 + 0x7F, 256}}
+	if !slices.Equal(got, want) {
+		t.Errorf("runs %+v, want %+v", got, want)
+	}
+
+	for name, bad := range map[string][]byte{
+		"no length":       {0x10, 0x01, 0x00},
+		"length too long": {0x19, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 0x00},
+		"truncated":       {0x31, 0x10},
+	} {
+		a := nonResident(0, 0, 0, 0, 0, nil)
+		a = append(a[:0x48], bad...) // the pairs run to the attribute's end
+		if _, err := mftRuns(a); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := mftRuns(make([]byte, 0x20)); err == nil {
+		t.Error("short attribute: no error")
+	}
+}
+
+func TestMFTLeVar(t *testing.T) {
+	for _, c := range []struct {
+		b      []byte
+		signed bool
+		want   int64
+	}{
+		{[]byte{0xF0}, true, -16},
+		{[]byte{0xF0}, false, 0xF0},
+		{[]byte{0x00, 0x80}, true, -0x8000},
+		{[]byte{0xFF, 0x7F}, true, 0x7FFF},
+		{[]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, true, -1<<63 + 1},
+	} {
+		if got := leVar(c.b, c.signed); got != c.want {
+			t.Errorf("leVar(% x, %v) = %d, want %d", c.b, c.signed, got, c.want)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	v := &mftVolume{cluster: 4096}
+	const ft = 133000000000000000 // a FILETIME in 2022
+	rec := protect(fileRecord(false, 0,
+		standardInfo(ft),
+		fileName(40, 2, "REPORT~1.PDF"), // DOS name first, as NTFS often stores it
+		fileName(40, 1, "report for 2022.pdf"),
+		nonResident(0, 0x8000, 65536, 70000, 12288, []byte{0x11, 0x10, 0x20, 0x00}), // sparse
+	), 0x0101)
+	r, ok, err := v.parseRecord(rec)
+	if err != nil || !ok {
+		t.Fatalf("parseRecord: ok %v, %v", ok, err)
+	}
+	if r.name != "report for 2022.pdf" || r.nameNS != 1 || r.parent != 40 || r.dir || r.base != 0 {
+		t.Errorf("name %q ns %d parent %d dir %v base %d", r.name, r.nameNS, r.parent, r.dir, r.base)
+	}
+	if !r.hasData || r.size != 70000 || r.alloc != 12288 {
+		t.Errorf("data %v size %d alloc %d; want 70000, 12288 (sparse: the compressed size)", r.hasData, r.size, r.alloc)
+	}
+	if want := int64(ft-116444736000000000) * 100; r.mtime != want {
+		t.Errorf("mtime %d, want %d", r.mtime, want)
+	}
+
+	small := protect(fileRecord(false, 0, fileName(5, 3, "a.txt"), resident(0x80, []byte("hello"))), 1)
+	if r, _, err := v.parseRecord(small); err != nil || r.size != 5 || r.alloc != 4096 {
+		t.Errorf("resident data: size %d alloc %d, %v", r.size, r.alloc, err)
+	}
+
+	free := fileRecord(false, 0)
+	binary.LittleEndian.PutUint16(free[0x16:], 0)
+	if _, ok, err := v.parseRecord(protect(free, 1)); ok || err != nil {
+		t.Errorf("free record: ok %v, %v", ok, err)
+	}
+
+	bad := fileRecord(false, 0, attr(0x30, make([]byte, 0x10))) // a $FILE_NAME with no value
+	if _, _, err := v.parseRecord(protect(bad, 1)); err == nil {
+		t.Error("bad $FILE_NAME: no error")
+	}
+}
+
+// A file whose attributes overflow into an extension record gets its data
+// size from the extension, whichever record is read first; a later piece
+// of a fragmented stream doesn't overwrite the first's sizes.
+func TestMFTMergeExtension(t *testing.T) {
+	v := &mftVolume{cluster: 4096}
+	parse := func(rec []byte) mftRecord {
+		t.Helper()
+		r, ok, err := v.parseRecord(protect(rec, 0x0202))
+		if err != nil || !ok {
+			t.Fatalf("parseRecord: ok %v, %v", ok, err)
+		}
+		return r
+	}
+	base := func() mftRecord { return parse(fileRecord(false, 0, fileName(mftRoot, 1, "disk.vhdx"))) }
+	ext := func() mftRecord {
+		return parse(fileRecord(false, 30,
+			fileName(mftRoot, 2, "DISK~1.VHD"),
+			nonResident(0, 0, 1<<30, 1<<30-100, 0, []byte{0x11, 0x01, 0x01, 0x00}),
+			nonResident(4096, 0, 0, 0, 0, []byte{0x11, 0x01, 0x02, 0x00}),
+		))
+	}
+
+	for _, order := range []string{"base first", "extension first"} {
+		for _, alloc := range []bool{false, true} {
+			nodes := make([]mftNode, 40)
+			if order == "base first" {
+				v.merge(nodes, 30, base(), alloc)
+				v.merge(nodes, 31, ext(), alloc)
+			} else {
+				v.merge(nodes, 31, ext(), alloc)
+				v.merge(nodes, 30, base(), alloc)
+			}
+			want := int64(1<<30 - 100)
+			if alloc {
+				want = 1 << 30
+			}
+			n := nodes[30]
+			if !n.used || n.name != "disk.vhdx" || n.parent != mftRoot || n.size != want {
+				t.Errorf("%s, alloc %v: %+v, want disk.vhdx of %d", order, alloc, n, want)
+			}
+			if nodes[31].used {
+				t.Errorf("%s: the extension record became a node of its own", order)
+			}
+		}
+	}
+
+	nodes := make([]mftNode, 10)
+	v.merge(nodes, 5, ext(), false) // base 30 is past the table
+	if !slices.Equal(nodes, make([]mftNode, 10)) {
+		t.Errorf("an extension of a record past the table changed %+v", nodes)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ########### NETWORK: UNC CREDENTIALS ##################
+// -net-user connects each UNC share named in -roots with
+// WNetAddConnection2 before the scan and disconnects it afterwards, for
+// shares that need other credentials than the logged-on session. The
+// password comes from GOSIZE_NET_PASS or the console, never a flag, so it
+// doesn't show up in the process list or a -print-config dump.
+var (
+	modMpr                    = windows.NewLazySystemDLL("mpr.dll")
+	procWNetAddConnection2    = modMpr.NewProc("WNetAddConnection2W")
+	procWNetCancelConnection2 = modMpr.NewProc("WNetCancelConnection2W")
+)
+
+// netResource: NETRESOURCEW.
+type netResource struct {
+	scope, typ, displayType, usage uint32
+	localName, remoteName          *uint16
+	comment, provider              *uint16
+}
+
+const (
+	resourceTypeDisk = 0x1 // RESOURCETYPE_DISK
+	connectTemporary = 0x4 // CONNECT_TEMPORARY: don't remember the connection
+)
+
+// netConns: the shares this run connected, and so must disconnect.
+type netConns struct {
+	shares []string // \\server\share, no trailing separator
+}
+
+// connectShares: connects every distinct UNC share among roots as user.
+// On failure the shares connected so far are disconnected again.
+func connectShares(roots []string, user, pass string) (*netConns, error) {
+	nc := &netConns{}
+	seen := make(map[string]bool)
+	for _, r := range roots {
+		vol := volumeRoot(r)
+		if !strings.HasPrefix(vol, `\\`) {
+			continue
+		}
+		share := strings.TrimSuffix(vol, `\`)
+		if seen[strings.ToLower(share)] {
+			continue
+		}
+		seen[strings.ToLower(share)] = true
+
+		if err := addConnection(share, user, pass); err != nil {
+			nc.close()
+			if errors.Is(err, windows.ERROR_SESSION_CREDENTIAL_CONFLICT) {
+				return nil, fmt.Errorf("connect %s: already connected with other credentials (see `net use %s /delete`)", share, share)
+			}
+			return nil, fmt.Errorf("connect %s as %s: %w", share, user, err)
+		}
+		nc.shares = append(nc.shares, share)
+	}
+	return nc, nil
+}
+
+func addConnection(share, user, pass string) error {
+	remote, err := windows.UTF16PtrFromString(share)
+	if err != nil {
+		return err
+	}
+	u, err := windows.UTF16PtrFromString(user)
+	if err != nil {
+		return err
+	}
+	p, err := windows.UTF16PtrFromString(pass)
+	if err != nil {
+		return err
+	}
+	nr := netResource{typ: resourceTypeDisk, remoteName: remote}
+	r, _, _ := procWNetAddConnection2.Call(uintptr(unsafe.Pointer(&nr)),
+		uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(u)), connectTemporary)
+	if r != 0 {
+		return windows.Errno(r)
+	}
+	return nil
+}
+
+// close disconnects the shares, even if files on them are still open.
+func (nc *netConns) close() {
+	for _, share := range nc.shares {
+		if p, err := windows.UTF16PtrFromString(share); err == nil {
+			procWNetCancelConnection2.Call(uintptr(unsafe.Pointer(p)), 0, 1)
+		}
+	}
+	nc.shares = nil
+}
+
+// envNetPass: the environment variable holding the -net-user password.
+const envNetPass = "GOSIZE_NET_PASS"
+
+// promptPassword: reads a password from the console with echo off, for
+// -net-user without GOSIZE_NET_PASS.
+func promptPassword(user string) (string, error) {
+	fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+	h := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err == nil {
+		windows.SetConsoleMode(h, mode&^windows.ENABLE_ECHO_INPUT)
+		defer windows.SetConsoleMode(h, mode)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ########### NETWORK: ERRORS & FRONTIER ##################
+// isNetworkError: the share or the connection to it went away, as opposed
+// to a problem with one path on it. Such directories are worth retrying.
+func isNetworkError(err error) bool {
+	for _, e := range []windows.Errno{
+		windows.ERROR_NETNAME_DELETED,
+		windows.ERROR_BAD_NETPATH,
+		windows.ERROR_UNEXP_NET_ERR,
+		windows.ERROR_NETWORK_UNREACHABLE,
+		windows.ERROR_SEM_TIMEOUT,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeDir: one directory in a resume state file. For a partial one the
+// aggregate holds what was counted so far.
+type resumeDir struct {
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+	Root  int    `json:"root"` // index into resumeState.Roots
+	savedAgg
+}
+
+// netLog: directories whose listing failed with a network error (the
+// frontier), and the ancestors whose totals are missing them.
+type netLog struct {
+	mu       sync.Mutex
+	frontier []resumeDir
+	partial  []resumeDir
+}
+
+func (n *netLog) lost(path string, depth int) {
+	n.mu.Lock()
+	n.frontier = append(n.frontier, resumeDir{Path: path, Depth: depth})
+	n.mu.Unlock()
+}
+
+func (n *netLog) incomplete(path string, depth int, agg dirAgg) {
+	n.mu.Lock()
+	n.partial = append(n.partial, resumeDir{Path: path, Depth: depth, savedAgg: saveAgg(agg)})
+	n.mu.Unlock()
+}
+
+// pending: number of directories left unread.
+func (n *netLog) pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.frontier)
+}
+
+// ########### NETWORK: RESUME STATE ##################
+// resumeState: what -resume=FILE saves when network errors leave part of
+// the tree unread: the unread directories, the partial totals of their
+// ancestors, and the heaps and counters so far. A resumed run lists only
+// the frontier and adds what it finds to those ancestors.
+type resumeState struct {
+	SchemaVersion string `json:"schemaVersion"`
+	Kind          string `json:"kind,omitempty"` // checkpointKind for a -checkpoint file
+	RunID         string `json:"runId"`          // kept by the resumed run
+
+	Roots      []string    `json:"roots"`
+	RootErrors []string    `json:"rootErrors"` // "" for ok or retried roots
+	Frontier   []resumeDir `json:"frontier"`
+	Partial    []resumeDir `json:"partial"`
+	Files      []item      `json:"files"`
+	Dirs       []item      `json:"dirs,omitempty"` // empty with -combined: Files holds both
+	FilesSeen  int64       `json:"filesSeen"`
+	DirsSeen   int64       `json:"dirsSeen"`
+	Errors     int64       `json:"errors"`
+	SkippedBy  []int64     `json:"skippedBy"`
+	SkipBytes  []int64     `json:"skippedBytes"`
+	Completed  []doneDir   `json:"completed,omitempty"` // -checkpoint: directories finished before it was saved
+}
+
+// loadResume: reads a state file; a missing file is (nil, nil).
+func loadResume(path string) (*resumeState, error) {
+	b, err := readMaybeGzip(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st resumeState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := checkSchema(st.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// saveResume: writes the finished scan's frontier to path, or removes path
+// when nothing is left to resume. Returns the number of pending directories.
+func (sc *scan) saveResume(path string) (int, error) {
+	s := &sc.stats
+	s.net.mu.Lock()
+	frontier, partial := s.net.frontier, s.net.partial
+	s.net.mu.Unlock()
+	if len(frontier) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	st := resumeState{
+		SchemaVersion: schemaVersion,
+		RunID:         sc.runID,
+		Roots:         sc.roots,
+		Files:         sc.fileTop.sortedDesc(),
+		FilesSeen:     atomic.LoadInt64(&s.filesSeen),
+		DirsSeen:      atomic.LoadInt64(&s.dirsSeen),
+		Errors:        atomic.LoadInt64(&s.errors) - atomic.LoadInt64(&s.netErrors),
+	}
+	if !sc.cfg.combined {
+		st.Dirs = sc.dirTop.sortedDesc()
+	}
+	for _, err := range sc.rootErrs {
+		msg := ""
+		if err != nil && !isNetworkError(err) {
+			msg = err.Error()
+		}
+		st.RootErrors = append(st.RootErrors, msg)
+	}
+	for r := skipReason(0); r < numSkipReasons; r++ {
+		st.SkippedBy = append(st.SkippedBy, atomic.LoadInt64(&s.skippedBy[r]))
+		st.SkipBytes = append(st.SkipBytes, atomic.LoadInt64(&s.skippedBytes[r]))
+	}
+	for _, d := range frontier {
+		d.Root = rootIndex(sc.roots, d.Path)
+		st.Frontier = append(st.Frontier, d)
+	}
+	for _, d := range partial {
+		d.Root = rootIndex(sc.roots, d.Path)
+		st.Partial = append(st.Partial, d)
+	}
+
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(frontier), writeFileAtomic(path, b)
+}
+
+// rootIndex: the root that path lies under (the longest matching prefix).
+func rootIndex(roots []string, path string) int {
+	best, bestLen := 0, -1
+	lp := strings.ToLower(path)
+	for i, r := range roots {
+		lr := strings.ToLower(r)
+		if (lp == strings.TrimRight(lr, `\/`) || strings.HasPrefix(lp, lr)) && len(lr) > bestLen {
+			best, bestLen = i, len(lr)
+		}
+	}
+	return best
+}
+
+// isChildOf: whether path is a direct subdirectory of dir.
+func isChildOf(path, dir string) bool {
+	return pathKey(filepath.Dir(path)) == pathKey(dir)
+}
+
+// resumeScan: like startScan, but starts from a saved state and walks only
+// its frontier. Each root's goroutine lists that root's frontier, then
+// re-ranks its partial ancestors with what was found.
+func resumeScan(ctx context.Context, st *resumeState, cfg walkCfg) *scan {
+	if st.Kind == checkpointKind {
+		return resumeCheckpoint(ctx, st, cfg)
+	}
+	sc := newScan(st.Roots, cfg)
+	ctx = sc.abortable(ctx)
+	cfg = sc.cfg // with the per-scan collectors newScan adds
+	if st.RunID != "" {
+		sc.runID = st.RunID
+	}
+
+	stale := make(map[string]bool, len(st.Partial)) // old, too-small totals
+	for _, d := range st.Partial {
+		stale[d.Path] = true
+	}
+	for _, it := range st.Files {
+		if !(it.IsDir && stale[it.Path]) {
+			sc.fileTop.push(it)
+		}
+	}
+	for _, it := range st.Dirs {
+		if !stale[it.Path] {
+			sc.dirTop.push(it)
+		}
+	}
+	s := &sc.stats
+	s.filesSeen, s.dirsSeen, s.errors = st.FilesSeen, st.DirsSeen, st.Errors
+	for r := skipReason(0); r < numSkipReasons && int(r) < len(st.SkippedBy) && int(r) < len(st.SkipBytes); r++ {
+		s.skippedBy[r], s.skippedBytes[r] = st.SkippedBy[r], st.SkipBytes[r]
+		s.skipped += st.SkippedBy[r]
+	}
+
+	sem := sc.walkerSem()
+	sc.launch(func(i int, root string) error {
+		var rootErr error
+		if i < len(st.RootErrors) && st.RootErrors[i] != "" {
+			rootErr = errors.New(st.RootErrors[i])
+		}
+
+		ancestors := make(map[int]*dirAgg)
+		for j, d := range st.Partial {
+			if d.Root == i {
+				a := d.agg()
+				ancestors[j] = &a
+			}
+		}
+		for _, d := range st.Frontier {
+			if d.Root != i {
+				continue
+			}
+			agg, err := walkDir(ctx, d.Path, d.Depth, cfg, sem, sc.fileTop, sc.dirTop, s)
+			if err != nil {
+				if d.Depth == 0 {
+					rootErr = err
+				}
+				agg = dirAgg{netLost: isNetworkError(err)}
+			} else if d.Depth > 0 {
+				pushDir(sc.dirTop, d.Path, d.Depth, agg, cfg)
+			} else {
+				sc.rootSizes[i] = agg.size
+			}
+			for j, a := range ancestors {
+				if st.Partial[j].Path != d.Path && isUnder(d.Path, st.Partial[j].Path) {
+					a.add(agg)
+					if isChildOf(d.Path, st.Partial[j].Path) {
+						a.childDir(d.Path, agg.size, agg.modified())
+					}
+				}
+			}
+		}
+		// An ancestor's saved largest child may be one of the others, at
+		// its old total.
+		for j, a := range ancestors {
+			for k, c := range ancestors {
+				if isChildOf(st.Partial[k].Path, st.Partial[j].Path) {
+					a.childDir(st.Partial[k].Path, c.size, c.modified())
+				}
+			}
+		}
+
+		for j, a := range ancestors {
+			d := st.Partial[j]
+			if a.netLost {
+				s.net.incomplete(d.Path, d.Depth, *a)
+			}
+			if d.Depth > 0 {
+				pushDir(sc.dirTop, d.Path, d.Depth, *a, cfg)
+			} else {
+				sc.rootSizes[i] = a.size
+			}
+		}
+		return rootErr
+	})
+	return sc
+}
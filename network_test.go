@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestRootIndex(t *testing.T) {
+	roots := []string{`\\srv\share\`, `\\srv\share\deep\`, `C:\Data`}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{`\\srv\share\a\b`, 0},
+		{`\\srv\share`, 0},
+		{`\\srv\share\deep\x`, 1}, // the longest matching root
+		{`\\SRV\Share\Deep`, 1},
+		{`C:\Data\f`, 2},
+		{`C:\Other`, 0}, // under none: the first
+	}
+	for _, tt := range tests {
+		if got := rootIndex(roots, tt.path); got != tt.want {
+			t.Errorf("rootIndex(%q) = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+// dirRows: each ranked directory's size, full size and file count.
+func dirRows(sc *scan) map[string]string {
+	out := make(map[string]string)
+	for _, it := range sc.dirTop.sortedDesc() {
+		out[it.Path] = fmt.Sprint(it.Size, " ", it.total(), " ", it.Files, " ", it.Partial)
+	}
+	return out
+}
+
+// A resumed scan must rank the partial ancestors as a full scan would:
+// with -rank=exclusive that needs their largest subdirectory, which the
+// resume file has to keep along with the size.
+func TestResumeRestoresAggregates(t *testing.T) {
+	root := writeTree(t, map[string]int{
+		"top/a/x/f": 100, "top/a/y/g": 50, "top/a/h": 5, "top/b/k": 30, "c/m": 1,
+	})
+	cfg := testCfg()
+	cfg.rankExclusive = true
+
+	full := startScan(context.Background(), []string{root}, cfg)
+	full.wait()
+	want := dirRows(full)
+
+	failListing(t, filepath.Join(root, "top", "a", "x"), windows.ERROR_NETNAME_DELETED)
+	lost := startScan(context.Background(), []string{root}, cfg)
+	lost.wait()
+	path := filepath.Join(t.TempDir(), "resume.json")
+	if n, err := lost.saveResume(path); err != nil || n != 1 {
+		t.Fatalf("saveResume: %d unread, %v; want 1", n, err)
+	}
+	listDir = os.ReadDir
+
+	st, err := loadResume(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := resumeScan(context.Background(), st, cfg)
+	sc.wait()
+	got := dirRows(sc)
+	for p, w := range want {
+		if got[p] != w {
+			t.Errorf("%s: resumed %q, want %q", p, got[p], w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("resumed ranks %d directories, want %d", len(got), len(want))
+	}
+	if sc.rootSizes[0] != full.rootSizes[0] {
+		t.Errorf("root size %d, want %d", sc.rootSizes[0], full.rootSizes[0])
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ########### RESULT MODEL: VERSIONING ##################
+// schemaVersion: version of every structured output (-json, the control
+// pipe's result, gRPC results, -resume state files). Bump the minor for
+// added fields, the major for renames, removals or changed meanings;
+// readers refuse files from another major.
+const schemaVersion = "1.17"
+
+// schemaMajor: "1" for "1.0".
+func schemaMajor(v string) string {
+	major, _, _ := strings.Cut(v, ".")
+	return major
+}
+
+// checkSchema: nil when a file written at version v can be read by this build.
+func checkSchema(v string) error {
+	if schemaMajor(v) != schemaMajor(schemaVersion) {
+		if v == "" {
+			v = "unversioned"
+		}
+		return fmt.Errorf("written with schema version %s; this build reads %s.x", v, schemaMajor(schemaVersion))
+	}
+	return nil
+}
+
+// newRunID: a random (version 4) UUID identifying one scan.
+func newRunID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ########### RESULT MODEL: JSON ##################
+// jsonRow/jsonResult: shapes the -json output for both lists plus summary.
+// The control pipe's result event carries the same document.
+type jsonRow struct {
+	Rank         int       `json:"rank"`
+	SizeBytes    int64     `json:"sizeBytes"`
+	SizeHuman    string    `json:"sizeHuman"`
+	DrivePercent float64   `json:"drivePercent,omitempty"` // 0 omitted if unknown
+	Drive        string    `json:"drive,omitempty"`        // e.g., "C:\\"
+	Path         string    `json:"path"`
+	LowerBound   bool      `json:"lowerBound,omitempty"` // size excludes levels below -depth-scan
+	Type         string    `json:"type,omitempty"`       // "dir" or "file"; only with -combined
+	IsDir        bool      `json:"isDir"`
+	Ext          string    `json:"ext,omitempty"`             // files: lowercased extension, e.g. ".iso"
+	DriveTotal   uint64    `json:"driveTotalBytes,omitempty"` // what drivePercent is of
+	Tag          string    `json:"tag,omitempty"`             // e.g. "mount"
+	DeltaBytes   *int64    `json:"deltaBytes,omitempty"`      // change since -baseline; absent when new
+	Delta        string    `json:"delta,omitempty"`           // DeltaBytes as "+1.20 GB", or "new"
+	Dir          string    `json:"dir,omitempty"`             // parent of Path; only with -columns=dir
+	Name         string    `json:"name,omitempty"`            // base name of Path; only with -columns=dir
+	ParentPct    float64   `json:"parentPercent,omitempty"`   // share of the containing directory; only with -columns=parent
+	ParentBytes  int64     `json:"parentBytes,omitempty"`     // the containing directory's size; only with -columns=parent
+	Files        *int64    `json:"files,omitempty"`           // dirs: files in the subtree
+	AvgFile      int64     `json:"avgFileBytes,omitempty"`
+	MedianFile   int64     `json:"medianFileBytesApprox,omitempty"` // with -columns=files; within 12.5%
+	SeenAt       time.Time `json:"seenAt"`                          // when the size was measured
+	FileID       string    `json:"fileId,omitempty"`                // -show-id
+	Modified     time.Time `json:"modified,omitzero"`               // files: mtime; dirs: own or direct child's newest mtime
+	Exclusive    *int64    `json:"exclusiveBytes,omitempty"`        // -rank=exclusive: sizeBytes less the largest direct subdirectory; what rank orders by
+}
+
+// jsonVolume: capacity of one volume the result touches, so rows can be
+// re-ranked by share of the drive without the machine at hand.
+type jsonVolume struct {
+	Drive        string `json:"drive"`
+	TotalBytes   uint64 `json:"totalBytes"`
+	FreeBytes    uint64 `json:"freeBytes"`
+	ScannedBytes int64  `json:"scannedBytes"`           // counted under roots on this volume
+	OverCapacity bool   `json:"overCapacity,omitempty"` // scanned exceeds used: something was counted twice
+}
+
+// jsonSkip: one category of the top-level "skipped" object.
+type jsonSkip struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"` // only entries whose size was known when skipped
+}
+
+// jsonRootStatus: whether one root produced results.
+type jsonRootStatus struct {
+	Root    string `json:"root"`
+	OK      bool   `json:"ok"`
+	Size    int64  `json:"sizeBytes"`
+	Aborted bool   `json:"aborted,omitempty"` // stopped early: device removed
+	Invalid bool   `json:"invalid,omitempty"` // missing or unreadable at startup; not scanned
+	Error   string `json:"error,omitempty"`
+}
+
+type jsonResult struct {
+	SchemaVersion string `json:"schemaVersion"`
+	RunID         string `json:"runId"`
+
+	Roots     []string `json:"roots"`
+	TopK      int      `json:"topK"`
+	SizeMode  string   `json:"sizeMode"`         // "apparent" (-metric=logical) or the -metric name
+	RankBy    string   `json:"rankBy,omitempty"` // "exclusive" with -rank=exclusive: directories are ranked by exclusiveBytes
+	Generated string   `json:"generated"`
+	Duration  string   `json:"duration"`
+	Summary   struct {
+		FilesSeen int64            `json:"filesSeen"`
+		DirsSeen  int64            `json:"dirsSeen"`
+		Skipped   int64            `json:"skipped"`
+		Errors    int64            `json:"errors"`
+		NetErrors int64            `json:"netErrors,omitempty"`      // included in errors
+		Denied    int64            `json:"expectedDenied,omitempty"` // not included in errors
+		Unread    int              `json:"unreadDirs,omitempty"`
+		NotOwned  int64            `json:"notOwned,omitempty"`
+		NotSparse int64            `json:"notSparse,omitempty"`        // files left out by -sparse-only
+		Cloud     int64            `json:"cloudFiles,omitempty"`       // cloud placeholders, tagged "cloud"
+		Online    int64            `json:"cloudOnlineBytes,omitempty"` // their bytes not stored locally
+		Vanished  int64            `json:"vanished,omitempty"`         // deleted mid-scan; not included in errors
+		Retyped   int64            `json:"changedType,omitempty"`      // file <-> directory mid-scan; not included in errors
+		Special   map[string]int64 `json:"specialFiles,omitempty"`     // by kind; never sized
+
+		ExcludedDirs   int64 `json:"excludedDirs,omitempty"`   // -report-skipped-bytes
+		ExcludedBytes  int64 `json:"excludedBytes,omitempty"`  // their size...
+		ExcludedApprox bool  `json:"excludedApprox,omitempty"` // ...estimated, not counted
+	} `json:"summary"`
+	RootStatus   []jsonRootStatus    `json:"rootStatus"`
+	Skipped      map[string]jsonSkip `json:"skipped"`
+	OwnerFilter  []string            `json:"ownerFilter,omitempty"`
+	Owners       []ownerUsage        `json:"owners,omitempty"`  // -group-by-owner
+	Special      []specialFile       `json:"special,omitempty"` // -report-special
+	Sparse       *sparseReport       `json:"sparse,omitempty"`  // -report-sparse
+	Links        []linkInfo          `json:"links,omitempty"`   // -report-links
+	SameVolume   []volumeAlias       `json:"sameVolume,omitempty"`
+	Children     []childInfo         `json:"children,omitempty"`     // -children
+	ShowUnder    []string            `json:"showUnder,omitempty"`    // -show-under: directories and files are only those below
+	Changed      []jsonRow           `json:"changed,omitempty"`      // -changed-since
+	Written      *jsonWritten        `json:"written,omitempty"`      // -written-between
+	Breakdown    []breakdownRow      `json:"breakdown,omitempty"`    // -breakdown
+	Caches       []cacheLoc          `json:"caches,omitempty"`       // -caches
+	Rollups      []rollupRow         `json:"rollups,omitempty"`      // -rollup
+	HugeDirs     []hugeDir           `json:"hugeDirs,omitempty"`     // -skip-if-entries-over
+	Concentrated []concentrated      `json:"concentrated,omitempty"` // -concentration
+	Matches      []jsonRow           `json:"matches,omitempty"`      // -find-ext: every matching file, largest first
+	BadNames     []nameIssue         `json:"badNames,omitempty"`     // -max-name-length, -names-ascii
+	Directories  []jsonRow           `json:"directories"`
+	Files        []jsonRow           `json:"files"`
+	Items        []jsonRow           `json:"items,omitempty"`   // -combined: files and dirs in one ranking
+	Config       *effectiveConfig    `json:"config,omitempty"`  // the flags the run was started with; -with-config reruns it
+	Volumes      []jsonVolume        `json:"volumes,omitempty"` // every drive of a root or row
+	Phases       jsonPhases          `json:"phases"`
+}
+
+// jsonWritten: the -written-between range and its per-day bytes.
+type jsonWritten struct {
+	From string       `json:"from"`
+	To   string       `json:"to"` // exclusive
+	Days []writtenDay `json:"days"`
+}
+
+// jsonPhases: where the run's time went, in milliseconds. Listing and
+// stat are summed over all walkers; OutputMs runs up to when the
+// document was built.
+type jsonPhases struct {
+	ScanMs       float64 `json:"scanMs"`
+	ListingMs    float64 `json:"listingMs"`
+	StatMs       float64 `json:"statMs"`
+	DriveSpaceMs float64 `json:"driveSpaceMs"`
+	OutputMs     float64 `json:"outputMs"`
+}
+
+// jsonResult: the -json document for a finished scan.
+func (sc *scan) jsonResult(dsc *driveSpaceCache, splitDir bool) jsonResult {
+	drives := make(map[string]bool)
+	for _, r := range sc.roots {
+		drives[volumeRoot(r)] = true
+	}
+	toRows := func(items []item, split bool) []jsonRow {
+		out := make([]jsonRow, 0, len(items))
+		for i, it := range items {
+			drives[volumeRoot(it.Path)] = true
+			tot := dsc.totalFor(it.Path)
+			size := it.total()
+			pct := 0.0
+			if tot > 0 {
+				pct = (float64(size) / float64(tot)) * 100
+			}
+			row := jsonRow{
+				Rank:         i + 1,
+				SizeBytes:    size,
+				SizeHuman:    humanBytesFixed(size),
+				LowerBound:   it.Partial,
+				DrivePercent: pct,
+				Drive:        volumeRoot(it.Path),
+				Path:         it.Path,
+				Tag:          it.Tag,
+				SeenAt:       it.SeenAt,
+				FileID:       it.ID,
+				Modified:     it.Modified,
+				IsDir:        it.IsDir,
+				DriveTotal:   tot,
+			}
+			if !it.IsDir {
+				row.Ext = extKey(it.Path)
+			}
+			if sc.cfg.combined {
+				row.Type = "file"
+				if it.IsDir {
+					row.Type = "dir"
+				}
+			}
+			if split {
+				row.Dir, row.Name = splitPath(it.Path)
+			}
+			if it.Parent > 0 {
+				row.ParentPct = float64(size) / float64(it.Parent) * 100
+				row.ParentBytes = it.Parent
+			}
+			if it.IsDir {
+				row.Files = &it.Files
+				if it.Files > 0 {
+					row.AvgFile = size / it.Files
+				}
+				if sc.cfg.fileStats && it.Files > 0 {
+					row.MedianFile = it.Median
+				}
+			}
+			if it.Total > 0 {
+				row.Exclusive = &it.Size
+			}
+			if sc.baseline != nil {
+				if d, ok := sc.baseline.delta(it); ok {
+					row.DeltaBytes = &d
+				}
+				row.Delta = sc.baseline.label(it)
+			}
+			out = append(out, row)
+		}
+		return out
+	}
+
+	s := &sc.stats
+	res := jsonResult{
+		SchemaVersion: schemaVersion,
+		RunID:         sc.runID,
+		Roots:         sc.roots,
+		TopK:          sc.cfg.topK,
+		SizeMode:      "apparent",
+		Generated:     time.Now().Format(time.RFC3339),
+		Duration:      sc.elapsed.String(),
+		Config:        sc.cfg.config,
+	}
+	if sc.cfg.metricName != "logical" {
+		res.SizeMode = sc.cfg.metricName
+	}
+	if sc.cfg.rankExclusive {
+		res.RankBy = "exclusive"
+	}
+	if sc.cfg.combined {
+		res.Directories, res.Files = []jsonRow{}, []jsonRow{}
+		res.Items = toRows(sc.fileTop.sortedDesc(), splitDir)
+	} else {
+		res.Directories = toRows(sc.dirTop.sortedDesc(), false)
+		res.Files = toRows(sc.fileTop.sortedDesc(), splitDir)
+	}
+	if sc.cfg.changedTop != nil {
+		res.Changed = toRows(sc.cfg.changedTop.sortedDesc(), false)
+	}
+	if r := sc.cfg.written; r != nil && sc.cfg.writtenDays != nil {
+		res.Written = &jsonWritten{
+			From: r.from.In(r.loc).Format(time.RFC3339),
+			To:   r.to.In(r.loc).Format(time.RFC3339),
+			Days: sc.cfg.writtenDays.rows(),
+		}
+	}
+	res.Summary.FilesSeen = atomic.LoadInt64(&s.filesSeen)
+	res.Summary.DirsSeen = atomic.LoadInt64(&s.dirsSeen)
+	res.Summary.Skipped = atomic.LoadInt64(&s.skipped)
+	res.Summary.Errors = atomic.LoadInt64(&s.errors)
+	res.Summary.NotSparse = atomic.LoadInt64(&s.notSparse)
+	res.Summary.Cloud = atomic.LoadInt64(&s.cloud)
+	res.Summary.Online = atomic.LoadInt64(&s.online)
+	res.Summary.Vanished = atomic.LoadInt64(&s.vanished)
+	res.Summary.Retyped = atomic.LoadInt64(&s.retyped)
+	res.Summary.ExcludedDirs = atomic.LoadInt64(&s.excludedDirs)
+	res.Summary.ExcludedBytes = atomic.LoadInt64(&s.excludedBytes)
+	res.Summary.ExcludedApprox = atomic.LoadInt32(&s.excludedApprox) == 1
+	res.Summary.NetErrors = atomic.LoadInt64(&s.netErrors)
+	res.Summary.Denied = atomic.LoadInt64(&s.denied)
+	res.Summary.Special = s.specialCounts()
+	res.Summary.Unread = s.net.pending()
+	for i, r := range sc.roots {
+		st := jsonRootStatus{Root: r, OK: sc.rootErrs[i] == nil, Size: sc.rootSizes[i], Aborted: errors.Is(sc.rootErrs[i], errDeviceRemoved)}
+		if sc.rootErrs[i] != nil {
+			st.Error = sc.rootErrs[i].Error()
+		}
+		res.RootStatus = append(res.RootStatus, st)
+	}
+	for _, err := range sc.invalidRoots {
+		var re *rootError
+		if errors.As(err, &re) {
+			res.RootStatus = append(res.RootStatus, jsonRootStatus{Root: re.root, Invalid: true, Error: err.Error()})
+		}
+	}
+	res.Skipped = make(map[string]jsonSkip, numSkipReasons)
+	for r := skipReason(0); r < numSkipReasons; r++ {
+		res.Skipped[skipReasonNames[r]] = jsonSkip{
+			Count: atomic.LoadInt64(&s.skippedBy[r]),
+			Bytes: atomic.LoadInt64(&s.skippedBytes[r]),
+		}
+	}
+	if of := sc.cfg.owner; of != nil {
+		res.Summary.NotOwned = atomic.LoadInt64(&s.notOwned)
+		res.OwnerFilter = of.names
+	}
+	if sc.cfg.owners != nil {
+		res.Owners = sc.cfg.owners.ranked()
+	}
+	if sc.cfg.links != nil {
+		res.Links = sc.cfg.links.sorted(sc.cfg.pathLess)
+	}
+	if sc.cfg.special != nil {
+		res.Special = sc.cfg.special.sorted(sc.cfg.pathLess)
+	}
+	if sc.cfg.sparseFiles != nil {
+		r := sc.cfg.sparseFiles.report()
+		res.Sparse = &r
+	}
+	res.SameVolume = sc.sameVolume
+	res.ShowUnder = sc.shownUnder
+	if sc.cfg.children != nil {
+		res.Children = sc.cfg.children.ranked()
+	}
+	if sc.cfg.breakdown != nil {
+		res.Breakdown = bucketChildren(res.Children, sc.cfg.breakdown)
+	}
+	if sc.cfg.caches != nil {
+		res.Caches = sc.cfg.caches.rows()
+	}
+	if sc.cfg.rollups != nil {
+		res.Rollups = sc.cfg.rollups.rows()
+	}
+	if sc.cfg.huge != nil {
+		res.HugeDirs = sc.cfg.huge.sorted()
+	}
+	if sc.cfg.concTop != nil {
+		res.Concentrated = concentratedRows(sc.cfg.concTop.sortedDesc())
+	}
+	if sc.cfg.find != nil {
+		res.Matches = toRows(sc.cfg.find.sorted(), false)
+	}
+	if sc.cfg.names != nil {
+		res.BadNames = sc.cfg.names.sorted(sc.cfg.pathLess)
+	}
+	scanned, over := sc.volumeBytes(), sc.overCapacity(dsc)
+	for d := range drives {
+		if d == "" {
+			continue
+		}
+		sp := dsc.spaceFor(d)
+		res.Volumes = append(res.Volumes, jsonVolume{Drive: d, TotalBytes: sp.total, FreeBytes: sp.free,
+			ScannedBytes: scanned[d], OverCapacity: slices.Contains(over, d)})
+	}
+	sort.Slice(res.Volumes, func(i, j int) bool { return res.Volumes[i].Drive < res.Volumes[j].Drive })
+	res.Phases = sc.phases(dsc) // last: the rows above query drive space
+	return res
+}
+
+// ########### RESULT MODEL: BASELINE ##################
+// baseline: sizes from an earlier -json document, keyed by kind and path,
+// for -baseline's DELTA column. A nil *baseline means no column.
+type baseline struct {
+	sizes map[string]int64
+}
+
+func baselineKey(isDir bool, path string) string {
+	if isDir {
+		return "d:" + strings.ToLower(path)
+	}
+	return "f:" + strings.ToLower(path)
+}
+
+// loadBaseline: reads a -json document. A missing file is an empty
+// baseline, so the first run shows every row as new.
+func loadBaseline(path string) (*baseline, error) {
+	b := &baseline{sizes: make(map[string]int64)}
+	data, err := readMaybeGzip(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res jsonResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := checkSchema(res.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, r := range res.Directories {
+		b.sizes[baselineKey(true, r.Path)] = r.SizeBytes
+	}
+	for _, r := range res.Files {
+		b.sizes[baselineKey(false, r.Path)] = r.SizeBytes
+	}
+	for _, r := range res.Items {
+		b.sizes[baselineKey(r.Type == "dir", r.Path)] = r.SizeBytes
+	}
+	return b, nil
+}
+
+// saveBaseline: writes res as the next run's baseline, replacing the file
+// in one rename so an interrupted run never leaves it half-written.
+func saveBaseline(path string, res jsonResult) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// delta: size change since the baseline; ok is false for new items.
+func (b *baseline) delta(it item) (int64, bool) {
+	old, ok := b.sizes[baselineKey(it.IsDir, it.Path)]
+	return it.total() - old, ok
+}
+
+// label: the delta as "+1.20 GB", "-300.00 MB", "0 B", or "new".
+func (b *baseline) label(it item) string {
+	d, ok := b.delta(it)
+	switch {
+	case !ok:
+		return "new"
+	case d > 0:
+		return "+" + humanBytesFixed(d)
+	case d < 0:
+		return "-" + humanBytesFixed(-d)
+	}
+	return "0 B"
+}
+
+// header and cell: the DELTA column for the tables; empty without -baseline.
+func (b *baseline) header() string {
+	if b == nil {
+		return ""
+	}
+	return "DELTA\t"
+}
+
+func (b *baseline) cell(it item) string {
+	if b == nil {
+		return ""
+	}
+	return b.label(it) + "\t"
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"disktop/gosizepb"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with testdata/name, or rewrites the file with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n")) // a checkout with autocrlf
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs; if the change is meant, bump schemaVersion where it applies and rerun with -update\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+// ----- schemas -----
+
+// jsonFields lists every key a value of type t can write, one per line
+// as "path type", nested objects and arrays included.
+func jsonFields(t reflect.Type, prefix string, out *[]string, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		jsonFields(t.Elem(), prefix+"[]", out, seen)
+		return
+	case reflect.Map:
+		jsonFields(t.Elem(), prefix+"{}", out, seen)
+		return
+	case reflect.Struct:
+		if t == reflect.TypeFor[time.Time]() {
+			return
+		}
+		if seen[t] {
+			*out = append(*out, prefix+" (recursive)")
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" {
+				if f.Anonymous {
+					jsonFields(f.Type, prefix, out, seen)
+					continue
+				}
+				name = f.Name
+			}
+			key := strings.TrimPrefix(prefix+"."+name, ".")
+			*out = append(*out, strings.TrimSpace(fmt.Sprintf("%s %s %s", key, jsonType(f.Type), opts)))
+			jsonFields(f.Type, key, out, seen)
+		}
+	}
+}
+
+// jsonType: t as the line for its key shows it, nested structs as "object"
+// since their fields get lines of their own.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return "[]" + jsonType(t.Elem())
+	case reflect.Map:
+		return "map[" + t.Key().String() + "]" + jsonType(t.Elem())
+	case reflect.Struct:
+		if t != reflect.TypeFor[time.Time]() {
+			return "object"
+		}
+	}
+	return t.String()
+}
+
+// TestJSONSchema: renaming, retyping or dropping any -json field fails
+// here, omitempty ones included.
+func TestJSONSchema(t *testing.T) {
+	lines := []string{"schemaVersion " + schemaVersion}
+	jsonFields(reflect.TypeFor[jsonResult](), "", &lines, map[reflect.Type]bool{})
+	golden(t, "json-schema.golden", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// TestProtoSchema: the same for the gRPC messages, with field numbers.
+func TestProtoSchema(t *testing.T) {
+	var lines []string
+	var walk func(protoreflect.MessageDescriptors)
+	walk = func(ms protoreflect.MessageDescriptors) {
+		for i := range ms.Len() {
+			m := ms.Get(i)
+			fs := m.Fields()
+			for j := range fs.Len() {
+				f := fs.Get(j)
+				typ := f.Kind().String()
+				if f.Message() != nil {
+					typ = string(f.Message().Name())
+				}
+				lines = append(lines, fmt.Sprintf("%s.%s = %d %s %s", m.Name(), f.Name(), f.Number(), f.Cardinality(), typ))
+			}
+			walk(m.Messages())
+		}
+	}
+	walk(gosizepb.File_gosizepb_gosize_proto.Messages())
+	golden(t, "proto-schema.golden", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// ----- a result -----
+
+// goldenTree: a fixture with fixed sizes and times.
+func goldenTree(t *testing.T) string {
+	root := writeTree(t, map[string]int{
+		"docs/report.PDF": 3000, "docs/notes.txt": 1200, "docs/old/archive.zip": 5000,
+		"media/clip.mp4": 8000, "empty.dat": 0,
+	})
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// Deepest first, so setting a file's time doesn't move its directory's.
+	var paths []string
+	filepath.WalkDir(root, func(p string, _ os.DirEntry, err error) error {
+		paths = append(paths, p)
+		return err
+	})
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := os.Chtimes(paths[i], mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// normalizedResult: the -json document of a scan of root, with what
+// changes from run to run or machine to machine blanked out and root
+// written as ROOT.
+func normalizedResult(sc *scan, root string) jsonResult {
+	res := sc.jsonResult(newDriveSpaceCache(), false)
+	rel := func(p string) string {
+		return "ROOT" + filepath.ToSlash(strings.TrimPrefix(p, root))
+	}
+	rows := func(rs []jsonRow) {
+		for i := range rs {
+			r := &rs[i]
+			r.Path, r.Drive, r.DriveTotal, r.DrivePercent, r.SeenAt = rel(r.Path), "", 0, 0, time.Time{}
+			r.Modified = r.Modified.UTC()
+		}
+	}
+	rows(res.Directories)
+	rows(res.Files)
+	res.Roots = []string{"ROOT"}
+	for i := range res.RootStatus {
+		res.RootStatus[i].Root = rel(res.RootStatus[i].Root)
+	}
+	res.RunID, res.Generated, res.Duration, res.Volumes, res.Phases = "RUN", "", "", nil, jsonPhases{}
+	return res
+}
+
+func TestJSONResult(t *testing.T) {
+	root := goldenTree(t)
+	sc := startScan(context.Background(), []string{root}, testCfg())
+	sc.wait()
+	b, err := json.MarshalIndent(normalizedResult(sc, root), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "result.golden.json", append(b, '\n'))
+}
+
+// The gRPC result is built from the same scan as -json and must agree
+// with it row for row.
+func TestGRPCResultMatchesJSON(t *testing.T) {
+	root := goldenTree(t)
+	sc := startScan(context.Background(), []string{root}, testCfg())
+	sc.wait()
+	js, pb := sc.jsonResult(newDriveSpaceCache(), false), grpcResult(sc)
+	if pb.SchemaVersion != js.SchemaVersion || pb.RunId != js.RunID {
+		t.Errorf("schema %s run %s, JSON has %s %s", pb.SchemaVersion, pb.RunId, js.SchemaVersion, js.RunID)
+	}
+	for _, l := range []struct {
+		name string
+		js   []jsonRow
+		pb   []*gosizepb.Item
+	}{{"directories", js.Directories, pb.Directories}, {"files", js.Files, pb.Files}} {
+		if len(l.pb) != len(l.js) {
+			t.Errorf("%s: %d rows, JSON has %d", l.name, len(l.pb), len(l.js))
+			continue
+		}
+		for i, r := range l.js {
+			if p := l.pb[i]; p.Path != r.Path || p.SizeBytes != r.SizeBytes || p.LowerBound != r.LowerBound {
+				t.Errorf("%s[%d]: %s %d %v, JSON has %s %d %v", l.name, i, p.Path, p.SizeBytes, p.LowerBound, r.Path, r.SizeBytes, r.LowerBound)
+			}
+		}
+	}
+	if pb.Stats.FilesSeen != js.Summary.FilesSeen || pb.Stats.DirsSeen != js.Summary.DirsSeen {
+		t.Errorf("stats %+v, JSON summary has %d files, %d dirs", pb.Stats, js.Summary.FilesSeen, js.Summary.DirsSeen)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	major := schemaMajor(schemaVersion)
+	for v, ok := range map[string]bool{
+		schemaVersion: true,
+		major + ".0":  true,
+		major + ".99": true,
+		"":            false,
+		"0.9":         false,
+		"99.0":        false,
+	} {
+		if err := checkSchema(v); (err == nil) != ok {
+			t.Errorf("checkSchema(%q) = %v", v, err)
+		}
+	}
+}
+
+func TestRunID(t *testing.T) {
+	a, b := newRunID(), newRunID()
+	if a == b {
+		t.Errorf("two runs got the same ID %s", a)
+	}
+	// xxxxxxxx-xxxx-4xxx-[89ab]xxx-xxxxxxxxxxxx
+	if len(a) != 36 || a[14] != '4' || !strings.ContainsRune("89ab", rune(a[19])) || strings.Count(a, "-") != 4 {
+		t.Errorf("%s is not a version 4 UUID", a)
+	}
+}
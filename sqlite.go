@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows"
+	_ "modernc.org/sqlite" // pure Go, no cgo
+)
+
+// ########### SQLITE EXPORT: ROWS ##################
+// -sqlite=scan.db writes every file and directory the walk counts into one
+// table, for ad-hoc SQL after the scan:
+//
+//	SELECT path, size FROM entries WHERE is_dir = 0 ORDER BY size DESC LIMIT 50;
+//
+// Rows go through a bounded channel to a single writer, which inserts them
+// through modernc.org/sqlite in transactions of sqliteBatch rows, so memory
+// stays flat however large the volume. The table has no indexes; add them
+// afterwards with CREATE INDEX.
+
+// sqliteSchema: the one table in the file.
+const sqliteSchema = "CREATE TABLE entries(path TEXT, size INTEGER, mtime INTEGER, ext TEXT, owner TEXT, is_dir INTEGER)"
+
+// sqliteBatch: rows per transaction.
+const sqliteBatch = 10000
+
+// sqliteRow: one entry. mtime is in Unix seconds; owner is a SID until
+// the writer resolves it, "" when -sqlite-owners is off.
+type sqliteRow struct {
+	path  string
+	size  int64
+	mtime int64
+	ext   string
+	owner string
+	isDir bool
+}
+
+// sqliteExport: the walker's side of -sqlite.
+type sqliteExport struct {
+	path   string
+	owners bool // -sqlite-owners: look up each file's owner
+	rows   chan sqliteRow
+	done   chan error
+	count  int64 // rows written; read after close
+}
+
+// newSQLiteExport: starts the writer for path. The file appears, complete,
+// when close returns.
+func newSQLiteExport(path string, owners bool) (*sqliteExport, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	f.Close() // an empty file is an empty database
+	x := &sqliteExport{path: path, owners: owners, rows: make(chan sqliteRow, 4096), done: make(chan error, 1)}
+	go func() { x.done <- x.write(f.Name()) }()
+	return x, nil
+}
+
+// file records a regular file.
+func (x *sqliteExport) file(path string, size int64, mtime time.Time) {
+	r := sqliteRow{path: path, size: size, mtime: mtime.Unix(), ext: extKey(path)}
+	if x.owners {
+		if sid := fileOwner(path); sid != nil {
+			r.owner = sid.String()
+		}
+	}
+	x.rows <- r
+}
+
+// dir records a directory with its subtree total.
+func (x *sqliteExport) dir(path string, size int64, mtime time.Time) {
+	var m int64
+	if !mtime.IsZero() {
+		m = mtime.Unix()
+	}
+	x.rows <- sqliteRow{path: path, size: size, mtime: m, isDir: true}
+}
+
+// close waits for the writer and moves the finished file into place.
+func (x *sqliteExport) close() error {
+	close(x.rows)
+	return <-x.done
+}
+
+// write drains rows into the database at tmp, then renames it to x.path.
+func (x *sqliteExport) write(tmp string) error {
+	err := x.insert(tmp)
+	if err == nil {
+		err = syncFile(tmp) // before the rename, so a reboot can't leave a torn database
+	}
+	if err == nil && isGzipPath(x.path) {
+		// Only a finished database can be compressed: into x.path, streamed.
+		err = gzipDone(tmp, x.path)
+		os.Remove(tmp)
+		return err
+	}
+	if err == nil {
+		err = os.Rename(tmp, x.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// insert creates the table in the database at path and fills it from
+// x.rows. After an error it keeps draining, so the walk isn't blocked.
+func (x *sqliteExport) insert(path string) (err error) {
+	defer func() {
+		for range x.rows {
+		}
+	}()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	// A new file renamed into place when done needs no rollback journal,
+	// and syncFile does the one fsync that matters.
+	for _, q := range []string{"PRAGMA journal_mode=OFF", "PRAGMA synchronous=OFF", sqliteSchema} {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	var tx *sql.Tx
+	var stmt *sql.Stmt
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	names := make(map[string]string) // SID -> DOMAIN\user
+	for r := range x.rows {
+		if tx == nil {
+			if tx, err = db.Begin(); err != nil {
+				return err
+			}
+			if stmt, err = tx.Prepare("INSERT INTO entries VALUES(?, ?, ?, ?, ?, ?)"); err != nil {
+				return err
+			}
+		}
+		owner := any(nil)
+		if r.owner != "" {
+			name, ok := names[r.owner]
+			if !ok {
+				name = r.owner
+				if sid, err := windows.StringToSid(r.owner); err == nil {
+					if acct, dom, _, err := sid.LookupAccount(""); err == nil {
+						name = dom + `\` + acct
+					}
+				}
+				names[r.owner] = name
+			}
+			owner = name
+		}
+		ext := any(nil)
+		if r.ext != "" {
+			ext = r.ext
+		}
+		isDir := int64(0)
+		if r.isDir {
+			isDir = 1
+		}
+		if _, err := stmt.Exec(r.path, r.size, r.mtime, ext, owner, isDir); err != nil {
+			return err
+		}
+		if x.count++; x.count%sqliteBatch == 0 {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			tx = nil
+		}
+	}
+	if tx != nil {
+		err = tx.Commit()
+		tx = nil
+	}
+	return err
+}
+
+// syncFile: fsync of the file at path.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// gzipDone: the finished database at src, gzipped into dst.
+func gzipDone(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return copyFileAtomic(dst, f)
+}
+
+var errNoSQLite = errors.New("-sqlite needs a one-off scan (not -control-pipe, -grpc or -index-serve)")
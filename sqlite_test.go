@@ -0,0 +1,159 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openExport opens a finished -sqlite file and checks its integrity.
+func openExport(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var ok string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&ok); err != nil || ok != "ok" {
+		t.Fatalf("integrity_check: %q, %v", ok, err)
+	}
+	return db
+}
+
+func TestSQLiteExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.db")
+	x, err := newSQLiteExport(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	n := sqliteBatch + 5 // across a transaction boundary
+	for i := range n {
+		x.file(fmt.Sprintf(`C:\d\f%d.TXT`, i), int64(i), mtime)
+	}
+	x.file(`C:\d\noext`, 7, mtime)
+	x.dir(`C:\d`, 12345, time.Time{})
+	if err := x.close(); err != nil {
+		t.Fatal(err)
+	}
+	if x.count != int64(n+2) {
+		t.Errorf("count %d, want %d", x.count, n+2)
+	}
+	if m, _ := filepath.Glob(path + ".*.tmp"); len(m) != 0 {
+		t.Errorf("temporary files left: %v", m)
+	}
+
+	db := openExport(t, path)
+	var rows, files int64
+	if err := db.QueryRow("SELECT COUNT(*), SUM(is_dir = 0) FROM entries").Scan(&rows, &files); err != nil {
+		t.Fatal(err)
+	}
+	if rows != int64(n+2) || files != int64(n+1) {
+		t.Errorf("%d rows, %d files; want %d, %d", rows, files, n+2, n+1)
+	}
+
+	var size, mt int64
+	var ext, owner sql.NullString
+	err = db.QueryRow("SELECT size, mtime, ext, owner FROM entries WHERE path = ?", `C:\d\f42.TXT`).Scan(&size, &mt, &ext, &owner)
+	if err != nil || size != 42 || mt != mtime.Unix() || ext.String != ".txt" || owner.Valid {
+		t.Errorf("f42: size %d mtime %d ext %v owner %v, %v", size, mt, ext, owner, err)
+	}
+	if err := db.QueryRow("SELECT ext FROM entries WHERE path = ?", `C:\d\noext`).Scan(&ext); err != nil || ext.Valid {
+		t.Errorf("no extension: %v, %v; want NULL", ext, err)
+	}
+	if err := db.QueryRow("SELECT size, mtime FROM entries WHERE is_dir = 1").Scan(&size, &mt); err != nil || size != 12345 || mt != 0 {
+		t.Errorf("directory: size %d mtime %d, %v", size, mt, err)
+	}
+}
+
+func TestSQLiteExportGzip(t *testing.T) {
+	dir := t.TempDir()
+	x, err := newSQLiteExport(filepath.Join(dir, "scan.db.gz"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.file(`C:\a.bin`, 1, time.Now())
+	if err := x.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "scan.db.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.db")
+	if err := os.WriteFile(plain, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var rows int
+	if err := openExport(t, plain).QueryRow("SELECT COUNT(*) FROM entries").Scan(&rows); err != nil || rows != 1 {
+		t.Errorf("%d rows, %v; want 1", rows, err)
+	}
+	if m, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(m) != 0 {
+		t.Errorf("temporary files left: %v", m)
+	}
+}
+
+// A scan with -sqlite records every file and directory it counts, each
+// directory with its subtree total.
+func TestSQLiteScan(t *testing.T) {
+	root := writeTree(t, map[string]int{"a/f1": 100, "a/sub/f2": 50, "b/f3": 30})
+	path := filepath.Join(t.TempDir(), "scan.db")
+	cfg := testCfg()
+	var err error
+	if cfg.sqlite, err = newSQLiteExport(path, false); err != nil {
+		t.Fatal(err)
+	}
+	startScan(context.Background(), []string{root}, cfg).wait()
+	if err := cfg.sqlite.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]int64)
+	rows, err := openExport(t, path).Query("SELECT path, size FROM entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		var n int64
+		if err := rows.Scan(&p, &n); err != nil {
+			t.Fatal(err)
+		}
+		got[p] = n
+	}
+	want := map[string]int64{
+		root:                                  180,
+		filepath.Join(root, "a"):              150,
+		filepath.Join(root, "a", "f1"):        100,
+		filepath.Join(root, "a", "sub"):       50,
+		filepath.Join(root, "a", "sub", "f2"): 50,
+		filepath.Join(root, "b"):              30,
+		filepath.Join(root, "b", "f3"):        30,
+	}
+	if len(got) != len(want) {
+		t.Errorf("%d rows, want %d: %v", len(got), len(want), got)
+	}
+	for p, n := range want {
+		if got[p] != n {
+			t.Errorf("%s: %d, want %d", p, got[p], n)
+		}
+	}
+}